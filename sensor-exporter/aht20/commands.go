@@ -6,37 +6,35 @@ package aht20
 
 import (
 	"context"
-	"io"
+	"sensor-exporter/i2cbus"
 	"sensor-exporter/units"
-	"time"
 
-	"github.com/d2r2/go-i2c"
 	"github.com/pkg/errors"
 )
 
-func reset(ctx context.Context, i2c *i2c.I2C) error {
-	const cmd_reset byte = 0xBA
-	_, err := i2c.WriteBytes([]byte{cmd_reset})
-	if err != nil {
-		return err
-	}
+const driverName = "aht20"
 
-	select {
-	case <-ctx.Done():
-	case <-time.After(wakeUpTimeout):
-	}
+func reset(ctx context.Context, device *i2cbus.Device) error {
+	return device.Transaction(ctx, "reset", func(tx *i2cbus.Tx) error {
+		const cmd_reset byte = 0xBA
+		err := tx.Write([]byte{cmd_reset})
+		if err != nil {
+			return err
+		}
 
-	return nil
+		return tx.Sleep(wakeUpTimeout)
+	})
 }
 
 type statusResponse struct {
 	IsCalibrated bool
 	IsBusy       bool
+	Raw          byte
 }
 
-func status(i2c *i2c.I2C) (*statusResponse, error) {
+func status(tx *i2cbus.Tx) (*statusResponse, error) {
 	buf := make([]byte, 1)
-	_, err := i2c.ReadBytes(buf)
+	err := tx.Read(buf)
 	if err != nil {
 		return nil, err
 	}
@@ -48,31 +46,53 @@ func status(i2c *i2c.I2C) (*statusResponse, error) {
 	response := &statusResponse{
 		IsCalibrated: b&calibratedMask > 0,
 		IsBusy:       b&busyMask > 0,
+		Raw:          b,
 	}
 
 	return response, nil
 }
 
-func calibrate(ctx context.Context, i2c *i2c.I2C) error {
-	const cmd_calibrate byte = 0xE1
-	_, err := i2c.WriteBytes([]byte{cmd_calibrate, 0x08, 0x00})
-	if err != nil {
-		return err
+// variant infers the member of the AHT family from its status byte. The AHT20 sets bit 4 once its registers are initialized,
+// whereas the AHT10 documents that bit as reserved, so this is a best guess rather than an identification.
+func (s *statusResponse) variant() string {
+	const initializedMask byte = 0b00010000
+	if s.Raw&initializedMask > 0 {
+		return "AHT2x"
 	}
+	return "AHT1x"
+}
 
+// waitUntilReady polls the status of the sensor until it is no longer busy
+func waitUntilReady(tx *i2cbus.Tx) (*statusResponse, error) {
 	for {
-		status, err := status(i2c)
+		status, err := status(tx)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read status")
+		}
+
+		if !status.IsBusy {
+			return status, nil
+		}
+
+		err = tx.Sleep(statusTimeout)
 		if err != nil {
-			return errors.Wrap(err, "failed to read status")
+			return nil, err
 		}
+	}
+}
 
-		if status.IsBusy {
-			select {
-			case <-ctx.Done():
-				return nil
-			case <-time.After(statusTimeout):
-			}
-			continue
+func calibrate(ctx context.Context, device *i2cbus.Device) (*statusResponse, error) {
+	var status *statusResponse
+	err := device.Transaction(ctx, "calibrate", func(tx *i2cbus.Tx) error {
+		const cmd_calibrate byte = 0xE1
+		err := tx.Write([]byte{cmd_calibrate, 0x08, 0x00})
+		if err != nil {
+			return err
+		}
+
+		status, err = waitUntilReady(tx)
+		if err != nil {
+			return err
 		}
 
 		if !status.IsCalibrated {
@@ -80,60 +100,58 @@ func calibrate(ctx context.Context, i2c *i2c.I2C) error {
 		}
 
 		return nil
-	}
+	})
+	return status, err
 }
 
-func trigger(ctx context.Context, i2c *i2c.I2C) (*Reading, error) {
-	const cmd_trigger byte = 0xAC
-	_, err := i2c.WriteBytes([]byte{cmd_trigger, 0x33, 0x00})
-	if err != nil {
-		return nil, err
-	}
-
-	for {
-		status, err := status(i2c)
+func trigger(ctx context.Context, device *i2cbus.Device) (*Reading, error) {
+	var reading *Reading
+	err := device.Transaction(ctx, "trigger", func(tx *i2cbus.Tx) error {
+		const cmd_trigger byte = 0xAC
+		err := tx.Write([]byte{cmd_trigger, 0x33, 0x00})
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to read status")
+			return err
 		}
 
-		if status.IsBusy {
-			select {
-			case <-ctx.Done():
-				return nil, io.EOF
-			case <-time.After(statusTimeout):
-			}
-			continue
+		_, err = waitUntilReady(tx)
+		if err != nil {
+			return err
 		}
 
 		buf := make([]byte, 6)
-		_, err = i2c.ReadBytes(buf)
+		err = tx.Read(buf)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to read reading")
+			return errors.Wrap(err, "failed to read reading")
 		}
 
-		/*
-		 * buf index 0       1       2       3       4       5
-		 *           |-------|-------|-------|-------|-------|-------
-		 * category  SSSSSSSSHHHHHHHHHHHHHHHHHHHHTTTTTTTTTTTTTTTTTTTT
-		 *
-		 * Categories:
-		 * S: State (8 bits)
-		 * H: Humidity (20 bits)
-		 * T: Temperature (20 bits)
-		 */
-
-		var rawHumidityReading uint32
-		rawHumidityReading = uint32(buf[1])<<12 | uint32(buf[2])<<4 | uint32(buf[3])>>4
-		humidity := units.RelativeHumidity(rawHumidityReading) / 0x100000
-
-		var rawTemperatureReading uint32
-		rawTemperatureReading = uint32((buf[3]&0xF))<<16 | uint32(buf[4])<<8 | uint32(buf[5])
-		temperature := ((units.Celsius(rawTemperatureReading) * 200.0) / 0x100000) - 50
-
-		reading := &Reading{
-			humidity,
-			temperature,
-		}
-		return reading, nil
+		reading = parseReading(buf)
+		return nil
+	})
+	return reading, err
+}
+
+func parseReading(buf []byte) *Reading {
+	/*
+	 * buf index 0       1       2       3       4       5
+	 *           |-------|-------|-------|-------|-------|-------
+	 * category  SSSSSSSSHHHHHHHHHHHHHHHHHHHHTTTTTTTTTTTTTTTTTTTT
+	 *
+	 * Categories:
+	 * S: State (8 bits)
+	 * H: Humidity (20 bits)
+	 * T: Temperature (20 bits)
+	 */
+
+	var rawHumidityReading uint32
+	rawHumidityReading = uint32(buf[1])<<12 | uint32(buf[2])<<4 | uint32(buf[3])>>4
+	humidity := units.RelativeHumidity(rawHumidityReading) / 0x100000
+
+	var rawTemperatureReading uint32
+	rawTemperatureReading = uint32((buf[3]&0xF))<<16 | uint32(buf[4])<<8 | uint32(buf[5])
+	temperature := ((units.Celsius(rawTemperatureReading) * 200.0) / 0x100000) - 50
+
+	return &Reading{
+		humidity,
+		temperature,
 	}
 }
@@ -0,0 +1,31 @@
+package aht20
+
+import (
+	"context"
+	"sensor-exporter/i2cbus"
+
+	"github.com/pkg/errors"
+)
+
+// reservedMask covers the bits of the status byte that the AHT10 and AHT20 always clear: the mode bits, which stay 0 in the
+// normal mode the driver uses, and the lowest two bits. Bit 2 is left out as some AHT20 report 0x1C once calibrated.
+const reservedMask byte = 0b01100011
+
+// Probe reads the status byte of an AHT sensor without resetting or calibrating it. Another device at the address is told
+// apart by a status byte with reserved bits set, such as the 0xFF read from many devices that do not expect a bare read.
+func Probe(ctx context.Context, device *i2cbus.Device) (*Info, error) {
+	var info *Info
+	err := device.Transaction(ctx, "probe", func(tx *i2cbus.Tx) error {
+		status, err := status(tx)
+		if err != nil {
+			return err
+		}
+		if status.Raw&reservedMask != 0 {
+			return errors.Errorf("failed to identify an AHT sensor at %v; status 0x%02x has reserved bits set", device, status.Raw)
+		}
+
+		info = &Info{status.variant(), status.Raw}
+		return nil
+	})
+	return info, err
+}
@@ -3,13 +3,12 @@ package aht20
 import (
 	"context"
 	"io"
+	"sensor-exporter/i2cbus"
+	"sensor-exporter/reconnect"
 	"sensor-exporter/units"
 	"time"
 
-	"github.com/d2r2/go-i2c"
-	"github.com/d2r2/go-logger"
 	"github.com/pkg/errors"
-	"github.com/syncromatics/go-kit/v2/log"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -24,39 +23,52 @@ type Reading struct {
 	Temperature units.Celsius
 }
 
-type Sensor struct {
-	i2cAddr          uint8
-	i2cBus           int
-	readings         chan *Reading
-	reconnectTimeout time.Duration
+// Info describes the identity of the connected AHT sensor
+type Info struct {
+	// Member of the AHT family inferred from the status byte, either AHT1x or AHT2x
+	Variant string
+	// Status byte reported by the sensor after calibration
+	Status byte
 }
 
-func init() {
-	logger.ChangePackageLogLevel("i2c", logger.InfoLevel)
+type Sensor struct {
+	buses             *i2cbus.Manager
+	address           i2cbus.Address
+	infos             chan *Info
+	readings          chan *Reading
+	reconnectSettings reconnect.Settings
 }
 
 func NewSensor(
-	i2cAddr uint8,
-	i2cBus int,
-	reconnectTimeout time.Duration,
+	buses *i2cbus.Manager,
+	address i2cbus.Address,
+	reconnectSettings reconnect.Settings,
 ) *Sensor {
+	infos := make(chan *Info)
 	readings := make(chan *Reading)
 	return &Sensor{
-		i2cAddr,
-		i2cBus,
+		buses,
+		address,
+		infos,
 		readings,
-		reconnectTimeout,
+		reconnectSettings,
 	}
 }
 
+func (s *Sensor) Infos() <-chan *Info {
+	return s.infos
+}
+
 func (s *Sensor) Readings() <-chan *Reading {
 	return s.readings
 }
 
 func (s *Sensor) Start(ctx context.Context) func() error {
 	return func() error {
+		defer close(s.infos)
 		defer close(s.readings)
 
+		policy := reconnect.NewPolicy(s.reconnectSettings, driverName, s.address.String())
 		for {
 			select {
 			case <-ctx.Done():
@@ -64,25 +76,37 @@ func (s *Sensor) Start(ctx context.Context) func() error {
 			case <-time.After(wakeUpTimeout):
 			}
 
-			i2c, err := i2c.NewI2C(s.i2cAddr, s.i2cBus)
+			device, err := s.buses.Open(driverName, s.address)
 			if err != nil {
-				return errors.Wrapf(err, "failed to open I2C address %v on bus %v", s.i2cAddr, s.i2cBus)
+				return errors.Wrapf(err, "failed to open I2C device %v", s.address)
 			}
 
 			group, innerCtx := errgroup.WithContext(ctx)
 			group.Go(func() error {
-				err = reset(innerCtx, i2c)
+				err := reset(innerCtx, device)
+				if err == io.EOF {
+					return nil
+				}
 				if err != nil {
 					return errors.Wrap(err, "failed to reset sensor")
 				}
 
-				err = calibrate(innerCtx, i2c)
+				status, err := calibrate(innerCtx, device)
+				if err == io.EOF {
+					return nil
+				}
 				if err != nil {
 					return errors.Wrap(err, "failed to calibrate sensor")
 				}
 
+				select {
+				case s.infos <- &Info{status.variant(), status.Raw}:
+				case <-innerCtx.Done():
+					return nil
+				}
+
 				for {
-					reading, err := trigger(innerCtx, i2c)
+					reading, err := trigger(innerCtx, device)
 					if err == io.EOF {
 						return nil
 					}
@@ -92,27 +116,17 @@ func (s *Sensor) Start(ctx context.Context) func() error {
 
 					select {
 					case s.readings <- reading:
+						policy.Healthy()
 					case <-innerCtx.Done():
 						return nil
 					}
 				}
 			})
-			group.Go(func() error {
-				<-innerCtx.Done()
-				i2c.Close()
-				return nil
-			})
 
 			err = group.Wait()
-			log.Info("disconnected from sensor; waiting to reconnect",
-				"err", err,
-				"reconnectTimeout", s.reconnectTimeout)
-
-			select {
-			case <-ctx.Done():
+			device.Close()
+			if !policy.Wait(ctx, err) {
 				return nil
-			case <-time.After(s.reconnectTimeout):
-				log.Info("reconnecting")
 			}
 		}
 	}
@@ -0,0 +1,160 @@
+// Package clock detects whether the wall clock has been synchronized, so that decisions based on wall time, such as
+// whether a stored baseline has expired, can be deferred on hosts that boot without a real-time clock
+package clock
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+// Checker reports whether the wall clock is synchronized
+type Checker interface {
+	Synchronized() (bool, error)
+}
+
+// Kernel checks the synchronization status the kernel keeps for NTP daemons such as chrony, ntpd and timesyncd
+type Kernel struct{}
+
+// Sentinel considers the clock synchronized once a file exists, such as the one systemd-timesyncd creates after
+// synchronizing
+type Sentinel struct {
+	Path string
+}
+
+// Synchronized returns whether the sentinel file exists
+func (s Sentinel) Synchronized() (bool, error) {
+	_, err := os.Stat(s.Path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to check clock sentinel %v", s.Path)
+	}
+	return true, nil
+}
+
+// Trusted always considers the clock synchronized
+type Trusted struct{}
+
+// Synchronized returns true
+func (Trusted) Synchronized() (bool, error) {
+	return true, nil
+}
+
+// NewChecker returns the checker for a method, which is one of kernel, sentinel or none
+func NewChecker(method, sentinelPath string) (Checker, error) {
+	switch method {
+	case "kernel":
+		return Kernel{}, nil
+	case "sentinel":
+		return Sentinel{sentinelPath}, nil
+	case "none":
+		return Trusted{}, nil
+	default:
+		return nil, errors.Errorf("failed to configure unknown clock check %q; expected kernel, sentinel or none", method)
+	}
+}
+
+// defaultPollInterval is how often the checker is asked while waiting for synchronization
+const defaultPollInterval = 5 * time.Second
+
+// Guard remembers once the clock has been synchronized and lets callers wait for it for a bounded duration
+type Guard struct {
+	checker      Checker
+	timeout      time.Duration
+	pollInterval time.Duration
+
+	mu           sync.Mutex
+	synchronized bool
+	gaveUp       bool
+	// Whether the checker is failing, so that a lasting failure, such as the kernel check on a platform other than Linux,
+	// is only warned about once
+	failing bool
+}
+
+// NewGuard returns a guard that waits up to timeout for the clock to synchronize; a timeout of 0 waits indefinitely
+func NewGuard(checker Checker, timeout time.Duration) *Guard {
+	return &Guard{
+		checker:      checker,
+		timeout:      timeout,
+		pollInterval: defaultPollInterval,
+	}
+}
+
+// Synchronized returns whether the clock is, or has been, synchronized. A clock that was synchronized once is trusted
+// from then on, since losing synchronization leaves it close to correct.
+func (g *Guard) Synchronized() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.synchronized {
+		return true
+	}
+
+	synchronized, err := g.checker.Synchronized()
+	if err != nil {
+		if g.failing {
+			log.Debug("failed to check clock synchronization",
+				"err", err)
+		} else {
+			log.Warn("failed to check clock synchronization; the clock is not trusted until the check succeeds",
+				"err", err)
+		}
+		g.failing = true
+		return false
+	}
+	g.failing = false
+	g.synchronized = synchronized
+	return synchronized
+}
+
+// WaitForSync waits until the clock is synchronized, returning false if it is not by the timeout or the context is
+// done. Once the guard has given up waiting, later calls return immediately.
+func (g *Guard) WaitForSync(ctx context.Context) bool {
+	if g.Synchronized() {
+		return true
+	}
+	g.mu.Lock()
+	gaveUp := g.gaveUp
+	g.mu.Unlock()
+	if gaveUp {
+		return false
+	}
+
+	log.Warn("waiting for the clock to synchronize before making decisions based on wall time",
+		"now", time.Now(),
+		"timeout", g.timeout)
+
+	var timeout <-chan time.Time
+	if g.timeout > 0 {
+		timer := time.NewTimer(g.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	ticker := time.NewTicker(g.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timeout:
+			g.mu.Lock()
+			g.gaveUp = true
+			g.mu.Unlock()
+			log.Warn("clock did not synchronize in time; proceeding without trusting wall time",
+				"now", time.Now(),
+				"timeout", g.timeout)
+			return false
+		case <-ticker.C:
+			if g.Synchronized() {
+				log.Info("clock synchronized",
+					"now", time.Now())
+				return true
+			}
+		}
+	}
+}
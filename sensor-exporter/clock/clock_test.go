@@ -0,0 +1,170 @@
+package clock
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fakeChecker answers each check with the next of its results, repeating the last one once they run out
+type fakeChecker struct {
+	mu      sync.Mutex
+	results []error
+	checks  int
+}
+
+// errNotSynchronized is a result of fakeChecker reporting an unsynchronized clock rather than a failed check
+var errNotSynchronized = errors.New("not synchronized")
+
+func (c *fakeChecker) Synchronized() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := c.results[len(c.results)-1]
+	if c.checks < len(c.results) {
+		result = c.results[c.checks]
+	}
+	c.checks++
+	if result == errNotSynchronized {
+		return false, nil
+	}
+	return result == nil, result
+}
+
+func TestWaitForSync(t *testing.T) {
+	errCheck := errors.New("failed to read clock status")
+	tests := []struct {
+		name    string
+		results []error
+		timeout time.Duration
+		// Whether WaitForSync is expected to return true, and whether the guard gave up
+		synchronized bool
+		gaveUp       bool
+	}{
+		{
+			name:         "already synchronized",
+			results:      []error{nil},
+			timeout:      time.Hour,
+			synchronized: true,
+		},
+		{
+			name:         "synchronizes while waiting",
+			results:      []error{errNotSynchronized, errNotSynchronized, nil},
+			timeout:      time.Hour,
+			synchronized: true,
+		},
+		{
+			name:         "synchronizes after the check failed",
+			results:      []error{errCheck, errCheck, nil},
+			timeout:      time.Hour,
+			synchronized: true,
+		},
+		{
+			name:    "gives up at the timeout",
+			results: []error{errNotSynchronized},
+			timeout: 20 * time.Millisecond,
+			gaveUp:  true,
+		},
+		{
+			name:    "gives up while the check fails",
+			results: []error{errCheck},
+			timeout: 20 * time.Millisecond,
+			gaveUp:  true,
+		},
+		{
+			name:         "timeout of 0 waits indefinitely",
+			results:      []error{errNotSynchronized, errNotSynchronized, errNotSynchronized, errNotSynchronized, errNotSynchronized, nil},
+			timeout:      0,
+			synchronized: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			guard := NewGuard(&fakeChecker{results: test.results}, test.timeout)
+			guard.pollInterval = time.Millisecond
+
+			synchronized := guard.WaitForSync(context.Background())
+			if synchronized != test.synchronized {
+				t.Errorf("got synchronized %v, want %v", synchronized, test.synchronized)
+			}
+			if guard.gaveUp != test.gaveUp {
+				t.Errorf("got gave up %v, want %v", guard.gaveUp, test.gaveUp)
+			}
+			if guard.Synchronized() != test.synchronized {
+				t.Errorf("got guard synchronized %v, want %v", guard.Synchronized(), test.synchronized)
+			}
+		})
+	}
+}
+
+func TestWaitForSyncAfterGivingUp(t *testing.T) {
+	checker := &fakeChecker{results: []error{errNotSynchronized}}
+	guard := NewGuard(checker, 10*time.Millisecond)
+	guard.pollInterval = time.Millisecond
+	if guard.WaitForSync(context.Background()) {
+		t.Fatalf("got synchronized, want the guard to give up")
+	}
+
+	// later callers, such as a sensor that reconnects, do not wait again
+	checks := checker.checks
+	start := time.Now()
+	if guard.WaitForSync(context.Background()) {
+		t.Errorf("got synchronized, want false after giving up")
+	}
+	if waited := time.Since(start); waited >= 10*time.Millisecond {
+		t.Errorf("waited %v after giving up, want an immediate return", waited)
+	}
+	if checker.checks != checks+1 {
+		t.Errorf("got %v checks after giving up, want 1", checker.checks-checks)
+	}
+
+	// the clock is still trusted once it synchronizes
+	checker.results = []error{nil}
+	if !guard.WaitForSync(context.Background()) {
+		t.Errorf("got not synchronized, want synchronized once the checker reports it")
+	}
+}
+
+func TestWaitForSyncCanceled(t *testing.T) {
+	guard := NewGuard(&fakeChecker{results: []error{errNotSynchronized}}, 0)
+	guard.pollInterval = time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if guard.WaitForSync(ctx) {
+		t.Errorf("got synchronized, want false when the context is done")
+	}
+	if guard.gaveUp {
+		t.Errorf("got gave up, want a canceled wait not to stop later waits")
+	}
+}
+
+func TestSynchronizedFailing(t *testing.T) {
+	errCheck := errors.New("failed to read clock status")
+	tests := []struct {
+		name    string
+		results []error
+		// Whether the guard is expected to be failing after each check, which warns only on the first failure
+		failing []bool
+	}{
+		{"lasting failure", []error{errCheck, errCheck, errCheck}, []bool{true, true, true}},
+		{"recovered failure", []error{errCheck, errNotSynchronized, errCheck}, []bool{true, false, true}},
+		{"synchronized", []error{errCheck, nil, errCheck}, []bool{true, false, false}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checker := &fakeChecker{results: test.results}
+			guard := NewGuard(checker, 0)
+			for i, want := range test.failing {
+				guard.Synchronized()
+				if guard.failing != want {
+					t.Errorf("got failing %v after check %v, want %v", guard.failing, i+1, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package clock
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// from linux/timex.h
+const (
+	timeError = 5
+	staUnsync = 0x0040
+)
+
+// Synchronized returns whether the kernel clock is synchronized, reading its status without changing it
+func (Kernel) Synchronized() (bool, error) {
+	timex := unix.Timex{}
+	state, err := unix.Adjtimex(&timex)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read clock status")
+	}
+
+	return state != timeError && timex.Status&staUnsync == 0, nil
+}
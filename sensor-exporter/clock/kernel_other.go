@@ -0,0 +1,10 @@
+//go:build !linux
+
+package clock
+
+import "github.com/pkg/errors"
+
+// Synchronized fails on platforms without adjtimex
+func (Kernel) Synchronized() (bool, error) {
+	return false, errors.New("failed to read clock status; the kernel clock check is only supported on Linux")
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sensor-exporter/internal/baseline"
+	"sensor-exporter/internal/exporter"
+	"sensor-exporter/internal/state"
+	"sensor-exporter/sgp30"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+const probeTimeout = 5 * time.Second
+
+var (
+	baselineCmd = &cobra.Command{
+		Use:   "baseline",
+		Short: "inspect, export, import, reset and roll back the stored baselines of SGP30 sensors",
+		Long: `Manages the baselines of SGP30 sensors held in the state file. Each subcommand takes the name of the sensor as
+an optional argument, which may be omitted when only one SGP30 is configured. Baselines are kept by the serial of
+the sensor, which is read from the connected sensor unless --serial is given. Stop the exporter before importing or
+resetting a baseline, since it stores the baseline held by the sensor every hour.`,
+	}
+
+	baselineShowCmd = &cobra.Command{
+		Use:   "show [sensor]",
+		Short: "print the stored baseline and compare it against the connected sensor",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			target, err := resolveBaseline(cmd, args)
+			if err != nil {
+				return err
+			}
+			stored, err := target.baseline()
+			if err != nil {
+				return err
+			}
+
+			connected := "unknown"
+			switch {
+			case target.connected == nil:
+			case baseline.Verify(stored, target.connected) != nil:
+				log.Warn("stored baseline belongs to a different sensor; it will not be restored",
+					"sensor", target.instance.Name,
+					"stored", sgp30.FormatSerial(stored.Serial),
+					"connected", sgp30.FormatSerial(target.connected))
+				connected = sgp30.FormatSerial(target.connected) + " (mismatch)"
+			default:
+				connected = sgp30.FormatSerial(target.connected) + " (match)"
+			}
+			warnIfExpired(target.instance, stored)
+
+			return writeBaseline(cmd.OutOrStdout(), target, stored, connected, time.Now())
+		},
+	}
+
+	baselineExportCmd = &cobra.Command{
+		Use:   "export [sensor]",
+		Short: "write the stored baseline as JSON to standard output or a file",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			output, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+
+			target, err := resolveBaseline(cmd, args)
+			if err != nil {
+				return err
+			}
+			stored, err := target.baseline()
+			if err != nil {
+				return err
+			}
+			warnIfExpired(target.instance, stored)
+
+			if output == "" || output == "-" {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "\t")
+				return encoder.Encode(stored)
+			}
+			return exporter.WriteBaseline(output, stored)
+		},
+	}
+
+	baselineImportCmd = &cobra.Command{
+		Use:   "import <file> [sensor]",
+		Short: "store a previously exported baseline for a sensor after checking it belongs to the connected sensor",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			force, err := cmd.Flags().GetBool("force")
+			if err != nil {
+				return err
+			}
+
+			imported, err := exporter.ReadBaseline(args[0])
+			if err != nil {
+				return err
+			}
+
+			settings, instance, err := selectGasSensor(args[1:])
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+			defer cancel()
+			serial, err := baseline.ConnectedSerial(ctx, instance)
+			if err == nil {
+				err = baseline.Verify(imported, serial)
+			}
+			if err != nil {
+				if !force || len(imported.Serial) == 0 {
+					return errors.Wrap(err, "failed to import baseline; use --force to import it anyway")
+				}
+				log.Warn("importing baseline that could not be verified against the connected sensor",
+					"err", err,
+					"sensor", instance.Name)
+			}
+			warnIfExpired(instance, imported)
+
+			store, err := exporter.OpenState(settings, []exporter.SensorSettings{instance})
+			if err != nil {
+				return err
+			}
+			err = store.Update(exporter.BaselineKey(imported.Serial), func(sensor *state.Sensor) error {
+				sensor.Model = "SGP30"
+				sensor.Baseline = imported
+				return nil
+			})
+			if err != nil {
+				return errors.Wrap(err, "failed to store baseline")
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "imported baseline of sensor %v (serial %v) into %v; start the exporter to restore it\n",
+				instance.Name,
+				sgp30.FormatSerial(imported.Serial),
+				store.Path())
+			return nil
+		},
+	}
+
+	baselineResetCmd = &cobra.Command{
+		Use:   "reset [sensor]",
+		Short: "remove the stored baseline so that the sensor acclimates from scratch when the exporter next starts",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			target, err := resolveBaseline(cmd, args)
+			if err != nil {
+				return err
+			}
+
+			key := exporter.BaselineKey(target.serial)
+			if sensor := target.store.Sensor(key); sensor == nil || sensor.Baseline == nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "no baseline stored for sensor %v (serial %v)\n",
+					target.instance.Name,
+					sgp30.FormatSerial(target.serial))
+				return nil
+			}
+
+			err = target.store.Update(key, func(sensor *state.Sensor) error {
+				sensor.Baseline = nil
+				return nil
+			})
+			if err != nil {
+				return errors.Wrap(err, "failed to remove baseline")
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "removed baseline of sensor %v (serial %v) from %v\n",
+				target.instance.Name,
+				sgp30.FormatSerial(target.serial),
+				target.store.Path())
+			return nil
+		},
+	}
+
+	baselineAgeCmd = &cobra.Command{
+		Use:   "age [sensor]",
+		Short: "print how old the stored baseline is and how long it remains valid",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			target, err := resolveBaseline(cmd, args)
+			if err != nil {
+				return err
+			}
+			stored, err := target.baseline()
+			if err != nil {
+				return err
+			}
+
+			age := baseline.AgeOf(stored, time.Now())
+			if age.Expired {
+				fmt.Fprintf(cmd.OutOrStdout(), "%v: stored %v ago, expired %v ago\n",
+					target.instance.Name,
+					formatDuration(age.Age),
+					formatDuration(-age.Remaining))
+				warnIfExpired(target.instance, stored)
+				return nil
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "%v: stored %v ago, valid for another %v\n",
+				target.instance.Name,
+				formatDuration(age.Age),
+				formatDuration(age.Remaining))
+			return nil
+		},
+	}
+
+	baselineHistoryCmd = &cobra.Command{
+		Use:   "history [sensor]",
+		Short: "list the baselines read from the sensor and the conditions they were read under",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			output, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+
+			target, err := resolveBaseline(cmd, args)
+			if err != nil {
+				return err
+			}
+
+			_, history := exporter.BaselineHistory(target.store, target.serial)
+			switch output {
+			case "json":
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(history)
+			case "table":
+				return writeBaselineHistory(cmd.OutOrStdout(), history)
+			default:
+				return errors.Errorf("failed to write unknown output format %q", output)
+			}
+		},
+	}
+
+	baselineRollbackCmd = &cobra.Command{
+		Use:   "rollback [sensor]",
+		Short: "restore an earlier baseline from the history, such as one from before a bad air event",
+		Long: `Stores the baseline at the given index of the history, as listed by "baseline history", as the baseline to
+restore when the exporter next starts. While the exporter is running, roll back through its API instead with
+POST /api/v1/sensors/<sensor>/baseline/rollback and a body of {"index": <index>}, which also applies the baseline to
+the sensor immediately.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			index, err := cmd.Flags().GetInt("to")
+			if err != nil {
+				return err
+			}
+
+			target, err := resolveBaseline(cmd, args)
+			if err != nil {
+				return err
+			}
+
+			rolledBack, err := exporter.RollbackBaseline(target.store, target.serial, index)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "rolled back baseline of sensor %v (serial %v) to eCO2 0x%04X, TVOC 0x%04X; start the exporter to restore it\n",
+				target.instance.Name,
+				sgp30.FormatSerial(target.serial),
+				uint16(rolledBack.EquivalentCO2),
+				uint16(rolledBack.TotalVOC))
+			return nil
+		},
+	}
+)
+
+// baselineTarget is the SGP30 sensor whose stored baseline a subcommand operates on
+type baselineTarget struct {
+	instance exporter.SensorSettings
+	store    *state.Store
+	// Serial under which the baseline is stored
+	serial []uint16
+	// Serial of the connected sensor, or nil if it could not be read
+	connected []uint16
+}
+
+func (t *baselineTarget) baseline() (*sgp30.BaselineReading, error) {
+	sensor := t.store.Sensor(exporter.BaselineKey(t.serial))
+	if sensor == nil || sensor.Baseline == nil {
+		return nil, errors.Errorf("failed to find a stored baseline for sensor %v (serial %v) in %v",
+			t.instance.Name,
+			sgp30.FormatSerial(t.serial),
+			t.store.Path())
+	}
+	return sensor.Baseline, nil
+}
+
+// selectGasSensor returns the settings and the SGP30 instance named by the optional argument
+func selectGasSensor(args []string) (*exporter.Settings, exporter.SensorSettings, error) {
+	settings, err := readSettings()
+	if err != nil {
+		return nil, exporter.SensorSettings{}, err
+	}
+
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	}
+	instance, err := baseline.Instance(settings, name)
+	return settings, instance, err
+}
+
+// resolveBaseline opens the state store and determines the serial of the sensor whose baseline to operate on: the one
+// given by --serial, else the connected sensor, else the only SGP30 with a stored baseline
+func resolveBaseline(cmd *cobra.Command, args []string) (*baselineTarget, error) {
+	settings, instance, err := selectGasSensor(args)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := exporter.OpenState(settings, []exporter.SensorSettings{instance})
+	if err != nil {
+		return nil, err
+	}
+	target := &baselineTarget{
+		instance: instance,
+		store:    store,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	target.connected, err = baseline.ConnectedSerial(ctx, instance)
+	if err != nil {
+		log.Warn("failed to read serial of connected sensor",
+			"err", err,
+			"sensor", instance.Name)
+	}
+
+	serial, err := cmd.Flags().GetString("serial")
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case serial != "":
+		target.serial, err = sgp30.ParseSerial(serial)
+		if err != nil {
+			return nil, err
+		}
+	case target.connected != nil:
+		target.serial = target.connected
+	default:
+		stored := baseline.StoredSerials(store)
+		if len(stored) == 0 {
+			return nil, errors.Errorf("failed to find a stored baseline in %v", store.Path())
+		}
+		if len(stored) > 1 {
+			return nil, errors.Errorf("failed to choose between %v stored baselines without a connected sensor; use --serial", len(stored))
+		}
+		target.serial = stored[0]
+	}
+	return target, nil
+}
+
+func warnIfExpired(instance exporter.SensorSettings, stored *sgp30.BaselineReading) {
+	if !stored.IsExpired(time.Now()) {
+		return
+	}
+	log.Warn("baseline has expired; the sensor will acclimate from scratch instead of restoring it",
+		"sensor", instance.Name,
+		"baselineInvalidAfter", stored.BaselineInvalidAfter)
+}
+
+func writeBaseline(w io.Writer, target *baselineTarget, stored *sgp30.BaselineReading, connected string, now time.Time) error {
+	age := baseline.AgeOf(stored, now)
+	status := "valid"
+	if age.Expired {
+		status = "expired"
+	}
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "Sensor:\t%v\n", target.instance.Name)
+	fmt.Fprintf(table, "State file:\t%v\n", target.store.Path())
+	fmt.Fprintf(table, "Serial:\t%v\n", sgp30.FormatSerial(stored.Serial))
+	fmt.Fprintf(table, "Connected serial:\t%v\n", connected)
+	fmt.Fprintf(table, "eCO2 baseline:\t0x%04X\n", uint16(stored.EquivalentCO2))
+	fmt.Fprintf(table, "TVOC baseline:\t0x%04X\n", uint16(stored.TotalVOC))
+	fmt.Fprintf(table, "Stored:\t%v (%v ago)\n", stored.StoredAt().Format(time.RFC3339), formatDuration(age.Age))
+	fmt.Fprintf(table, "Readings valid from:\t%v\n", stored.SensorReadingsNotValidBefore.Format(time.RFC3339))
+	fmt.Fprintf(table, "Invalid after:\t%v (%v)\n", stored.BaselineInvalidAfter.Format(time.RFC3339), status)
+	if sensor := target.store.Sensor(exporter.BaselineKey(target.serial)); sensor != nil && sensor.Acclimation != nil {
+		fmt.Fprintf(table, "Provisional:\t%v\n", stored.Provisional)
+		fmt.Fprintf(table, "Acclimation:\tstarted %v, ran %v of %v, last seen %v\n",
+			sensor.Acclimation.StartedAt.Format(time.RFC3339),
+			formatDuration(sensor.Acclimation.Elapsed),
+			sgp30.AcclimationDuration,
+			sensor.Acclimation.LastSeen.Format(time.RFC3339))
+	}
+	return table.Flush()
+}
+
+func writeBaselineHistory(w io.Writer, history []state.BaselineRecord) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "INDEX\tRECORDED\tECO2\tTVOC\tTEMPERATURE\tHUMIDITY\tSTATUS")
+	for idx, record := range history {
+		status := "accepted"
+		if record.Rejected != "" {
+			status = "rejected: " + record.Rejected
+		} else if record.RecordedAt.Before(record.Baseline.SensorReadingsNotValidBefore) {
+			status = "acclimating"
+		}
+		if record.UntrustedTime {
+			status += ", clock not synchronized"
+		}
+		fmt.Fprintf(table, "%d\t%s\t0x%04X\t0x%04X\t%s\t%s\t%s\n",
+			idx,
+			record.RecordedAt.Format(time.RFC3339),
+			uint16(record.Baseline.EquivalentCO2),
+			uint16(record.Baseline.TotalVOC),
+			formatCondition(record.Conditions.TemperatureCelsius, 1, "°C"),
+			formatCondition(record.Conditions.RelativeHumidityRatio, 100, "%"),
+			status)
+	}
+	return table.Flush()
+}
+
+func formatCondition(value *float64, scale float64, unit string) string {
+	if value == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%s", *value*scale, unit)
+}
+
+// formatDuration rounds a duration to the minute for display
+func formatDuration(d time.Duration) string {
+	return d.Round(time.Minute).String()
+}
+
+func init() {
+	baselineCmd.PersistentFlags().String("serial", "", "Serial of the sensor whose stored baseline to use instead of the serial of the connected sensor")
+	baselineExportCmd.Flags().StringP("output", "o", "", "File to write the baseline to (default standard output)")
+	baselineHistoryCmd.Flags().StringP("output", "o", "table", "Output format: table or json")
+	baselineRollbackCmd.Flags().Int("to", 0, "Index in the history of the baseline to roll back to")
+	baselineRollbackCmd.MarkFlagRequired("to")
+	baselineImportCmd.Flags().Bool("force", false, "Import the baseline even if it cannot be verified against the connected sensor")
+
+	baselineCmd.AddCommand(baselineShowCmd)
+	baselineCmd.AddCommand(baselineExportCmd)
+	baselineCmd.AddCommand(baselineImportCmd)
+	baselineCmd.AddCommand(baselineResetCmd)
+	baselineCmd.AddCommand(baselineAgeCmd)
+	baselineCmd.AddCommand(baselineHistoryCmd)
+	baselineCmd.AddCommand(baselineRollbackCmd)
+	rootCmd.AddCommand(baselineCmd)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"sensor-exporter/internal/state"
+	"sensor-exporter/sgp30"
+	"testing"
+	"time"
+)
+
+func TestWriteBaselineHistory(t *testing.T) {
+	recorded := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	temperature, humidity := 21.5, 0.456
+	baseline := func(eco2, tvoc uint16, acclimatedAfter time.Duration) *sgp30.BaselineReading {
+		return &sgp30.BaselineReading{
+			EquivalentCO2:                sgp30.PartsPerMillion(eco2),
+			TotalVOC:                     sgp30.PartsPerBillion(tvoc),
+			SensorReadingsNotValidBefore: recorded.Add(acclimatedAfter),
+		}
+	}
+	tests := []struct {
+		name    string
+		history []state.BaselineRecord
+		table   string
+	}{
+		{
+			name:    "no baselines",
+			history: []state.BaselineRecord{},
+			table:   "INDEX  RECORDED  ECO2  TVOC  TEMPERATURE  HUMIDITY  STATUS\n",
+		},
+		{
+			name: "statuses and conditions",
+			history: []state.BaselineRecord{
+				{Baseline: baseline(0x8a2c, 0x8d11, time.Hour), RecordedAt: recorded},
+				{
+					Baseline:   baseline(0x8a40, 0x8d20, -time.Hour),
+					RecordedAt: recorded,
+					Conditions: state.Conditions{TemperatureCelsius: &temperature, RelativeHumidityRatio: &humidity},
+				},
+				{Baseline: baseline(0x9000, 0x9100, -time.Hour), RecordedAt: recorded, Rejected: "deviates 40% from the median"},
+			},
+			table: "INDEX  RECORDED              ECO2    TVOC    TEMPERATURE  HUMIDITY  STATUS\n" +
+				"0      2026-10-01T12:00:00Z  0x8A2C  0x8D11  -            -         acclimating\n" +
+				"1      2026-10-01T12:00:00Z  0x8A40  0x8D20  21.5°C       45.6%     accepted\n" +
+				"2      2026-10-01T12:00:00Z  0x9000  0x9100  -            -         rejected: deviates 40% from the median\n",
+		},
+		{
+			name: "recorded while the clock was not synchronized",
+			history: []state.BaselineRecord{
+				{Baseline: baseline(0x8a2c, 0x8d11, -time.Hour), RecordedAt: recorded, UntrustedTime: true},
+			},
+			table: "INDEX  RECORDED              ECO2    TVOC    TEMPERATURE  HUMIDITY  STATUS\n" +
+				"0      2026-10-01T12:00:00Z  0x8A2C  0x8D11  -            -         accepted, clock not synchronized\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buffer := bytes.Buffer{}
+			err := writeBaselineHistory(&buffer, test.history)
+			if err != nil {
+				t.Fatalf("failed to write history: %v", err)
+			}
+			if buffer.String() != test.table {
+				t.Errorf("got table\n%v\nwant\n%v", buffer.String(), test.table)
+			}
+		})
+	}
+}
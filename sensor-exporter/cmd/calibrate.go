@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sensor-exporter/internal/exporter"
+	"sensor-exporter/internal/state"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	calibrateCmd = &cobra.Command{
+		Use:   "calibrate <sensor> [measurement=offset...]",
+		Short: "show or set the offsets added to the readings of a sensor",
+		Long: `Stores offsets that the exporter adds to the readings of an AHT20 or PMS5003 sensor, in the unit of the
+measurement, e.g. "calibrate aht20 temperature=-1.5 relative_humidity=0.02" for a sensor warmed by the board it is
+mounted on. An offset of 0 removes the calibration of a measurement, and --reset removes all of them. Without
+offsets, prints the calibration of the sensor. The offsets are kept in the state file and applied when the exporter
+next starts.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reset, err := cmd.Flags().GetBool("reset")
+			if err != nil {
+				return err
+			}
+			offsets, err := parseOffsets(args[1:])
+			if err != nil {
+				return err
+			}
+
+			settings, err := readSettings()
+			if err != nil {
+				return err
+			}
+			instances, err := settings.SensorInstances()
+			if err != nil {
+				return err
+			}
+			var instance *exporter.SensorSettings
+			for i := range instances {
+				if instances[i].Name == args[0] {
+					instance = &instances[i]
+				}
+			}
+			if instance == nil {
+				return errors.Errorf("failed to find sensor %v in the configuration", args[0])
+			}
+			err = exporter.ValidateCalibration(instance.Model, offsets)
+			if err != nil {
+				return err
+			}
+
+			store, err := exporter.OpenState(settings, instances)
+			if err != nil {
+				return err
+			}
+			if !reset && len(offsets) == 0 {
+				return writeCalibration(cmd.OutOrStdout(), exporter.StoredCalibration(store, *instance))
+			}
+
+			err = store.Update(exporter.CalibrationKey(*instance), func(sensor *state.Sensor) error {
+				sensor.Model = strings.ToUpper(instance.Model)
+				if reset {
+					sensor.Calibration = nil
+				}
+				for name, offset := range offsets {
+					if sensor.Calibration == nil {
+						sensor.Calibration = map[string]float64{}
+					}
+					sensor.Calibration[name] = offset
+					if offset == 0 {
+						delete(sensor.Calibration, name)
+					}
+				}
+				if len(sensor.Calibration) == 0 {
+					sensor.Calibration = nil
+				}
+				return nil
+			})
+			if err != nil {
+				return errors.Wrap(err, "failed to store calibration")
+			}
+
+			err = writeCalibration(cmd.OutOrStdout(), exporter.StoredCalibration(store, *instance))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "stored calibration of sensor %v in %v; restart the exporter to apply it\n",
+				instance.Name,
+				store.Path())
+			return nil
+		},
+	}
+)
+
+// parseOffsets parses measurement=offset arguments
+func parseOffsets(args []string) (exporter.Calibration, error) {
+	offsets := exporter.Calibration{}
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("failed to parse offset %q; expected measurement=offset", arg)
+		}
+		offset, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse offset of %v", parts[0])
+		}
+		offsets[parts[0]] = offset
+	}
+	return offsets, nil
+}
+
+func writeCalibration(w io.Writer, calibration exporter.Calibration) error {
+	names := []string{}
+	for name := range calibration {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "MEASUREMENT\tOFFSET")
+	for _, name := range names {
+		fmt.Fprintf(table, "%s\t%+g\n", name, calibration[name])
+	}
+	return table.Flush()
+}
+
+func init() {
+	calibrateCmd.Flags().Bool("reset", false, "Remove every offset of the sensor before setting the given ones")
+	rootCmd.AddCommand(calibrateCmd)
+}
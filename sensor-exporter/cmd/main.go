@@ -17,26 +17,47 @@ var (
 		SilenceErrors: true,
 		SilenceUsage:  true,
 		RunE: func(_ *cobra.Command, args []string) error {
-			settings := &exporter.Settings{}
-			err := viper.Unmarshal(settings)
+			settings, err := readSettings()
 			if err != nil {
-				return errors.Wrap(err, "failed to parse settings")
+				return err
 			}
 			log.Info("using settings",
-				"settings", settings)
+				"settings", settings.Redacted())
 
 			return exporter.Execute(settings)
 		},
 	}
 )
 
+// readSettings reads the settings from flags, environment variables and the configuration file, if any
+func readSettings() (*exporter.Settings, error) {
+	configFile := viper.GetString("config")
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+		err := viper.ReadInConfig()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read configuration file %v", configFile)
+		}
+	}
+
+	settings := &exporter.Settings{}
+	err := viper.Unmarshal(settings)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse settings")
+	}
+
+	return settings, nil
+}
+
 func init() {
-	exporter.ConfigureFlags(rootCmd.Flags())
+	rootCmd.PersistentFlags().String("config", "", "Configuration file (YAML, JSON or TOML) listing the sensor instances and other settings")
+	exporter.ConfigureFlags(rootCmd.PersistentFlags())
 
 	viper.SetEnvPrefix("EXPORTER")
 	replacer := strings.NewReplacer("-", "_")
 	viper.SetEnvKeyReplacer(replacer)
 	viper.AutomaticEnv()
+	viper.BindPFlags(rootCmd.PersistentFlags())
 	viper.BindPFlags(rootCmd.Flags())
 }
 
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sensor-exporter/internal/readout"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	readCmd = &cobra.Command{
+		Use:   "read [sensor...]",
+		Short: "take one reading from each configured sensor, or the named sensors, and print it",
+		Long: `Starts the drivers of the configured sensors, waits for a valid reading from each (or averages --count
+readings) and prints them as a table or JSON. Exits non-zero if any sensor fails to produce readings before
+the timeout. Stop the exporter first so that the two do not compete for the sensors.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			count, err := cmd.Flags().GetInt("count")
+			if err != nil {
+				return err
+			}
+			if count < 1 {
+				return errors.Errorf("failed to take %v readings; count must be at least 1", count)
+			}
+			timeout, err := cmd.Flags().GetDuration("timeout")
+			if err != nil {
+				return err
+			}
+			output, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+
+			settings, err := readSettings()
+			if err != nil {
+				return err
+			}
+
+			results, err := readout.Read(context.Background(), settings, args, count, timeout)
+			if err != nil {
+				return err
+			}
+
+			switch output {
+			case "json":
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				err = encoder.Encode(results)
+			case "table":
+				err = writeTable(cmd.OutOrStdout(), results)
+			default:
+				return errors.Errorf("failed to write unknown output format %q", output)
+			}
+			if err != nil {
+				return err
+			}
+
+			failed := 0
+			for _, result := range results {
+				if result.Error != "" {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return errors.Errorf("failed to read %v of %v sensors", failed, len(results))
+			}
+			return nil
+		},
+	}
+)
+
+func writeTable(w io.Writer, results []readout.Result) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "SENSOR\tMODEL\tSERIAL\tMEASUREMENT\tVALUE\tUNIT\tVALID")
+	for _, result := range results {
+		for _, sample := range result.Samples {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%t\n",
+				sample.Sensor,
+				sample.Model,
+				sample.Serial,
+				sample.Measurement,
+				strconv.FormatFloat(sample.Value, 'f', -1, 64),
+				sample.Unit,
+				sample.Valid)
+		}
+	}
+	err := table.Flush()
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if result.Error != "" {
+			fmt.Fprintf(w, "%s: %s\n", result.Sensor, result.Error)
+		}
+	}
+	return nil
+}
+
+func init() {
+	readCmd.Flags().Int("count", 1, "Number of readings to average from each sensor")
+	readCmd.Flags().Duration("timeout", 1*time.Minute, "Duration to wait for readings before giving up")
+	readCmd.Flags().StringP("output", "o", "table", "Output format: table or json")
+	rootCmd.AddCommand(readCmd)
+}
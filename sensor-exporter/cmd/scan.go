@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"sensor-exporter/internal/scan"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	scanCmd = &cobra.Command{
+		Use:   "scan",
+		Short: "discover and identify sensors on the I2C buses and serial ports, printing a configuration snippet",
+		Long: `Probes every /dev/i2c-N bus at the addresses of the supported sensors, looking behind TCA9548A multiplexer
+channels, and listens briefly on candidate serial ports for PMS5003 frames. Stop the exporter before scanning so
+that the two do not compete for the sensors.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			options := scan.Options{}
+			var err error
+			options.Buses, err = cmd.Flags().GetIntSlice("bus")
+			if err != nil {
+				return err
+			}
+			options.Ports, err = cmd.Flags().GetStringSlice("port")
+			if err != nil {
+				return err
+			}
+			options.SerialTimeout, err = cmd.Flags().GetDuration("serial-timeout")
+			if err != nil {
+				return err
+			}
+			options.Muxes, err = cmd.Flags().GetBool("mux")
+			if err != nil {
+				return err
+			}
+
+			found, err := scan.Scan(context.Background(), options)
+			if err != nil {
+				return errors.Wrap(err, "failed to scan for sensors")
+			}
+
+			return scan.WriteConfig(cmd.OutOrStdout(), found)
+		},
+	}
+)
+
+func init() {
+	scanCmd.Flags().IntSlice("bus", nil, "Numbers of the I2C buses to scan (default all /dev/i2c-N buses)")
+	scanCmd.Flags().StringSlice("port", scan.DefaultPorts, "Serial ports to listen on for PMS5003 frames")
+	scanCmd.Flags().Duration("serial-timeout", 3*time.Second, "Duration to listen on each serial port")
+	scanCmd.Flags().Bool("mux", true, "Look for sensors behind the channels of TCA9548A multiplexers")
+	rootCmd.AddCommand(scanCmd)
+}
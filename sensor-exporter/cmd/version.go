@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"sensor-exporter/internal/version"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	versionCmd = &cobra.Command{
+		Use:   "version",
+		Short: "print the version of the exporter",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			info := version.Get()
+			fmt.Fprintf(cmd.OutOrStdout(), "sensor-exporter %s (revision %s, %s)\n", info.Version, info.Revision, info.GoVersion)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+}
@@ -0,0 +1,34 @@
+package i2cbus
+
+import "fmt"
+
+// Address locates a device on a bus, optionally behind a channel of a multiplexer
+type Address struct {
+	// Number of the bus, i.e. N in /dev/i2c-N
+	Bus int
+	// Multiplexer channel the device is attached to, or nil if it is attached to the bus directly
+	Mux *MuxChannel
+	// Address of the device
+	Device uint8
+}
+
+// MuxChannel is a downstream channel of a multiplexer on a bus
+type MuxChannel struct {
+	// Address of the multiplexer on the bus
+	Address uint8
+	// Channel of the multiplexer, from 0 to 7 on the TCA9548A
+	Channel int
+}
+
+// Location formats the address without the bus, e.g. 0x38 or 0x70:2/0x38
+func (a Address) Location() string {
+	if a.Mux == nil {
+		return fmt.Sprintf("0x%02x", a.Device)
+	}
+	return fmt.Sprintf("0x%02x:%d/0x%02x", a.Mux.Address, a.Mux.Channel, a.Device)
+}
+
+// String formats the address for logs and metric labels, e.g. i2c-1/0x38 or i2c-1/0x70:2/0x38
+func (a Address) String() string {
+	return fmt.Sprintf("%s/%s", Name(a.Bus), a.Location())
+}
@@ -0,0 +1,220 @@
+package i2cbus
+
+import (
+	"context"
+	"io"
+	"sensor-exporter/internal/instrumentation"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Channels recorded for a multiplexer besides the one selected
+const (
+	// channelNone is recorded once all channels of a multiplexer are disconnected
+	channelNone = -1
+	// channelUnknown is recorded when the control register of a multiplexer may have changed, e.g. after a failed transaction
+	channelUnknown = -2
+)
+
+// Bus is a single I2C bus on which only one transaction takes place at a time
+type Bus struct {
+	number int
+	port   port
+	lock   chan struct{}
+	refs   int
+	// channel selected on each multiplexer used so far, only accessed while the bus is held
+	selected map[Mux]int
+}
+
+func newBus(number int, port port) *Bus {
+	return &Bus{
+		number:   number,
+		port:     port,
+		lock:     make(chan struct{}, 1),
+		selected: map[Mux]int{},
+	}
+}
+
+// WriteTo writes to any address on the bus; it must only be called while the bus is held
+func (b *Bus) WriteTo(addr uint8, buf []byte) error {
+	err := b.port.setAddress(addr)
+	if err != nil {
+		return errors.Wrapf(err, "failed to address 0x%02x on %v", addr, Name(b.number))
+	}
+
+	n, err := b.port.Write(buf)
+	if err != nil {
+		return err
+	}
+	if n != len(buf) {
+		return errors.Errorf("failed to write %v bytes to 0x%02x on %v (wrote %v)", len(buf), addr, Name(b.number), n)
+	}
+	return nil
+}
+
+// selectChannel connects only the given multiplexer channel to the bus, or no channel at all if the multiplexer is nil. Channels of
+// other multiplexers are disconnected so that devices sharing an address behind different multiplexers, or directly on the bus, do
+// not answer at the same time.
+func (b *Bus) selectChannel(mux Mux, channel int) error {
+	for other, selected := range b.selected {
+		if other == mux || selected == channelNone {
+			continue
+		}
+
+		err := other.Select(b, channelNone)
+		if err != nil {
+			b.selected[other] = channelUnknown
+			return errors.Wrap(err, "failed to disconnect multiplexer")
+		}
+		b.selected[other] = channelNone
+	}
+	if mux == nil {
+		return nil
+	}
+
+	selected, ok := b.selected[mux]
+	if ok && selected == channel {
+		return nil
+	}
+
+	err := mux.Select(b, channel)
+	if err != nil {
+		b.selected[mux] = channelUnknown
+		return err
+	}
+	b.selected[mux] = channel
+	return nil
+}
+
+// forgetChannels records that the control registers of all multiplexers may have changed, so that they are written again before
+// the next transaction
+func (b *Bus) forgetChannels() {
+	for mux := range b.selected {
+		b.selected[mux] = channelUnknown
+	}
+}
+
+func (b *Bus) acquire(ctx context.Context) error {
+	waitStart := time.Now()
+	select {
+	case <-ctx.Done():
+		return io.EOF
+	case b.lock <- struct{}{}:
+	}
+	instrumentation.ObserveI2CBusWait(Name(b.number), time.Since(waitStart))
+	return nil
+}
+
+func (b *Bus) release(busyStart time.Time) {
+	instrumentation.AddI2CBusBusy(Name(b.number), time.Since(busyStart))
+	<-b.lock
+}
+
+// Device is a sensor at an address on a bus
+type Device struct {
+	manager *Manager
+	bus     *Bus
+	driver  string
+	address Address
+	mux     Mux
+}
+
+// String formats the device for logs and metric labels, e.g. i2c-1/0x38
+func (d *Device) String() string {
+	return d.address.String()
+}
+
+// Address returns where the device is attached
+func (d *Device) Address() Address {
+	return d.address
+}
+
+// Driver returns the name of the driver that opened the device
+func (d *Device) Driver() string {
+	return d.driver
+}
+
+// Close releases the device, closing the bus once no other device is using it
+func (d *Device) Close() error {
+	return d.manager.release(d.bus)
+}
+
+// Transaction runs a complete command and response exchange with the device while holding exclusive access to the bus,
+// selecting the multiplexer channel of the device first, or disconnecting all multiplexer channels if the device is attached to
+// the bus directly. It returns io.EOF if the context is done before the bus becomes available.
+func (d *Device) Transaction(ctx context.Context, command string, fn func(tx *Tx) error) error {
+	err := d.bus.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	busyStart := time.Now()
+	defer d.bus.release(busyStart)
+	defer instrumentation.ObserveI2CTransaction(d.driver, d.String(), command)()
+
+	channel := channelNone
+	if d.mux != nil {
+		channel = d.address.Mux.Channel
+	}
+	err = d.bus.selectChannel(d.mux, channel)
+	if err != nil {
+		return errors.Wrapf(err, "failed to select multiplexer channel for %v", d)
+	}
+
+	err = d.bus.port.setAddress(d.address.Device)
+	if err != nil {
+		return errors.Wrapf(err, "failed to address %v", d)
+	}
+
+	err = fn(&Tx{ctx, d})
+	if err != nil && d.mux != nil {
+		// the multiplexer may have been reset along with the device, so select its channel again next time
+		d.bus.selected[d.mux] = channelUnknown
+	}
+	return err
+}
+
+// Tx is exclusive access to a device for the duration of a transaction
+type Tx struct {
+	ctx    context.Context
+	device *Device
+}
+
+// Device returns the device the transaction is addressed to
+func (t *Tx) Device() *Device {
+	return t.device
+}
+
+// Write writes all bytes to the device
+func (t *Tx) Write(buf []byte) error {
+	n, err := t.device.bus.port.Write(buf)
+	if err != nil {
+		return err
+	}
+	if n != len(buf) {
+		return errors.Errorf("failed to write %v bytes to %v (wrote %v)", len(buf), t.device, n)
+	}
+	return nil
+}
+
+// Read fills the buffer from the device
+func (t *Tx) Read(buf []byte) error {
+	n, err := t.device.bus.port.Read(buf)
+	if err != nil {
+		return err
+	}
+	if n != len(buf) {
+		return errors.Errorf("failed to read %v bytes from %v (read %v)", len(buf), t.device, n)
+	}
+	return nil
+}
+
+// Sleep waits for the device to process a command without releasing the bus. It returns io.EOF if the context is done first.
+func (t *Tx) Sleep(duration time.Duration) error {
+	select {
+	case <-t.ctx.Done():
+		return io.EOF
+	case <-time.After(duration):
+		return nil
+	}
+}
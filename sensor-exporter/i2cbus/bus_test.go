@@ -0,0 +1,135 @@
+package i2cbus
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// fakePort accepts every transfer without touching hardware
+type fakePort struct{}
+
+func (p *fakePort) setAddress(addr uint8) error   { return nil }
+func (p *fakePort) Read(buf []byte) (int, error)  { return len(buf), nil }
+func (p *fakePort) Write(buf []byte) (int, error) { return len(buf), nil }
+func (p *fakePort) Close() error                  { return nil }
+
+func newFakeManager(muxes map[uint8]*FakeMux) *Manager {
+	manager := NewManager()
+	manager.open = func(number int) (port, error) {
+		return &fakePort{}, nil
+	}
+	for addr, mux := range muxes {
+		manager.RegisterMux(1, addr, mux)
+	}
+	return manager
+}
+
+func address(mux *MuxChannel, device uint8) Address {
+	return Address{Bus: 1, Mux: mux, Device: device}
+}
+
+func channel(addr uint8, number int) *MuxChannel {
+	return &MuxChannel{Address: addr, Channel: number}
+}
+
+func succeed(tx *Tx) error {
+	return nil
+}
+
+func fail(tx *Tx) error {
+	return errors.New("failed to read")
+}
+
+func TestTransactionSelectsChannels(t *testing.T) {
+	type step struct {
+		address Address
+		fn      func(tx *Tx) error
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		// channels selected on the multiplexers at 0x70 and 0x71, in order
+		selections70 []int
+		selections71 []int
+	}{
+		{
+			name:         "channel selected once for consecutive transactions",
+			steps:        []step{{address(channel(0x70, 2), 0x38), succeed}, {address(channel(0x70, 2), 0x38), succeed}},
+			selections70: []int{2},
+		},
+		{
+			name:         "channel switched between devices on one multiplexer",
+			steps:        []step{{address(channel(0x70, 2), 0x38), succeed}, {address(channel(0x70, 5), 0x38), succeed}},
+			selections70: []int{2, 5},
+		},
+		{
+			name:         "other multiplexer disconnected",
+			steps:        []step{{address(channel(0x70, 2), 0x38), succeed}, {address(channel(0x71, 0), 0x38), succeed}},
+			selections70: []int{2, -1},
+			selections71: []int{0},
+		},
+		{
+			name: "all multiplexers disconnected for a direct device",
+			steps: []step{
+				{address(channel(0x70, 2), 0x38), succeed},
+				{address(channel(0x71, 3), 0x58), succeed},
+				{address(nil, 0x38), succeed},
+			},
+			selections70: []int{2, -1},
+			selections71: []int{3, -1},
+		},
+		{
+			name:         "direct device without selected channels",
+			steps:        []step{{address(nil, 0x38), succeed}, {address(nil, 0x38), succeed}},
+			selections70: nil,
+		},
+		{
+			name:         "channel selected again after a failed transaction",
+			steps:        []step{{address(channel(0x70, 2), 0x38), fail}, {address(channel(0x70, 2), 0x38), succeed}},
+			selections70: []int{2, 2},
+		},
+		{
+			name: "multiplexer in an unknown state disconnected for a direct device",
+			steps: []step{
+				{address(channel(0x70, 2), 0x38), fail},
+				{address(nil, 0x38), succeed},
+				{address(nil, 0x38), succeed},
+			},
+			selections70: []int{2, -1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mux70, mux71 := NewFakeMux(), NewFakeMux()
+			manager := newFakeManager(map[uint8]*FakeMux{0x70: mux70, 0x71: mux71})
+
+			// the devices stay open so that the bus, and what is selected on it, is kept between transactions
+			for _, step := range test.steps {
+				device, err := manager.Open("test", step.address)
+				if err != nil {
+					t.Fatalf("failed to open %v: %v", step.address, err)
+				}
+				defer device.Close()
+				_ = device.Transaction(context.Background(), "test", step.fn)
+			}
+
+			assertSelections(t, "0x70", mux70.Selections(), test.selections70)
+			assertSelections(t, "0x71", mux71.Selections(), test.selections71)
+		})
+	}
+}
+
+func assertSelections(t *testing.T, mux string, got []int, want []int) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("multiplexer %v selected %v, want %v", mux, got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("multiplexer %v selected %v, want %v", mux, got, want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Package i2cbus shares the I2C buses of the host between sensor drivers, serializing complete command and response transactions
+package i2cbus
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Manager owns the I2C buses of the host, opening each bus once no matter how many devices are attached to it
+type Manager struct {
+	mu    sync.Mutex
+	buses map[int]*Bus
+	muxes map[int]map[uint8]Mux
+	open  func(number int) (port, error)
+}
+
+// NewManager creates a manager of the /dev/i2c-N buses of the host
+func NewManager() *Manager {
+	return &Manager{
+		buses: map[int]*Bus{},
+		muxes: map[int]map[uint8]Mux{},
+		open:  openDevPort,
+	}
+}
+
+// RegisterMux sets the multiplexer at an address on a bus. Multiplexers that are not registered are assumed to be a TCA9548A.
+func (m *Manager) RegisterMux(number int, addr uint8, mux Mux) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.mux(number, addr)
+	m.muxes[number][addr] = mux
+}
+
+func (m *Manager) mux(number int, addr uint8) Mux {
+	if m.muxes[number] == nil {
+		m.muxes[number] = map[uint8]Mux{}
+	}
+
+	mux, ok := m.muxes[number][addr]
+	if !ok {
+		mux = &TCA9548A{addr}
+		m.muxes[number][addr] = mux
+	}
+	return mux
+}
+
+// Open returns the device at an address, opening the bus if no other device is using it. The device must be closed when no longer in use.
+func (m *Manager) Open(driver string, address Address) (*Device, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bus, ok := m.buses[address.Bus]
+	if !ok {
+		port, err := m.open(address.Bus)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open I2C bus %v", address.Bus)
+		}
+
+		bus = newBus(address.Bus, port)
+		m.buses[address.Bus] = bus
+	}
+	bus.refs++
+
+	device := &Device{
+		manager: m,
+		bus:     bus,
+		driver:  driver,
+		address: address,
+	}
+	if address.Mux != nil {
+		device.mux = m.mux(address.Bus, address.Mux.Address)
+	}
+	return device, nil
+}
+
+func (m *Manager) release(bus *Bus) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bus.refs--
+	if bus.refs > 0 {
+		return nil
+	}
+
+	delete(m.buses, bus.number)
+	return bus.port.Close()
+}
+
+// Name formats the name of a bus, e.g. i2c-1
+func Name(number int) string {
+	return fmt.Sprintf("i2c-%d", number)
+}
@@ -0,0 +1,112 @@
+package i2cbus
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Mux is an I2C multiplexer that connects its downstream channels to the bus
+type Mux interface {
+	// Select connects only the given channel to the bus, or disconnects all channels if the channel is negative
+	Select(bus Writer, channel int) error
+}
+
+// Writer writes to any address on a bus while the bus is held by a transaction
+type Writer interface {
+	WriteTo(addr uint8, buf []byte) error
+}
+
+// TCA9548A is the Texas Instruments 8-channel I2C multiplexer
+type TCA9548A struct {
+	Address uint8
+}
+
+func (m *TCA9548A) Select(bus Writer, channel int) error {
+	if channel > 7 {
+		return errors.Errorf("failed to select channel %v of TCA9548A at 0x%02x; channels range from 0 to 7", channel, m.Address)
+	}
+
+	var control byte
+	if channel >= 0 {
+		control = 1 << channel
+	}
+	return bus.WriteTo(m.Address, []byte{control})
+}
+
+// FakeMux records channel selections without touching the bus, so that multiplexed configurations can be exercised without hardware
+type FakeMux struct {
+	mu         sync.Mutex
+	selected   int
+	selections []int
+}
+
+func NewFakeMux() *FakeMux {
+	return &FakeMux{selected: -1}
+}
+
+func (m *FakeMux) Select(_ Writer, channel int) error {
+	if channel > 7 {
+		return errors.Errorf("failed to select channel %v of fake multiplexer", channel)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.selected = channel
+	m.selections = append(m.selections, channel)
+	return nil
+}
+
+// Selected returns the currently selected channel, or -1 if no channel is selected
+func (m *FakeMux) Selected() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.selected
+}
+
+// Selections returns every channel selected so far, in order
+func (m *FakeMux) Selections() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]int{}, m.selections...)
+}
+
+// ProbeTCA9548A checks whether the device answers like a TCA9548A by writing to its control register and reading it back.
+// The control register is first written with the value read from it, which leaves a TCA9548A as it was, and only if that
+// value reads back are the channels switched, so that another device at the address, such as a BME280 at 0x76, is not
+// sent commands it could act on. All channels of the multiplexer are disconnected afterwards.
+func ProbeTCA9548A(ctx context.Context, device *Device) (bool, error) {
+	isMux := false
+	err := device.Transaction(ctx, "probe_tca9548a", func(tx *Tx) error {
+		buf := make([]byte, 1)
+		err := tx.Read(buf)
+		if err != nil {
+			return err
+		}
+
+		// the control register may change from here on, so forget what is selected on the bus
+		device.bus.forgetChannels()
+
+		for _, control := range []byte{buf[0], 0b00000101, 0b00000000} {
+			err := tx.Write([]byte{control})
+			if err != nil {
+				return err
+			}
+
+			err = tx.Read(buf)
+			if err != nil {
+				return err
+			}
+			if buf[0] != control {
+				return nil
+			}
+		}
+		isMux = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return isMux, nil
+}
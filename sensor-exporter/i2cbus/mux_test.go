@@ -0,0 +1,89 @@
+package i2cbus
+
+import (
+	"context"
+	"testing"
+)
+
+// registerPort answers reads with a register chosen by the last byte written, like a TCA9548A with an identity register
+// map or a sensor with a register pointer
+type registerPort struct {
+	fakePort
+	registers func(pointer byte) byte
+	pointer   byte
+	writes    []byte
+}
+
+func (p *registerPort) Read(buf []byte) (int, error) {
+	buf[0] = p.registers(p.pointer)
+	return len(buf), nil
+}
+
+func (p *registerPort) Write(buf []byte) (int, error) {
+	p.pointer = buf[0]
+	p.writes = append(p.writes, buf[0])
+	return len(buf), nil
+}
+
+func TestProbeTCA9548A(t *testing.T) {
+	tests := []struct {
+		name      string
+		pointer   byte
+		registers func(pointer byte) byte
+		isMux     bool
+		writes    []byte
+	}{
+		{
+			name:      "multiplexer with no channel selected",
+			registers: func(pointer byte) byte { return pointer },
+			isMux:     true,
+			writes:    []byte{0x00, 0x05, 0x00},
+		},
+		{
+			name:      "multiplexer with a channel selected",
+			pointer:   0x08,
+			registers: func(pointer byte) byte { return pointer },
+			isMux:     true,
+			writes:    []byte{0x08, 0x05, 0x00},
+		},
+		{
+			name:      "device with a register pointer",
+			pointer:   0xd0,
+			registers: func(pointer byte) byte { return map[byte]byte{0xd0: 0x60}[pointer] },
+			isMux:     false,
+			writes:    []byte{0x60},
+		},
+		{
+			name:      "device that ignores writes",
+			registers: func(pointer byte) byte { return 0xff },
+			isMux:     false,
+			writes:    []byte{0xff, 0x05},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &registerPort{registers: test.registers, pointer: test.pointer}
+			manager := NewManager()
+			manager.open = func(number int) (port, error) {
+				return fake, nil
+			}
+			device, err := manager.Open("test", Address{Bus: 1, Device: 0x70})
+			if err != nil {
+				t.Fatalf("failed to open device: %v", err)
+			}
+			defer device.Close()
+
+			isMux, err := ProbeTCA9548A(context.Background(), device)
+			if err != nil {
+				t.Fatalf("failed to probe: %v", err)
+			}
+			if isMux != test.isMux {
+				t.Errorf("got multiplexer %v, want %v", isMux, test.isMux)
+			}
+			if string(fake.writes) != string(test.writes) {
+				t.Errorf("wrote %x, want %x", fake.writes, test.writes)
+			}
+		})
+	}
+}
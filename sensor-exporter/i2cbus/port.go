@@ -0,0 +1,50 @@
+package i2cbus
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// port is the low level access to an I2C bus
+type port interface {
+	setAddress(addr uint8) error
+	Read(buf []byte) (int, error)
+	Write(buf []byte) (int, error)
+	Close() error
+}
+
+// I2C_SLAVE ioctl from linux/i2c-dev.h
+const ioctlSlave = 0x0703
+
+// devPort accesses a bus through the Linux i2c-dev interface
+type devPort struct {
+	*os.File
+	addr       uint8
+	hasAddress bool
+}
+
+func openDevPort(number int) (port, error) {
+	file, err := os.OpenFile(fmt.Sprintf("/dev/i2c-%d", number), os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &devPort{File: file}, nil
+}
+
+func (p *devPort) setAddress(addr uint8) error {
+	if p.hasAddress && p.addr == addr {
+		return nil
+	}
+
+	err := unix.IoctlSetInt(int(p.Fd()), ioctlSlave, int(addr))
+	if err != nil {
+		return err
+	}
+
+	p.addr = addr
+	p.hasAddress = true
+	return nil
+}
@@ -0,0 +1,297 @@
+// Package aggregate summarizes the samples of every measurement over fixed windows, so that short spikes between
+// scrapes are not lost
+package aggregate
+
+import (
+	"context"
+	"math"
+	"sensor-exporter/internal/measurement"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+const (
+	// closeInterval is how often windows that have ended without a later sample are closed
+	closeInterval = time.Second
+	// maxValues bounds the values a window keeps for its percentiles, so that long windows over fast measurements, such
+	// as the raw signals of an SGP30, do not hold every sample
+	maxValues = 4096
+)
+
+// Summary describes the valid samples of a measurement over a window
+type Summary struct {
+	measurement.Source
+	Measurement string `json:"measurement"`
+	Unit        string `json:"unit"`
+	// Length of the window, e.g. 10s, 1m or 1h30m
+	Window string `json:"window"`
+	// Start and end of the window, which are aligned to its length
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Count  int       `json:"count"`
+	Min    float64   `json:"min"`
+	Max    float64   `json:"max"`
+	Mean   float64   `json:"mean"`
+	Stddev float64   `json:"stddev"`
+	P50    float64   `json:"p50"`
+	P95    float64   `json:"p95"`
+}
+
+type key struct {
+	sensor      string
+	measurement string
+	window      time.Duration
+}
+
+// accumulator collects the values of a measurement in the current window. The count, minimum, maximum, mean and
+// standard deviation are exact; the percentiles are computed from every stride-th value, where the stride doubles
+// whenever maxValues are kept, so that the kept values stay spread evenly over the window.
+type accumulator struct {
+	source measurement.Source
+	unit   string
+	start  time.Time
+
+	count int
+	min   float64
+	max   float64
+	mean  float64
+	// Sum of the squared differences from the mean, updated with Welford's method
+	squares float64
+
+	values  []float64
+	stride  int
+	skipped int
+}
+
+func newAccumulator(start time.Time) *accumulator {
+	return &accumulator{start: start, stride: 1}
+}
+
+func (c *accumulator) add(value float64) {
+	if c.count == 0 || value < c.min {
+		c.min = value
+	}
+	if c.count == 0 || value > c.max {
+		c.max = value
+	}
+	c.count++
+	delta := value - c.mean
+	c.mean += delta / float64(c.count)
+	c.squares += delta * (value - c.mean)
+
+	c.skipped++
+	if c.skipped < c.stride {
+		return
+	}
+	c.skipped = 0
+	c.values = append(c.values, value)
+	if len(c.values) < maxValues {
+		return
+	}
+	for i := 0; i < len(c.values)/2; i++ {
+		c.values[i] = c.values[2*i]
+	}
+	c.values = c.values[:len(c.values)/2]
+	c.stride *= 2
+}
+
+// Aggregator summarizes samples over each configured window and hands the summaries of windows that have ended to its
+// subscribers
+type Aggregator struct {
+	windows []time.Duration
+
+	mu           sync.Mutex
+	accumulators map[key]*accumulator
+	latest       map[key]Summary
+	subscribers  []chan []Summary
+}
+
+// NewAggregator returns an aggregator over the given windows
+func NewAggregator(windows []time.Duration) *Aggregator {
+	return &Aggregator{
+		windows:      windows,
+		accumulators: map[key]*accumulator{},
+		latest:       map[key]Summary{},
+	}
+}
+
+// Subscribe returns a channel receiving the summaries of each window as it ends. Summaries are dropped for a
+// subscriber whose buffer is full.
+func (a *Aggregator) Subscribe(buffer int) <-chan []Summary {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	subscriber := make(chan []Summary, buffer)
+	a.subscribers = append(a.subscribers, subscriber)
+	return subscriber
+}
+
+// Start aggregates the samples received until the context is done
+func (a *Aggregator) Start(ctx context.Context, samples <-chan []measurement.Sample) func() error {
+	return func() error {
+		windows := []string{}
+		for _, window := range a.windows {
+			windows = append(windows, FormatWindow(window))
+		}
+		log.Info("aggregating samples over windows",
+			"windows", strings.Join(windows, ","))
+
+		ticker := time.NewTicker(closeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case batch, ok := <-samples:
+				if !ok {
+					return nil
+				}
+				a.add(batch)
+			case now := <-ticker.C:
+				a.closeEnded(now)
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// add adds the valid samples to the windows they fall into, closing windows that have ended
+func (a *Aggregator) add(batch []measurement.Sample) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	closed := []Summary{}
+	for _, sample := range batch {
+		if !sample.Valid || math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+			continue
+		}
+		for _, window := range a.windows {
+			k := key{sample.Sensor, sample.Measurement, window}
+			start := sample.Time.Truncate(window)
+			current, ok := a.accumulators[k]
+			if ok && !current.start.Equal(start) {
+				closed = append(closed, a.close(k, current))
+				ok = false
+			}
+			if !ok {
+				current = newAccumulator(start)
+				a.accumulators[k] = current
+			}
+			current.source = sample.Source
+			current.unit = sample.Unit
+			current.add(sample.Value)
+		}
+	}
+	a.publish(closed)
+}
+
+// closeEnded closes the windows that have ended without a sample in a later window
+func (a *Aggregator) closeEnded(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	closed := []Summary{}
+	for k, current := range a.accumulators {
+		if !current.start.Add(k.window).After(now) {
+			closed = append(closed, a.close(k, current))
+		}
+	}
+	sortSummaries(closed)
+	a.publish(closed)
+}
+
+// close summarizes a window and forgets its values
+func (a *Aggregator) close(k key, current *accumulator) Summary {
+	delete(a.accumulators, k)
+	summary := current.summarize()
+	summary.Source = current.source
+	summary.Measurement = k.measurement
+	summary.Unit = current.unit
+	summary.Window = FormatWindow(k.window)
+	summary.Start = current.start
+	summary.End = current.start.Add(k.window)
+	a.latest[k] = summary
+	return summary
+}
+
+func (a *Aggregator) publish(summaries []Summary) {
+	if len(summaries) == 0 {
+		return
+	}
+	for _, subscriber := range a.subscribers {
+		select {
+		case subscriber <- summaries:
+		default:
+			droppedSummaries.Add(float64(len(summaries)))
+		}
+	}
+}
+
+// Latest returns the summary of the last window that ended for each sensor, measurement and window, sorted by sensor,
+// measurement and window. Summaries that ended more than two windows ago are left out, as their sensor stopped
+// producing samples.
+func (a *Aggregator) Latest(now time.Time) []Summary {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	summaries := []Summary{}
+	for k, summary := range a.latest {
+		if now.Sub(summary.End) > 2*k.window {
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	sortSummaries(summaries)
+	return summaries
+}
+
+// sortSummaries sorts summaries by sensor, measurement and length of the window
+func sortSummaries(summaries []Summary) {
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Sensor != summaries[j].Sensor {
+			return summaries[i].Sensor < summaries[j].Sensor
+		}
+		if summaries[i].Measurement != summaries[j].Measurement {
+			return summaries[i].Measurement < summaries[j].Measurement
+		}
+		return summaries[i].End.Sub(summaries[i].Start) < summaries[j].End.Sub(summaries[j].Start)
+	})
+}
+
+// FormatWindow formats the length of a window without trailing zero units, e.g. 1m rather than 1m0s
+func FormatWindow(window time.Duration) string {
+	formatted := window.String()
+	if strings.HasSuffix(formatted, "m0s") {
+		formatted = strings.TrimSuffix(formatted, "0s")
+	}
+	if strings.HasSuffix(formatted, "h0m") {
+		formatted = strings.TrimSuffix(formatted, "0m")
+	}
+	return formatted
+}
+
+// summarize computes the statistics of the values of a window, with the population standard deviation and
+// percentiles interpolated between the closest ranks of the kept values
+func (c *accumulator) summarize() Summary {
+	sorted := append([]float64{}, c.values...)
+	sort.Float64s(sorted)
+
+	return Summary{
+		Count:  c.count,
+		Min:    c.min,
+		Max:    c.max,
+		Mean:   c.mean,
+		Stddev: math.Sqrt(c.squares / float64(c.count)),
+		P50:    percentile(sorted, 0.5),
+		P95:    percentile(sorted, 0.95),
+	}
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	rank := p * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
@@ -0,0 +1,118 @@
+package aggregate
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	sequence := func(n int) []float64 {
+		values := []float64{}
+		for i := 0; i < n; i++ {
+			values = append(values, float64(i))
+		}
+		return values
+	}
+	tests := []struct {
+		name   string
+		values []float64
+		want   Summary
+		// Largest difference from the wanted percentiles, which are estimated beyond maxValues
+		tolerance float64
+	}{
+		{
+			name:   "single value",
+			values: []float64{21.5},
+			want:   Summary{Count: 1, Min: 21.5, Max: 21.5, Mean: 21.5, Stddev: 0, P50: 21.5, P95: 21.5},
+		},
+		{
+			name:   "unsorted values",
+			values: []float64{4, 1, 3, 2},
+			want:   Summary{Count: 4, Min: 1, Max: 4, Mean: 2.5, Stddev: math.Sqrt(1.25), P50: 2.5, P95: 3.85},
+		},
+		{
+			name:   "interpolated between ranks",
+			values: []float64{10, 20},
+			want:   Summary{Count: 2, Min: 10, Max: 20, Mean: 15, Stddev: 5, P50: 15, P95: 19.5},
+		},
+		{
+			name:   "repeated values",
+			values: []float64{7, 7, 7, 7, 7},
+			want:   Summary{Count: 5, Min: 7, Max: 7, Mean: 7, Stddev: 0, P50: 7, P95: 7},
+		},
+		{
+			name:   "negative values",
+			values: []float64{-40, -10, 0, 85},
+			want:   Summary{Count: 4, Min: -40, Max: 85, Mean: 8.75, Stddev: math.Sqrt(2154.6875), P50: -5, P95: 72.25},
+		},
+		{
+			name:   "all values kept up to the limit",
+			values: sequence(maxValues - 1),
+			want: Summary{
+				Count: maxValues - 1, Min: 0, Max: maxValues - 2, Mean: float64(maxValues-2) / 2,
+				Stddev: math.Sqrt(float64((maxValues-1)*(maxValues-1)-1) / 12),
+				P50:    float64(maxValues-2) / 2, P95: 0.95 * float64(maxValues-2),
+			},
+		},
+		{
+			name:   "percentiles estimated beyond the limit",
+			values: sequence(100000),
+			want: Summary{
+				Count: 100000, Min: 0, Max: 99999, Mean: 49999.5,
+				Stddev: math.Sqrt(float64(100000*100000-1) / 12),
+				P50:    49999.5, P95: 94999.05,
+			},
+			tolerance: 100,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newAccumulator(time.Time{})
+			for _, value := range test.values {
+				c.add(value)
+			}
+			if len(c.values) >= maxValues {
+				t.Errorf("kept %v values, want fewer than %v", len(c.values), maxValues)
+			}
+
+			got := c.summarize()
+			near := func(a, b, tolerance float64) bool {
+				return math.Abs(a-b) <= tolerance+1e-9*math.Max(1, math.Abs(b))
+			}
+			if got.Count != test.want.Count || got.Min != test.want.Min || got.Max != test.want.Max ||
+				!near(got.Mean, test.want.Mean, 0) || !near(got.Stddev, test.want.Stddev, 0) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+			if !near(got.P50, test.want.P50, test.tolerance) || !near(got.P95, test.want.P95, test.tolerance) {
+				t.Errorf("got percentiles %v and %v, want %v and %v", got.P50, got.P95, test.want.P50, test.want.P95)
+			}
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		name   string
+		sorted []float64
+		p      float64
+		want   float64
+	}{
+		{"single value", []float64{3}, 0.95, 3},
+		{"minimum", []float64{1, 2, 3}, 0, 1},
+		{"maximum", []float64{1, 2, 3}, 1, 3},
+		{"exact rank", []float64{1, 2, 3}, 0.5, 2},
+		{"between ranks", []float64{1, 2, 3, 4}, 0.5, 2.5},
+		{"close to the top", []float64{0, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100}, 0.95, 95},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := percentile(test.sorted, test.p)
+			if math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
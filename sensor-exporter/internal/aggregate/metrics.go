@@ -0,0 +1,81 @@
+package aggregate
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	droppedSummaries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_aggregate_dropped_summaries_total",
+			Help: "Number of window summaries not handed to an output because it fell behind",
+		},
+	)
+
+	summaryLabelNames = []string{"sensor", "model", "serial", "measurement", "unit", "window"}
+
+	windowSamplesDesc = prometheus.NewDesc(
+		"sensor_window_samples",
+		"Number of valid samples in the last completed window",
+		summaryLabelNames, nil,
+	)
+	windowMinDesc = prometheus.NewDesc(
+		"sensor_window_min",
+		"Lowest valid sample in the last completed window",
+		summaryLabelNames, nil,
+	)
+	windowMaxDesc = prometheus.NewDesc(
+		"sensor_window_max",
+		"Highest valid sample in the last completed window",
+		summaryLabelNames, nil,
+	)
+	windowMeanDesc = prometheus.NewDesc(
+		"sensor_window_mean",
+		"Mean of the valid samples in the last completed window",
+		summaryLabelNames, nil,
+	)
+	windowStddevDesc = prometheus.NewDesc(
+		"sensor_window_stddev",
+		"Population standard deviation of the valid samples in the last completed window",
+		summaryLabelNames, nil,
+	)
+	windowQuantileDesc = prometheus.NewDesc(
+		"sensor_window_quantile",
+		"Quantile of the valid samples in the last completed window",
+		append(append([]string{}, summaryLabelNames...), "quantile"), nil,
+	)
+)
+
+// Collectors returns the metrics of the aggregator for registration, besides the aggregator itself
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		droppedSummaries,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (a *Aggregator) Describe(descs chan<- *prometheus.Desc) {
+	descs <- windowSamplesDesc
+	descs <- windowMinDesc
+	descs <- windowMaxDesc
+	descs <- windowMeanDesc
+	descs <- windowStddevDesc
+	descs <- windowQuantileDesc
+}
+
+// Collect implements prometheus.Collector with the summaries of the last completed windows, which are not exposed
+// once the sensor stops producing samples
+func (a *Aggregator) Collect(metrics chan<- prometheus.Metric) {
+	for _, summary := range a.Latest(time.Now()) {
+		labels := []string{summary.Sensor, summary.Model, summary.Serial, summary.Measurement, summary.Unit, summary.Window}
+		metrics <- prometheus.MustNewConstMetric(windowSamplesDesc, prometheus.GaugeValue, float64(summary.Count), labels...)
+		metrics <- prometheus.MustNewConstMetric(windowMinDesc, prometheus.GaugeValue, summary.Min, labels...)
+		metrics <- prometheus.MustNewConstMetric(windowMaxDesc, prometheus.GaugeValue, summary.Max, labels...)
+		metrics <- prometheus.MustNewConstMetric(windowMeanDesc, prometheus.GaugeValue, summary.Mean, labels...)
+		metrics <- prometheus.MustNewConstMetric(windowStddevDesc, prometheus.GaugeValue, summary.Stddev, labels...)
+		metrics <- prometheus.MustNewConstMetric(windowQuantileDesc, prometheus.GaugeValue, summary.P50, append(labels, "0.5")...)
+		metrics <- prometheus.MustNewConstMetric(windowQuantileDesc, prometheus.GaugeValue, summary.P95, append(labels, "0.95")...)
+	}
+}
@@ -0,0 +1,82 @@
+// Package aqi computes the US EPA Air Quality Index of particulate matter from the hourly averages of PMS5003
+// readings, as the NowCast of the last 12 hours and as the average of the last 24 hours
+package aqi
+
+import "math"
+
+// Pollutants the index is computed for
+const (
+	PM25 = "pm2_5"
+	PM10 = "pm10"
+)
+
+// Categories of the index, named as in the EPA technical assistance document
+const (
+	CategoryGood                        = "Good"
+	CategoryModerate                    = "Moderate"
+	CategoryUnhealthyForSensitiveGroups = "Unhealthy for Sensitive Groups"
+	CategoryUnhealthy                   = "Unhealthy"
+	CategoryVeryUnhealthy               = "Very Unhealthy"
+	CategoryHazardous                   = "Hazardous"
+)
+
+// breakpoint maps a range of concentrations, in µg/m³, linearly onto a range of the index
+type breakpoint struct {
+	concentrationLow  float64
+	concentrationHigh float64
+	indexLow          float64
+	indexHigh         float64
+	category          string
+}
+
+// breakpoints are the tables of the 2024 revision of the PM2.5 standard, which lowered the upper end of the Good
+// category from 12.0 to 9.0 µg/m³ and merged the Hazardous ranges of both pollutants into one spanning 301 to 500
+var breakpoints = map[string][]breakpoint{
+	PM25: {
+		{0.0, 9.0, 0, 50, CategoryGood},
+		{9.1, 35.4, 51, 100, CategoryModerate},
+		{35.5, 55.4, 101, 150, CategoryUnhealthyForSensitiveGroups},
+		{55.5, 125.4, 151, 200, CategoryUnhealthy},
+		{125.5, 225.4, 201, 300, CategoryVeryUnhealthy},
+		{225.5, 325.4, 301, 500, CategoryHazardous},
+	},
+	PM10: {
+		{0, 54, 0, 50, CategoryGood},
+		{55, 154, 51, 100, CategoryModerate},
+		{155, 254, 101, 150, CategoryUnhealthyForSensitiveGroups},
+		{255, 354, 151, 200, CategoryUnhealthy},
+		{355, 424, 201, 300, CategoryVeryUnhealthy},
+		{425, 604, 301, 500, CategoryHazardous},
+	},
+}
+
+// Truncate truncates a concentration to the precision of the breakpoints of the pollutant: one decimal for PM2.5 and
+// whole µg/m³ for PM10. Negative concentrations are truncated to 0.
+func Truncate(pollutant string, concentration float64) float64 {
+	if concentration < 0 {
+		return 0
+	}
+	// The epsilon keeps values such as 2.3, which is slightly below 2.3 in binary, from truncating to 2.2
+	if pollutant == PM25 {
+		return math.Floor(concentration*10+1e-9) / 10
+	}
+	return math.Floor(concentration + 1e-9)
+}
+
+// Calculate returns the index of a concentration of a pollutant and its category. Concentrations beyond the highest
+// breakpoint are extrapolated along the Hazardous range, giving indexes above 500.
+func Calculate(pollutant string, concentration float64) (int, string) {
+	table := breakpoints[pollutant]
+	concentration = Truncate(pollutant, concentration)
+
+	selected := table[len(table)-1]
+	for _, b := range table {
+		if concentration <= b.concentrationHigh {
+			selected = b
+			break
+		}
+	}
+	index := (selected.indexHigh-selected.indexLow)/(selected.concentrationHigh-selected.concentrationLow)*
+		(concentration-selected.concentrationLow) + selected.indexLow
+	return int(math.Round(index)), selected.category
+}
@@ -0,0 +1,66 @@
+package aqi
+
+import "testing"
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name          string
+		pollutant     string
+		concentration float64
+		want          float64
+	}{
+		{"PM2.5 to one decimal", PM25, 12.38, 12.3},
+		{"PM2.5 already truncated", PM25, 2.3, 2.3},
+		{"PM2.5 just below a decimal in binary", PM25, 0.1 + 0.2, 0.3},
+		{"PM10 to whole units", PM10, 54.99, 54},
+		{"PM10 already truncated", PM10, 155, 155},
+		{"negative", PM25, -0.4, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Truncate(test.pollutant, test.concentration)
+			if got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		name          string
+		pollutant     string
+		concentration float64
+		index         int
+		category      string
+	}{
+		{"PM2.5 zero", PM25, 0, 0, CategoryGood},
+		{"PM2.5 top of good", PM25, 9.0, 50, CategoryGood},
+		{"PM2.5 between breakpoints truncated into good", PM25, 9.05, 50, CategoryGood},
+		{"PM2.5 bottom of moderate", PM25, 9.1, 51, CategoryModerate},
+		{"PM2.5 former top of good", PM25, 12.0, 56, CategoryModerate},
+		{"PM2.5 top of moderate", PM25, 35.4, 100, CategoryModerate},
+		{"PM2.5 bottom of unhealthy for sensitive groups", PM25, 35.5, 101, CategoryUnhealthyForSensitiveGroups},
+		{"PM2.5 unhealthy", PM25, 100, 182, CategoryUnhealthy},
+		{"PM2.5 very unhealthy", PM25, 150, 225, CategoryVeryUnhealthy},
+		{"PM2.5 bottom of hazardous", PM25, 225.5, 301, CategoryHazardous},
+		{"PM2.5 top of hazardous", PM25, 325.4, 500, CategoryHazardous},
+		{"PM2.5 extrapolated beyond the table", PM25, 500, 848, CategoryHazardous},
+		{"PM10 top of good", PM10, 54.9, 50, CategoryGood},
+		{"PM10 bottom of moderate", PM10, 55, 51, CategoryModerate},
+		{"PM10 moderate", PM10, 100, 73, CategoryModerate},
+		{"PM10 unhealthy", PM10, 300, 173, CategoryUnhealthy},
+		{"PM10 top of hazardous", PM10, 604, 500, CategoryHazardous},
+		{"PM10 extrapolated beyond the table", PM10, 700, 607, CategoryHazardous},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			index, category := Calculate(test.pollutant, test.concentration)
+			if index != test.index || category != test.category {
+				t.Errorf("got %v (%v), want %v (%v)", index, category, test.index, test.category)
+			}
+		})
+	}
+}
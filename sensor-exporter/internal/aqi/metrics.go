@@ -0,0 +1,72 @@
+package aqi
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	indexLabelNames = []string{"sensor", "model", "serial", "period"}
+
+	valueDesc = prometheus.NewDesc(
+		"aqi_value",
+		"US EPA Air Quality Index of particulate matter, over the NowCast or the last 24 hours",
+		indexLabelNames, nil,
+	)
+	categoryDesc = prometheus.NewDesc(
+		"aqi_category",
+		"Always 1; the category label is the category of the index",
+		append(append([]string{}, indexLabelNames...), "category"), nil,
+	)
+	dominantPollutantDesc = prometheus.NewDesc(
+		"aqi_dominant_pollutant",
+		"Always 1; the pollutant label is the pollutant with the highest index",
+		append(append([]string{}, indexLabelNames...), "pollutant"), nil,
+	)
+	pollutantValueDesc = prometheus.NewDesc(
+		"aqi_pollutant_value",
+		"Air Quality Index of one pollutant",
+		append(append([]string{}, indexLabelNames...), "pollutant"), nil,
+	)
+	concentrationDesc = prometheus.NewDesc(
+		"aqi_concentration_micrograms_per_cubic_meter",
+		"NowCast or 24-hour average concentration of a pollutant the index is computed from",
+		append(append([]string{}, indexLabelNames...), "pollutant"), nil,
+	)
+)
+
+// Describe implements prometheus.Collector
+func (m *Monitor) Describe(descs chan<- *prometheus.Desc) {
+	descs <- valueDesc
+	descs <- categoryDesc
+	descs <- dominantPollutantDesc
+	descs <- pollutantValueDesc
+	descs <- concentrationDesc
+}
+
+// Collect implements prometheus.Collector with the index of each sensor over each period with enough hourly averages
+func (m *Monitor) Collect(metrics chan<- prometheus.Metric) {
+	for _, report := range m.Reports(time.Now()) {
+		periods := []struct {
+			name  string
+			index *Index
+		}{
+			{"nowcast", report.NowCast},
+			{"daily", report.Daily},
+		}
+		for _, period := range periods {
+			if period.index == nil {
+				continue
+			}
+			labels := []string{report.Sensor, report.Model, report.Serial, period.name}
+			metrics <- prometheus.MustNewConstMetric(valueDesc, prometheus.GaugeValue, float64(period.index.AQI), labels...)
+			metrics <- prometheus.MustNewConstMetric(categoryDesc, prometheus.GaugeValue, 1, append(labels, period.index.Category)...)
+			metrics <- prometheus.MustNewConstMetric(dominantPollutantDesc, prometheus.GaugeValue, 1, append(labels, period.index.DominantPollutant)...)
+			for pollutant, index := range period.index.Pollutants {
+				metrics <- prometheus.MustNewConstMetric(pollutantValueDesc, prometheus.GaugeValue, float64(index.AQI), append(labels, pollutant)...)
+				metrics <- prometheus.MustNewConstMetric(concentrationDesc, prometheus.GaugeValue, index.Concentration, append(labels, pollutant)...)
+			}
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package aqi
+
+import (
+	"context"
+	"math"
+	"math/bits"
+	"sensor-exporter/internal/measurement"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+// Model is the sensor model whose readings the index is computed from
+const Model = "PMS5003"
+
+// Measurements maps the measurements the index is computed from to their pollutant. The environmental concentrations
+// are used, as they are adjusted for atmospheric conditions.
+var Measurements = map[string]string{
+	"pm2_5_environmental": PM25,
+	"pm10_environmental":  PM10,
+}
+
+// pollutants lists the pollutants in the order that breaks ties for the dominant pollutant
+var pollutants = []string{PM25, PM10}
+
+// minimumQuarters is the number of quarter-hours of an hour that need samples for its average to count, so that an
+// hour in which the sensor was mostly disconnected does not stand for the whole hour
+const minimumQuarters = 3
+
+// Report is the index of a sensor, absent for a period without enough hourly averages
+type Report struct {
+	measurement.Source
+	NowCast *Index `json:"nowcast,omitempty"`
+	// Index of the average of the last 24 hours
+	Daily *Index `json:"daily,omitempty"`
+}
+
+// Index is the index over a period, which is the highest index of its pollutants
+type Index struct {
+	AQI               int    `json:"aqi"`
+	Category          string `json:"category"`
+	DominantPollutant string `json:"dominantPollutant"`
+	// Pollutants with enough hourly averages over the period
+	Pollutants map[string]PollutantIndex `json:"pollutants"`
+}
+
+// PollutantIndex is the index of one pollutant over a period
+type PollutantIndex struct {
+	// NowCast or 24-hour average concentration in µg/m³
+	Concentration float64 `json:"concentration"`
+	AQI           int     `json:"aqi"`
+	Category      string  `json:"category"`
+	// Number of hours with a valid average over the period
+	Hours int `json:"hours"`
+}
+
+// hour accumulates the valid samples of a pollutant in a clock hour
+type hour struct {
+	sum   float64
+	count int
+	// Bit set of the quarter-hours with samples
+	quarters uint8
+}
+
+// sensor holds the hours of the last day of each pollutant of a sensor
+type sensor struct {
+	source measurement.Source
+	hours  map[string]map[int64]*hour
+}
+
+// Monitor averages the particulate readings of each sensor by hour and computes the index from the hourly averages
+type Monitor struct {
+	mu      sync.Mutex
+	sensors map[string]*sensor
+}
+
+// NewMonitor returns a monitor without hourly averages
+func NewMonitor() *Monitor {
+	return &Monitor{
+		sensors: map[string]*sensor{},
+	}
+}
+
+// Start averages the samples received until the context is done
+func (m *Monitor) Start(ctx context.Context, samples <-chan []measurement.Sample) func() error {
+	return func() error {
+		log.Info("computing the air quality index of particulate sensors")
+
+		for {
+			select {
+			case batch, ok := <-samples:
+				if !ok {
+					return nil
+				}
+				m.add(batch)
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+func (m *Monitor) add(batch []measurement.Sample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, sample := range batch {
+		pollutant, ok := Measurements[sample.Measurement]
+		if !ok || sample.Model != Model || !sample.Valid || math.IsNaN(sample.Value) {
+			continue
+		}
+		s := m.sensor(sample.Source)
+		s.source = sample.Source
+		s.add(pollutant, sample.Time, sample.Value, 1)
+	}
+}
+
+// Restore adds the average of the valid samples of a measurement in a period within one hour, such as a quarter-hour
+// read from the history, so that the index is available right after a restart
+func (m *Monitor) Restore(source measurement.Source, name string, start time.Time, mean float64, count int) {
+	pollutant, ok := Measurements[name]
+	if !ok || count == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sensor(source).add(pollutant, start, mean*float64(count), count)
+}
+
+func (m *Monitor) sensor(source measurement.Source) *sensor {
+	s, ok := m.sensors[source.Sensor]
+	if !ok {
+		s = &sensor{source: source, hours: map[string]map[int64]*hour{}}
+		m.sensors[source.Sensor] = s
+	}
+	return s
+}
+
+// add adds a sum of samples to the hour of t, dropping hours that are too old to be part of either index
+func (s *sensor) add(pollutant string, t time.Time, sum float64, count int) {
+	hours, ok := s.hours[pollutant]
+	if !ok {
+		hours = map[int64]*hour{}
+		s.hours[pollutant] = hours
+	}
+	start := t.Truncate(time.Hour)
+	h, ok := hours[start.Unix()]
+	if !ok {
+		h = &hour{}
+		hours[start.Unix()] = h
+		for key := range hours {
+			if key < start.Add(-dailyHours*time.Hour).Unix() {
+				delete(hours, key)
+			}
+		}
+	}
+	h.sum += sum
+	h.count += count
+	h.quarters |= 1 << (t.Sub(start) / (15 * time.Minute))
+}
+
+// Reports returns the index of each sensor, sorted by sensor, from the clock hours completed before now
+func (m *Monitor) Reports(now time.Time) []Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reports := []Report{}
+	for _, s := range m.sensors {
+		report := Report{Source: s.source}
+		nowCast := map[string]PollutantIndex{}
+		daily := map[string]PollutantIndex{}
+		for _, pollutant := range pollutants {
+			hourly, hours := s.hourly(pollutant, now)
+			if concentration, ok := NowCast(hourly); ok {
+				nowCast[pollutant] = pollutantIndex(pollutant, concentration, hours[:nowCastHours])
+			}
+			if concentration, ok := DailyAverage(hourly); ok {
+				daily[pollutant] = pollutantIndex(pollutant, concentration, hours)
+			}
+		}
+		report.NowCast = overall(nowCast)
+		report.Daily = overall(daily)
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Sensor < reports[j].Sensor
+	})
+	return reports
+}
+
+// hourly returns the averages of the last 24 completed hours of a pollutant, the most recent first, with NaN for hours
+// without enough samples, along with whether each hour has a valid average
+func (s *sensor) hourly(pollutant string, now time.Time) ([]float64, []bool) {
+	current := now.Truncate(time.Hour)
+	averages := make([]float64, dailyHours)
+	valid := make([]bool, dailyHours)
+	for i := range averages {
+		averages[i] = math.NaN()
+		h, ok := s.hours[pollutant][current.Add(-time.Duration(i+1)*time.Hour).Unix()]
+		if ok && h.count > 0 && bits.OnesCount8(h.quarters) >= minimumQuarters {
+			averages[i] = h.sum / float64(h.count)
+			valid[i] = true
+		}
+	}
+	return averages, valid
+}
+
+func pollutantIndex(pollutant string, concentration float64, hours []bool) PollutantIndex {
+	truncated := Truncate(pollutant, concentration)
+	index, category := Calculate(pollutant, truncated)
+	count := 0
+	for _, valid := range hours {
+		if valid {
+			count++
+		}
+	}
+	return PollutantIndex{
+		Concentration: truncated,
+		AQI:           index,
+		Category:      category,
+		Hours:         count,
+	}
+}
+
+// overall returns the index of the pollutant with the highest index, or nil if no pollutant has an index
+func overall(indexes map[string]PollutantIndex) *Index {
+	var result *Index
+	for _, pollutant := range pollutants {
+		index, ok := indexes[pollutant]
+		if !ok || (result != nil && index.AQI <= result.AQI) {
+			continue
+		}
+		result = &Index{
+			AQI:               index.AQI,
+			Category:          index.Category,
+			DominantPollutant: pollutant,
+			Pollutants:        indexes,
+		}
+	}
+	return result
+}
@@ -0,0 +1,74 @@
+package aqi
+
+import "math"
+
+const (
+	// nowCastHours is the number of hourly averages the NowCast weighs
+	nowCastHours = 12
+	// nowCastMinimumWeight keeps the NowCast from following the latest hour alone when concentrations vary widely
+	nowCastMinimumWeight = 0.5
+	// dailyHours is the number of hourly averages the 24-hour index averages
+	dailyHours = 24
+	// dailyMinimumHours is the 75% completeness the 24-hour average requires
+	dailyMinimumHours = 18
+)
+
+// NowCast returns the NowCast of hourly averages, the most recent first, with NaN for hours without a valid average.
+// It requires two of the three most recent hours and weighs each earlier hour by a factor that shrinks as the range
+// of the averages grows, so that it follows rising or falling concentrations quickly.
+func NowCast(hourly []float64) (float64, bool) {
+	if len(hourly) > nowCastHours {
+		hourly = hourly[:nowCastHours]
+	}
+	recent := 0
+	for i := 0; i < 3 && i < len(hourly); i++ {
+		if !math.IsNaN(hourly[i]) {
+			recent++
+		}
+	}
+	if recent < 2 {
+		return 0, false
+	}
+
+	lowest, highest := math.Inf(1), math.Inf(-1)
+	for _, average := range hourly {
+		if !math.IsNaN(average) {
+			lowest = math.Min(lowest, average)
+			highest = math.Max(highest, average)
+		}
+	}
+	weight := 1.0
+	if highest > 0 {
+		weight = math.Max(lowest/highest, nowCastMinimumWeight)
+	}
+
+	sum, weights := 0.0, 0.0
+	for i, average := range hourly {
+		if math.IsNaN(average) {
+			continue
+		}
+		factor := math.Pow(weight, float64(i))
+		sum += factor * average
+		weights += factor
+	}
+	return sum / weights, true
+}
+
+// DailyAverage returns the average of the last 24 hourly averages, the most recent first, with NaN for hours without
+// a valid average. It requires 18 of the 24 hours.
+func DailyAverage(hourly []float64) (float64, bool) {
+	if len(hourly) > dailyHours {
+		hourly = hourly[:dailyHours]
+	}
+	sum, hours := 0.0, 0
+	for _, average := range hourly {
+		if !math.IsNaN(average) {
+			sum += average
+			hours++
+		}
+	}
+	if hours < dailyMinimumHours {
+		return 0, false
+	}
+	return sum / float64(hours), true
+}
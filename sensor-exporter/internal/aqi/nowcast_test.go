@@ -0,0 +1,69 @@
+package aqi
+
+import (
+	"math"
+	"testing"
+)
+
+// hours returns count hourly averages of the same value
+func hours(count int, value float64) []float64 {
+	averages := make([]float64, count)
+	for i := range averages {
+		averages[i] = value
+	}
+	return averages
+}
+
+func TestNowCast(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name   string
+		hourly []float64
+		want   float64
+		ok     bool
+	}{
+		{"steady", hours(12, 10), 10, true},
+		{"rising", []float64{20, 10}, 50.0 / 3, true},
+		{"weight of the range", []float64{10, 15}, 12, true},
+		{"weight limited when the range is wide", []float64{30, 10}, 35 / 1.5, true},
+		{"zero", []float64{0, 0, 0}, 0, true},
+		{"latest hour missing", []float64{nan, 10, 10}, 10, true},
+		{"earlier hours missing", []float64{20, 10, nan, 20, nan}, (20 + 0.5*10 + 0.125*20) / 1.625, true},
+		{"two of the three recent hours missing", []float64{nan, 10, nan, 10, 10}, 0, false},
+		{"no hours", []float64{}, 0, false},
+		{"hours beyond twelve ignored", append(hours(12, 10), 1000), 10, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := NowCast(test.hourly)
+			if ok != test.ok || math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("got %v (%v), want %v (%v)", got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+func TestDailyAverage(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name   string
+		hourly []float64
+		want   float64
+		ok     bool
+	}{
+		{"complete", hours(24, 10), 10, true},
+		{"average of the valid hours", append(append(hours(12, 10), hours(6, 20)...), hours(6, nan)...), 40.0 / 3, true},
+		{"too few hours", append(hours(17, 10), hours(7, nan)...), 0, false},
+		{"hours beyond 24 ignored", append(hours(24, 10), hours(24, 1000)...), 10, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := DailyAverage(test.hourly)
+			if ok != test.ok || math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("got %v (%v), want %v (%v)", got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
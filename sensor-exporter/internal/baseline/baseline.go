@@ -0,0 +1,110 @@
+// Package baseline inspects and manages the stored baselines of SGP30 sensors
+package baseline
+
+import (
+	"context"
+	"sensor-exporter/i2cbus"
+	"sensor-exporter/internal/exporter"
+	"sensor-exporter/internal/models"
+	"sensor-exporter/internal/state"
+	"sensor-exporter/sgp30"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+)
+
+const driverName = "baseline"
+
+// Instance returns the configured SGP30 instance with the given name, or the only one if no name is given
+func Instance(settings *exporter.Settings, name string) (exporter.SensorSettings, error) {
+	instances, err := settings.SensorInstances()
+	if err != nil {
+		return exporter.SensorSettings{}, err
+	}
+
+	gasSensors := []exporter.SensorSettings{}
+	for _, instance := range instances {
+		if instance.Model != models.SGP30 {
+			continue
+		}
+		if instance.Name == name {
+			return instance, nil
+		}
+		gasSensors = append(gasSensors, instance)
+	}
+
+	switch {
+	case name != "":
+		return exporter.SensorSettings{}, errors.Errorf("failed to find SGP30 sensor %v in the configuration", name)
+	case len(gasSensors) == 0:
+		return exporter.SensorSettings{}, errors.New("failed to find an SGP30 sensor in the configuration")
+	case len(gasSensors) > 1:
+		return exporter.SensorSettings{}, errors.Errorf("failed to choose between %v SGP30 sensors; name the sensor", len(gasSensors))
+	}
+	return gasSensors[0], nil
+}
+
+// ConnectedSerial reads the serial of the SGP30 sensor attached at the address of an instance
+func ConnectedSerial(ctx context.Context, instance exporter.SensorSettings) ([]uint16, error) {
+	buses := i2cbus.NewManager()
+	if instance.Mux != nil && instance.Mux.Fake {
+		buses.RegisterMux(instance.Bus, instance.Mux.Address, i2cbus.NewFakeMux())
+	}
+
+	address := instance.I2CAddress()
+	device, err := buses.Open(driverName, address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open I2C device %v", address)
+	}
+	defer device.Close()
+
+	info, err := sgp30.Probe(ctx, device)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to probe SGP30 sensor at %v", address)
+	}
+	return info.Serial, nil
+}
+
+// StoredSerials returns the serials of the SGP30 sensors with a baseline in the state store
+func StoredSerials(store *state.Store) [][]uint16 {
+	serials := [][]uint16{}
+	for _, sensor := range store.Sensors() {
+		if sensor.Baseline != nil && len(sensor.Baseline.Serial) > 0 {
+			serials = append(serials, sensor.Baseline.Serial)
+		}
+	}
+	return serials
+}
+
+// Verify returns an error if a baseline was not read from the sensor with the given serial
+func Verify(baseline *sgp30.BaselineReading, serial []uint16) error {
+	if len(baseline.Serial) == 0 {
+		return errors.New("failed to verify baseline without a serial")
+	}
+	if !slices.Equal(baseline.Serial, serial) {
+		return errors.Errorf("failed to verify baseline of sensor %v against connected sensor %v",
+			sgp30.FormatSerial(baseline.Serial),
+			sgp30.FormatSerial(serial))
+	}
+	return nil
+}
+
+// Age describes how old a baseline is and how long it may still be restored to the sensor
+type Age struct {
+	// Duration since the baseline was read from the sensor
+	Age time.Duration
+	// Duration until the baseline expires; negative once it has expired
+	Remaining time.Duration
+	// Whether the baseline is too old to be restored to the sensor
+	Expired bool
+}
+
+// AgeOf returns the age of a baseline at the given time
+func AgeOf(baseline *sgp30.BaselineReading, now time.Time) Age {
+	return Age{
+		Age:       now.Sub(baseline.StoredAt()),
+		Remaining: baseline.BaselineInvalidAfter.Sub(now),
+		Expired:   baseline.IsExpired(now),
+	}
+}
@@ -0,0 +1,135 @@
+package baseline
+
+import (
+	"path/filepath"
+	"sensor-exporter/internal/exporter"
+	"sensor-exporter/internal/state"
+	"sensor-exporter/sgp30"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstance(t *testing.T) {
+	gas := exporter.SensorSettings{Name: "gas", Model: "sgp30", Bus: 1}
+	kitchen := exporter.SensorSettings{Name: "kitchen", Model: "sgp30", Bus: 3}
+	room := exporter.SensorSettings{Name: "room", Model: "aht20", Bus: 1}
+	tests := []struct {
+		name     string
+		sensors  []exporter.SensorSettings
+		argument string
+		instance string
+		valid    bool
+	}{
+		{"only SGP30 without a name", []exporter.SensorSettings{room, gas}, "", "gas", true},
+		{"named SGP30", []exporter.SensorSettings{gas, kitchen}, "kitchen", "kitchen", true},
+		{"several SGP30 without a name", []exporter.SensorSettings{gas, kitchen}, "", "", false},
+		{"unknown name", []exporter.SensorSettings{gas}, "attic", "", false},
+		{"named sensor of another model", []exporter.SensorSettings{room, gas}, "room", "", false},
+		{"no SGP30", []exporter.SensorSettings{room}, "", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance, err := Instance(&exporter.Settings{Sensors: test.sensors}, test.argument)
+			if (err == nil) != test.valid {
+				t.Fatalf("got error %v, want valid %v", err, test.valid)
+			}
+			if instance.Name != test.instance {
+				t.Errorf("got sensor %q, want %q", instance.Name, test.instance)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		stored   []uint16
+		serial   []uint16
+		verified bool
+	}{
+		{"same sensor", []uint16{0, 0x123, 0x4567}, []uint16{0, 0x123, 0x4567}, true},
+		{"different sensor", []uint16{0, 0x123, 0x4567}, []uint16{0, 0x123, 0x4568}, false},
+		{"baseline without a serial", nil, []uint16{0, 0x123, 0x4567}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Verify(&sgp30.BaselineReading{Serial: test.stored}, test.serial)
+			if (err == nil) != test.verified {
+				t.Errorf("got error %v, want verified %v", err, test.verified)
+			}
+		})
+	}
+}
+
+func TestAgeOf(t *testing.T) {
+	stored := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	baseline := &sgp30.BaselineReading{BaselineInvalidAfter: stored.Add(sgp30.BaselineValidity)}
+	tests := []struct {
+		name string
+		now  time.Time
+		age  Age
+	}{
+		{"just stored", stored, Age{0, sgp30.BaselineValidity, false}},
+		{"an hour old", stored.Add(time.Hour), Age{time.Hour, sgp30.BaselineValidity - time.Hour, false}},
+		{"at expiry", stored.Add(sgp30.BaselineValidity), Age{sgp30.BaselineValidity, 0, true}},
+		{"after expiry", stored.Add(sgp30.BaselineValidity + time.Hour), Age{sgp30.BaselineValidity + time.Hour, -time.Hour, true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			age := AgeOf(baseline, test.now)
+			if age != test.age {
+				t.Errorf("got %+v, want %+v", age, test.age)
+			}
+		})
+	}
+}
+
+func TestStoredSerials(t *testing.T) {
+	tests := []struct {
+		name    string
+		sensors map[string]*state.Sensor
+		serials []string
+	}{
+		{"empty store", map[string]*state.Sensor{}, []string{}},
+		{
+			name: "only sensors with a baseline and a serial",
+			sensors: map[string]*state.Sensor{
+				"sgp30/000000000001": {Model: "SGP30", Baseline: &sgp30.BaselineReading{Serial: []uint16{0, 0, 1}}},
+				"sgp30/000000000002": {Model: "SGP30"},
+				"sgp30/legacy":       {Model: "SGP30", Baseline: &sgp30.BaselineReading{}},
+				"pms5003/outside":    {Model: "PMS5003"},
+			},
+			serials: []string{"000000000001"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+			if err != nil {
+				t.Fatalf("failed to open state: %v", err)
+			}
+			for key, sensor := range test.sensors {
+				err := store.Update(key, func(stored *state.Sensor) error {
+					*stored = *sensor
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("failed to store sensor: %v", err)
+				}
+			}
+
+			serials := []string{}
+			for _, serial := range StoredSerials(store) {
+				serials = append(serials, sgp30.FormatSerial(serial))
+			}
+			if strings.Join(serials, ",") != strings.Join(test.serials, ",") {
+				t.Errorf("got serials %v, want %v", serials, test.serials)
+			}
+		})
+	}
+}
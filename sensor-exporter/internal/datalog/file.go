@@ -0,0 +1,288 @@
+package datalog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// dayLayout is the date in the names of the files, which rotate at midnight UTC
+const dayLayout = "2006-01-02"
+
+// fileNamePattern matches the names of the files the logger writes, e.g. pms5003-2024-05-01.csv, the second file of
+// that day pms5003-2024-05-01.1.csv, and either compressed as pms5003-2024-05-01.csv.gz
+var fileNamePattern = regexp.MustCompile(`^([a-z0-9]+)-(\d{4}-\d{2}-\d{2})(?:\.(\d+))?\.(csv|ndjson)(\.gz)?$`)
+
+// logFile is the file of one sensor type currently being written
+type logFile struct {
+	dir    string
+	prefix string
+	ext    string
+	// Header line of CSV files, nil for NDJSON
+	header     []byte
+	rotateSize int64
+	// Reports whether a stored file is being compressed, so that it is not continued
+	compressing func(path string) bool
+	// Whether the next line starts a new file, so that this one can be removed to keep the directory within its size
+	// limit
+	rotateNext bool
+
+	day      string
+	sequence int
+	file     *os.File
+	writer   *bufio.Writer
+	size     int64
+}
+
+// storedFile is a file in the log directory
+type storedFile struct {
+	path       string
+	prefix     string
+	day        time.Time
+	sequence   int
+	compressed bool
+	size       int64
+}
+
+// write appends a line, first rotating to a new file if the line belongs to a later day or the file is full. It
+// returns the path of the file it rotated away from, if any, so that it can be compressed.
+func (f *logFile) write(t time.Time, line []byte) (string, error) {
+	rotated := ""
+	day := t.UTC().Format(dayLayout)
+	full := f.rotateNext || f.rotateSize > 0 && f.size > int64(len(f.header)) && f.size+int64(len(line)) > f.rotateSize
+	if f.file == nil || day != f.day || full {
+		if f.file != nil {
+			rotated = f.file.Name()
+		}
+		err := f.open(day, full)
+		if err != nil {
+			return rotated, err
+		}
+	}
+
+	n, err := f.writer.Write(line)
+	f.size += int64(n)
+	if err != nil {
+		path := f.file.Name()
+		f.close()
+		return rotated, errors.Wrapf(err, "failed to write to log file %v", path)
+	}
+	return rotated, nil
+}
+
+// open opens the file of the given day, continuing the latest file of that day if it is still uncompressed, has the
+// same layout and is not full, and starting the next file of the day otherwise
+func (f *logFile) open(day string, full bool) error {
+	f.close()
+	f.rotateNext = false
+
+	stored, err := listFiles(f.dir)
+	if err != nil {
+		return err
+	}
+	sequence := -1
+	var latest *storedFile
+	for i, file := range stored {
+		if file.prefix == f.prefix && file.day.Format(dayLayout) == day && file.sequence > sequence {
+			sequence = file.sequence
+			latest = &stored[i]
+		}
+	}
+
+	if latest == nil {
+		sequence = 0
+	} else if full || latest.compressed || f.compressing(latest.path) || !f.continues(latest) {
+		sequence++
+	}
+
+	path := filepath.Join(f.dir, f.name(day, sequence))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open log file %v", path)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Wrapf(err, "failed to open log file %v", path)
+	}
+
+	f.day = day
+	f.sequence = sequence
+	f.file = file
+	f.writer = bufio.NewWriter(file)
+	f.size = info.Size()
+	if f.size == 0 && f.header != nil {
+		n, err := f.writer.Write(f.header)
+		f.size += int64(n)
+		if err != nil {
+			f.close()
+			return errors.Wrapf(err, "failed to write header to log file %v", path)
+		}
+	}
+	return nil
+}
+
+// continues returns whether lines can be appended to a stored file without changing its layout or exceeding its size
+func (f *logFile) continues(stored *storedFile) bool {
+	if f.rotateSize > 0 && stored.size >= f.rotateSize {
+		return false
+	}
+	if f.header == nil || stored.size == 0 {
+		return true
+	}
+
+	file, err := os.Open(stored.path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	header := make([]byte, len(f.header))
+	_, err = io.ReadFull(file, header)
+	return err == nil && bytes.Equal(header, f.header)
+}
+
+func (f *logFile) name(day string, sequence int) string {
+	if sequence == 0 {
+		return fmt.Sprintf("%v-%v.%v", f.prefix, day, f.ext)
+	}
+	return fmt.Sprintf("%v-%v.%v.%v", f.prefix, day, sequence, f.ext)
+}
+
+func (f *logFile) path() string {
+	if f.file == nil {
+		return ""
+	}
+	return f.file.Name()
+}
+
+func (f *logFile) flush() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.writer.Flush()
+	if err != nil {
+		path := f.file.Name()
+		f.close()
+		return errors.Wrapf(err, "failed to write to log file %v", path)
+	}
+	return nil
+}
+
+func (f *logFile) close() {
+	if f.file == nil {
+		return
+	}
+	f.writer.Flush()
+	f.file.Close()
+	f.file = nil
+	f.writer = nil
+}
+
+// listFiles returns the files the logger wrote to a directory, oldest first
+func listFiles(dir string) ([]storedFile, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read log directory %v", dir)
+	}
+
+	files := []storedFile{}
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		day, err := time.Parse(dayLayout, match[2])
+		if err != nil {
+			continue
+		}
+		sequence := 0
+		if match[3] != "" {
+			sequence, _ = strconv.Atoi(match[3])
+		}
+		files = append(files, storedFile{
+			path:       filepath.Join(dir, entry.Name()),
+			prefix:     match[1],
+			day:        day,
+			sequence:   sequence,
+			compressed: match[5] != "",
+			size:       entry.Size(),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].day.Equal(files[j].day) {
+			return files[i].day.Before(files[j].day)
+		}
+		if files[i].sequence != files[j].sequence {
+			return files[i].sequence < files[j].sequence
+		}
+		return files[i].prefix < files[j].prefix
+	})
+	return files, nil
+}
+
+// compress replaces a file with its gzip-compressed copy, which only appears once it is complete. The file is left as
+// it is if the context is done first.
+func compress(ctx context.Context, path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open log file %v for compression", path)
+	}
+	defer source.Close()
+
+	temporary := path + ".gz.tmp"
+	target, err := os.Create(temporary)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create compressed log file %v", temporary)
+	}
+	writer := gzip.NewWriter(target)
+	_, err = io.Copy(writer, contextReader{ctx, source})
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		err = target.Sync()
+	}
+	closeErr := target.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temporary, path+".gz")
+	}
+	if err != nil {
+		os.Remove(temporary)
+		return errors.Wrapf(err, "failed to compress log file %v", path)
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to remove compressed log file %v", path)
+	}
+	return nil
+}
+
+// contextReader fails reads once its context is done, so that a long copy can be abandoned
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	err := r.ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
@@ -0,0 +1,518 @@
+// Package datalog writes sensor readings, or aggregates of them per interval, to plain CSV or NDJSON files that
+// rotate daily or by size, are compressed once rotated and are removed by age or total size
+package datalog
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"os"
+	"sensor-exporter/internal/measurement"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+// Settings configure where and what the logger writes
+type Settings struct {
+	Dir string
+	// Either csv or ndjson
+	Format string
+	// Interval over which readings are aggregated into one record per sensor; 0 writes every reading
+	Interval time.Duration
+	// Size after which a file is rotated before the end of the day; 0 only rotates daily
+	RotateSize int64
+	// Whether rotated files are compressed with gzip
+	Compress bool
+	// Age after which files are removed; 0 keeps them regardless of age
+	MaxAge time.Duration
+	// Total size of the files beyond which the oldest are removed; 0 does not limit it
+	MaxBytes int64
+}
+
+const (
+	// flushInterval is how often buffered lines are written to the files and aggregates of ended intervals are recorded
+	flushInterval = 5 * time.Second
+	// sweepInterval is how often files are compressed and removed besides when a file is rotated
+	sweepInterval = time.Minute
+	// compressionQueue is the number of files waiting for compression beyond which the rest wait for a later sweep
+	compressionQueue = 16
+)
+
+// Logger writes one file per sensor type, so that every file has the columns of that type
+type Logger struct {
+	settings Settings
+	// Whether the wall clock can be trusted to remove files by age
+	clockSynced func() bool
+	files       map[string]*logFile
+	windows     map[string]*window
+	// Files are compressed in a goroutine of their own, since compressing a large file would hold up the samples
+	compressions chan string
+	mu           sync.Mutex
+	// Files queued for compression or being compressed, which are neither continued nor removed until it is done
+	compressing map[string]bool
+}
+
+// window aggregates the readings of a sensor over an interval
+type window struct {
+	source        measurement.Source
+	start         time.Time
+	readings      int
+	validReadings int
+	values        map[string]*stats
+}
+
+// stats summarizes the valid samples of a measurement
+type stats struct {
+	count int
+	min   float64
+	max   float64
+	sum   float64
+}
+
+// NewLogger validates the settings and creates the directory of the files
+func NewLogger(settings Settings, clockSynced func() bool) (*Logger, error) {
+	if settings.Format != "csv" && settings.Format != "ndjson" {
+		return nil, errors.Errorf("failed to configure data logger with format %v; expected csv or ndjson", settings.Format)
+	}
+	err := os.MkdirAll(settings.Dir, 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create log directory %v", settings.Dir)
+	}
+
+	return &Logger{
+		settings:     settings,
+		clockSynced:  clockSynced,
+		files:        map[string]*logFile{},
+		windows:      map[string]*window{},
+		compressions: make(chan string, compressionQueue),
+		compressing:  map[string]bool{},
+	}, nil
+}
+
+// Start writes the samples received until the context is done, at which point the aggregates of the current interval
+// are written as they are. A compression in progress is abandoned and redone after the next start.
+func (l *Logger) Start(ctx context.Context, samples <-chan []measurement.Sample) func() error {
+	return func() error {
+		log.Info("logging readings to files",
+			"dir", l.settings.Dir,
+			"format", l.settings.Format,
+			"interval", l.settings.Interval)
+
+		compressCtx, cancel := context.WithCancel(ctx)
+		compressed := make(chan struct{})
+		go func() {
+			l.compressLoop(compressCtx)
+			close(compressed)
+		}()
+		defer func() {
+			cancel()
+			<-compressed
+		}()
+		l.sweep()
+
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		sweepTicker := time.NewTicker(sweepInterval)
+		defer sweepTicker.Stop()
+		for {
+			select {
+			case batch, ok := <-samples:
+				if !ok {
+					l.close()
+					return nil
+				}
+				l.record(batch)
+			case now := <-ticker.C:
+				l.flush(now)
+			case <-sweepTicker.C:
+				l.sweep()
+			case <-ctx.Done():
+				l.close()
+				return nil
+			}
+		}
+	}
+}
+
+// record writes a reading, or adds it to the aggregates of its interval
+func (l *Logger) record(batch []measurement.Sample) {
+	if len(batch) == 0 {
+		return
+	}
+	source := batch[0].Source
+	if _, ok := measurement.Measurements[source.Model]; !ok {
+		log.Debug("not logging reading of unknown sensor type",
+			"sensor", source.Sensor,
+			"model", source.Model)
+		return
+	}
+
+	if l.settings.Interval == 0 {
+		l.writeReading(batch)
+		return
+	}
+
+	start := batch[0].Time.Truncate(l.settings.Interval)
+	current, ok := l.windows[source.Sensor]
+	if ok && !current.start.Equal(start) {
+		l.writeWindow(current)
+		ok = false
+	}
+	if !ok {
+		current = &window{source: source, start: start, values: map[string]*stats{}}
+		l.windows[source.Sensor] = current
+	}
+	current.add(batch)
+}
+
+func (w *window) add(batch []measurement.Sample) {
+	w.readings++
+	valid := true
+	for _, sample := range batch {
+		valid = valid && sample.Valid
+		if !sample.Valid || math.IsNaN(sample.Value) {
+			continue
+		}
+		s, ok := w.values[sample.Measurement]
+		if !ok {
+			s = &stats{min: sample.Value, max: sample.Value}
+			w.values[sample.Measurement] = s
+		}
+		s.count++
+		s.sum += sample.Value
+		s.min = math.Min(s.min, sample.Value)
+		s.max = math.Max(s.max, sample.Value)
+	}
+	if valid {
+		w.validReadings++
+	}
+	if w.source.Serial == "" {
+		w.source.Serial = batch[0].Serial
+	}
+}
+
+// columns returns the stable layout of the records of a sensor type
+func (l *Logger) columns(model string) []string {
+	if l.settings.Interval == 0 {
+		return append([]string{"time", "sensor", "serial", "valid"}, measurement.Measurements[model]...)
+	}
+	columns := []string{"time", "sensor", "serial", "readings", "valid_readings"}
+	for _, name := range measurement.Measurements[model] {
+		columns = append(columns, name+"_mean", name+"_min", name+"_max")
+	}
+	return columns
+}
+
+// writeReading writes a record holding the samples of a reading, leaving the measurements it does not include empty
+func (l *Logger) writeReading(batch []measurement.Sample) {
+	source := batch[0].Source
+	valid := true
+	values := map[string]interface{}{}
+	for _, sample := range batch {
+		valid = valid && sample.Valid
+		values[sample.Measurement] = sample.Value
+	}
+
+	fields := []interface{}{batch[0].Time, source.Sensor, source.Serial, valid}
+	for _, name := range measurement.Measurements[source.Model] {
+		fields = append(fields, values[name])
+	}
+	l.write(source.Model, batch[0].Time, fields)
+}
+
+// writeWindow writes a record holding the aggregates of an interval, which are empty for measurements without valid
+// samples in it
+func (l *Logger) writeWindow(w *window) {
+	fields := []interface{}{w.start, w.source.Sensor, w.source.Serial, w.readings, w.validReadings}
+	for _, name := range measurement.Measurements[w.source.Model] {
+		s, ok := w.values[name]
+		if !ok {
+			fields = append(fields, nil, nil, nil)
+			continue
+		}
+		fields = append(fields, s.sum/float64(s.count), s.min, s.max)
+	}
+	l.write(w.source.Model, w.start, fields)
+}
+
+func (l *Logger) write(model string, t time.Time, fields []interface{}) {
+	file, ok := l.files[model]
+	if !ok {
+		file = l.newFile(model)
+		l.files[model] = file
+	}
+
+	line, err := l.encode(l.columns(model), fields)
+	if err == nil {
+		var rotated string
+		rotated, err = file.write(t, line)
+		if rotated != "" {
+			rotatedFiles.Inc()
+			l.sweep()
+		}
+	}
+	if err != nil {
+		writeErrors.Inc()
+		log.Error("failed to log reading",
+			"err", err,
+			"model", model)
+		return
+	}
+	writtenRecords.Inc()
+}
+
+func (l *Logger) newFile(model string) *logFile {
+	file := &logFile{
+		dir:         l.settings.Dir,
+		prefix:      strings.ToLower(model),
+		ext:         l.settings.Format,
+		rotateSize:  l.settings.RotateSize,
+		compressing: l.isCompressing,
+	}
+	if l.settings.Format == "csv" {
+		header, _ := encodeCSV(l.columns(model))
+		file.header = header
+	}
+	return file
+}
+
+// encode encodes a record as a CSV line or as a JSON object with the fields in the order of the columns
+func (l *Logger) encode(columns []string, fields []interface{}) ([]byte, error) {
+	if l.settings.Format == "csv" {
+		values := []string{}
+		for _, field := range fields {
+			values = append(values, formatCSV(field))
+		}
+		return encodeCSV(values)
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, column := range columns {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		key, _ := json.Marshal(column)
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		value, err := json.Marshal(jsonValue(fields[i]))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to encode %v", column)
+		}
+		buffer.Write(value)
+	}
+	buffer.WriteString("}\n")
+	return buffer.Bytes(), nil
+}
+
+func formatCSV(field interface{}) string {
+	switch value := field.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return value.UTC().Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case int:
+		return strconv.Itoa(value)
+	case bool:
+		return strconv.FormatBool(value)
+	case string:
+		return value
+	default:
+		return ""
+	}
+}
+
+func jsonValue(field interface{}) interface{} {
+	switch value := field.(type) {
+	case time.Time:
+		return value.UTC().Format(time.RFC3339Nano)
+	case float64:
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil
+		}
+		return value
+	default:
+		return value
+	}
+}
+
+func encodeCSV(values []string) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	err := writer.Write(values)
+	writer.Flush()
+	if err == nil {
+		err = writer.Error()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode CSV record")
+	}
+	return buffer.Bytes(), nil
+}
+
+// flush writes the aggregates of intervals that have ended and the buffered lines
+func (l *Logger) flush(now time.Time) {
+	for sensor, w := range l.windows {
+		if !w.start.Add(l.settings.Interval).After(now) {
+			l.writeWindow(w)
+			delete(l.windows, sensor)
+		}
+	}
+	for model, file := range l.files {
+		err := file.flush()
+		if err != nil {
+			writeErrors.Inc()
+			log.Error("failed to log readings",
+				"err", err,
+				"model", model)
+		}
+	}
+}
+
+func (l *Logger) close() {
+	for sensor, w := range l.windows {
+		l.writeWindow(w)
+		delete(l.windows, sensor)
+	}
+	for _, file := range l.files {
+		file.close()
+	}
+}
+
+// sweep queues files that are no longer written for compression, including files of earlier days left uncompressed
+// by a restart, and removes files beyond the age and size limits, oldest first. If the files still exceed the size limit
+// once every other file is removed, the files being written are rotated so that the next sweep can remove them.
+func (l *Logger) sweep() {
+	open := map[string]*logFile{}
+	for _, file := range l.files {
+		if file.path() != "" {
+			open[file.path()] = file
+		}
+	}
+
+	if l.settings.Compress {
+		stored, err := listFiles(l.settings.Dir)
+		if err != nil {
+			log.Error("failed to clean up log files",
+				"err", err)
+			return
+		}
+		// The latest file of today may be continued after a restart, so it is only compressed once rotated
+		today := time.Now().UTC().Format(dayLayout)
+		latest := map[string]string{}
+		for _, file := range stored {
+			if file.day.Format(dayLayout) == today {
+				latest[file.prefix] = file.path
+			}
+		}
+		for _, file := range stored {
+			if open[file.path] != nil || file.compressed || latest[file.prefix] == file.path || l.isCompressing(file.path) {
+				continue
+			}
+			l.queueCompression(file.path)
+		}
+	}
+
+	stored, err := listFiles(l.settings.Dir)
+	if err != nil {
+		log.Error("failed to clean up log files",
+			"err", err)
+		return
+	}
+	total := int64(0)
+	for _, file := range stored {
+		total += file.size
+	}
+	overLimit := func() bool {
+		return l.settings.MaxBytes > 0 && total > l.settings.MaxBytes
+	}
+	// files being compressed are removed by a later sweep if need be, until which the files being written are kept
+	waiting := false
+	for _, file := range stored {
+		if open[file.path] != nil {
+			continue
+		}
+		if l.isCompressing(file.path) {
+			waiting = true
+			continue
+		}
+		expired := l.settings.MaxAge > 0 && l.clockSynced() && file.day.AddDate(0, 0, 1).Before(time.Now().Add(-l.settings.MaxAge))
+		if !expired && !overLimit() {
+			continue
+		}
+
+		err = os.Remove(file.path)
+		if err != nil {
+			log.Error("failed to remove old log file",
+				"err", err,
+				"path", file.path)
+			continue
+		}
+		total -= file.size
+		removedFiles.Inc()
+	}
+
+	storedBytes.Set(float64(total))
+	if waiting {
+		return
+	}
+
+	for _, file := range stored {
+		if !overLimit() {
+			break
+		}
+		if open[file.path] == nil || open[file.path].rotateNext {
+			continue
+		}
+		log.Warn("log files exceed their size limit; rotating the file being written so that it can be removed",
+			"path", file.path,
+			"maxBytes", l.settings.MaxBytes)
+		open[file.path].rotateNext = true
+		total -= file.size
+	}
+}
+
+// queueCompression queues a file for compression unless the queue is full, in which case a later sweep queues it
+func (l *Logger) queueCompression(path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case l.compressions <- path:
+		l.compressing[path] = true
+	default:
+	}
+}
+
+func (l *Logger) isCompressing(path string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.compressing[path]
+}
+
+// compressLoop compresses the queued files until the context is done
+func (l *Logger) compressLoop(ctx context.Context) {
+	for {
+		select {
+		case path := <-l.compressions:
+			err := compress(ctx, path)
+			if err != nil && ctx.Err() == nil {
+				log.Error("failed to compress log file",
+					"err", err)
+			}
+			l.mu.Lock()
+			delete(l.compressing, path)
+			l.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package datalog
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sensor-exporter/internal/measurement"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSweep(t *testing.T) {
+	today := time.Now().UTC().Format(dayLayout)
+	tests := []struct {
+		name     string
+		compress bool
+		maxBytes int64
+		// Files in the directory and their sizes; the file of today is being written
+		files map[string]int
+		kept  []string
+		// Whether the file being written is rotated to stay within the size limit
+		rotated    bool
+		compressed []string
+	}{
+		{
+			name:     "within the limit",
+			maxBytes: 100,
+			files:    map[string]int{"aht20-2026-01-01.ndjson": 40, "aht20-" + today + ".ndjson": 40},
+			kept:     []string{"aht20-2026-01-01.ndjson", "aht20-" + today + ".ndjson"},
+		},
+		{
+			name:     "oldest removed first",
+			maxBytes: 100,
+			files: map[string]int{
+				"aht20-2026-01-01.ndjson":    40,
+				"aht20-2026-01-02.ndjson.gz": 40,
+				"aht20-2026-01-02.1.ndjson":  40,
+				"aht20-" + today + ".ndjson": 20,
+			},
+			kept: []string{"aht20-2026-01-02.1.ndjson", "aht20-2026-01-02.ndjson.gz", "aht20-" + today + ".ndjson"},
+		},
+		{
+			name:     "file being written rotated beyond the limit",
+			maxBytes: 100,
+			files:    map[string]int{"aht20-2026-01-01.ndjson": 40, "aht20-" + today + ".ndjson": 120},
+			kept:     []string{"aht20-" + today + ".ndjson"},
+			rotated:  true,
+		},
+		{
+			name:       "files being compressed kept",
+			compress:   true,
+			maxBytes:   100,
+			files:      map[string]int{"aht20-2026-01-01.ndjson": 40, "aht20-2026-01-02.ndjson": 40, "aht20-" + today + ".ndjson": 40},
+			kept:       []string{"aht20-2026-01-01.ndjson", "aht20-2026-01-02.ndjson", "aht20-" + today + ".ndjson"},
+			compressed: []string{"aht20-2026-01-01.ndjson", "aht20-2026-01-02.ndjson"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, size := range test.files {
+				err := ioutil.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644)
+				if err != nil {
+					t.Fatalf("failed to create %v: %v", name, err)
+				}
+			}
+			l, err := NewLogger(Settings{Dir: dir, Format: "ndjson", Compress: test.compress, MaxBytes: test.maxBytes}, func() bool { return true })
+			if err != nil {
+				t.Fatalf("failed to create logger: %v", err)
+			}
+			file := l.newFile("AHT20")
+			err = file.open(today, false)
+			if err != nil {
+				t.Fatalf("failed to open file: %v", err)
+			}
+			defer file.close()
+			l.files["AHT20"] = file
+
+			l.sweep()
+
+			kept := []string{}
+			entries, _ := ioutil.ReadDir(dir)
+			for _, entry := range entries {
+				kept = append(kept, entry.Name())
+			}
+			if strings.Join(kept, ",") != strings.Join(test.kept, ",") {
+				t.Errorf("kept %v, want %v", kept, test.kept)
+			}
+			if file.rotateNext != test.rotated {
+				t.Errorf("got rotation %v, want %v", file.rotateNext, test.rotated)
+			}
+			compressed := []string{}
+			for path := range l.compressing {
+				compressed = append(compressed, filepath.Base(path))
+			}
+			sort.Strings(compressed)
+			if strings.Join(compressed, ",") != strings.Join(test.compressed, ",") {
+				t.Errorf("queued %v for compression, want %v", compressed, test.compressed)
+			}
+		})
+	}
+}
+
+func TestLoggerCompressesRotatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLogger(Settings{Dir: dir, Format: "ndjson", RotateSize: 200, Compress: true}, func() bool { return true })
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	samples := make(chan []measurement.Sample)
+	done := make(chan error)
+	go func() {
+		done <- l.Start(ctx, samples)()
+	}()
+	for i := 0; i < 6; i++ {
+		samples <- []measurement.Sample{{
+			Source:      measurement.Source{Sensor: "room", Model: "AHT20"},
+			Measurement: "temperature",
+			Value:       float64(i),
+			Valid:       true,
+			Time:        time.Now(),
+		}}
+	}
+
+	// compression happens in the background, so the rotated files are compressed a little later
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stored, err := listFiles(dir)
+		if err != nil {
+			t.Fatalf("failed to list files: %v", err)
+		}
+		uncompressed := 0
+		for _, file := range stored {
+			if !file.compressed {
+				uncompressed++
+			}
+		}
+		if len(stored) > 1 && uncompressed == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v files of which %v uncompressed, want every file but the latest compressed", len(stored), uncompressed)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,47 @@
+package datalog
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	writtenRecords = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_datalog_written_records_total",
+			Help: "Number of records written to the log files",
+		},
+	)
+	writeErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_datalog_write_errors_total",
+			Help: "Number of failures to write to the log files",
+		},
+	)
+	rotatedFiles = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_datalog_rotated_files_total",
+			Help: "Number of log files rotated at the end of the day or because they reached their size limit",
+		},
+	)
+	removedFiles = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_datalog_removed_files_total",
+			Help: "Number of log files removed because of their age or the size limit of the directory",
+		},
+	)
+	storedBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "sensor_exporter_datalog_stored_bytes",
+			Help: "Size of the log files on disk",
+		},
+	)
+)
+
+// Collectors returns the metrics of the data logger for registration
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		writtenRecords,
+		writeErrors,
+		rotatedFiles,
+		removedFiles,
+		storedBytes,
+	}
+}
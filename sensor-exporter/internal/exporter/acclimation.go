@@ -0,0 +1,131 @@
+package exporter
+
+import (
+	"sensor-exporter/internal/state"
+	"sensor-exporter/sgp30"
+	"time"
+
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+// acclimationSaveInterval is how often the acclimation progress of a gas sensor is written to the state file
+const acclimationSaveInterval = 10 * time.Minute
+
+// acclimationProgress persists the progress of a gas sensor establishing its baseline so that it can resume after a
+// restart instead of starting the full acclimation over
+type acclimationProgress struct {
+	keeper    *baselineKeeper
+	key       string
+	progress  *state.Acclimation
+	lastSaved time.Time
+}
+
+func newAcclimationProgress(keeper *baselineKeeper) *acclimationProgress {
+	return &acclimationProgress{
+		keeper: keeper,
+	}
+}
+
+// identify loads the progress of the sensor with the given serial
+func (a *acclimationProgress) identify(serial []uint16) {
+	a.key = BaselineKey(serial)
+	a.progress = nil
+	a.lastSaved = time.Time{}
+	if sensor := a.keeper.store.Sensor(a.key); sensor != nil {
+		a.progress = sensor.Acclimation
+	}
+}
+
+// observe records the progress reported by an air quality reading, saving it when the sensor is first seen
+// acclimating, periodically while it acclimates and once it has acclimated. Readings taken before the driver restored
+// the stored baseline are skipped, as they do not know how far the sensor has acclimated.
+func (a *acclimationProgress) observe(reading *sgp30.AirQualityReading, now time.Time) {
+	if a.key == "" || reading.BaselinePending {
+		return
+	}
+
+	if reading.DurationUntilValid <= 0 {
+		// the progress is kept until the first baseline after acclimation replaces the provisional one
+		if a.progress != nil && a.progress.Elapsed < sgp30.AcclimationDuration {
+			log.Info("gas sensor acclimated",
+				"key", a.key,
+				"startedAt", a.progress.StartedAt)
+			a.progress.Elapsed = sgp30.AcclimationDuration
+			a.progress.LastSeen = now
+			a.save(now)
+		}
+		return
+	}
+
+	elapsed := sgp30.AcclimationDuration - reading.DurationUntilValid
+	if a.progress == nil || elapsed < a.progress.Elapsed-time.Minute {
+		// the sensor is acclimating for the first time or started over, such as after being powered off too long
+		a.progress = &state.Acclimation{
+			StartedAt: now.Add(-elapsed),
+		}
+		a.lastSaved = time.Time{}
+	}
+	a.progress.Elapsed = elapsed
+	a.progress.LastSeen = now
+
+	if now.Sub(a.lastSaved) >= acclimationSaveInterval {
+		a.save(now)
+	}
+}
+
+func (a *acclimationProgress) save(now time.Time) {
+	a.lastSaved = now
+	// a resumed acclimation judges how long the sensor was off by LastSeen, which errs towards starting over when it
+	// was saved by a clock that is behind
+	a.progress.UntrustedTime = !a.keeper.clock.Synchronized()
+	progress := a.progress
+	err := a.keeper.store.Update(a.key, func(sensor *state.Sensor) error {
+		sensor.Model = "SGP30"
+		sensor.Acclimation = progress
+		return nil
+	})
+	if err != nil {
+		log.Error("failed to store acclimation progress",
+			"err", err,
+			"key", a.key,
+			"path", a.keeper.store.Path())
+	}
+}
+
+// resumeAcclimation returns the provisional baseline of a sensor adjusted so that acclimation resumes with the run time
+// it still needs, or nil if acclimation has to start over. The datasheet allows restoring a baseline read up to seven
+// days before the sensor is powered up again; a provisional baseline is held to the same limit, measured from when the
+// sensor was last seen acclimating.
+func resumeAcclimation(sensor *state.Sensor, now time.Time) *sgp30.BaselineReading {
+	progress := sensor.Acclimation
+	if progress == nil {
+		log.Warn("failed to resume acclimation without stored progress; sensor will acclimate from scratch",
+			"baseline", sensor.Baseline)
+		return nil
+	}
+
+	poweredOff := now.Sub(progress.LastSeen)
+	if poweredOff < 0 || poweredOff > sgp30.BaselineValidity {
+		log.Warn("sensor was off too long to resume acclimation; sensor will acclimate from scratch",
+			"lastSeen", progress.LastSeen,
+			"poweredOff", poweredOff,
+			"limit", sgp30.BaselineValidity)
+		return nil
+	}
+
+	remaining := sgp30.AcclimationDuration - progress.Elapsed
+	if remaining < 0 {
+		remaining = 0
+	}
+	baseline := *sensor.Baseline
+	baseline.SensorReadingsNotValidBefore = now.Add(remaining)
+	baseline.BaselineInvalidAfter = progress.LastSeen.Add(sgp30.BaselineValidity)
+
+	log.Info("resuming acclimation with provisional baseline",
+		"startedAt", progress.StartedAt,
+		"elapsed", progress.Elapsed,
+		"remaining", remaining,
+		"poweredOff", poweredOff,
+		"baseline", baseline)
+	return &baseline
+}
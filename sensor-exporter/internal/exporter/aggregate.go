@@ -0,0 +1,95 @@
+package exporter
+
+import (
+	"net/http"
+	"sensor-exporter/internal/aggregate"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/go-kit/v2/cmd"
+	"golang.org/x/exp/slices"
+)
+
+// summariesResponse lists the summaries of the last completed windows
+type summariesResponse struct {
+	Summaries []aggregate.Summary `json:"summaries"`
+}
+
+// AggregateWindows parses the windows over which samples are summarized, shortest first
+func (s *Settings) AggregateWindows() ([]time.Duration, error) {
+	windows := []time.Duration{}
+	for _, value := range s.AggregateWindowNames {
+		window, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse aggregate window %v", value)
+		}
+		if window < time.Second {
+			return nil, errors.Errorf("failed to configure aggregate window %v; windows must be at least 1s", value)
+		}
+		if slices.Contains(windows, window) {
+			return nil, errors.Errorf("failed to configure aggregate window %v; it is given more than once", value)
+		}
+		windows = append(windows, window)
+	}
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i] < windows[j]
+	})
+	return windows, nil
+}
+
+// startAggregator starts summarizing the samples of the sensors over the configured windows, if any
+func startAggregator(group *cmd.ProcessGroup, settings *Settings, sensors *tracker) (*aggregate.Aggregator, error) {
+	windows, err := settings.AggregateWindows()
+	if err != nil {
+		return nil, err
+	}
+	if len(windows) == 0 {
+		return nil, nil
+	}
+
+	aggregator := aggregate.NewAggregator(windows)
+	registry.MustRegister(aggregator)
+	registry.MustRegister(aggregate.Collectors()...)
+	subscription := sensors.samples.subscribe("aggregate", sampleFilter{}, outputBuffer)
+	group.Go(aggregator.Start(group.Context(), subscription.samples))
+	return aggregator, nil
+}
+
+// serveSummaries answers /api/v1/summaries?sensor=&measurement=&window= with the summaries of the last completed
+// windows, optionally selected by sensor, measurement and window
+func (a *api) serveSummaries(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	if a.aggregator == nil {
+		writeError(w, http.StatusNotFound, errors.New("failed to list summaries; aggregation is disabled"))
+		return
+	}
+
+	query := r.URL.Query()
+	if _, ok := a.selectInstances(w, query["sensor"]); !ok {
+		return
+	}
+	sensors := query["sensor"]
+	measurements := query["measurement"]
+	windows := []string{}
+	for _, value := range query["window"] {
+		window, err := time.ParseDuration(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.Wrap(err, "failed to parse window"))
+			return
+		}
+		windows = append(windows, aggregate.FormatWindow(window))
+	}
+
+	summaries := []aggregate.Summary{}
+	for _, summary := range a.aggregator.Latest(time.Now()) {
+		if (len(sensors) == 0 || slices.Contains(sensors, summary.Sensor)) &&
+			(len(measurements) == 0 || slices.Contains(measurements, summary.Measurement)) &&
+			(len(windows) == 0 || slices.Contains(windows, summary.Window)) {
+			summaries = append(summaries, summary)
+		}
+	}
+	writeJSON(w, http.StatusOK, summariesResponse{Summaries: summaries})
+}
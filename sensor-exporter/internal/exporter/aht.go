@@ -1,32 +1,63 @@
 package exporter
 
 import (
-	"sensor-exporter/aht20"
-	"sensor-exporter/units"
+	"sensor-exporter/internal/measurement"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 var (
-	aht_received_packets = promauto.NewCounter(
+	aht_readings_total = promauto.With(registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aht_readings_total",
+			Help: "Number of readings received from the sensor",
+		},
+		withSensorLabels(),
+	)
+	aht_absolute_humidity_grams_per_cubic_meter = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aht_absolute_humidity_grams_per_cubic_meter",
+			Help: "Concentration of humidity in grams per cubic meter",
+		},
+		withSensorLabels(),
+	)
+	aht_relative_humidity_ratio = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aht_relative_humidity_ratio",
+			Help: "Relative humidity as a ratio between 0 and 1",
+		},
+		withSensorLabels(),
+	)
+	aht_temperature_celsius = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aht_temperature_celsius",
+			Help: "Temperature in degrees Celsius",
+		},
+		withSensorLabels(),
+	)
+)
+
+// v1 metrics, only registered when v1 compatibility is enabled
+var (
+	aht_received_packets = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "aht_received_packets",
 		},
 	)
-	aht_absolute_humidity = promauto.NewGauge(
+	aht_absolute_humidity = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "aht_absolute_humidity",
 			Help: "Concentration of humidity in grams per cubic meter",
 		},
 	)
-	aht_relative_humidity = promauto.NewGauge(
+	aht_relative_humidity = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "aht_relative_humidity",
 			Help: "Percentage of relative humidity",
 		},
 	)
-	aht_temperature = promauto.NewGauge(
+	aht_temperature = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "aht_temperature",
 			Help: "Temperature in degrees Celsius",
@@ -34,9 +65,23 @@ var (
 	)
 )
 
-func setAHTMetrics(reading *aht20.Reading, humidity units.GramsPerCubicMeter) {
+// setAHTMetrics counts a reading and sets the gauges of the samples that passed the filter, leaving the gauges of
+// rejected samples at their last accepted value
+func setAHTMetrics(labels sensorLabels, samples []measurement.Sample) {
+	aht_readings_total.WithLabelValues(labels.values()...).Inc()
 	aht_received_packets.Inc()
-	aht_absolute_humidity.Set(float64(humidity))
-	aht_relative_humidity.Set(float64(reading.Humidity))
-	aht_temperature.Set(float64(reading.Temperature))
+
+	for _, sample := range samples {
+		switch sample.Measurement {
+		case "absolute_humidity":
+			aht_absolute_humidity_grams_per_cubic_meter.WithLabelValues(labels.values()...).Set(sample.Value)
+			aht_absolute_humidity.Set(sample.Value)
+		case "relative_humidity":
+			aht_relative_humidity_ratio.WithLabelValues(labels.values()...).Set(sample.Value)
+			aht_relative_humidity.Set(sample.Value)
+		case "temperature":
+			aht_temperature_celsius.WithLabelValues(labels.values()...).Set(sample.Value)
+			aht_temperature.Set(sample.Value)
+		}
+	}
 }
@@ -0,0 +1,278 @@
+package exporter
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"sensor-exporter/internal/aggregate"
+	"sensor-exporter/internal/aqi"
+	"sensor-exporter/internal/history"
+	"sensor-exporter/internal/models"
+	"sensor-exporter/internal/state"
+	"sensor-exporter/sgp30"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+// openAPIDocument describes the JSON API
+//
+//go:embed openapi.yaml
+var openAPIDocument []byte
+
+// api serves the JSON API under /api/v1/
+type api struct {
+	ctx       context.Context
+	instances []SensorSettings
+	byName    map[string]SensorSettings
+	store     *state.Store
+	sensors   *tracker
+	// History store, nil if the history is disabled
+	history *history.Store
+	// Aggregator of window summaries, nil if aggregation is disabled
+	aggregator *aggregate.Aggregator
+	// Air quality index of the particulate sensors, nil if it is disabled
+	aqi *aqi.Monitor
+	// Whether baselines may be rolled back, as the API is not authenticated
+	allowRollback bool
+}
+
+func newAPI(ctx context.Context, instances []SensorSettings, store *state.Store, sensors *tracker, history *history.Store, aggregator *aggregate.Aggregator, monitor *aqi.Monitor, allowRollback bool) *api {
+	byName := map[string]SensorSettings{}
+	for _, instance := range instances {
+		byName[instance.Name] = instance
+	}
+	return &api{
+		ctx:           ctx,
+		instances:     instances,
+		byName:        byName,
+		store:         store,
+		sensors:       sensors,
+		history:       history,
+		aggregator:    aggregator,
+		aqi:           monitor,
+		allowRollback: allowRollback,
+	}
+}
+
+// sensorsResponse lists the configured sensors
+type sensorsResponse struct {
+	Sensors []sensorState `json:"sensors"`
+}
+
+// readingsResponse lists the latest reading of each selected sensor
+type readingsResponse struct {
+	Readings []sensorReading `json:"readings"`
+}
+
+// baselineResponse is the stored baseline of a gas sensor and its history
+type baselineResponse struct {
+	Serial   string                 `json:"serial"`
+	Baseline *sgp30.BaselineReading `json:"baseline"`
+	History  []state.BaselineRecord `json:"history"`
+}
+
+// rollbackRequest selects the baseline in the history of a gas sensor to roll back to
+type rollbackRequest struct {
+	Index *int `json:"index"`
+}
+
+func (a *api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/"), "/")
+	segments := strings.Split(path, "/")
+
+	switch {
+	case len(segments) == 1 && segments[0] == "openapi.yaml":
+		a.serveOpenAPI(w, r)
+	case len(segments) == 1 && segments[0] == "sensors":
+		a.serveSensors(w, r, r.URL.Query()["sensor"])
+	case len(segments) == 2 && segments[0] == "sensors":
+		a.serveSensor(w, r, segments[1])
+	case len(segments) == 1 && segments[0] == "readings":
+		a.serveReadings(w, r, r.URL.Query()["sensor"])
+	case len(segments) == 2 && segments[0] == "readings":
+		a.serveReading(w, r, segments[1])
+	case len(segments) == 1 && segments[0] == "stream":
+		a.serveStream(w, r)
+	case len(segments) == 1 && segments[0] == "history":
+		a.serveHistory(w, r)
+	case len(segments) == 1 && segments[0] == "summaries":
+		a.serveSummaries(w, r)
+	case len(segments) == 1 && segments[0] == "aqi":
+		a.serveAQI(w, r)
+	case len(segments) == 3 && segments[0] == "sensors" && segments[2] == "baseline":
+		a.serveBaseline(w, r, segments[1])
+	case len(segments) == 4 && segments[0] == "sensors" && segments[2] == "baseline" && segments[3] == "rollback":
+		a.serveRollback(w, r, segments[1])
+	default:
+		writeError(w, http.StatusNotFound, errors.Errorf("failed to find %v", r.URL.Path))
+	}
+}
+
+// gasSensor returns the driver of the named gas sensor and its serial, writing an error response if it is unknown or
+// has not reported its serial yet
+func (a *api) gasSensor(w http.ResponseWriter, name string) (*sgp30.Sensor, []uint16, bool) {
+	instance, ok := a.byName[name]
+	if !ok || instance.Model != models.SGP30 {
+		writeError(w, http.StatusNotFound, errors.Errorf("failed to find SGP30 sensor %v", name))
+		return nil, nil, false
+	}
+
+	sensor, serial := a.sensors.gasSensor(name)
+	if serial == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.Errorf("failed to identify sensor %v; it has not reported its serial yet", name))
+		return nil, nil, false
+	}
+	return sensor, serial, true
+}
+
+// allowGet writes an error response unless the request is a GET
+func allowGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.Errorf("failed to handle method %v", r.Method))
+		return false
+	}
+	return true
+}
+
+// selectInstances returns the named sensors, or every sensor if no names are given, writing an error response if any
+// of them is not configured
+func (a *api) selectInstances(w http.ResponseWriter, names []string) ([]SensorSettings, bool) {
+	selected, unknown := selectInstances(a.instances, names)
+	if len(unknown) > 0 {
+		writeError(w, http.StatusNotFound, errors.Errorf("failed to find sensors %v", strings.Join(unknown, ", ")))
+		return nil, false
+	}
+	return selected, true
+}
+
+func (a *api) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	_, err := w.Write(openAPIDocument)
+	if err != nil {
+		log.Debug("failed to write response",
+			"err", err)
+	}
+}
+
+func (a *api) serveSensors(w http.ResponseWriter, r *http.Request, names []string) {
+	if !allowGet(w, r) {
+		return
+	}
+
+	instances, ok := a.selectInstances(w, names)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, sensorsResponse{Sensors: a.sensors.sensorStates(instances)})
+}
+
+func (a *api) serveSensor(w http.ResponseWriter, r *http.Request, name string) {
+	if !allowGet(w, r) {
+		return
+	}
+
+	instances, ok := a.selectInstances(w, []string{name})
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, a.sensors.sensorStates(instances)[0])
+}
+
+func (a *api) serveReadings(w http.ResponseWriter, r *http.Request, names []string) {
+	if !allowGet(w, r) {
+		return
+	}
+
+	instances, ok := a.selectInstances(w, names)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, readingsResponse{Readings: a.sensors.readings(instances)})
+}
+
+func (a *api) serveReading(w http.ResponseWriter, r *http.Request, name string) {
+	if !allowGet(w, r) {
+		return
+	}
+
+	instances, ok := a.selectInstances(w, []string{name})
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, a.sensors.readings(instances)[0])
+}
+
+func (a *api) serveBaseline(w http.ResponseWriter, r *http.Request, name string) {
+	if !allowGet(w, r) {
+		return
+	}
+
+	_, serial, ok := a.gasSensor(w, name)
+	if !ok {
+		return
+	}
+
+	baseline, history := BaselineHistory(a.store, serial)
+	writeJSON(w, http.StatusOK, baselineResponse{
+		Serial:   sgp30.FormatSerial(serial),
+		Baseline: baseline,
+		History:  history,
+	})
+}
+
+func (a *api) serveRollback(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.Errorf("failed to handle method %v", r.Method))
+		return
+	}
+	if !a.allowRollback {
+		writeError(w, http.StatusForbidden, errors.New("failed to roll back baseline; rollback over the API is disabled, see --api-rollback"))
+		return
+	}
+
+	sensor, serial, ok := a.gasSensor(w, name)
+	if !ok {
+		return
+	}
+
+	request := rollbackRequest{}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.Index == nil {
+		writeError(w, http.StatusBadRequest, errors.New("failed to decode request; expected {\"index\": <index in history>}"))
+		return
+	}
+
+	baseline, err := RollbackBaseline(a.store, serial, *request.Index)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	sensor.RestoreBaseline(a.ctx, baseline)
+	log.Info("rolled back baseline",
+		"sensor", name,
+		"index", *request.Index,
+		"baseline", baseline)
+	writeJSON(w, http.StatusOK, baseline)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Debug("failed to write response",
+			"err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,275 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sensor-exporter/i2cbus"
+	"sensor-exporter/internal/models"
+	"sensor-exporter/internal/state"
+	"sensor-exporter/reconnect"
+	"sensor-exporter/sgp30"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAPI returns an API over an AHT20 with a recent reading, an acclimating SGP30 with a baseline history and a
+// second SGP30 that has not reported its serial
+func newTestAPI(t *testing.T, ctx context.Context, allowRollback bool) *api {
+	t.Helper()
+	instances := []SensorSettings{
+		{Name: "aht20", Model: models.AHT20, Room: "office"},
+		{Name: "sgp30", Model: models.SGP30},
+		{Name: "sgp30-2", Model: models.SGP30},
+	}
+	now := time.Now()
+	sensors := newTestTracker(t, instances, map[string]trackedState{
+		"aht20": {connected: true, readingAge: time.Second},
+		"sgp30": {connected: true, readingAge: time.Second, acclimating: time.Hour},
+	}, now)
+
+	serial := []uint16{0x0000, 0x0123, 0x4567}
+	sensors.setSerial("sgp30", serial)
+	// a newly reported serial drops the readings of the sensor it replaced
+	sensors.setAirQuality("sgp30", &sgp30.AirQualityReading{DurationUntilValid: time.Hour})
+	sensors.setGasSensor("sgp30", sgp30.NewSensor(i2cbus.NewFakeManager(map[uint8]io.ReadWriter{}), i2cbus.Address{Bus: 1, Device: 0x58}, reconnect.Settings{}, nil, nil))
+
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, eCO2 := range []sgp30.PartsPerMillion{0x8a2c, 0x8a40} {
+		_, err = recordBaseline(store, BaselinePolicy{}, &sgp30.BaselineReading{
+			Serial:                       serial,
+			SensorReadingsNotValidBefore: now.Add(-time.Hour),
+			BaselineInvalidAfter:         now.Add(sgp30.BaselineValidity),
+			EquivalentCO2:                eCO2,
+			TotalVOC:                     0x8d11,
+		}, state.Conditions{}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return newAPI(ctx, instances, store, sensors, nil, nil, nil, allowRollback)
+}
+
+func TestAPI(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		body          string
+		allowRollback bool
+		status        int
+		// Strings the response is expected to contain, and not to contain
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "sensors",
+			method:   http.MethodGet,
+			path:     "/api/v1/sensors",
+			status:   http.StatusOK,
+			contains: []string{`"name":"aht20"`, `"name":"sgp30"`, `"serial":"000001234567"`, `"room":"office"`, `"connected":true`},
+		},
+		{
+			name:     "sensors selected",
+			method:   http.MethodGet,
+			path:     "/api/v1/sensors?sensor=sgp30&sensor=sgp30-2",
+			status:   http.StatusOK,
+			contains: []string{`"name":"sgp30"`, `"name":"sgp30-2"`},
+			excludes: []string{`"name":"aht20"`},
+		},
+		{
+			name:     "unknown sensors selected",
+			method:   http.MethodGet,
+			path:     "/api/v1/sensors?sensor=aht20&sensor=pms5003&sensor=sgp40",
+			status:   http.StatusNotFound,
+			contains: []string{"failed to find sensors pms5003, sgp40"},
+		},
+		{
+			name:     "sensor",
+			method:   http.MethodGet,
+			path:     "/api/v1/sensors/aht20",
+			status:   http.StatusOK,
+			contains: []string{`{"name":"aht20","model":"AHT20"`},
+		},
+		{
+			name:     "unknown sensor",
+			method:   http.MethodGet,
+			path:     "/api/v1/sensors/pms5003",
+			status:   http.StatusNotFound,
+			contains: []string{"failed to find sensors pms5003"},
+		},
+		{
+			name:     "sensors with another method",
+			method:   http.MethodPost,
+			path:     "/api/v1/sensors",
+			status:   http.StatusMethodNotAllowed,
+			contains: []string{"failed to handle method POST"},
+		},
+		{
+			name:     "readings",
+			method:   http.MethodGet,
+			path:     "/api/v1/readings",
+			status:   http.StatusOK,
+			contains: []string{`"sensor":"aht20"`, `"temperature":{"value":21.5,"unit":"celsius","valid":true`, `"sensor":"sgp30-2","model":"SGP30","measurements":{}`},
+		},
+		{
+			name:     "reading of an acclimating gas sensor",
+			method:   http.MethodGet,
+			path:     "/api/v1/readings/sgp30",
+			status:   http.StatusOK,
+			contains: []string{`"acclimation":{"isValid":false,"isInitialized":false,"durationUntilValidSeconds":3600}`},
+		},
+		{
+			name:     "OpenAPI document",
+			method:   http.MethodGet,
+			path:     "/api/v1/openapi.yaml",
+			status:   http.StatusOK,
+			contains: []string{"openapi: 3.0.3"},
+		},
+		{
+			name:     "unknown path",
+			method:   http.MethodGet,
+			path:     "/api/v1/sensors/aht20/firmware",
+			status:   http.StatusNotFound,
+			contains: []string{"failed to find /api/v1/sensors/aht20/firmware"},
+		},
+		{
+			name:     "history disabled",
+			method:   http.MethodGet,
+			path:     "/api/v1/history?sensor=aht20&measurement=temperature",
+			status:   http.StatusNotFound,
+			contains: []string{"the history is disabled"},
+		},
+		{
+			name:     "summaries disabled",
+			method:   http.MethodGet,
+			path:     "/api/v1/summaries",
+			status:   http.StatusNotFound,
+			contains: []string{"aggregation is disabled"},
+		},
+		{
+			name:     "air quality index disabled",
+			method:   http.MethodGet,
+			path:     "/api/v1/aqi",
+			status:   http.StatusNotFound,
+			contains: []string{"it is disabled"},
+		},
+		{
+			name:     "baseline",
+			method:   http.MethodGet,
+			path:     "/api/v1/sensors/sgp30/baseline",
+			status:   http.StatusOK,
+			contains: []string{`"serial":"000001234567"`, `"EquivalentCO2":35392`, `"history":[`},
+		},
+		{
+			name:     "baseline of a sensor other than an SGP30",
+			method:   http.MethodGet,
+			path:     "/api/v1/sensors/aht20/baseline",
+			status:   http.StatusNotFound,
+			contains: []string{"failed to find SGP30 sensor aht20"},
+		},
+		{
+			name:     "baseline before the serial is reported",
+			method:   http.MethodGet,
+			path:     "/api/v1/sensors/sgp30-2/baseline",
+			status:   http.StatusServiceUnavailable,
+			contains: []string{"it has not reported its serial yet"},
+		},
+		{
+			name:     "rollback disabled",
+			method:   http.MethodPost,
+			path:     "/api/v1/sensors/sgp30/baseline/rollback",
+			body:     `{"index": 0}`,
+			status:   http.StatusForbidden,
+			contains: []string{"--api-rollback"},
+		},
+		{
+			name:          "rollback with another method",
+			method:        http.MethodGet,
+			path:          "/api/v1/sensors/sgp30/baseline/rollback",
+			allowRollback: true,
+			status:        http.StatusMethodNotAllowed,
+		},
+		{
+			name:          "rollback without an index",
+			method:        http.MethodPost,
+			path:          "/api/v1/sensors/sgp30/baseline/rollback",
+			body:          `{}`,
+			allowRollback: true,
+			status:        http.StatusBadRequest,
+			contains:      []string{"failed to decode request"},
+		},
+		{
+			name:          "rollback beyond the history",
+			method:        http.MethodPost,
+			path:          "/api/v1/sensors/sgp30/baseline/rollback",
+			body:          `{"index": 2}`,
+			allowRollback: true,
+			status:        http.StatusBadRequest,
+			contains:      []string{"failed to roll back to baseline 2 of 2"},
+		},
+		{
+			name:          "rollback",
+			method:        http.MethodPost,
+			path:          "/api/v1/sensors/sgp30/baseline/rollback",
+			body:          `{"index": 0}`,
+			allowRollback: true,
+			status:        http.StatusOK,
+			contains:      []string{`"EquivalentCO2":35372`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			api := newTestAPI(t, ctx, test.allowRollback)
+
+			recorder := httptest.NewRecorder()
+			api.ServeHTTP(recorder, httptest.NewRequest(test.method, test.path, strings.NewReader(test.body)))
+
+			if recorder.Code != test.status {
+				t.Errorf("got status %v, want %v", recorder.Code, test.status)
+			}
+			body := recorder.Body.String()
+			for _, want := range test.contains {
+				if !strings.Contains(body, want) {
+					t.Errorf("got body %v, want it to contain %v", body, want)
+				}
+			}
+			for _, unwanted := range test.excludes {
+				if strings.Contains(body, unwanted) {
+					t.Errorf("got body %v, want it not to contain %v", body, unwanted)
+				}
+			}
+			if test.path != "/api/v1/openapi.yaml" && !json.Valid(recorder.Body.Bytes()) {
+				t.Errorf("got body %v, want JSON", body)
+			}
+		})
+	}
+}
+
+func TestRollbackStoresBaseline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api := newTestAPI(t, ctx, true)
+
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/sensors/sgp30/baseline/rollback", strings.NewReader(`{"index": 0}`)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %v, want %v", recorder.Code, http.StatusOK)
+	}
+
+	baseline, _ := BaselineHistory(api.store, []uint16{0x0000, 0x0123, 0x4567})
+	if baseline == nil || baseline.EquivalentCO2 != 0x8a2c {
+		t.Errorf("got stored baseline %+v, want the first baseline in the history", baseline)
+	}
+}
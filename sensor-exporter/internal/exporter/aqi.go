@@ -0,0 +1,103 @@
+package exporter
+
+import (
+	"net/http"
+	"sensor-exporter/internal/aqi"
+	"sensor-exporter/internal/history"
+	"sensor-exporter/internal/measurement"
+	"sensor-exporter/internal/models"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/go-kit/v2/cmd"
+	"github.com/syncromatics/go-kit/v2/log"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// aqiRestoreStep is the resolution at which hourly averages are restored from the history, fine enough to tell which
+// quarter-hours of an hour had samples
+const aqiRestoreStep = 15 * time.Minute
+
+// aqiResponse lists the air quality index of each selected particulate sensor
+type aqiResponse struct {
+	Reports []aqi.Report `json:"reports"`
+}
+
+// startAQI starts computing the air quality index of the particulate sensors, if enabled, restoring the hourly
+// averages of the last day from the history if it is enabled
+func startAQI(group *cmd.ProcessGroup, settings *Settings, instances []SensorSettings, sensors *tracker, historyStore *history.Store) *aqi.Monitor {
+	if !settings.AQI {
+		return nil
+	}
+	particulate := []SensorSettings{}
+	for _, instance := range instances {
+		if instance.Model == models.PMS5003 {
+			particulate = append(particulate, instance)
+		}
+	}
+	if len(particulate) == 0 {
+		return nil
+	}
+
+	monitor := aqi.NewMonitor()
+	if historyStore != nil {
+		restoreAQI(monitor, particulate, historyStore, time.Now())
+	}
+
+	registry.MustRegister(monitor)
+	subscription := sensors.samples.subscribe("aqi", sampleFilter{Measurements: maps.Keys(aqi.Measurements)}, outputBuffer)
+	group.Go(monitor.Start(group.Context(), subscription.samples))
+	return monitor
+}
+
+// restoreAQI adds the averages of each quarter-hour of the last day held by the history to the monitor
+func restoreAQI(monitor *aqi.Monitor, instances []SensorSettings, historyStore *history.Store, now time.Time) {
+	from := now.Truncate(time.Hour).Add(-24 * time.Hour)
+	for _, instance := range instances {
+		source := measurement.Source{Sensor: instance.Name, Model: strings.ToUpper(instance.Model)}
+		for name := range aqi.Measurements {
+			result, err := historyStore.Query(history.Query{
+				Sensor:      instance.Name,
+				Measurement: name,
+				From:        from,
+				To:          now,
+				Step:        aqiRestoreStep,
+			})
+			if err != nil {
+				log.Warn("failed to restore hourly averages for the air quality index from the history",
+					"err", err,
+					"sensor", instance.Name,
+					"measurement", name)
+				continue
+			}
+			for _, point := range result.Points {
+				monitor.Restore(source, name, point.Time, point.Mean, int(point.Count))
+			}
+		}
+	}
+}
+
+// serveAQI answers /api/v1/aqi?sensor= with the air quality index of each selected particulate sensor
+func (a *api) serveAQI(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	if a.aqi == nil {
+		writeError(w, http.StatusNotFound, errors.New("failed to compute the air quality index; it is disabled or no PMS5003 is configured"))
+		return
+	}
+
+	names := r.URL.Query()["sensor"]
+	if _, ok := a.selectInstances(w, names); !ok {
+		return
+	}
+	reports := []aqi.Report{}
+	for _, report := range a.aqi.Reports(time.Now()) {
+		if len(names) == 0 || slices.Contains(names, report.Sensor) {
+			reports = append(reports, report)
+		}
+	}
+	writeJSON(w, http.StatusOK, aqiResponse{Reports: reports})
+}
@@ -0,0 +1,247 @@
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"sensor-exporter/clock"
+	"sensor-exporter/internal/state"
+	"sensor-exporter/sgp30"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+// baselinePolicyReferenceSize is the number of recently accepted baselines whose median a new baseline is compared to
+const baselinePolicyReferenceSize = 24
+
+// BaselinePolicy decides whether a baseline read from an SGP30 may replace the stored baseline that is restored when
+// the sensor starts
+type BaselinePolicy struct {
+	// Fraction by which either signal of a baseline may deviate from the median of recently accepted baselines; 0
+	// accepts every baseline
+	MaxDeviation float64
+	// Number of baselines kept in the history of each sensor
+	HistorySize int
+	// Number of consecutive rejected baselines, deviating from the median of recent baselines by no more than the
+	// maximum deviation from each other, after which they are accepted as the new normal of a sensor whose environment
+	// or response has changed for good; 0 keeps rejecting them
+	AcceptAfter int
+}
+
+// BaselinePolicy returns the policy applied to baselines read from SGP30 sensors
+func (s *Settings) BaselinePolicy() BaselinePolicy {
+	return BaselinePolicy{
+		MaxDeviation: s.BaselineMaxDeviation,
+		HistorySize:  s.BaselineHistorySize,
+		AcceptAfter:  s.BaselineAcceptAfter,
+	}
+}
+
+// Evaluate returns why a baseline should be rejected given the history of the sensor, or an empty string to accept it.
+// Baselines read while the sensor was still acclimating are neither judged nor used to judge later baselines.
+func (p BaselinePolicy) Evaluate(history []state.BaselineRecord, baseline *sgp30.BaselineReading, now time.Time) string {
+	if p.MaxDeviation <= 0 || now.Before(baseline.SensorReadingsNotValidBefore) {
+		return ""
+	}
+
+	eCO2 := []float64{}
+	tVOC := []float64{}
+	for idx := len(history) - 1; idx >= 0 && len(eCO2) < baselinePolicyReferenceSize; idx-- {
+		record := history[idx]
+		if record.Rejected != "" || record.RecordedAt.Before(record.Baseline.SensorReadingsNotValidBefore) {
+			continue
+		}
+		eCO2 = append(eCO2, float64(record.Baseline.EquivalentCO2))
+		tVOC = append(tVOC, float64(record.Baseline.TotalVOC))
+	}
+	if len(eCO2) == 0 {
+		return ""
+	}
+
+	reason := ""
+	if deviation := relativeDeviation(float64(baseline.EquivalentCO2), median(eCO2)); deviation > p.MaxDeviation {
+		reason = fmt.Sprintf("eCO2 baseline deviates %.0f%% from the median of recent baselines", deviation*100)
+	} else if deviation := relativeDeviation(float64(baseline.TotalVOC), median(tVOC)); deviation > p.MaxDeviation {
+		reason = fmt.Sprintf("TVOC baseline deviates %.0f%% from the median of recent baselines", deviation*100)
+	}
+	if reason != "" && p.drift(history, baseline, now) != nil {
+		return ""
+	}
+	return reason
+}
+
+// drift returns the indexes in the history of the rejected baselines that precede a baseline, if together with it they
+// are enough consecutive rejections that agree with each other to be taken as a lasting drift of the sensor. Baselines
+// read while the sensor was acclimating are skipped, as they are neither accepted nor rejected.
+func (p BaselinePolicy) drift(history []state.BaselineRecord, baseline *sgp30.BaselineReading, now time.Time) []int {
+	if p.AcceptAfter <= 0 || p.MaxDeviation <= 0 || now.Before(baseline.SensorReadingsNotValidBefore) {
+		return nil
+	}
+
+	indexes := []int{}
+	eCO2 := []float64{float64(baseline.EquivalentCO2)}
+	tVOC := []float64{float64(baseline.TotalVOC)}
+	for idx := len(history) - 1; idx >= 0 && len(eCO2) < p.AcceptAfter; idx-- {
+		record := history[idx]
+		if record.RecordedAt.Before(record.Baseline.SensorReadingsNotValidBefore) {
+			continue
+		}
+		if record.Rejected == "" {
+			break
+		}
+		indexes = append(indexes, idx)
+		eCO2 = append(eCO2, float64(record.Baseline.EquivalentCO2))
+		tVOC = append(tVOC, float64(record.Baseline.TotalVOC))
+	}
+	if len(eCO2) < p.AcceptAfter {
+		return nil
+	}
+
+	eCO2Median, tVOCMedian := median(eCO2), median(tVOC)
+	for i := range eCO2 {
+		if relativeDeviation(eCO2[i], eCO2Median) > p.MaxDeviation || relativeDeviation(tVOC[i], tVOCMedian) > p.MaxDeviation {
+			return nil
+		}
+	}
+	return indexes
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+func relativeDeviation(value, reference float64) float64 {
+	if reference == 0 {
+		return 0
+	}
+	return math.Abs(value-reference) / reference
+}
+
+// recordBaseline adds a baseline to the history of its sensor and, if the policy accepts it, stores it as the baseline
+// to restore. It returns the reason the baseline was rejected, if it was.
+func recordBaseline(store *state.Store, policy BaselinePolicy, baseline *sgp30.BaselineReading, conditions state.Conditions, untrustedTime bool) (string, error) {
+	rejected := ""
+	now := time.Now()
+	err := store.Update(BaselineKey(baseline.Serial), func(sensor *state.Sensor) error {
+		sensor.Model = "SGP30"
+		if baseline.Provisional {
+			// provisional baselines only preserve acclimation progress and are too frequent to keep in the history
+			sensor.Baseline = baseline
+			return nil
+		}
+
+		drift := policy.drift(sensor.BaselineHistory, baseline, now)
+		rejected = policy.Evaluate(sensor.BaselineHistory, baseline, now)
+		if rejected == "" && len(drift) > 0 {
+			// the rejected baselines agree with the accepted one, so they become the reference for later baselines
+			for _, idx := range drift {
+				sensor.BaselineHistory[idx].Rejected = ""
+			}
+			log.Warn("accepting baselines after consecutive consistent rejections; the sensor appears to have drifted",
+				"serial", sgp30.FormatSerial(baseline.Serial),
+				"rejections", len(drift),
+				"baseline", baseline)
+		}
+		sensor.BaselineHistory = append(sensor.BaselineHistory, state.BaselineRecord{
+			Baseline:      baseline,
+			RecordedAt:    now,
+			Conditions:    conditions,
+			Rejected:      rejected,
+			UntrustedTime: untrustedTime,
+		})
+		if policy.HistorySize > 0 && len(sensor.BaselineHistory) > policy.HistorySize {
+			sensor.BaselineHistory = sensor.BaselineHistory[len(sensor.BaselineHistory)-policy.HistorySize:]
+		}
+		if rejected == "" {
+			sensor.Baseline = baseline
+			sensor.Acclimation = nil
+		}
+		return nil
+	})
+	return rejected, err
+}
+
+// baselineKeeper stores the baselines and acclimation progress of gas sensors, marking those stored while the wall
+// clock is not synchronized as having untrusted timestamps
+type baselineKeeper struct {
+	store  *state.Store
+	policy BaselinePolicy
+	clock  *clock.Guard
+}
+
+func (k *baselineKeeper) tryRecord(instance SensorSettings, labels sensorLabels, baseline *sgp30.BaselineReading, conditions state.Conditions) {
+	// the driver only reads baselines once the clock is trusted or the guard gave up waiting for it, in which case
+	// the baseline is kept rather than lose the sensor's progress to a clock that may never synchronize
+	untrustedTime := !k.clock.Synchronized()
+	if untrustedTime {
+		log.Warn("storing baseline with untrusted timestamps since the clock is not synchronized",
+			"sensor", instance.Name,
+			"baseline", baseline)
+	}
+
+	store, policy := k.store, k.policy
+	rejected, err := recordBaseline(store, policy, baseline, conditions, untrustedTime)
+	if err != nil {
+		log.Error("failed to store baseline",
+			"err", err,
+			"sensor", instance.Name,
+			"baseline", baseline,
+			"path", store.Path())
+		return
+	}
+
+	if rejected != "" {
+		incSGPBaselineRejections(labels)
+		log.Warn("refused to store suspicious baseline; keeping the previous baseline",
+			"sensor", instance.Name,
+			"reason", rejected,
+			"baseline", baseline,
+			"conditions", conditions)
+		return
+	}
+
+	log.Info("stored new baseline",
+		"sensor", instance.Name,
+		"provisional", baseline.Provisional,
+		"path", store.Path(),
+		"baseline", baseline)
+}
+
+// BaselineHistory returns the stored baseline and the baseline history of the SGP30 sensor with the given serial
+func BaselineHistory(store *state.Store, serial []uint16) (*sgp30.BaselineReading, []state.BaselineRecord) {
+	sensor := store.Sensor(BaselineKey(serial))
+	if sensor == nil {
+		return nil, nil
+	}
+	return sensor.Baseline, sensor.BaselineHistory
+}
+
+// RollbackBaseline stores the baseline at the given index of the history of the SGP30 sensor with the given serial as
+// the baseline to restore. The rolled back baseline is valid for the full validity period from now, since the sensor
+// has been running in the meantime.
+func RollbackBaseline(store *state.Store, serial []uint16, index int) (*sgp30.BaselineReading, error) {
+	var rolledBack *sgp30.BaselineReading
+	err := store.Update(BaselineKey(serial), func(sensor *state.Sensor) error {
+		if index < 0 || index >= len(sensor.BaselineHistory) {
+			return errors.Errorf("failed to roll back to baseline %v of %v in the history of sensor %v",
+				index,
+				len(sensor.BaselineHistory),
+				sgp30.FormatSerial(serial))
+		}
+
+		baseline := *sensor.BaselineHistory[index].Baseline
+		baseline.BaselineInvalidAfter = time.Now().Add(sgp30.BaselineValidity)
+		sensor.Baseline = &baseline
+		rolledBack = &baseline
+		return nil
+	})
+	return rolledBack, err
+}
@@ -0,0 +1,125 @@
+package exporter
+
+import (
+	"sensor-exporter/internal/state"
+	"sensor-exporter/sgp30"
+	"testing"
+	"time"
+)
+
+func TestBaselinePolicyEvaluate(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	reading := func(eCO2, tVOC int) *sgp30.BaselineReading {
+		return &sgp30.BaselineReading{
+			EquivalentCO2: sgp30.PartsPerMillion(eCO2),
+			TotalVOC:      sgp30.PartsPerBillion(tVOC),
+		}
+	}
+	records := func(count int, eCO2, tVOC int, rejected string) []state.BaselineRecord {
+		history := []state.BaselineRecord{}
+		for i := 0; i < count; i++ {
+			history = append(history, state.BaselineRecord{
+				Baseline:   reading(eCO2, tVOC),
+				RecordedAt: now.Add(-time.Duration(count-i) * time.Hour),
+				Rejected:   rejected,
+			})
+		}
+		return history
+	}
+	join := func(histories ...[]state.BaselineRecord) []state.BaselineRecord {
+		joined := []state.BaselineRecord{}
+		for _, history := range histories {
+			joined = append(joined, history...)
+		}
+		return joined
+	}
+	policy := BaselinePolicy{MaxDeviation: 0.2, AcceptAfter: 4}
+
+	tests := []struct {
+		name     string
+		policy   BaselinePolicy
+		history  []state.BaselineRecord
+		baseline *sgp30.BaselineReading
+		rejected bool
+		drift    int
+	}{
+		{
+			name:     "no history",
+			policy:   policy,
+			baseline: reading(30000, 30000),
+		},
+		{
+			name:     "within the deviation",
+			policy:   policy,
+			history:  records(5, 30000, 30000, ""),
+			baseline: reading(33000, 27000),
+		},
+		{
+			name:     "eCO2 beyond the deviation",
+			policy:   policy,
+			history:  records(5, 30000, 30000, ""),
+			baseline: reading(40000, 30000),
+			rejected: true,
+		},
+		{
+			name:     "TVOC beyond the deviation",
+			policy:   policy,
+			history:  records(5, 30000, 30000, ""),
+			baseline: reading(30000, 20000),
+			rejected: true,
+		},
+		{
+			name:     "too few consistent rejections",
+			policy:   policy,
+			history:  join(records(5, 30000, 30000, ""), records(2, 40000, 30000, "deviates")),
+			baseline: reading(40000, 30000),
+			rejected: true,
+		},
+		{
+			name:     "enough consistent rejections",
+			policy:   policy,
+			history:  join(records(5, 30000, 30000, ""), records(3, 40000, 30000, "deviates")),
+			baseline: reading(41000, 30000),
+			drift:    3,
+		},
+		{
+			name:     "rejections that disagree with each other",
+			policy:   policy,
+			history:  join(records(5, 30000, 30000, ""), records(2, 40000, 30000, "deviates"), records(1, 60000, 30000, "deviates")),
+			baseline: reading(40000, 30000),
+			rejected: true,
+		},
+		{
+			name:     "rejections interrupted by an accepted baseline",
+			policy:   policy,
+			history:  join(records(2, 40000, 30000, "deviates"), records(5, 30000, 30000, ""), records(1, 40000, 30000, "deviates")),
+			baseline: reading(40000, 30000),
+			rejected: true,
+		},
+		{
+			name:     "drift disabled",
+			policy:   BaselinePolicy{MaxDeviation: 0.2},
+			history:  join(records(5, 30000, 30000, ""), records(10, 40000, 30000, "deviates")),
+			baseline: reading(40000, 30000),
+			rejected: true,
+		},
+		{
+			name:     "policy disabled",
+			policy:   BaselinePolicy{},
+			history:  records(5, 30000, 30000, ""),
+			baseline: reading(60000, 10000),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rejected := test.policy.Evaluate(test.history, test.baseline, now)
+			if (rejected != "") != test.rejected {
+				t.Errorf("got rejection %q, want rejected %v", rejected, test.rejected)
+			}
+			if drift := test.policy.drift(test.history, test.baseline, now); len(drift) != test.drift {
+				t.Errorf("got %v drifted baselines, want %v", len(drift), test.drift)
+			}
+		})
+	}
+}
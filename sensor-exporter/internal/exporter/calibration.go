@@ -0,0 +1,77 @@
+package exporter
+
+import (
+	"sensor-exporter/internal/measurement"
+	"sensor-exporter/internal/models"
+	"sensor-exporter/internal/state"
+	"sensor-exporter/units"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+)
+
+// calibratedMeasurements lists the measurements of each model that can be calibrated. Derived measurements follow the
+// ones they are derived from, and SGP30 sensors calibrate themselves through their baseline.
+var calibratedMeasurements = map[string][]string{
+	models.AHT20:   {"temperature", "relative_humidity"},
+	models.PMS5003: measurement.Measurements["PMS5003"],
+}
+
+// Calibration holds the offsets added to the readings of a sensor by measurement, e.g. a negative temperature offset
+// for a sensor warmed by the board it is mounted on
+type Calibration map[string]float64
+
+// CalibrationKey returns the key of the state holding the calibration of a sensor
+func CalibrationKey(instance SensorSettings) string {
+	return state.InstanceKey(instance.Model, instance.Name)
+}
+
+// StoredCalibration returns the calibration of a sensor held in the state store, which is empty if none is stored
+func StoredCalibration(store *state.Store, instance SensorSettings) Calibration {
+	sensor := store.Sensor(CalibrationKey(instance))
+	if sensor == nil {
+		return Calibration{}
+	}
+	return Calibration(sensor.Calibration)
+}
+
+// ValidateCalibration returns an error if the calibration sets an offset for a measurement of the model that cannot be
+// calibrated
+func ValidateCalibration(model string, calibration Calibration) error {
+	calibrated, ok := calibratedMeasurements[model]
+	if !ok {
+		return errors.Errorf("failed to calibrate %v sensor; only %v and %v sensors can be calibrated", model, models.AHT20, models.PMS5003)
+	}
+	for name := range calibration {
+		if !slices.Contains(calibrated, name) {
+			return errors.Errorf("failed to calibrate %v of %v sensor; measurements that can be calibrated are %v", name, model, strings.Join(calibrated, ", "))
+		}
+	}
+	return nil
+}
+
+// Apply adds the offsets to the matching samples and derives the absolute humidity again from the calibrated
+// temperature and relative humidity
+func (c Calibration) Apply(samples []measurement.Sample) []measurement.Sample {
+	if len(c) == 0 {
+		return samples
+	}
+
+	var temperature, relativeHumidity float64
+	for i := range samples {
+		samples[i].Value += c[samples[i].Measurement]
+		switch samples[i].Measurement {
+		case "temperature":
+			temperature = samples[i].Value
+		case "relative_humidity":
+			relativeHumidity = samples[i].Value
+		}
+	}
+	for i := range samples {
+		if samples[i].Measurement == "absolute_humidity" {
+			samples[i].Value = float64(units.AbsoluteHumidity(units.Celsius(temperature), units.RelativeHumidity(relativeHumidity)))
+		}
+	}
+	return samples
+}
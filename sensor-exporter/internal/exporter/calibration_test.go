@@ -0,0 +1,54 @@
+package exporter
+
+import (
+	"math"
+	"sensor-exporter/internal/measurement"
+	"sensor-exporter/internal/models"
+	"sensor-exporter/units"
+	"testing"
+)
+
+func TestValidateCalibration(t *testing.T) {
+	tests := []struct {
+		name        string
+		model       string
+		calibration Calibration
+		valid       bool
+	}{
+		{"AHT20 temperature and humidity", models.AHT20, Calibration{"temperature": -1.5, "relative_humidity": 0.02}, true},
+		{"AHT20 derived measurement", models.AHT20, Calibration{"absolute_humidity": 1}, false},
+		{"PMS5003 particulate matter", models.PMS5003, Calibration{"pm2_5_environmental": -2}, true},
+		{"PMS5003 temperature", models.PMS5003, Calibration{"temperature": -1.5}, false},
+		{"SGP30", models.SGP30, Calibration{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateCalibration(test.model, test.calibration)
+			if (err == nil) != test.valid {
+				t.Errorf("got error %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestCalibrationApply(t *testing.T) {
+	samples := []measurement.Sample{
+		{Measurement: "temperature", Value: 25},
+		{Measurement: "relative_humidity", Value: 0.4},
+		{Measurement: "absolute_humidity", Value: float64(units.AbsoluteHumidity(25, 0.4))},
+	}
+
+	samples = Calibration{"temperature": -2, "relative_humidity": 0.05}.Apply(samples)
+
+	want := map[string]float64{
+		"temperature":       23,
+		"relative_humidity": 0.45,
+		"absolute_humidity": float64(units.AbsoluteHumidity(23, 0.45)),
+	}
+	for _, sample := range samples {
+		if math.Abs(sample.Value-want[sample.Measurement]) > 1e-9 {
+			t.Errorf("got %v %v, want %v", sample.Measurement, sample.Value, want[sample.Measurement])
+		}
+	}
+}
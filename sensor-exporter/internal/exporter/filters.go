@@ -0,0 +1,69 @@
+package exporter
+
+import (
+	"sensor-exporter/internal/filter"
+	"sensor-exporter/internal/measurement"
+	"sensor-exporter/internal/models"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+)
+
+// DefaultFilters reject values outside the operating range of each model, as given by its datasheet
+var DefaultFilters = map[string]map[string]filter.Settings{
+	models.AHT20: {
+		"temperature":       {Min: float64Ptr(-40), Max: float64Ptr(85)},
+		"relative_humidity": {Min: float64Ptr(0), Max: float64Ptr(1)},
+	},
+}
+
+// FilterRules returns the filter chain of each measurement of each sensor. The filters of a sensor override the
+// filters of the configuration, which override the defaults of the model, one measurement at a time.
+func (s *Settings) FilterRules(instances []SensorSettings) (map[string]map[string]filter.Settings, error) {
+	err := validateFilters("filters", s.Filters, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := map[string]map[string]filter.Settings{}
+	for _, instance := range instances {
+		measurements := measurement.Measurements[strings.ToUpper(instance.Model)]
+		err := validateFilters("filters of sensor "+instance.Name, instance.Filters, measurements)
+		if err != nil {
+			return nil, err
+		}
+
+		sensorRules := map[string]filter.Settings{}
+		for _, layer := range []map[string]filter.Settings{DefaultFilters[instance.Model], s.Filters, instance.Filters} {
+			for name, settings := range layer {
+				if slices.Contains(measurements, name) {
+					sensorRules[name] = settings
+				}
+			}
+		}
+		rules[instance.Name] = sensorRules
+	}
+	return rules, nil
+}
+
+// validateFilters checks the filters of measurements, which must be among the given measurements or, if none are
+// given, among the measurements of any model
+func validateFilters(scope string, filters map[string]filter.Settings, measurements []string) error {
+	for name, settings := range filters {
+		known := slices.Contains(measurements, name)
+		if measurements == nil {
+			for _, names := range measurement.Measurements {
+				known = known || slices.Contains(names, name)
+			}
+		}
+		if !known {
+			return errors.Errorf("failed to configure %v; unknown measurement %v", scope, name)
+		}
+		err := settings.Validate()
+		if err != nil {
+			return errors.Wrapf(err, "failed to configure %v for %v", scope, name)
+		}
+	}
+	return nil
+}
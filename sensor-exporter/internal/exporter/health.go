@@ -0,0 +1,147 @@
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"sensor-exporter/sgp30"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ReadinessRules decide when the exporter reports itself ready
+type ReadinessRules struct {
+	// Maximum age of the latest reading of a sensor for it to be ready
+	MaxReadingAge time.Duration
+	// Names of the sensors that must be ready for the exporter to be ready
+	Required map[string]bool
+	// Whether gas sensors must have finished acclimating to be ready
+	RequireAcclimated bool
+}
+
+// ReadinessRules returns the readiness rules for the given sensor instances
+func (s *Settings) ReadinessRules(instances []SensorSettings) (ReadinessRules, error) {
+	rules := ReadinessRules{
+		MaxReadingAge:     s.ReadyMaxReadingAge,
+		Required:          map[string]bool{},
+		RequireAcclimated: s.ReadyRequireAcclimated,
+	}
+
+	names := map[string]bool{}
+	for _, instance := range instances {
+		names[instance.Name] = true
+	}
+
+	if len(s.ReadySensors) == 0 {
+		rules.Required = names
+		return rules, nil
+	}
+	for _, name := range s.ReadySensors {
+		if !names[name] {
+			return rules, errors.Errorf("failed to require unknown sensor %v for readiness", name)
+		}
+		rules.Required[name] = true
+	}
+	return rules, nil
+}
+
+// sensorHealth is the state of one sensor as reported by /readyz
+type sensorHealth struct {
+	Name                        string     `json:"name"`
+	Model                       string     `json:"model"`
+	Serial                      string     `json:"serial,omitempty"`
+	Required                    bool       `json:"required"`
+	Ready                       bool       `json:"ready"`
+	Reasons                     []string   `json:"reasons,omitempty"`
+	Connected                   bool       `json:"connected"`
+	Circuit                     string     `json:"circuit"`
+	Acclimating                 bool       `json:"acclimating"`
+	AcclimationRemainingSeconds float64    `json:"acclimationRemainingSeconds,omitempty"`
+	LastReadingAt               *time.Time `json:"lastReadingAt,omitempty"`
+	LastReadingAgeSeconds       *float64   `json:"lastReadingAgeSeconds,omitempty"`
+	LastError                   string     `json:"lastError,omitempty"`
+	LastErrorAt                 *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// readiness is the response of /readyz
+type readiness struct {
+	Ready   bool           `json:"ready"`
+	Sensors []sensorHealth `json:"sensors"`
+}
+
+// health reports the state of each sensor and whether the exporter is ready according to the rules
+func (t *tracker) health(instances []SensorSettings, rules ReadinessRules, now time.Time) readiness {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := readiness{
+		Ready:   true,
+		Sensors: []sensorHealth{},
+	}
+	for _, instance := range instances {
+		tracked := t.sensor(instance.Name)
+		health := sensorHealth{
+			Name:      instance.Name,
+			Model:     strings.ToUpper(instance.Model),
+			Required:  rules.Required[instance.Name],
+			Connected: tracked.status.Connected,
+			Circuit:   tracked.status.Circuit.String(),
+		}
+		if tracked.serial != nil {
+			health.Serial = sgp30.FormatSerial(tracked.serial)
+		}
+		if tracked.airQuality != nil && tracked.airQuality.DurationUntilValid > 0 {
+			health.Acclimating = true
+			health.AcclimationRemainingSeconds = tracked.airQuality.DurationUntilValid.Seconds()
+		}
+		if !tracked.lastReadingAt.IsZero() {
+			lastReadingAt := tracked.lastReadingAt
+			age := now.Sub(lastReadingAt).Seconds()
+			health.LastReadingAt = &lastReadingAt
+			health.LastReadingAgeSeconds = &age
+		}
+		if tracked.status.LastError != nil {
+			lastErrorAt := tracked.status.LastErrorAt
+			health.LastError = tracked.status.LastError.Error()
+			health.LastErrorAt = &lastErrorAt
+		}
+
+		if !health.Connected {
+			health.Reasons = append(health.Reasons, "not connected")
+		}
+		switch {
+		case tracked.lastReadingAt.IsZero():
+			health.Reasons = append(health.Reasons, "no readings yet")
+		case rules.MaxReadingAge > 0 && now.Sub(tracked.lastReadingAt) > rules.MaxReadingAge:
+			health.Reasons = append(health.Reasons, fmt.Sprintf("last reading older than %v", rules.MaxReadingAge))
+		}
+		if rules.RequireAcclimated && health.Acclimating {
+			health.Reasons = append(health.Reasons, "acclimating")
+		}
+		health.Ready = len(health.Reasons) == 0
+		if health.Required && !health.Ready {
+			result.Ready = false
+		}
+
+		result.Sensors = append(result.Sensors, health)
+	}
+	return result
+}
+
+// serveHealthz reports that the process is alive and serving requests
+func serveHealthz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// serveReadyz reports the state of each sensor, with a status of 503 unless every required sensor is ready
+func serveReadyz(instances []SensorSettings, rules ReadinessRules, sensors *tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		result := sensors.health(instances, rules, time.Now())
+		status := http.StatusOK
+		if !result.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, result)
+	}
+}
@@ -0,0 +1,243 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sensor-exporter/internal/filter"
+	"sensor-exporter/internal/measurement"
+	"sensor-exporter/internal/models"
+	"sensor-exporter/reconnect"
+	"sensor-exporter/sgp30"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// trackedState is the state of a sensor fed to the tracker by a test
+type trackedState struct {
+	connected bool
+	// Age of the latest reading, or no reading if 0
+	readingAge time.Duration
+	// Remaining acclimation reported by a gas sensor
+	acclimating time.Duration
+	lastError   error
+}
+
+// newTestTracker returns a tracker holding the given state of each sensor
+func newTestTracker(t *testing.T, instances []SensorSettings, states map[string]trackedState, now time.Time) *tracker {
+	t.Helper()
+	sensors := newTracker(filter.NewFilter(nil))
+	for _, instance := range instances {
+		state, ok := states[instance.Name]
+		if !ok {
+			continue
+		}
+		sensors.observeReconnects(instance.Name, reconnect.Settings{}).OnStatus(reconnect.Status{
+			Connected:   state.connected,
+			Circuit:     reconnect.Closed,
+			LastError:   state.lastError,
+			LastErrorAt: now,
+		})
+		if state.readingAge > 0 {
+			sensors.recordSamples(instance.Name, []measurement.Sample{{
+				Source:      measurement.Source{Sensor: instance.Name, Model: strings.ToUpper(instance.Model)},
+				Measurement: "temperature",
+				Value:       21.5,
+				Unit:        "celsius",
+				Valid:       true,
+				Time:        now.Add(-state.readingAge),
+			}})
+		}
+		if instance.Model == models.SGP30 {
+			sensors.setAirQuality(instance.Name, &sgp30.AirQualityReading{DurationUntilValid: state.acclimating})
+		}
+	}
+	return sensors
+}
+
+func TestServeReadyz(t *testing.T) {
+	instances := []SensorSettings{
+		{Name: "aht20", Model: models.AHT20},
+		{Name: "sgp30", Model: models.SGP30},
+	}
+	healthy := trackedState{connected: true, readingAge: time.Second}
+	tests := []struct {
+		name   string
+		rules  ReadinessRules
+		states map[string]trackedState
+		status int
+		// Reasons each sensor is not ready, joined by commas
+		reasons map[string]string
+	}{
+		{
+			name:    "every required sensor ready",
+			rules:   ReadinessRules{Required: map[string]bool{"aht20": true, "sgp30": true}},
+			states:  map[string]trackedState{"aht20": healthy, "sgp30": healthy},
+			status:  http.StatusOK,
+			reasons: map[string]string{"aht20": "", "sgp30": ""},
+		},
+		{
+			name:  "required sensor never read",
+			rules: ReadinessRules{Required: map[string]bool{"aht20": true, "sgp30": true}},
+			states: map[string]trackedState{
+				"aht20": healthy,
+				"sgp30": {lastError: errors.New("failed to read serial")},
+			},
+			status:  http.StatusServiceUnavailable,
+			reasons: map[string]string{"aht20": "", "sgp30": "not connected,no readings yet"},
+		},
+		{
+			name:    "optional sensor not ready",
+			rules:   ReadinessRules{Required: map[string]bool{"aht20": true}},
+			states:  map[string]trackedState{"aht20": healthy},
+			status:  http.StatusOK,
+			reasons: map[string]string{"aht20": "", "sgp30": "not connected,no readings yet"},
+		},
+		{
+			name:  "stale reading",
+			rules: ReadinessRules{Required: map[string]bool{"aht20": true}, MaxReadingAge: 30 * time.Second},
+			states: map[string]trackedState{
+				"aht20": {connected: true, readingAge: time.Minute},
+			},
+			status:  http.StatusServiceUnavailable,
+			reasons: map[string]string{"aht20": "last reading older than 30s", "sgp30": "not connected,no readings yet"},
+		},
+		{
+			name:  "reading age not checked without a maximum",
+			rules: ReadinessRules{Required: map[string]bool{"aht20": true}},
+			states: map[string]trackedState{
+				"aht20": {connected: true, readingAge: time.Hour},
+			},
+			status:  http.StatusOK,
+			reasons: map[string]string{"aht20": "", "sgp30": "not connected,no readings yet"},
+		},
+		{
+			name:  "disconnected with a recent reading",
+			rules: ReadinessRules{Required: map[string]bool{"aht20": true}, MaxReadingAge: 30 * time.Second},
+			states: map[string]trackedState{
+				"aht20": {readingAge: time.Second, lastError: errors.New("failed to read from sensor")},
+			},
+			status:  http.StatusServiceUnavailable,
+			reasons: map[string]string{"aht20": "not connected", "sgp30": "not connected,no readings yet"},
+		},
+		{
+			name:  "acclimating gas sensor required to have acclimated",
+			rules: ReadinessRules{Required: map[string]bool{"sgp30": true}, RequireAcclimated: true},
+			states: map[string]trackedState{
+				"sgp30": {connected: true, readingAge: time.Second, acclimating: time.Hour},
+			},
+			status:  http.StatusServiceUnavailable,
+			reasons: map[string]string{"aht20": "not connected,no readings yet", "sgp30": "acclimating"},
+		},
+		{
+			name:  "acclimating gas sensor",
+			rules: ReadinessRules{Required: map[string]bool{"sgp30": true}},
+			states: map[string]trackedState{
+				"sgp30": {connected: true, readingAge: time.Second, acclimating: time.Hour},
+			},
+			status:  http.StatusOK,
+			reasons: map[string]string{"aht20": "not connected,no readings yet", "sgp30": ""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sensors := newTestTracker(t, instances, test.states, time.Now())
+			recorder := httptest.NewRecorder()
+			serveReadyz(instances, test.rules, sensors)(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if recorder.Code != test.status {
+				t.Errorf("got status %v, want %v", recorder.Code, test.status)
+			}
+			result := readiness{}
+			err := json.Unmarshal(recorder.Body.Bytes(), &result)
+			if err != nil {
+				t.Fatalf("failed to decode response %q: %v", recorder.Body.String(), err)
+			}
+			if result.Ready != (test.status == http.StatusOK) {
+				t.Errorf("got ready %v with status %v", result.Ready, recorder.Code)
+			}
+			if len(result.Sensors) != len(instances) {
+				t.Fatalf("got %v sensors, want %v", len(result.Sensors), len(instances))
+			}
+			for _, health := range result.Sensors {
+				reasons := strings.Join(health.Reasons, ",")
+				if reasons != test.reasons[health.Name] {
+					t.Errorf("got reasons %q for %v, want %q", reasons, health.Name, test.reasons[health.Name])
+				}
+				if health.Ready != (reasons == "") {
+					t.Errorf("got ready %v for %v with reasons %q", health.Ready, health.Name, reasons)
+				}
+				if health.Required != test.rules.Required[health.Name] {
+					t.Errorf("got required %v for %v, want %v", health.Required, health.Name, test.rules.Required[health.Name])
+				}
+				state := test.states[health.Name]
+				if health.Acclimating != (state.acclimating > 0) {
+					t.Errorf("got acclimating %v for %v, want %v", health.Acclimating, health.Name, state.acclimating > 0)
+				}
+				if state.lastError != nil && health.LastError != state.lastError.Error() {
+					t.Errorf("got last error %q for %v, want %q", health.LastError, health.Name, state.lastError)
+				}
+				if (health.LastReadingAgeSeconds != nil) != (state.readingAge > 0) {
+					t.Errorf("got last reading age %v for %v, want one %v", health.LastReadingAgeSeconds, health.Name, state.readingAge > 0)
+				}
+			}
+		})
+	}
+}
+
+func TestReadinessRules(t *testing.T) {
+	instances := []SensorSettings{
+		{Name: "aht20", Model: models.AHT20},
+		{Name: "sgp30", Model: models.SGP30},
+	}
+	tests := []struct {
+		name     string
+		sensors  []string
+		required string
+		err      string
+	}{
+		{"every sensor by default", nil, "aht20,sgp30", ""},
+		{"named sensors", []string{"sgp30"}, "sgp30", ""},
+		{"unknown sensor", []string{"pms5003"}, "", "failed to require unknown sensor pms5003 for readiness"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := &Settings{ReadySensors: test.sensors}
+			rules, err := settings.ReadinessRules(instances)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to create rules: %v", err)
+			}
+			required := []string{}
+			for _, instance := range instances {
+				if rules.Required[instance.Name] {
+					required = append(required, instance.Name)
+				}
+			}
+			if strings.Join(required, ",") != test.required {
+				t.Errorf("got required %v, want %v", strings.Join(required, ","), test.required)
+			}
+		})
+	}
+}
+
+func TestServeHealthz(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	serveHealthz(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("got status %v, want %v", recorder.Code, http.StatusOK)
+	}
+	if body := strings.TrimSpace(recorder.Body.String()); body != `{"status":"ok"}` {
+		t.Errorf("got body %v, want %v", body, `{"status":"ok"}`)
+	}
+}
@@ -0,0 +1,171 @@
+package exporter
+
+import (
+	"net/http"
+	"sensor-exporter/clock"
+	"sensor-exporter/internal/history"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/go-kit/v2/cmd"
+)
+
+const (
+	// historyDefaultRange is the range of a history query that does not give its start
+	historyDefaultRange = time.Hour
+	// historyMaxPoints limits the number of steps of a history query, and the number of raw samples of one without a
+	// step
+	historyMaxPoints = 11000
+)
+
+// historyResponse is the history of a measurement of a sensor
+type historyResponse struct {
+	Sensor      string    `json:"sensor"`
+	Measurement string    `json:"measurement"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	history.Result
+}
+
+// HistorySettings returns the settings of the history store
+func (s *Settings) HistorySettings() history.Settings {
+	return history.Settings{
+		Dir:               s.HistoryDir,
+		RawRetention:      s.HistoryRawRetention,
+		MinuteRetention:   s.HistoryMinuteRetention,
+		HourRetention:     s.HistoryHourRetention,
+		RawSignalInterval: s.HistorySignalInterval,
+	}
+}
+
+// startHistory opens the history store, if enabled, and records the samples of the sensors into it
+func startHistory(group *cmd.ProcessGroup, settings *Settings, sensors *tracker, clockGuard *clock.Guard) (*history.Store, error) {
+	if settings.HistoryDir == "" {
+		return nil, nil
+	}
+
+	store, err := history.Open(settings.HistorySettings(), clockGuard.Synchronized)
+	if err != nil {
+		return nil, err
+	}
+
+	registry.MustRegister(history.Collectors()...)
+	subscription := sensors.samples.subscribeDurable("history", sampleFilter{})
+	group.Go(store.Start(group.Context(), subscription.samples))
+	return store, nil
+}
+
+// serveHistory answers /api/v1/history?sensor=&measurement=&from=&to=&step= with the raw samples or aggregates of a
+// measurement
+func (a *api) serveHistory(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	if a.history == nil {
+		writeError(w, http.StatusNotFound, errors.New("failed to query history; the history is disabled"))
+		return
+	}
+
+	query := r.URL.Query()
+	sensor := query.Get("sensor")
+	measurement := query.Get("measurement")
+	if sensor == "" || measurement == "" {
+		writeError(w, http.StatusBadRequest, errors.New("failed to query history; sensor and measurement are required"))
+		return
+	}
+	if _, ok := a.byName[sensor]; !ok {
+		writeError(w, http.StatusNotFound, errors.Errorf("failed to find sensor %v", sensor))
+		return
+	}
+
+	to := time.Now()
+	if value := query.Get("to"); value != "" {
+		parsed, err := parseHistoryTime(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.Wrap(err, "failed to parse to"))
+			return
+		}
+		to = parsed
+	}
+	from := to.Add(-historyDefaultRange)
+	if value := query.Get("from"); value != "" {
+		parsed, err := parseHistoryTime(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.Wrap(err, "failed to parse from"))
+			return
+		}
+		from = parsed
+	}
+	if !from.Before(to) {
+		writeError(w, http.StatusBadRequest, errors.New("failed to query history; from must be before to"))
+		return
+	}
+
+	step := time.Duration(0)
+	if value := query.Get("step"); value != "" {
+		parsed, err := parseHistoryStep(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.Wrap(err, "failed to parse step"))
+			return
+		}
+		step = parsed
+		if to.Sub(from)/step > historyMaxPoints {
+			writeError(w, http.StatusBadRequest, errors.Errorf("failed to query history; more than %v steps between from and to, use a larger step", historyMaxPoints))
+			return
+		}
+	}
+
+	result, err := a.history.Query(history.Query{
+		Sensor:      sensor,
+		Measurement: measurement,
+		From:        from,
+		To:          to,
+		Step:        step,
+		MaxSamples:  historyMaxPoints,
+	})
+	if _, ok := err.(history.TooManySamplesError); ok {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, historyResponse{
+		Sensor:      sensor,
+		Measurement: measurement,
+		From:        from,
+		To:          to,
+		Result:      result,
+	})
+}
+
+// parseHistoryTime parses a time given in RFC 3339 or as seconds since the epoch
+func parseHistoryTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, errors.Errorf("expected RFC 3339 or seconds since the epoch, got %q", value)
+	}
+	return parsed, nil
+}
+
+// parseHistoryStep parses a positive step given as a duration such as 5m or in seconds
+func parseHistoryStep(value string) (time.Duration, error) {
+	step, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, parseErr := strconv.ParseFloat(value, 64)
+		if parseErr != nil {
+			return 0, errors.Errorf("expected a duration such as 5m or seconds, got %q", value)
+		}
+		step = time.Duration(seconds * float64(time.Second))
+	}
+	if step <= 0 {
+		return 0, errors.Errorf("expected a positive step, got %q", value)
+	}
+	return step, nil
+}
@@ -0,0 +1,127 @@
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sensor-exporter/internal/history"
+	"strings"
+	"testing"
+)
+
+func TestServeHistory(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		// String the response is expected to contain
+		contains string
+	}{
+		{
+			name:     "last hour by default",
+			query:    "?sensor=aht20&measurement=temperature",
+			status:   http.StatusOK,
+			contains: `"tier":"raw"`,
+		},
+		{
+			name:     "RFC 3339 range with a step",
+			query:    "?sensor=aht20&measurement=temperature&from=2024-01-01T00:00:00Z&to=2024-01-02T00:00:00Z&step=1h",
+			status:   http.StatusOK,
+			contains: `"from":"2024-01-01T00:00:00Z","to":"2024-01-02T00:00:00Z","tier":"1h","step":"1h0m0s"`,
+		},
+		{
+			name:     "range in seconds since the epoch with a step in seconds",
+			query:    "?sensor=aht20&measurement=temperature&from=1700000000.5&to=1700086400&step=3600",
+			status:   http.StatusOK,
+			contains: `"tier":"1h","step":"1h0m0s"`,
+		},
+		{
+			name:     "without a measurement",
+			query:    "?sensor=aht20",
+			status:   http.StatusBadRequest,
+			contains: "sensor and measurement are required",
+		},
+		{
+			name:     "unknown sensor",
+			query:    "?sensor=pms5003&measurement=pm2_5_environmental",
+			status:   http.StatusNotFound,
+			contains: "failed to find sensor pms5003",
+		},
+		{
+			name:     "unparseable to",
+			query:    "?sensor=aht20&measurement=temperature&to=yesterday",
+			status:   http.StatusBadRequest,
+			contains: `failed to parse to: expected RFC 3339 or seconds since the epoch, got \"yesterday\"`,
+		},
+		{
+			name:     "unparseable from",
+			query:    "?sensor=aht20&measurement=temperature&from=2024-01-01",
+			status:   http.StatusBadRequest,
+			contains: `failed to parse from: expected RFC 3339 or seconds since the epoch, got \"2024-01-01\"`,
+		},
+		{
+			name:     "from after to",
+			query:    "?sensor=aht20&measurement=temperature&from=1700086400&to=1700000000",
+			status:   http.StatusBadRequest,
+			contains: "from must be before to",
+		},
+		{
+			name:     "empty range",
+			query:    "?sensor=aht20&measurement=temperature&from=1700000000&to=1700000000",
+			status:   http.StatusBadRequest,
+			contains: "from must be before to",
+		},
+		{
+			name:     "unparseable step",
+			query:    "?sensor=aht20&measurement=temperature&step=hourly",
+			status:   http.StatusBadRequest,
+			contains: `failed to parse step: expected a duration such as 5m or seconds, got \"hourly\"`,
+		},
+		{
+			name:     "negative step",
+			query:    "?sensor=aht20&measurement=temperature&step=-5m",
+			status:   http.StatusBadRequest,
+			contains: `failed to parse step: expected a positive step, got \"-5m\"`,
+		},
+		{
+			name:     "zero step",
+			query:    "?sensor=aht20&measurement=temperature&step=0",
+			status:   http.StatusBadRequest,
+			contains: `failed to parse step: expected a positive step, got \"0\"`,
+		},
+		{
+			name:     "too many steps",
+			query:    "?sensor=aht20&measurement=temperature&from=1700000000&to=1700086400&step=7s",
+			status:   http.StatusBadRequest,
+			contains: "more than 11000 steps between from and to, use a larger step",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			api := newTestAPI(t, ctx, false)
+			store, err := history.Open(history.Settings{
+				Dir:             t.TempDir(),
+				RawRetention:    DefaultHistoryRawRetention,
+				MinuteRetention: DefaultHistoryMinuteRetention,
+				HourRetention:   DefaultHistoryHourRetention,
+			}, func() bool { return true })
+			if err != nil {
+				t.Fatalf("failed to open history: %v", err)
+			}
+			api.history = store
+
+			recorder := httptest.NewRecorder()
+			api.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/history"+test.query, nil))
+
+			if recorder.Code != test.status {
+				t.Errorf("got status %v, want %v", recorder.Code, test.status)
+			}
+			if body := recorder.Body.String(); !strings.Contains(body, test.contains) {
+				t.Errorf("got body %v, want it to contain %v", body, test.contains)
+			}
+		})
+	}
+}
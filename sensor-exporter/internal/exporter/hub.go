@@ -0,0 +1,122 @@
+package exporter
+
+import (
+	"sensor-exporter/internal/measurement"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/syncromatics/go-kit/v2/log"
+	"golang.org/x/exp/slices"
+)
+
+var sensor_exporter_dropped_samples_total = promauto.With(registry).NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "sensor_exporter_dropped_samples_total",
+		Help: "Number of samples not delivered to a subscriber, such as an API stream or an output, because its buffer was full",
+	},
+	[]string{"subscriber"},
+)
+
+// sampleFilter selects samples by sensor and measurement; an empty list selects every sensor or measurement
+type sampleFilter struct {
+	Sensors      []string
+	Measurements []string
+}
+
+func (f sampleFilter) matches(sample measurement.Sample) bool {
+	return (len(f.Sensors) == 0 || slices.Contains(f.Sensors, sample.Sensor)) &&
+		(len(f.Measurements) == 0 || slices.Contains(f.Measurements, sample.Measurement))
+}
+
+func (f sampleFilter) apply(samples []measurement.Sample) []measurement.Sample {
+	matching := []measurement.Sample{}
+	for _, sample := range samples {
+		if f.matches(sample) {
+			matching = append(matching, sample)
+		}
+	}
+	return matching
+}
+
+// hub fans the samples of every sensor out to subscribers such as API streams
+type hub struct {
+	mu          sync.Mutex
+	subscribers map[*subscription]bool
+}
+
+// subscription receives the samples that match its filter. Samples are dropped rather than holding up the sensors if
+// the subscriber falls behind, and counted in sensor_exporter_dropped_samples_total by the name of the subscriber.
+type subscription struct {
+	name    string
+	filter  sampleFilter
+	samples chan []measurement.Sample
+	// Whether the subscriber is expected to keep every sample, so that falling behind is warned about
+	durable bool
+	// Whether samples are being dropped since the subscriber last accepted a batch
+	dropping bool
+}
+
+func newHub() *hub {
+	return &hub{
+		subscribers: map[*subscription]bool{},
+	}
+}
+
+// subscribe returns a subscription buffering up to the given number of batches of samples, which must be unsubscribed
+// when no longer read
+func (h *hub) subscribe(name string, filter sampleFilter, buffer int) *subscription {
+	return h.add(&subscription{
+		name:    name,
+		filter:  filter,
+		samples: make(chan []measurement.Sample, buffer),
+	})
+}
+
+// subscribeDurable returns a subscription for a subscriber that keeps every sample, such as an output that writes them
+// to disk, with a buffer large enough to ride out a slow disk or a stalled write. Dropped samples are warned about once
+// each time the subscriber falls behind.
+func (h *hub) subscribeDurable(name string, filter sampleFilter) *subscription {
+	return h.add(&subscription{
+		name:    name,
+		filter:  filter,
+		samples: make(chan []measurement.Sample, durableBuffer),
+		durable: true,
+	})
+}
+
+func (h *hub) add(s *subscription) *subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[s] = true
+	return s
+}
+
+func (h *hub) unsubscribe(s *subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, s)
+}
+
+func (h *hub) publish(samples []measurement.Sample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subscribers {
+		matching := s.filter.apply(samples)
+		if len(matching) == 0 {
+			continue
+		}
+		select {
+		case s.samples <- matching:
+			s.dropping = false
+		default:
+			sensor_exporter_dropped_samples_total.WithLabelValues(s.name).Add(float64(len(matching)))
+			if s.durable && !s.dropping {
+				log.Warn("output fell behind; dropping samples until it catches up",
+					"subscriber", s.name,
+					"buffer", cap(s.samples))
+			}
+			s.dropping = true
+		}
+	}
+}
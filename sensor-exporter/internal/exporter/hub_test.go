@@ -0,0 +1,76 @@
+package exporter
+
+import (
+	"sensor-exporter/internal/measurement"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHubPublish(t *testing.T) {
+	batch := []measurement.Sample{
+		{Source: measurement.Source{Sensor: "aht20"}, Measurement: "temperature"},
+		{Source: measurement.Source{Sensor: "aht20"}, Measurement: "relative_humidity"},
+	}
+	tests := []struct {
+		name    string
+		durable bool
+		buffer  int
+		filter  sampleFilter
+		batches int
+		// Number of batches delivered and samples dropped
+		delivered int
+		dropped   float64
+	}{
+		{"within the buffer", false, 4, sampleFilter{}, 4, 4, 0},
+		{"beyond the buffer", false, 4, sampleFilter{}, 6, 4, 4},
+		{"filtered samples not counted as dropped", false, 1, sampleFilter{Measurements: []string{"temperature"}}, 3, 1, 2},
+		{"not matching the filter", false, 1, sampleFilter{Sensors: []string{"sgp30"}}, 3, 0, 0},
+		{"durable", true, durableBuffer, sampleFilter{}, outputBuffer * 2, outputBuffer * 2, 0},
+		{"durable beyond the buffer", true, durableBuffer, sampleFilter{}, durableBuffer + 3, durableBuffer, 6},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHub()
+			var s *subscription
+			if test.durable {
+				s = h.subscribeDurable(test.name, test.filter)
+			} else {
+				s = h.subscribe(test.name, test.filter, test.buffer)
+			}
+			if cap(s.samples) != test.buffer {
+				t.Errorf("got buffer %v, want %v", cap(s.samples), test.buffer)
+			}
+
+			for i := 0; i < test.batches; i++ {
+				h.publish(batch)
+			}
+			if len(s.samples) != test.delivered {
+				t.Errorf("got %v batches delivered, want %v", len(s.samples), test.delivered)
+			}
+			dropped := testutil.ToFloat64(sensor_exporter_dropped_samples_total.WithLabelValues(test.name))
+			if dropped != test.dropped {
+				t.Errorf("got %v samples dropped, want %v", dropped, test.dropped)
+			}
+			if s.dropping != (test.dropped > 0) {
+				t.Errorf("got dropping %v, want %v", s.dropping, test.dropped > 0)
+			}
+
+			// a subscriber that catches up is warned about again the next time it falls behind
+			if len(s.samples) > 0 {
+				<-s.samples
+				h.publish(batch)
+				if s.dropping {
+					t.Errorf("got dropping after catching up, want false")
+				}
+			}
+
+			h.unsubscribe(s)
+			h.publish(batch)
+			if len(s.samples) > test.delivered {
+				t.Errorf("got %v batches delivered after unsubscribing, want %v", len(s.samples), test.delivered)
+			}
+		})
+	}
+}
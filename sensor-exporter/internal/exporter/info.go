@@ -0,0 +1,57 @@
+package exporter
+
+import (
+	"sensor-exporter/internal/version"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	sensor_info = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sensor_info",
+			Help: "Identity of a connected sensor; always 1",
+		},
+		withSensorLabels("firmware", "bus", "address"),
+	)
+)
+
+// deviceInfo describes where a sensor is attached and what it reports about itself
+type deviceInfo struct {
+	// Feature set, firmware version or variant reported by the sensor
+	Firmware string
+	// I2C bus or serial port to which the sensor is attached
+	Bus string
+	// Address of the sensor on an I2C bus
+	Address string
+}
+
+func (i deviceInfo) values(labels sensorLabels) []string {
+	return labels.values(i.Firmware, i.Bus, i.Address)
+}
+
+func setSensorInfo(labels sensorLabels, info deviceInfo) {
+	sensor_info.WithLabelValues(info.values(labels)...).Set(1)
+}
+
+func deleteSensorInfo(labels sensorLabels, info deviceInfo) {
+	sensor_info.DeleteLabelValues(info.values(labels)...)
+}
+
+func newBuildInfoCollector() prometheus.Collector {
+	info := version.Get()
+	buildInfo := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "sensor_exporter_build_info",
+			Help: "Build of the running exporter; always 1",
+			ConstLabels: prometheus.Labels{
+				"version":   info.Version,
+				"revision":  info.Revision,
+				"goversion": info.GoVersion,
+			},
+		},
+	)
+	buildInfo.Set(1)
+	return buildInfo
+}
@@ -7,11 +7,16 @@ import (
 	"io/ioutil"
 	"net/http"
 	"sensor-exporter/aht20"
+	"sensor-exporter/clock"
+	"sensor-exporter/i2cbus"
+	"sensor-exporter/internal/filter"
+	"sensor-exporter/internal/models"
 	"sensor-exporter/pms5003"
+	"sensor-exporter/reconnect"
 	"sensor-exporter/sgp30"
-	"sensor-exporter/units"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/pflag"
 	"github.com/syncromatics/go-kit/v2/cmd"
@@ -20,49 +25,366 @@ import (
 
 // Settings defines the configured settings for the exporter
 type Settings struct {
-	MetricsPort      int           `mapstructure:"metrics-port"`
-	ReconnectTimeout time.Duration `mapstructure:"reconnect-timeout"`
-	PMSPortName      string        `mapstructure:"pms5003-port"`
-	AHT20I2CAddr     uint8         `mapstructure:"aht20-i2c-addr"`
-	AHT20I2CBus      int           `mapstructure:"aht20-i2c-bus"`
-	SGP30I2CAddr     uint8         `mapstructure:"sgp30-i2c-addr"`
-	SGP30I2CBus      int           `mapstructure:"sgp30-i2c-bus"`
-	BaselineFile     string        `mapstructure:"baseline-file"`
+	MetricsPort             int               `mapstructure:"metrics-port"`
+	ReconnectTimeout        time.Duration     `mapstructure:"reconnect-timeout"`
+	ReconnectMaxTimeout     time.Duration     `mapstructure:"reconnect-max-timeout"`
+	ReconnectMultiplier     float64           `mapstructure:"reconnect-multiplier"`
+	ReconnectJitter         float64           `mapstructure:"reconnect-jitter"`
+	ReconnectResetAfter     time.Duration     `mapstructure:"reconnect-reset-after"`
+	CircuitBreakerThreshold int               `mapstructure:"circuit-breaker-threshold"`
+	CircuitBreakerTimeout   time.Duration     `mapstructure:"circuit-breaker-timeout"`
+	PMSPortName             string            `mapstructure:"pms5003-port"`
+	AHT20I2CAddr            uint8             `mapstructure:"aht20-i2c-addr"`
+	AHT20I2CBus             int               `mapstructure:"aht20-i2c-bus"`
+	SGP30I2CAddr            uint8             `mapstructure:"sgp30-i2c-addr"`
+	SGP30I2CBus             int               `mapstructure:"sgp30-i2c-bus"`
+	BaselineFile            string            `mapstructure:"baseline-file"`
+	StateFile               string            `mapstructure:"state-file"`
+	BaselineHistorySize     int               `mapstructure:"baseline-history-size"`
+	BaselineMaxDeviation    float64           `mapstructure:"baseline-max-deviation"`
+	BaselineAcceptAfter     int               `mapstructure:"baseline-accept-after"`
+	ClockCheck              string            `mapstructure:"clock-check"`
+	ClockSentinelFile       string            `mapstructure:"clock-sentinel-file"`
+	ClockSyncTimeout        time.Duration     `mapstructure:"clock-sync-timeout"`
+	ReadyMaxReadingAge      time.Duration     `mapstructure:"ready-max-reading-age"`
+	ReadySensors            []string          `mapstructure:"ready-sensors"`
+	ReadyRequireAcclimated  bool              `mapstructure:"ready-require-acclimated"`
+	MetricsV1Compat         bool              `mapstructure:"metrics-v1-compat"`
+	APIRollback             bool              `mapstructure:"api-rollback"`
+	MQTTBroker              string            `mapstructure:"mqtt-broker"`
+	MQTTClientID            string            `mapstructure:"mqtt-client-id"`
+	MQTTUsername            string            `mapstructure:"mqtt-username"`
+	MQTTPassword            string            `mapstructure:"mqtt-password"`
+	MQTTNode                string            `mapstructure:"mqtt-node"`
+	MQTTTopic               string            `mapstructure:"mqtt-topic"`
+	MQTTStatusTopic         string            `mapstructure:"mqtt-status-topic"`
+	MQTTFormat              string            `mapstructure:"mqtt-format"`
+	MQTTQoS                 uint8             `mapstructure:"mqtt-qos"`
+	MQTTRetain              bool              `mapstructure:"mqtt-retain"`
+	MQTTDiscovery           bool              `mapstructure:"mqtt-discovery"`
+	MQTTDiscoveryPrefix     string            `mapstructure:"mqtt-discovery-prefix"`
+	MQTTBufferSize          int               `mapstructure:"mqtt-buffer-size"`
+	MQTTInterval            time.Duration     `mapstructure:"mqtt-interval"`
+	InfluxURL               string            `mapstructure:"influx-url"`
+	InfluxOrg               string            `mapstructure:"influx-org"`
+	InfluxBucket            string            `mapstructure:"influx-bucket"`
+	InfluxToken             string            `mapstructure:"influx-token"`
+	InfluxBatchSize         int               `mapstructure:"influx-batch-size"`
+	InfluxFlushInterval     time.Duration     `mapstructure:"influx-flush-interval"`
+	InfluxTimeout           time.Duration     `mapstructure:"influx-timeout"`
+	InfluxSpoolDir          string            `mapstructure:"influx-spool-dir"`
+	InfluxSpoolMaxBytes     int64             `mapstructure:"influx-spool-max-bytes"`
+	RemoteWriteURL          string            `mapstructure:"remote-write-url"`
+	RemoteWriteUsername     string            `mapstructure:"remote-write-username"`
+	RemoteWritePassword     string            `mapstructure:"remote-write-password"`
+	RemoteWriteBearerToken  string            `mapstructure:"remote-write-bearer-token"`
+	RemoteWriteJob          string            `mapstructure:"remote-write-job"`
+	RemoteWriteInstance     string            `mapstructure:"remote-write-instance"`
+	RemoteWriteLabels       map[string]string `mapstructure:"remote-write-labels"`
+	RemoteWriteInterval     time.Duration     `mapstructure:"remote-write-interval"`
+	RemoteWriteTimeout      time.Duration     `mapstructure:"remote-write-timeout"`
+	RemoteWriteMinBackoff   time.Duration     `mapstructure:"remote-write-min-backoff"`
+	RemoteWriteMaxBackoff   time.Duration     `mapstructure:"remote-write-max-backoff"`
+	RemoteWriteWALDir       string            `mapstructure:"remote-write-wal-dir"`
+	RemoteWriteWALMaxBytes  int64             `mapstructure:"remote-write-wal-max-bytes"`
+	OTLPEndpoint            string            `mapstructure:"otlp-endpoint"`
+	OTLPProtocol            string            `mapstructure:"otlp-protocol"`
+	OTLPHeaders             map[string]string `mapstructure:"otlp-headers"`
+	OTLPCompression         string            `mapstructure:"otlp-compression"`
+	OTLPInterval            time.Duration     `mapstructure:"otlp-interval"`
+	OTLPTimeout             time.Duration     `mapstructure:"otlp-timeout"`
+	OTLPBufferSize          int               `mapstructure:"otlp-buffer-size"`
+	OTLPResourceAttributes  map[string]string `mapstructure:"otlp-resource-attributes"`
+	HistoryDir              string            `mapstructure:"history-dir"`
+	HistoryRawRetention     time.Duration     `mapstructure:"history-raw-retention"`
+	HistoryMinuteRetention  time.Duration     `mapstructure:"history-minute-retention"`
+	HistoryHourRetention    time.Duration     `mapstructure:"history-hour-retention"`
+	HistorySignalInterval   time.Duration     `mapstructure:"history-signal-interval"`
+	DatalogDir              string            `mapstructure:"datalog-dir"`
+	DatalogFormat           string            `mapstructure:"datalog-format"`
+	DatalogInterval         time.Duration     `mapstructure:"datalog-interval"`
+	DatalogRotateSize       int64             `mapstructure:"datalog-rotate-size"`
+	DatalogCompress         bool              `mapstructure:"datalog-compress"`
+	DatalogMaxAge           time.Duration     `mapstructure:"datalog-max-age"`
+	DatalogMaxBytes         int64             `mapstructure:"datalog-max-bytes"`
+	AggregateWindowNames    []string          `mapstructure:"aggregate-windows"`
+	AQI                     bool              `mapstructure:"aqi"`
+	Sensors                 []SensorSettings  `mapstructure:"sensors"`
+
+	// Filter chains by measurement, applied to every sensor with that measurement
+	Filters map[string]filter.Settings `mapstructure:"filters"`
+}
+
+// Redacted returns a copy of the settings with secrets masked, for logging
+func (s Settings) Redacted() Settings {
+	if s.MQTTPassword != "" {
+		s.MQTTPassword = "<redacted>"
+	}
+	if s.InfluxToken != "" {
+		s.InfluxToken = "<redacted>"
+	}
+	if s.RemoteWritePassword != "" {
+		s.RemoteWritePassword = "<redacted>"
+	}
+	if s.RemoteWriteBearerToken != "" {
+		s.RemoteWriteBearerToken = "<redacted>"
+	}
+	if len(s.OTLPHeaders) > 0 {
+		headers := map[string]string{}
+		for name := range s.OTLPHeaders {
+			headers[name] = "<redacted>"
+		}
+		s.OTLPHeaders = headers
+	}
+	return s
+}
+
+// ClockGuard returns the guard that defers decisions based on wall time until the clock is synchronized
+func (s *Settings) ClockGuard() (*clock.Guard, error) {
+	checker, err := clock.NewChecker(s.ClockCheck, s.ClockSentinelFile)
+	if err != nil {
+		return nil, err
+	}
+	return clock.NewGuard(checker, s.ClockSyncTimeout), nil
+}
+
+// ReconnectSettings returns the reconnect policy settings shared by all sensors
+func (s *Settings) ReconnectSettings() (reconnect.Settings, error) {
+	settings := reconnect.Settings{
+		InitialDelay:     s.ReconnectTimeout,
+		MaxDelay:         s.ReconnectMaxTimeout,
+		Multiplier:       s.ReconnectMultiplier,
+		Jitter:           s.ReconnectJitter,
+		ResetAfter:       s.ReconnectResetAfter,
+		FailureThreshold: s.CircuitBreakerThreshold,
+		OpenDuration:     s.CircuitBreakerTimeout,
+	}
+	err := settings.Validate()
+	if err != nil {
+		return reconnect.Settings{}, errors.Wrap(err, "failed to configure reconnects")
+	}
+	return settings, nil
 }
 
 const (
-	DefaultMetricsPort      int           = 9100
-	DefaultReconnectTimeout time.Duration = 1 * time.Second
-	DefaultPMS5003PortName  string        = "/dev/ttyAMA0"
-	DefaultAHT20I2CAddr     uint8         = 0x38
-	DefaultAHT20I2CBus      int           = 1
-	DefaultSGP30I2CAddr     uint8         = 0x58
-	DefaultSGP30I2CBus      int           = 1
-	DefaultBaselineFile     string        = "/var/lib/sensor-exporter/baseline.json"
+	DefaultMetricsPort             int           = 9100
+	DefaultReconnectTimeout        time.Duration = 1 * time.Second
+	DefaultReconnectMaxTimeout     time.Duration = 2 * time.Minute
+	DefaultReconnectMultiplier     float64       = 2
+	DefaultReconnectJitter         float64       = 0.2
+	DefaultReconnectResetAfter     time.Duration = 1 * time.Minute
+	DefaultCircuitBreakerThreshold int           = 10
+	DefaultCircuitBreakerTimeout   time.Duration = 10 * time.Minute
+	DefaultPMS5003PortName         string        = "/dev/ttyAMA0"
+	DefaultAHT20I2CAddr            uint8         = 0x38
+	DefaultAHT20I2CBus             int           = 1
+	DefaultSGP30I2CAddr            uint8         = 0x58
+	DefaultSGP30I2CBus             int           = 1
+	DefaultBaselineFile            string        = "/var/lib/sensor-exporter/baseline.json"
+	DefaultStateFile               string        = "/var/lib/sensor-exporter/state.json"
+	DefaultBaselineHistorySize     int           = 336
+	DefaultBaselineMaxDeviation    float64       = 0.2
+	DefaultBaselineAcceptAfter     int           = 24
+	DefaultClockCheck              string        = "kernel"
+	DefaultClockSentinelFile       string        = "/run/systemd/timesync/synchronized"
+	DefaultClockSyncTimeout        time.Duration = 10 * time.Minute
+	DefaultReadyMaxReadingAge      time.Duration = 30 * time.Second
+	DefaultReadyRequireAcclimated  bool          = false
+	DefaultMetricsV1Compat         bool          = false
+	DefaultAPIRollback             bool          = false
+	DefaultMQTTTopic               string        = "sensor-exporter/{{.Node}}/{{.Sensor}}"
+	DefaultMQTTStatusTopic         string        = "sensor-exporter/{{.Node}}/status"
+	DefaultMQTTFormat              string        = "json"
+	DefaultMQTTQoS                 uint8         = 1
+	DefaultMQTTRetain              bool          = true
+	DefaultMQTTDiscovery           bool          = true
+	DefaultMQTTDiscoveryPrefix     string        = "homeassistant"
+	DefaultMQTTBufferSize          int           = 10000
+	DefaultMQTTInterval            time.Duration = 10 * time.Second
+	DefaultInfluxBatchSize         int           = 1000
+	DefaultInfluxFlushInterval     time.Duration = 10 * time.Second
+	DefaultInfluxTimeout           time.Duration = 10 * time.Second
+	DefaultInfluxSpoolDir          string        = "/var/lib/sensor-exporter/influx-spool"
+	DefaultInfluxSpoolMaxBytes     int64         = 64 << 20
+	DefaultRemoteWriteJob          string        = "sensor-exporter"
+	DefaultRemoteWriteInterval     time.Duration = 15 * time.Second
+	DefaultRemoteWriteTimeout      time.Duration = 30 * time.Second
+	DefaultRemoteWriteMinBackoff   time.Duration = 1 * time.Second
+	DefaultRemoteWriteMaxBackoff   time.Duration = 1 * time.Minute
+	DefaultRemoteWriteWALDir       string        = "/var/lib/sensor-exporter/remote-write"
+	DefaultRemoteWriteWALMaxBytes  int64         = 128 << 20
+	DefaultOTLPProtocol            string        = "http/protobuf"
+	DefaultOTLPCompression         string        = "gzip"
+	DefaultOTLPInterval            time.Duration = 10 * time.Second
+	DefaultOTLPTimeout             time.Duration = 10 * time.Second
+	DefaultOTLPBufferSize          int           = 10000
+	DefaultHistoryDir              string        = "/var/lib/sensor-exporter/history"
+	DefaultHistoryRawRetention     time.Duration = 24 * time.Hour
+	DefaultHistoryMinuteRetention  time.Duration = 31 * 24 * time.Hour
+	DefaultHistoryHourRetention    time.Duration = 5 * 365 * 24 * time.Hour
+	DefaultHistorySignalInterval   time.Duration = time.Second
+	DefaultDatalogFormat           string        = "csv"
+	DefaultDatalogCompress         bool          = true
+	DefaultDatalogMaxBytes         int64         = 1 << 30
+	DefaultAQI                     bool          = true
 )
 
 func ConfigureFlags(flags *pflag.FlagSet) {
 	flags.Int("metrics-port", DefaultMetricsPort, "Port on which to host Prometheus metrics")
 	flags.Duration("reconnect-timeout", DefaultReconnectTimeout, "Duration to wait before attempting to reconnect to the sensor after a failure")
+	flags.Duration("reconnect-max-timeout", DefaultReconnectMaxTimeout, "Maximum duration to wait between attempts to reconnect to the sensor as failures repeat")
+	flags.Float64("reconnect-multiplier", DefaultReconnectMultiplier, "Factor by which the duration to wait before reconnecting grows after each consecutive failure")
+	flags.Float64("reconnect-jitter", DefaultReconnectJitter, "Fraction by which the duration to wait before reconnecting is randomly varied")
+	flags.Duration("reconnect-reset-after", DefaultReconnectResetAfter, "Duration of healthy readings after which the duration to wait before reconnecting resets")
+	flags.Int("circuit-breaker-threshold", DefaultCircuitBreakerThreshold, "Number of consecutive failures after which reconnect attempts are suspended; 0 disables the circuit breaker")
+	flags.Duration("circuit-breaker-timeout", DefaultCircuitBreakerTimeout, "Duration for which reconnect attempts are suspended before the sensor is probed again")
 	flags.String("pms5003-port", DefaultPMS5003PortName, "Path or name of block device through which to read from the Plantower PMS5003 sensor")
 	flags.Uint8("aht20-i2c-addr", DefaultAHT20I2CAddr, "I2C address of the Asair AHT20 sensor")
 	flags.Int("aht20-i2c-bus", DefaultAHT20I2CBus, "I2C bus to which the Asair AHT20 sensor is attached")
 	flags.Uint8("sgp30-i2c-addr", DefaultSGP30I2CAddr, "I2C address of the Sensiron SGP30 sensor")
 	flags.Int("sgp30-i2c-bus", DefaultSGP30I2CBus, "I2C bus to which the Sensiron SGP30 sensor is attached")
-	flags.String("baseline-file", DefaultBaselineFile, "File to store JSON-encoded sensor baseline data to")
+	flags.String("baseline-file", DefaultBaselineFile, "Baseline file written by earlier versions, migrated into the state file if it does not exist yet")
+	flags.String("state-file", DefaultStateFile, "File to store long-lived sensor state, such as baselines and fan run time, to")
+	flags.Int("baseline-history-size", DefaultBaselineHistorySize, "Number of hourly SGP30 baselines to keep in the history of each sensor for rollback")
+	flags.Float64("baseline-max-deviation", DefaultBaselineMaxDeviation, "Fraction by which a new SGP30 baseline may deviate from the median of recent baselines before it is refused; 0 accepts every baseline")
+	flags.Int("baseline-accept-after", DefaultBaselineAcceptAfter, "Number of consecutive refused SGP30 baselines, within the maximum deviation of each other, after which they are accepted as a lasting drift of the sensor; 0 keeps refusing them")
+	flags.String("clock-check", DefaultClockCheck, "How to tell whether the wall clock is synchronized before trusting it for baseline decisions: kernel (adjtimex status), sentinel (file exists) or none")
+	flags.String("clock-sentinel-file", DefaultClockSentinelFile, "File whose existence indicates the wall clock is synchronized when the clock check is sentinel")
+	flags.Duration("clock-sync-timeout", DefaultClockSyncTimeout, "Duration to wait for the wall clock to synchronize before proceeding without trusting it; 0 waits indefinitely")
+	flags.Duration("ready-max-reading-age", DefaultReadyMaxReadingAge, "Maximum age of the latest reading of a sensor for /readyz to consider it ready; 0 disables the check")
+	flags.StringSlice("ready-sensors", nil, "Names of the sensors that must be ready for /readyz to report ready (default all sensors)")
+	flags.Bool("ready-require-acclimated", DefaultReadyRequireAcclimated, "Whether /readyz requires gas sensors to have finished acclimating")
+	flags.Bool("metrics-v1-compat", DefaultMetricsV1Compat, "Also emit the v1 metric names alongside the v2 metric set while dashboards are migrated")
+	flags.Bool("api-rollback", DefaultAPIRollback, "Allow rolling back SGP30 baselines with POST /api/v1/sensors/<sensor>/baseline/rollback; the API is not authenticated, so only enable it on a trusted network")
+	flags.String("mqtt-broker", "", "URL of the MQTT broker to publish readings to, e.g. tcp://localhost:1883; MQTT is disabled if empty")
+	flags.String("mqtt-client-id", "", "Client ID with which to connect to the MQTT broker (default sensor-exporter-<node>)")
+	flags.String("mqtt-username", "", "Username with which to connect to the MQTT broker")
+	flags.String("mqtt-password", "", "Password with which to connect to the MQTT broker")
+	flags.String("mqtt-node", "", "Name identifying this exporter in MQTT topics and Home Assistant (default the hostname)")
+	flags.String("mqtt-topic", DefaultMQTTTopic, "Template of the MQTT topic of each sensor, with the fields .Node, .Sensor and .Model")
+	flags.String("mqtt-status-topic", DefaultMQTTStatusTopic, "Template of the MQTT topic on which the exporter reports itself online or offline, with the field .Node")
+	flags.String("mqtt-format", DefaultMQTTFormat, "Format of the readings published to MQTT: json (one object per sensor) or value (one topic per measurement)")
+	flags.Uint8("mqtt-qos", DefaultMQTTQoS, "MQTT quality of service with which to publish: 0, 1 or 2")
+	flags.Bool("mqtt-retain", DefaultMQTTRetain, "Whether the MQTT broker retains the latest readings")
+	flags.Bool("mqtt-discovery", DefaultMQTTDiscovery, "Whether to announce the sensors to Home Assistant through MQTT discovery")
+	flags.String("mqtt-discovery-prefix", DefaultMQTTDiscoveryPrefix, "Topic prefix on which Home Assistant listens for MQTT discovery")
+	flags.Int("mqtt-buffer-size", DefaultMQTTBufferSize, "Number of reading messages to buffer while the MQTT broker is unreachable")
+	flags.Duration("mqtt-interval", DefaultMQTTInterval, "Shortest duration between two publications of the readings of a sensor to MQTT; 0 publishes every reading")
+	flags.String("influx-url", "", "URL of InfluxDB to write readings to: http(s)://host:8086 for the v2 API or udp://host:8089; InfluxDB is disabled if empty")
+	flags.String("influx-org", "", "InfluxDB organization to write to")
+	flags.String("influx-bucket", "", "InfluxDB bucket to write to")
+	flags.String("influx-token", "", "InfluxDB API token with write access to the bucket")
+	flags.Int("influx-batch-size", DefaultInfluxBatchSize, "Number of lines after which a batch is written to InfluxDB without waiting for the flush interval")
+	flags.Duration("influx-flush-interval", DefaultInfluxFlushInterval, "Longest duration lines wait before they are written to InfluxDB, and how often spooled lines are retried")
+	flags.Duration("influx-timeout", DefaultInfluxTimeout, "Time limit of a single write to InfluxDB")
+	flags.String("influx-spool-dir", DefaultInfluxSpoolDir, "Directory in which lines are spooled while InfluxDB is unreachable")
+	flags.Int64("influx-spool-max-bytes", DefaultInfluxSpoolMaxBytes, "Size of the InfluxDB spool beyond which the oldest lines are dropped")
+	flags.String("remote-write-url", "", "URL of a Prometheus remote write endpoint to push metrics to, e.g. http://prometheus:9090/api/v1/write; remote write is disabled if empty")
+	flags.String("remote-write-username", "", "Username for basic authentication with the remote write endpoint")
+	flags.String("remote-write-password", "", "Password for basic authentication with the remote write endpoint")
+	flags.String("remote-write-bearer-token", "", "Bearer token with which to authenticate with the remote write endpoint, instead of basic authentication")
+	flags.String("remote-write-job", DefaultRemoteWriteJob, "Value of the job label added to the metrics pushed over remote write")
+	flags.String("remote-write-instance", "", "Value of the instance label added to the metrics pushed over remote write (default the hostname)")
+	flags.StringToString("remote-write-labels", nil, "Further labels added to the metrics pushed over remote write, e.g. site=home,floor=1")
+	flags.Duration("remote-write-interval", DefaultRemoteWriteInterval, "How often metrics are gathered and pushed over remote write")
+	flags.Duration("remote-write-timeout", DefaultRemoteWriteTimeout, "Time limit of a single remote write request")
+	flags.Duration("remote-write-min-backoff", DefaultRemoteWriteMinBackoff, "Delay before the first retry of a failed remote write request, doubling with each further failure")
+	flags.Duration("remote-write-max-backoff", DefaultRemoteWriteMaxBackoff, "Longest delay between retries of a failed remote write request")
+	flags.String("remote-write-wal-dir", DefaultRemoteWriteWALDir, "Directory in which metrics are buffered until the remote write endpoint accepts them")
+	flags.Int64("remote-write-wal-max-bytes", DefaultRemoteWriteWALMaxBytes, "Size of the remote write buffer beyond which the oldest metrics are dropped")
+	flags.String("otlp-endpoint", "", "URL of an OpenTelemetry collector to export readings to, e.g. http://collector:4318 for HTTP or http://collector:4317 for gRPC; OTLP is disabled if empty")
+	flags.String("otlp-protocol", DefaultOTLPProtocol, "OTLP transport: http/protobuf or grpc")
+	flags.StringToString("otlp-headers", nil, "Headers sent with every OTLP export, e.g. authorization=Bearer <token>")
+	flags.String("otlp-compression", DefaultOTLPCompression, "Compression of OTLP exports: gzip or none")
+	flags.Duration("otlp-interval", DefaultOTLPInterval, "How often readings are exported to the OTLP collector, and how often failed exports are retried")
+	flags.Duration("otlp-timeout", DefaultOTLPTimeout, "Time limit of a single OTLP export")
+	flags.Int("otlp-buffer-size", DefaultOTLPBufferSize, "Number of data points to buffer while the OTLP collector is unreachable")
+	flags.StringToString("otlp-resource-attributes", nil, "Resource attributes added to or overriding the ones identifying the device, e.g. deployment.environment=home")
+	flags.String("history-dir", DefaultHistoryDir, "Directory in which the history of the readings is stored for /api/v1/history; the history is disabled if empty")
+	flags.Duration("history-raw-retention", DefaultHistoryRawRetention, "Duration for which every raw sample is kept in the history")
+	flags.Duration("history-minute-retention", DefaultHistoryMinuteRetention, "Duration for which 1-minute aggregates are kept in the history")
+	flags.Duration("history-hour-retention", DefaultHistoryHourRetention, "Duration for which hourly aggregates are kept in the history; 0 keeps them forever")
+	flags.Duration("history-signal-interval", DefaultHistorySignalInterval, "Minimum interval between the raw signals of a gas sensor kept as raw samples in the history, as they are measured 40 times a second; 0 keeps every one")
+	flags.String("datalog-dir", "", "Directory to log readings to as CSV or NDJSON files, one per sensor type and day; the data logger is disabled if empty")
+	flags.String("datalog-format", DefaultDatalogFormat, "Format of the log files: csv or ndjson")
+	flags.Duration("datalog-interval", 0, "Interval over which readings are aggregated into one record per sensor with the mean, minimum and maximum of each measurement; 0 logs every reading")
+	flags.Int64("datalog-rotate-size", 0, "Size after which a log file is rotated before the end of the day; 0 only rotates at midnight UTC")
+	flags.Bool("datalog-compress", DefaultDatalogCompress, "Whether rotated log files are compressed with gzip")
+	flags.Duration("datalog-max-age", 0, "Age after which log files are removed; 0 keeps them regardless of age")
+	flags.Int64("datalog-max-bytes", DefaultDatalogMaxBytes, "Total size of the log files beyond which the oldest are removed; 0 does not limit it")
+	flags.StringSlice("aggregate-windows", nil, "Windows over which every measurement is summarized with its count, minimum, maximum, mean, standard deviation, median and 95th percentile, e.g. 10s,1m,5m; aggregation is disabled if empty")
+	flags.Bool("aqi", DefaultAQI, "Whether to compute the US EPA Air Quality Index of PMS5003 sensors from the hourly averages of their PM2.5 and PM10 readings")
 }
 
 func Execute(settings *Settings) error {
+	instances, err := settings.SensorInstances()
+	if err != nil {
+		return err
+	}
+
+	store, err := OpenState(settings, instances)
+	if err != nil {
+		return err
+	}
+
+	clockGuard, err := settings.ClockGuard()
+	if err != nil {
+		return err
+	}
+	reconnectSettings, err := settings.ReconnectSettings()
+	if err != nil {
+		return err
+	}
+	keeper := &baselineKeeper{store, settings.BaselinePolicy(), clockGuard}
+
+	readinessRules, err := settings.ReadinessRules(instances)
+	if err != nil {
+		return err
+	}
+
 	group := cmd.NewProcessGroup(context.Background())
+	filterRules, err := settings.FilterRules(instances)
+	if err != nil {
+		return err
+	}
+	sensors := newTracker(filter.NewFilter(filterRules))
+	registry.MustRegister(filter.Collectors()...)
+
+	registerExporterMetrics(registry)
+	registerClockMetrics(registry, clockGuard)
+	if settings.MetricsV1Compat {
+		registerV1Metrics(registry)
+	}
 
+	aggregator, err := startAggregator(group, settings, sensors)
+	if err != nil {
+		return err
+	}
+	err = startOutputs(group, settings, instances, sensors, clockGuard, aggregator)
+	if err != nil {
+		return err
+	}
+	historyStore, err := startHistory(group, settings, sensors, clockGuard)
+	if err != nil {
+		return err
+	}
+	monitor := startAQI(group, settings, instances, sensors, historyStore)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(
+		registry,
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}),
+	))
+	mux.HandleFunc("/healthz", serveHealthz)
+	mux.Handle("/readyz", serveReadyz(instances, readinessRules, sensors))
+	mux.Handle("/api/v1/", newAPI(group.Context(), instances, store, sensors, historyStore, aggregator, monitor, settings.APIRollback))
 	metricServer := http.Server{
 		Addr:    fmt.Sprintf(":%d", settings.MetricsPort),
-		Handler: nil,
+		Handler: mux,
 	}
 	log.Info("starting metrics server",
 		"addr", metricServer.Addr)
 	group.Go(func() error {
-		http.Handle("/metrics", promhttp.Handler())
 		return metricServer.ListenAndServe()
 	})
 	group.Go(func() error {
@@ -71,120 +393,70 @@ func Execute(settings *Settings) error {
 		return metricServer.Close()
 	})
 
-	particulateSensor := pms5003.NewSensor(settings.PMSPortName, settings.ReconnectTimeout)
-	group.Go(particulateSensor.Start(group.Context()))
+	buses := i2cbus.NewManager()
+	gasSensors := map[string][]*sgp30.Sensor{}
+	for _, instance := range instances {
+		if instance.Mux != nil && instance.Mux.Fake {
+			buses.RegisterMux(instance.Bus, instance.Mux.Address, i2cbus.NewFakeMux())
+		}
 
-	tempHumiditySensor := aht20.NewSensor(settings.AHT20I2CAddr, settings.AHT20I2CBus, settings.ReconnectTimeout)
-	group.Go(tempHumiditySensor.Start(group.Context()))
+		if instance.Model != models.SGP30 {
+			continue
+		}
 
-	initialBaseline := tryReadBaseline(settings.BaselineFile)
-	gasSensor := sgp30.NewSensor(settings.SGP30I2CAddr, settings.SGP30I2CBus, settings.ReconnectTimeout, initialBaseline)
-	group.Go(gasSensor.Start(group.Context()))
+		gasSensor := sgp30.NewSensor(buses, instance.I2CAddress(), sensors.observeReconnects(instance.Name, reconnectSettings), StoredBaseline(store, clockGuard), clockGuard)
+		group.Go(gasSensor.Start(group.Context()))
+		group.Go(runGasSensor(group.Context(), instance, gasSensor, keeper, sensors))
+		sensors.setGasSensor(instance.Name, gasSensor)
+		gasSensors[instance.HumiditySensor] = append(gasSensors[instance.HumiditySensor], gasSensor)
+	}
 
-	group.Go(func() error {
-		setHumidityAfter := time.Time{}
-		for {
-			select {
-			case reading, ok := <-particulateSensor.Readings():
-				if !ok {
-					log.Debug("particulate sensor readings channel closed")
-					return nil
-				}
-
-				setPMSMetrics(reading)
-			case reading, ok := <-tempHumiditySensor.Readings():
-				if !ok {
-					log.Debug("temperature and humidity sensor readings channel closed")
-					return nil
-				}
-
-				humidity := units.AbsoluteHumidity(reading.Temperature, reading.Humidity)
-				setAHTMetrics(reading, humidity)
-
-				now := time.Now()
-				if now.After(setHumidityAfter) {
-					setHumidityAfter = now.Add(10 * time.Second)
-
-					log.Debug("setting humidity on gas sensor",
-						"humidity", humidity,
-						"reading", reading)
-					gasSensor.SetHumidity(group.Context(), humidity)
-				}
-			case reading, ok := <-gasSensor.AirQualityReadings():
-				if !ok {
-					log.Debug("gas sensor air quality readings channel closed")
-					return nil
-				}
-
-				setSGPAirQualityMetrics(reading)
-			case reading, ok := <-gasSensor.RawReadings():
-				if !ok {
-					log.Debug("gas sensor raw readings channel closed")
-					return nil
-				}
-
-				setSGPRawMetrics(reading)
-			case baseline, ok := <-gasSensor.BaselineReadings():
-				if !ok {
-					log.Debug("gas sensor baseline readings channel closed")
-					return nil
-				}
-
-				tryWriteBaseline(settings.BaselineFile, baseline)
-			case <-group.Context().Done():
-				return nil
-			}
+	for _, instance := range instances {
+		switch instance.Model {
+		case models.PMS5003:
+			particulateSensor := pms5003.NewSensor(instance.Port, sensors.observeReconnects(instance.Name, reconnectSettings))
+			group.Go(particulateSensor.Start(group.Context()))
+			group.Go(runParticulateSensor(group.Context(), instance, particulateSensor, store, sensors))
+		case models.AHT20:
+			tempHumiditySensor := aht20.NewSensor(buses, instance.I2CAddress(), sensors.observeReconnects(instance.Name, reconnectSettings))
+			group.Go(tempHumiditySensor.Start(group.Context()))
+			group.Go(runTempHumiditySensor(group.Context(), instance, tempHumiditySensor, gasSensors[instance.Name], store, sensors))
 		}
-	})
+	}
 
 	return group.Wait()
 }
 
-func tryReadBaseline(path string) *sgp30.BaselineReading {
-	var initialBaseline *sgp30.BaselineReading
+// ReadBaseline reads the stored baseline of an SGP30
+func ReadBaseline(path string) (*sgp30.BaselineReading, error) {
 	bytes, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Error("failed to read baseline file; sensor will require acclimation",
-			"err", err,
-			"path", path)
-		return nil
+		return nil, errors.Wrapf(err, "failed to read baseline file %v", path)
 	}
 
-	err = json.Unmarshal(bytes, &initialBaseline)
+	var baseline *sgp30.BaselineReading
+	err = json.Unmarshal(bytes, &baseline)
 	if err != nil {
-		log.Error("failed to unmarshal baseline file; sensor will require acclimation",
-			"err", err,
-			"path", path,
-			"bytes", bytes)
-		return nil
+		return nil, errors.Wrapf(err, "failed to unmarshal baseline file %v", path)
+	}
+	if baseline == nil {
+		return nil, errors.Errorf("failed to find a baseline in baseline file %v", path)
 	}
 
-	log.Info("initializing sensor with stored baseline",
-		"path", path,
-		"initialBaseline", initialBaseline)
-	return initialBaseline
+	return baseline, nil
 }
 
-func tryWriteBaseline(path string, baseline *sgp30.BaselineReading) {
+// WriteBaseline stores the baseline of an SGP30
+func WriteBaseline(path string, baseline *sgp30.BaselineReading) error {
 	file, err := json.MarshalIndent(baseline, "", "\t")
 	if err != nil {
-		log.Error("failed to marshall baseline",
-			"err", err,
-			"baseline", baseline)
-		return
+		return errors.Wrap(err, "failed to marshal baseline")
 	}
 
 	err = ioutil.WriteFile(path, file, 0644)
 	if err != nil {
-		log.Error("failed to write baseline file",
-			"err", err,
-			"file", file,
-			"baseline", baseline,
-			"path", path)
-		return
+		return errors.Wrapf(err, "failed to write baseline file %v", path)
 	}
 
-	log.Info("stored new baseline",
-		"path", path,
-		"baseline", baseline)
+	return nil
 }
@@ -0,0 +1,93 @@
+package exporter
+
+import (
+	"sensor-exporter/clock"
+	"sensor-exporter/internal/instrumentation"
+	"sensor-exporter/internal/measurement"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+var (
+	// registry holds every metric served by the exporter, separate from the global default registry
+	registry = prometheus.NewRegistry()
+
+	sensorLabelNames = []string{"sensor", "model", "serial"}
+)
+
+// sensorLabels identifies the physical sensor that produced a v2 metric
+type sensorLabels struct {
+	// Name of the sensor as configured in the exporter
+	Sensor string
+	// Model of the sensor, e.g. AHT20
+	Model string
+	// Serial number of the sensor, if the sensor reports one
+	Serial string
+}
+
+func (l sensorLabels) values(extra ...string) []string {
+	return append([]string{l.Sensor, l.Model, l.Serial}, extra...)
+}
+
+func (l sensorLabels) source() measurement.Source {
+	return measurement.Source{
+		Sensor: l.Sensor,
+		Model:  l.Model,
+		Serial: l.Serial,
+	}
+}
+
+func withSensorLabels(names ...string) []string {
+	return append(append([]string{}, sensorLabelNames...), names...)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// registerExporterMetrics registers the metrics describing the exporter process itself
+func registerExporterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		newBuildInfoCollector(),
+	)
+	registerer.MustRegister(instrumentation.Collectors()...)
+}
+
+// registerClockMetrics registers the metric reporting whether the wall clock is trusted
+func registerClockMetrics(registerer prometheus.Registerer, clockGuard *clock.Guard) {
+	registerer.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "sensor_exporter_clock_synchronized",
+			Help: "Whether the wall clock has been synchronized (1) and is trusted for baseline decisions, or not (0)",
+		},
+		func() float64 {
+			return boolToFloat(clockGuard.Synchronized())
+		},
+	))
+}
+
+// registerV1Metrics registers the metric names used before the v2 metric set so that existing dashboards keep working during migration
+func registerV1Metrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(
+		aht_received_packets,
+		aht_absolute_humidity,
+		aht_relative_humidity,
+		aht_temperature,
+		pms_received_packets,
+		pms_particulate_matter_standard,
+		pms_particulate_matter_environmental,
+		pms_particle_counts,
+		sgp_received_packets,
+		sgp_h2_ppm,
+		sgp_ethanol_ppm,
+		sgp_seconds_until_acclimated,
+		sgp_tvoc_ppb,
+		sgp_eco2_ppm,
+	)
+}
@@ -0,0 +1,220 @@
+package exporter
+
+import (
+	"fmt"
+	"os"
+	"sensor-exporter/clock"
+	"sensor-exporter/internal/aggregate"
+	"sensor-exporter/internal/datalog"
+	"sensor-exporter/internal/influx"
+	"sensor-exporter/internal/mqtt"
+	"sensor-exporter/internal/otlp"
+	"sensor-exporter/internal/remotewrite"
+	"sensor-exporter/internal/version"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/go-kit/v2/cmd"
+)
+
+const (
+	// outputBuffer is the number of batches of samples an output buffers before samples are dropped
+	outputBuffer = 256
+	// durableBuffer is the number of batches of samples buffered for outputs that keep every reading, such as the
+	// InfluxDB spool, the history and the data logger, so that a slow disk or a stalled write does not drop samples.
+	// Sensors publish about 45 batches a second, most of them SGP30 raw signals, so this holds about six minutes.
+	durableBuffer = 16384
+)
+
+// MQTTSettings returns the settings of the MQTT publisher, defaulting the node to the hostname
+func (s *Settings) MQTTSettings() (mqtt.Settings, error) {
+	node := s.MQTTNode
+	if node == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return mqtt.Settings{}, errors.Wrap(err, "failed to determine hostname for MQTT node")
+		}
+		node = hostname
+	}
+	clientID := s.MQTTClientID
+	if clientID == "" {
+		clientID = fmt.Sprintf("sensor-exporter-%v", node)
+	}
+
+	return mqtt.Settings{
+		Broker:          s.MQTTBroker,
+		ClientID:        clientID,
+		Username:        s.MQTTUsername,
+		Password:        s.MQTTPassword,
+		Node:            node,
+		Topic:           s.MQTTTopic,
+		StatusTopic:     s.MQTTStatusTopic,
+		Format:          s.MQTTFormat,
+		QoS:             s.MQTTQoS,
+		Retain:          s.MQTTRetain,
+		Discovery:       s.MQTTDiscovery,
+		DiscoveryPrefix: s.MQTTDiscoveryPrefix,
+		BufferSize:      s.MQTTBufferSize,
+		Interval:        s.MQTTInterval,
+	}, nil
+}
+
+// InfluxSettings returns the settings of the InfluxDB writer
+func (s *Settings) InfluxSettings() influx.Settings {
+	return influx.Settings{
+		URL:           s.InfluxURL,
+		Org:           s.InfluxOrg,
+		Bucket:        s.InfluxBucket,
+		Token:         s.InfluxToken,
+		BatchSize:     s.InfluxBatchSize,
+		FlushInterval: s.InfluxFlushInterval,
+		Timeout:       s.InfluxTimeout,
+		SpoolDir:      s.InfluxSpoolDir,
+		SpoolMaxBytes: s.InfluxSpoolMaxBytes,
+	}
+}
+
+// RemoteWriteSettings returns the settings of the remote write sender, labelling the metrics with the job and
+// instance a scrape would add and defaulting the instance to the hostname
+func (s *Settings) RemoteWriteSettings() (remotewrite.Settings, error) {
+	instance := s.RemoteWriteInstance
+	if instance == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return remotewrite.Settings{}, errors.Wrap(err, "failed to determine hostname for remote write instance")
+		}
+		instance = hostname
+	}
+
+	labels := map[string]string{}
+	for name, value := range s.RemoteWriteLabels {
+		labels[name] = value
+	}
+	labels["job"] = s.RemoteWriteJob
+	labels["instance"] = instance
+
+	return remotewrite.Settings{
+		URL:            s.RemoteWriteURL,
+		Username:       s.RemoteWriteUsername,
+		Password:       s.RemoteWritePassword,
+		BearerToken:    s.RemoteWriteBearerToken,
+		ExternalLabels: labels,
+		Interval:       s.RemoteWriteInterval,
+		Timeout:        s.RemoteWriteTimeout,
+		MinBackoff:     s.RemoteWriteMinBackoff,
+		MaxBackoff:     s.RemoteWriteMaxBackoff,
+		WALDir:         s.RemoteWriteWALDir,
+		WALMaxBytes:    s.RemoteWriteWALMaxBytes,
+		Version:        version.Get().Version,
+	}, nil
+}
+
+// OTLPSettings returns the settings of the OTLP exporter
+func (s *Settings) OTLPSettings() otlp.Settings {
+	return otlp.Settings{
+		Endpoint:           s.OTLPEndpoint,
+		Protocol:           s.OTLPProtocol,
+		Headers:            s.OTLPHeaders,
+		Compression:        s.OTLPCompression,
+		Interval:           s.OTLPInterval,
+		Timeout:            s.OTLPTimeout,
+		BufferSize:         s.OTLPBufferSize,
+		ResourceAttributes: s.OTLPResourceAttributes,
+		Version:            version.Get().Version,
+	}
+}
+
+// DatalogSettings returns the settings of the data logger
+func (s *Settings) DatalogSettings() datalog.Settings {
+	return datalog.Settings{
+		Dir:        s.DatalogDir,
+		Format:     s.DatalogFormat,
+		Interval:   s.DatalogInterval,
+		RotateSize: s.DatalogRotateSize,
+		Compress:   s.DatalogCompress,
+		MaxAge:     s.DatalogMaxAge,
+		MaxBytes:   s.DatalogMaxBytes,
+	}
+}
+
+// startOutputs starts the enabled outputs, each fed by its own subscription to the samples of the sensors and, for
+// outputs that record them, to the window summaries of the aggregator if it is enabled
+func startOutputs(group *cmd.ProcessGroup, settings *Settings, instances []SensorSettings, sensors *tracker, clockGuard *clock.Guard, aggregator *aggregate.Aggregator) error {
+	if settings.MQTTBroker != "" {
+		mqttSettings, err := settings.MQTTSettings()
+		if err != nil {
+			return err
+		}
+		devices := []mqtt.Device{}
+		for _, instance := range instances {
+			devices = append(devices, mqtt.Device{Name: instance.Name, Model: strings.ToUpper(instance.Model)})
+		}
+		publisher, err := mqtt.NewPublisher(mqttSettings, devices, sensors.connected)
+		if err != nil {
+			return err
+		}
+
+		registry.MustRegister(mqtt.Collectors()...)
+		subscription := sensors.samples.subscribe("mqtt", sampleFilter{}, outputBuffer)
+		group.Go(publisher.Start(group.Context(), subscription.samples))
+	}
+
+	if settings.InfluxURL != "" {
+		writer, err := influx.NewWriter(settings.InfluxSettings())
+		if err != nil {
+			return err
+		}
+
+		// InfluxDB is the only output that records summaries, since the data logger aggregates over its own interval and
+		// OTLP only carries the readings
+		var summaries <-chan []aggregate.Summary
+		if aggregator != nil {
+			summaries = aggregator.Subscribe(outputBuffer)
+		}
+
+		registry.MustRegister(influx.Collectors()...)
+		subscription := sensors.samples.subscribeDurable("influx", sampleFilter{})
+		group.Go(writer.Start(group.Context(), subscription.samples, summaries))
+	}
+
+	if settings.RemoteWriteURL != "" {
+		remoteWriteSettings, err := settings.RemoteWriteSettings()
+		if err != nil {
+			return err
+		}
+		sender, err := remotewrite.NewSender(remoteWriteSettings, registry)
+		if err != nil {
+			return err
+		}
+
+		registry.MustRegister(remotewrite.Collectors()...)
+		group.Go(sender.Start(group.Context()))
+	}
+
+	if settings.OTLPEndpoint != "" {
+		rooms := map[string]string{}
+		for _, instance := range instances {
+			rooms[instance.Name] = instance.Room
+		}
+		exporter, err := otlp.NewExporter(settings.OTLPSettings(), rooms)
+		if err != nil {
+			return err
+		}
+
+		registry.MustRegister(otlp.Collectors()...)
+		subscription := sensors.samples.subscribe("otlp", sampleFilter{}, outputBuffer)
+		group.Go(exporter.Start(group.Context(), subscription.samples))
+	}
+
+	if settings.DatalogDir != "" {
+		logger, err := datalog.NewLogger(settings.DatalogSettings(), clockGuard.Synchronized)
+		if err != nil {
+			return err
+		}
+
+		registry.MustRegister(datalog.Collectors()...)
+		subscription := sensors.samples.subscribeDurable("datalog", sampleFilter{})
+		group.Go(logger.Start(group.Context(), subscription.samples))
+	}
+	return nil
+}
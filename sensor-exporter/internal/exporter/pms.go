@@ -1,33 +1,73 @@
 package exporter
 
 import (
-	"sensor-exporter/pms5003"
+	"sensor-exporter/internal/measurement"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 var (
-	pms_received_packets = promauto.NewCounter(
+	pms_readings_total = promauto.With(registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pms_readings_total",
+			Help: "Number of readings received from the sensor",
+		},
+		withSensorLabels(),
+	)
+	pms_particulate_matter_standard_micrograms_per_cubic_meter = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pms_particulate_matter_standard_micrograms_per_cubic_meter",
+			Help: "Micrograms per cubic meter, standard particle",
+		},
+		withSensorLabels("microns"),
+	)
+	pms_particulate_matter_environmental_micrograms_per_cubic_meter = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pms_particulate_matter_environmental_micrograms_per_cubic_meter",
+			Help: "Micrograms per cubic meter, adjusted for atmospheric environment",
+		},
+		withSensorLabels("microns"),
+	)
+	pms_particles_per_deciliter = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pms_particles_per_deciliter",
+			Help: "Number of particles with diameter beyond given number of microns in 0.1L of air",
+		},
+		withSensorLabels("microns_lower_bound"),
+	)
+	pms_fan_run_seconds_total = promauto.With(registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pms_fan_run_seconds_total",
+			Help: "Total time the fan of the sensor has run, persisted across restarts",
+		},
+		withSensorLabels(),
+	)
+)
+
+// v1 metrics, only registered when v1 compatibility is enabled
+var (
+	pms_received_packets = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "pms_received_packets",
 		},
 	)
-	pms_particulate_matter_standard = promauto.NewGaugeVec(
+	pms_particulate_matter_standard = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "pms_particulate_matter_standard",
 			Help: "Micrograms per cubic meter, standard particle",
 		},
 		[]string{"microns"},
 	)
-	pms_particulate_matter_environmental = promauto.NewGaugeVec(
+	pms_particulate_matter_environmental = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "pms_particulate_matter_environmental",
 			Help: "Micrograms per cubic meter, adjusted for atmospheric environment",
 		},
 		[]string{"microns"},
 	)
-	pms_particle_counts = promauto.NewGaugeVec(
+	pms_particle_counts = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "pms_particle_counts",
 			Help: "Number of particles with diameter beyond given number of microns in 0.1L of air",
@@ -36,18 +76,41 @@ var (
 	)
 )
 
-func setPMSMetrics(reading *pms5003.Reading) {
+// pmsGauges maps the measurements of a particulate reading to their gauges and the value of their size label
+var pmsGauges = map[string]struct {
+	gauge, v1 *prometheus.GaugeVec
+	size      string
+}{
+	"pm1_0_standard":      {pms_particulate_matter_standard_micrograms_per_cubic_meter, pms_particulate_matter_standard, "01.0"},
+	"pm2_5_standard":      {pms_particulate_matter_standard_micrograms_per_cubic_meter, pms_particulate_matter_standard, "02.5"},
+	"pm10_standard":       {pms_particulate_matter_standard_micrograms_per_cubic_meter, pms_particulate_matter_standard, "10.0"},
+	"pm1_0_environmental": {pms_particulate_matter_environmental_micrograms_per_cubic_meter, pms_particulate_matter_environmental, "01.0"},
+	"pm2_5_environmental": {pms_particulate_matter_environmental_micrograms_per_cubic_meter, pms_particulate_matter_environmental, "02.5"},
+	"pm10_environmental":  {pms_particulate_matter_environmental_micrograms_per_cubic_meter, pms_particulate_matter_environmental, "10.0"},
+	"particles_0_3um":     {pms_particles_per_deciliter, pms_particle_counts, "00.3"},
+	"particles_0_5um":     {pms_particles_per_deciliter, pms_particle_counts, "00.5"},
+	"particles_1_0um":     {pms_particles_per_deciliter, pms_particle_counts, "01.0"},
+	"particles_2_5um":     {pms_particles_per_deciliter, pms_particle_counts, "02.5"},
+	"particles_5_0um":     {pms_particles_per_deciliter, pms_particle_counts, "05.0"},
+	"particles_10um":      {pms_particles_per_deciliter, pms_particle_counts, "10.0"},
+}
+
+// setPMSMetrics counts a reading and sets the gauges of the samples that passed the filter, leaving the gauges of
+// rejected samples at their last accepted value
+func setPMSMetrics(labels sensorLabels, samples []measurement.Sample) {
+	pms_readings_total.WithLabelValues(labels.values()...).Inc()
 	pms_received_packets.Inc()
-	pms_particulate_matter_standard.WithLabelValues("01.0").Set(float64(reading.Pm10Std))
-	pms_particulate_matter_standard.WithLabelValues("02.5").Set(float64(reading.Pm25Std))
-	pms_particulate_matter_standard.WithLabelValues("10.0").Set(float64(reading.Pm100Std))
-	pms_particulate_matter_environmental.WithLabelValues("01.0").Set(float64(reading.Pm10Env))
-	pms_particulate_matter_environmental.WithLabelValues("02.5").Set(float64(reading.Pm25Env))
-	pms_particulate_matter_environmental.WithLabelValues("10.0").Set(float64(reading.Pm100Env))
-	pms_particle_counts.WithLabelValues("00.3").Set(float64(reading.Particles3um))
-	pms_particle_counts.WithLabelValues("00.5").Set(float64(reading.Particles5um))
-	pms_particle_counts.WithLabelValues("01.0").Set(float64(reading.Particles10um))
-	pms_particle_counts.WithLabelValues("02.5").Set(float64(reading.Particles25um))
-	pms_particle_counts.WithLabelValues("05.0").Set(float64(reading.Particles50um))
-	pms_particle_counts.WithLabelValues("10.0").Set(float64(reading.Particles100um))
+
+	for _, sample := range samples {
+		gauges, ok := pmsGauges[sample.Measurement]
+		if !ok {
+			continue
+		}
+		gauges.gauge.WithLabelValues(labels.values(gauges.size)...).Set(sample.Value)
+		gauges.v1.WithLabelValues(gauges.size).Set(sample.Value)
+	}
+}
+
+func addFanRunTime(labels sensorLabels, duration time.Duration) {
+	pms_fan_run_seconds_total.WithLabelValues(labels.values()...).Add(duration.Seconds())
 }
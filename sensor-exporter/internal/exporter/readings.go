@@ -0,0 +1,151 @@
+package exporter
+
+import (
+	"sensor-exporter/sgp30"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// sensorState describes a configured sensor and its connection as reported by /api/v1/sensors
+type sensorState struct {
+	Name           string     `json:"name"`
+	Model          string     `json:"model"`
+	Serial         string     `json:"serial,omitempty"`
+	Firmware       string     `json:"firmware,omitempty"`
+	Bus            string     `json:"bus"`
+	Address        string     `json:"address,omitempty"`
+	HumiditySensor string     `json:"humiditySensor,omitempty"`
+	Room           string     `json:"room,omitempty"`
+	Connected      bool       `json:"connected"`
+	Circuit        string     `json:"circuit"`
+	LastReadingAt  *time.Time `json:"lastReadingAt,omitempty"`
+}
+
+// sensorReading is the latest reading of a sensor as reported by /api/v1/readings
+type sensorReading struct {
+	Sensor string     `json:"sensor"`
+	Model  string     `json:"model"`
+	Serial string     `json:"serial,omitempty"`
+	Time   *time.Time `json:"time,omitempty"`
+	// Acclimation of a gas sensor, which decides whether its eCO2 and tVOC values are valid
+	Acclimation *acclimationState `json:"acclimation,omitempty"`
+	// Latest value of each measurement by name, including derived values such as absolute humidity
+	Measurements map[string]measurementValue `json:"measurements"`
+}
+
+// acclimationState mirrors the validity of the latest SGP30 air quality reading
+type acclimationState struct {
+	IsValid                   bool    `json:"isValid"`
+	IsInitialized             bool    `json:"isInitialized"`
+	DurationUntilValidSeconds float64 `json:"durationUntilValidSeconds"`
+}
+
+// measurementValue is the latest value of one measurement of a sensor
+type measurementValue struct {
+	Value float64   `json:"value"`
+	Unit  string    `json:"unit"`
+	Valid bool      `json:"valid"`
+	Time  time.Time `json:"time"`
+	// Value before filtering, present if the measurement is filtered
+	Raw *float64 `json:"raw,omitempty"`
+}
+
+// sensorStates describes the given sensors
+func (t *tracker) sensorStates(instances []SensorSettings) []sensorState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	states := []sensorState{}
+	for _, instance := range instances {
+		tracked := t.sensor(instance.Name)
+		info := instance.deviceInfo()
+		state := sensorState{
+			Name:           instance.Name,
+			Model:          strings.ToUpper(instance.Model),
+			Firmware:       tracked.firmware,
+			Bus:            info.Bus,
+			Address:        info.Address,
+			HumiditySensor: instance.HumiditySensor,
+			Room:           instance.Room,
+			Connected:      tracked.status.Connected,
+			Circuit:        tracked.status.Circuit.String(),
+		}
+		if tracked.serial != nil {
+			state.Serial = sgp30.FormatSerial(tracked.serial)
+		}
+		if !tracked.lastReadingAt.IsZero() {
+			lastReadingAt := tracked.lastReadingAt
+			state.LastReadingAt = &lastReadingAt
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// readings returns the latest reading of each of the given sensors; sensors without readings have no measurements
+func (t *tracker) readings(instances []SensorSettings) []sensorReading {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	readings := []sensorReading{}
+	for _, instance := range instances {
+		tracked := t.sensor(instance.Name)
+		reading := sensorReading{
+			Sensor:       instance.Name,
+			Model:        strings.ToUpper(instance.Model),
+			Measurements: map[string]measurementValue{},
+		}
+		if tracked.serial != nil {
+			reading.Serial = sgp30.FormatSerial(tracked.serial)
+		}
+		if !tracked.lastReadingAt.IsZero() {
+			lastReadingAt := tracked.lastReadingAt
+			reading.Time = &lastReadingAt
+		}
+		if tracked.airQuality != nil {
+			reading.Acclimation = &acclimationState{
+				IsValid:                   tracked.airQuality.IsValid,
+				IsInitialized:             tracked.airQuality.IsInitialized,
+				DurationUntilValidSeconds: tracked.airQuality.DurationUntilValid.Seconds(),
+			}
+		}
+		for name, sample := range tracked.samples {
+			reading.Measurements[name] = measurementValue{
+				Value: sample.Value,
+				Unit:  sample.Unit,
+				Valid: sample.Valid,
+				Time:  sample.Time,
+				Raw:   sample.Raw,
+			}
+		}
+		readings = append(readings, reading)
+	}
+	return readings
+}
+
+// selectInstances returns the instances with the given names in configuration order, or every instance if no names
+// are given, along with any names that are not configured
+func selectInstances(instances []SensorSettings, names []string) ([]SensorSettings, []string) {
+	if len(names) == 0 {
+		return instances, nil
+	}
+
+	configured := []string{}
+	selected := []SensorSettings{}
+	for _, instance := range instances {
+		configured = append(configured, instance.Name)
+		if slices.Contains(names, instance.Name) {
+			selected = append(selected, instance)
+		}
+	}
+
+	unknown := []string{}
+	for _, name := range names {
+		if !slices.Contains(configured, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	return selected, unknown
+}
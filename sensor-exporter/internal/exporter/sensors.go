@@ -0,0 +1,343 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"sensor-exporter/aht20"
+	"sensor-exporter/i2cbus"
+	"sensor-exporter/internal/filter"
+	"sensor-exporter/internal/measurement"
+	"sensor-exporter/internal/models"
+	"sensor-exporter/internal/state"
+	"sensor-exporter/pms5003"
+	"sensor-exporter/sgp30"
+	"sensor-exporter/units"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+// SensorSettings defines one sensor instance, as configured in the sensors list of the configuration file
+type SensorSettings struct {
+	// Unique name of the sensor, used as the sensor label of its metrics
+	Name string `mapstructure:"name"`
+	// Model of the sensor: aht20, sgp30 or pms5003
+	Model string `mapstructure:"model"`
+	// Path or name of the serial port of a PMS5003
+	Port string `mapstructure:"port"`
+	// I2C bus to which an AHT20 or SGP30 is attached
+	Bus int `mapstructure:"bus"`
+	// I2C address of an AHT20 or SGP30
+	Address uint8 `mapstructure:"address"`
+	// Multiplexer channel through which an AHT20 or SGP30 is attached, if any
+	Mux *MuxSettings `mapstructure:"mux"`
+	// Name of the AHT20 whose readings compensate an SGP30 for humidity; defaults to the first AHT20
+	HumiditySensor string `mapstructure:"humidity-sensor"`
+	// Room the sensor is placed in, attached to its readings by outputs that support it
+	Room string `mapstructure:"room"`
+	// Legacy baseline file of an SGP30 written by earlier versions, migrated into the state store if the state file does
+	// not exist yet; defaults to the baseline-file setting
+	BaselineFile string `mapstructure:"baseline-file"`
+	// Filter chains by measurement, overriding the filters setting for this sensor
+	Filters map[string]filter.Settings `mapstructure:"filters"`
+}
+
+// MuxSettings defines the TCA9548A multiplexer channel through which a sensor is attached
+type MuxSettings struct {
+	// I2C address of the multiplexer
+	Address uint8 `mapstructure:"address"`
+	// Channel of the multiplexer, from 0 to 7
+	Channel int `mapstructure:"channel"`
+	// Record channel selections without writing to the bus, for running without a multiplexer
+	Fake bool `mapstructure:"fake"`
+}
+
+// I2CAddress returns where an I2C sensor is attached
+func (s *SensorSettings) I2CAddress() i2cbus.Address {
+	address := i2cbus.Address{
+		Bus:    s.Bus,
+		Device: s.Address,
+	}
+	if s.Mux != nil {
+		address.Mux = &i2cbus.MuxChannel{
+			Address: s.Mux.Address,
+			Channel: s.Mux.Channel,
+		}
+	}
+	return address
+}
+
+func (s *SensorSettings) labels() sensorLabels {
+	return sensorLabels{
+		Sensor: s.Name,
+		Model:  strings.ToUpper(s.Model),
+	}
+}
+
+func (s *SensorSettings) deviceInfo() deviceInfo {
+	if s.Model == models.PMS5003 {
+		return deviceInfo{Bus: s.Port}
+	}
+
+	address := s.I2CAddress()
+	return deviceInfo{
+		Bus:     i2cbus.Name(address.Bus),
+		Address: address.Location(),
+	}
+}
+
+// SensorInstances returns the configured sensor instances, or one instance of each model configured by the individual
+// sensor flags if the configuration file does not list any sensors
+func (s *Settings) SensorInstances() ([]SensorSettings, error) {
+	instances := s.Sensors
+	if len(instances) == 0 {
+		instances = []SensorSettings{
+			{Name: models.PMS5003, Model: models.PMS5003, Port: s.PMSPortName},
+			{Name: models.AHT20, Model: models.AHT20, Bus: s.AHT20I2CBus, Address: s.AHT20I2CAddr},
+			{Name: models.SGP30, Model: models.SGP30, Bus: s.SGP30I2CBus, Address: s.SGP30I2CAddr},
+		}
+	}
+
+	names := map[string]bool{}
+	// Names of the I2C sensors by address, as two sensors at one address would answer at the same time
+	addresses := map[string]string{}
+	firstAHT20 := ""
+	resolved := []SensorSettings{}
+	for _, instance := range instances {
+		instance.Model = strings.ToLower(instance.Model)
+		if instance.Name == "" {
+			return nil, errors.Errorf("failed to configure %v sensor without a name", instance.Model)
+		}
+		if names[instance.Name] {
+			return nil, errors.Errorf("failed to configure sensor %v more than once", instance.Name)
+		}
+		names[instance.Name] = true
+
+		switch instance.Model {
+		case models.PMS5003:
+			if instance.Port == "" {
+				instance.Port = DefaultPMS5003PortName
+			}
+		case models.AHT20:
+			if instance.Address == 0 {
+				instance.Address = DefaultAHT20I2CAddr
+			}
+			if firstAHT20 == "" {
+				firstAHT20 = instance.Name
+			}
+		case models.SGP30:
+			if instance.Address == 0 {
+				instance.Address = DefaultSGP30I2CAddr
+			}
+			if instance.BaselineFile == "" {
+				instance.BaselineFile = s.BaselineFile
+			}
+		default:
+			return nil, errors.Errorf("failed to configure sensor %v of unknown model %q", instance.Name, instance.Model)
+		}
+
+		if instance.Mux != nil && (instance.Mux.Channel < 0 || instance.Mux.Channel > 7) {
+			return nil, errors.Errorf("failed to configure sensor %v on multiplexer channel %v; channels range from 0 to 7", instance.Name, instance.Mux.Channel)
+		}
+		if instance.Model != models.PMS5003 {
+			address := instance.I2CAddress().String()
+			if other, ok := addresses[address]; ok {
+				return nil, errors.Errorf("failed to configure sensor %v at %v; sensor %v is configured at the same address", instance.Name, address, other)
+			}
+			addresses[address] = instance.Name
+		}
+
+		resolved = append(resolved, instance)
+	}
+
+	for i := range resolved {
+		if resolved[i].Model != models.SGP30 {
+			continue
+		}
+		if resolved[i].HumiditySensor == "" {
+			resolved[i].HumiditySensor = firstAHT20
+			continue
+		}
+		if !names[resolved[i].HumiditySensor] {
+			return nil, errors.Errorf("failed to configure sensor %v with unknown humidity sensor %v", resolved[i].Name, resolved[i].HumiditySensor)
+		}
+	}
+
+	return resolved, nil
+}
+
+func runParticulateSensor(ctx context.Context, instance SensorSettings, sensor *pms5003.Sensor, store *state.Store, sensors *tracker) func() error {
+	return func() error {
+		labels := instance.labels()
+		info := instance.deviceInfo()
+		runTime := newFanRunTime(store, instance)
+		addFanRunTime(labels, runTime.total)
+		calibration := StoredCalibration(store, instance)
+		// the time is taken when the loop stops, so that the run time up to then is saved
+		defer func() { runTime.save(time.Now()) }()
+		for {
+			select {
+			case pmsInfo, ok := <-sensor.Infos():
+				if !ok {
+					log.Debug("particulate sensor info channel closed",
+						"sensor", instance.Name)
+					return nil
+				}
+
+				deleteSensorInfo(labels, info)
+				info.Firmware = fmt.Sprintf("0x%02x", pmsInfo.Version)
+				setSensorInfo(labels, info)
+				sensors.setFirmware(instance.Name, info.Firmware)
+			case reading, ok := <-sensor.Readings():
+				if !ok {
+					log.Debug("particulate sensor readings channel closed",
+						"sensor", instance.Name)
+					return nil
+				}
+
+				now := time.Now()
+				setPMSMetrics(labels, sensors.setSamples(instance.Name, calibration.Apply(measurement.FromPMS(labels.source(), reading, now))))
+				addFanRunTime(labels, runTime.observe(now))
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+func runTempHumiditySensor(ctx context.Context, instance SensorSettings, sensor *aht20.Sensor, gasSensors []*sgp30.Sensor, store *state.Store, sensors *tracker) func() error {
+	return func() error {
+		labels := instance.labels()
+		info := instance.deviceInfo()
+		calibration := StoredCalibration(store, instance)
+		setHumidityAfter := time.Time{}
+		for {
+			select {
+			case ahtInfo, ok := <-sensor.Infos():
+				if !ok {
+					log.Debug("temperature and humidity sensor info channel closed",
+						"sensor", instance.Name)
+					return nil
+				}
+
+				log.Debug("read temperature and humidity sensor info",
+					"sensor", instance.Name,
+					"info", ahtInfo)
+				deleteSensorInfo(labels, info)
+				info.Firmware = ahtInfo.Variant
+				setSensorInfo(labels, info)
+				sensors.setFirmware(instance.Name, info.Firmware)
+			case reading, ok := <-sensor.Readings():
+				if !ok {
+					log.Debug("temperature and humidity sensor readings channel closed",
+						"sensor", instance.Name)
+					return nil
+				}
+
+				now := time.Now()
+				samples := sensors.setSamples(instance.Name, calibration.Apply(measurement.FromAHT(labels.source(), reading, now)))
+				setAHTMetrics(labels, samples)
+				reading, accepted := filteredReading(reading, samples)
+				if !accepted {
+					log.Debug("not compensating gas sensors for a temperature and humidity reading the filter rejected",
+						"sensor", instance.Name)
+					continue
+				}
+				humidity := units.AbsoluteHumidity(reading.Temperature, reading.Humidity)
+				sensors.setTempHumidity(instance.Name, reading)
+				if len(gasSensors) > 0 && now.After(setHumidityAfter) {
+					setHumidityAfter = now.Add(10 * time.Second)
+
+					log.Debug("setting humidity on gas sensors",
+						"sensor", instance.Name,
+						"humidity", humidity,
+						"reading", reading)
+					for _, gasSensor := range gasSensors {
+						gasSensor.SetHumidity(ctx, humidity)
+					}
+				}
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// filteredReading returns the reading with the filtered temperature and relative humidity, or false if the filter
+// rejected either, so that a glitch does not skew the humidity compensation of the gas sensors
+func filteredReading(reading *aht20.Reading, samples []measurement.Sample) (*aht20.Reading, bool) {
+	filtered := *reading
+	found := 0
+	for _, sample := range samples {
+		switch sample.Measurement {
+		case "temperature":
+			filtered.Temperature = units.Celsius(sample.Value)
+			found++
+		case "relative_humidity":
+			filtered.Humidity = units.RelativeHumidity(sample.Value)
+			found++
+		}
+	}
+	return &filtered, found == 2
+}
+
+func runGasSensor(ctx context.Context, instance SensorSettings, sensor *sgp30.Sensor, keeper *baselineKeeper, sensors *tracker) func() error {
+	return func() error {
+		labels := instance.labels()
+		info := instance.deviceInfo()
+		acclimation := newAcclimationProgress(keeper)
+		for {
+			select {
+			case sgpInfo, ok := <-sensor.Infos():
+				if !ok {
+					log.Debug("gas sensor info channel closed",
+						"sensor", instance.Name)
+					return nil
+				}
+
+				deleteSensorInfo(labels, info)
+				serial := sgp30.FormatSerial(sgpInfo.Serial)
+				if serial != labels.Serial {
+					deleteSGPMetrics(labels)
+					labels.Serial = serial
+				}
+				sensors.setSerial(instance.Name, sgpInfo.Serial)
+				acclimation.identify(sgpInfo.Serial)
+				info.Firmware = fmt.Sprintf("0x%04x", sgpInfo.FeatureSet)
+				setSensorInfo(labels, info)
+				sensors.setFirmware(instance.Name, info.Firmware)
+			case reading, ok := <-sensor.AirQualityReadings():
+				if !ok {
+					log.Debug("gas sensor air quality readings channel closed",
+						"sensor", instance.Name)
+					return nil
+				}
+
+				now := time.Now()
+				sensors.setAirQuality(instance.Name, reading)
+				setSGPAirQualityMetrics(labels, reading, sensors.setSamples(instance.Name, measurement.FromSGPAirQuality(labels.source(), reading, now)))
+				acclimation.observe(reading, now)
+			case reading, ok := <-sensor.RawReadings():
+				if !ok {
+					log.Debug("gas sensor raw readings channel closed",
+						"sensor", instance.Name)
+					return nil
+				}
+
+				setSGPRawMetrics(labels, sensors.setSamples(instance.Name, measurement.FromSGPRaw(labels.source(), reading, time.Now())))
+			case baseline, ok := <-sensor.BaselineReadings():
+				if !ok {
+					log.Debug("gas sensor baseline readings channel closed",
+						"sensor", instance.Name)
+					return nil
+				}
+
+				keeper.tryRecord(instance, labels, baseline, sensors.conditions(instance))
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
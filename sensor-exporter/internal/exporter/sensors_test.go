@@ -0,0 +1,195 @@
+package exporter
+
+import (
+	"fmt"
+	"sensor-exporter/aht20"
+	"sensor-exporter/internal/filter"
+	"sensor-exporter/internal/measurement"
+	"sensor-exporter/internal/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSensorInstancesRejectsSharedAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		sensors []SensorSettings
+		err     string
+	}{
+		{
+			name: "different addresses",
+			sensors: []SensorSettings{
+				{Name: "a", Model: models.AHT20, Bus: 1, Address: 0x38},
+				{Name: "b", Model: models.SGP30, Bus: 1, Address: 0x58},
+			},
+		},
+		{
+			name: "same address on different buses",
+			sensors: []SensorSettings{
+				{Name: "a", Model: models.AHT20, Bus: 1},
+				{Name: "b", Model: models.AHT20, Bus: 2},
+			},
+		},
+		{
+			name: "same address on different multiplexer channels",
+			sensors: []SensorSettings{
+				{Name: "a", Model: models.AHT20, Bus: 1, Mux: &MuxSettings{Address: 0x70, Channel: 0}},
+				{Name: "b", Model: models.AHT20, Bus: 1, Mux: &MuxSettings{Address: 0x70, Channel: 1}},
+			},
+		},
+		{
+			name: "same address behind a multiplexer and on the bus",
+			sensors: []SensorSettings{
+				{Name: "a", Model: models.AHT20, Bus: 1, Mux: &MuxSettings{Address: 0x70, Channel: 0}},
+				{Name: "b", Model: models.AHT20, Bus: 1},
+			},
+		},
+		{
+			name: "same address on the bus",
+			sensors: []SensorSettings{
+				{Name: "a", Model: models.AHT20, Bus: 1},
+				{Name: "b", Model: models.AHT20, Bus: 1, Address: DefaultAHT20I2CAddr},
+			},
+			err: "failed to configure sensor b at i2c-1/0x38; sensor a is configured at the same address",
+		},
+		{
+			name: "same multiplexer channel",
+			sensors: []SensorSettings{
+				{Name: "a", Model: models.SGP30, Bus: 1, Mux: &MuxSettings{Address: 0x70, Channel: 3}},
+				{Name: "b", Model: models.SGP30, Bus: 1, Mux: &MuxSettings{Address: 0x70, Channel: 3}},
+			},
+			err: "failed to configure sensor b at i2c-1/0x70:3/0x58; sensor a is configured at the same address",
+		},
+		{
+			name: "particulate sensors on one port",
+			sensors: []SensorSettings{
+				{Name: "a", Model: models.PMS5003},
+				{Name: "b", Model: models.PMS5003, Port: "other"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := &Settings{Sensors: test.sensors}
+			_, err := settings.SensorInstances()
+			if test.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestFilteredReading(t *testing.T) {
+	low, high := -40.0, 85.0
+	rules := map[string]map[string]filter.Settings{
+		"room": {
+			"temperature":       {Min: &low, Max: &high},
+			"relative_humidity": {EMA: 0.5},
+		},
+	}
+	tests := []struct {
+		name     string
+		readings []aht20.Reading
+		// Filtered temperature and relative humidity of the last reading, or empty if it is rejected
+		want string
+	}{
+		{
+			name:     "accepted",
+			readings: []aht20.Reading{{Temperature: 21.5, Humidity: 40}},
+			want:     "21.5 40",
+		},
+		{
+			name:     "filtered values",
+			readings: []aht20.Reading{{Temperature: 21.5, Humidity: 40}, {Temperature: 22, Humidity: 50}},
+			want:     "22 45",
+		},
+		{
+			name:     "temperature spike rejected",
+			readings: []aht20.Reading{{Temperature: 21.5, Humidity: 40}, {Temperature: 150, Humidity: 40}},
+			want:     "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := filter.NewFilter(rules)
+			source := measurement.Source{Sensor: "room", Model: "AHT20"}
+			now := time.Now()
+			var reading *aht20.Reading
+			accepted := false
+			for i := range test.readings {
+				samples := f.Apply(measurement.FromAHT(source, &test.readings[i], now.Add(time.Duration(i)*time.Second)))
+				reading, accepted = filteredReading(&test.readings[i], samples)
+			}
+
+			got := ""
+			if accepted {
+				got = fmt.Sprintf("%v %v", reading.Temperature, reading.Humidity)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSetAHTMetrics(t *testing.T) {
+	low, high := -40.0, 85.0
+	settings := map[string]filter.Settings{
+		"temperature":       {Min: &low, Max: &high},
+		"relative_humidity": {EMA: 0.5},
+	}
+	tests := []struct {
+		name     string
+		readings []aht20.Reading
+		// Temperature and relative humidity gauges after the last reading
+		want string
+	}{
+		{
+			name:     "accepted",
+			readings: []aht20.Reading{{Temperature: 21.5, Humidity: 0.4}},
+			want:     "21.5 0.4",
+		},
+		{
+			name:     "filtered values",
+			readings: []aht20.Reading{{Temperature: 21.5, Humidity: 0.4}, {Temperature: 22, Humidity: 0.5}},
+			want:     "22 0.45",
+		},
+		{
+			name:     "temperature spike rejected",
+			readings: []aht20.Reading{{Temperature: 21.5, Humidity: 0.4}, {Temperature: 150, Humidity: 0.4}},
+			want:     "21.5 0.4",
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// each test has its own sensor, so that it starts without gauges
+			labels := sensorLabels{Sensor: fmt.Sprintf("room-%v", i), Model: "AHT20"}
+			sensors := newTracker(filter.NewFilter(map[string]map[string]filter.Settings{labels.Sensor: settings}))
+			now := time.Now()
+			for i := range test.readings {
+				samples := measurement.FromAHT(labels.source(), &test.readings[i], now.Add(time.Duration(i)*time.Second))
+				setAHTMetrics(labels, sensors.setSamples(labels.Sensor, samples))
+			}
+
+			got := fmt.Sprintf("%v %v",
+				testutil.ToFloat64(aht_temperature_celsius.WithLabelValues(labels.values()...)),
+				testutil.ToFloat64(aht_relative_humidity_ratio.WithLabelValues(labels.values()...)))
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			readings := testutil.ToFloat64(aht_readings_total.WithLabelValues(labels.values()...))
+			if readings != float64(len(test.readings)) {
+				t.Errorf("got %v readings, want %v", readings, len(test.readings))
+			}
+		})
+	}
+}
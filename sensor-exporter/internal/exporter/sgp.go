@@ -1,6 +1,7 @@
 package exporter
 
 import (
+	"sensor-exporter/internal/measurement"
 	"sensor-exporter/sgp30"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -8,37 +9,97 @@ import (
 )
 
 var (
-	sgp_received_packets = promauto.NewCounter(
+	sgp_readings_total = promauto.With(registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sgp_readings_total",
+			Help: "Number of readings received from the sensor by type of signal",
+		},
+		withSensorLabels("signal"),
+	)
+	sgp_h2_raw_signal = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sgp_h2_raw_signal",
+			Help: "Raw sensor signal for diatomic hydrogen (H2)",
+		},
+		withSensorLabels(),
+	)
+	sgp_ethanol_raw_signal = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sgp_ethanol_raw_signal",
+			Help: "Raw sensor signal for ethanol",
+		},
+		withSensorLabels(),
+	)
+	sgp_acclimation_remaining_seconds = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sgp_acclimation_remaining_seconds",
+			Help: "Number of seconds until the sensor is acclimated to its environment and can be considered to produce valid eCO2 and tVOC readings",
+		},
+		withSensorLabels(),
+	)
+	sgp_reading_valid = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sgp_reading_valid",
+			Help: "Whether the most recent eCO2 and tVOC readings can be considered valid (1) or not (0)",
+		},
+		withSensorLabels(),
+	)
+	sgp_tvoc_parts_per_billion = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sgp_tvoc_parts_per_billion",
+			Help: "Concentration of total volatile organic compounds (VOC) in parts per billion",
+		},
+		withSensorLabels(),
+	)
+	sgp_eco2_parts_per_million = promauto.With(registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sgp_eco2_parts_per_million",
+			Help: "Concentration of equivalent carbon dioxide (CO2) in parts per million",
+		},
+		withSensorLabels(),
+	)
+	sgp_baseline_rejections_total = promauto.With(registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sgp_baseline_rejections_total",
+			Help: "Number of baselines read from the sensor that the baseline policy refused to store because they deviated suspiciously",
+		},
+		withSensorLabels(),
+	)
+)
+
+// v1 metrics, only registered when v1 compatibility is enabled
+var (
+	sgp_received_packets = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "sgp_received_packets",
 		},
 	)
-	sgp_h2_ppm = promauto.NewGauge(
+	sgp_h2_ppm = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "sgp_h2_ppm",
 			Help: "Concentration of diatomic hydrogen (H2) in parts per million",
 		},
 	)
-	sgp_ethanol_ppm = promauto.NewGauge(
+	sgp_ethanol_ppm = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "sgp_ethanol_ppm",
 			Help: "Concentration of ethanol in parts per million",
 		},
 	)
-	sgp_seconds_until_acclimated = promauto.NewGauge(
+	sgp_seconds_until_acclimated = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "sgp_seconds_until_acclimated",
 			Help: "Number of seconds until the sensor is acclimated to its environment and can be considered to produce valid eCO2 and tVOC readings",
 		},
 	)
-	sgp_tvoc_ppb = promauto.NewGaugeVec(
+	sgp_tvoc_ppb = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "sgp_tvoc_ppb",
 			Help: "Concentration of total volatile organic compounds (VOC) in parts per billion",
 		},
 		[]string{"valid"},
 	)
-	sgp_eco2_ppm = promauto.NewGaugeVec(
+	sgp_eco2_ppm = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "sgp_eco2_ppm",
 			Help: "Concentration of equivalent carbon dioxide (CO2) in parts per million",
@@ -47,8 +108,15 @@ var (
 	)
 )
 
-func setSGPAirQualityMetrics(reading *sgp30.AirQualityReading) {
+// setSGPAirQualityMetrics counts an air quality reading, sets its validity and acclimation, and sets the gauges of the
+// samples that passed the filter, leaving the gauges of rejected samples at their last accepted value
+func setSGPAirQualityMetrics(labels sensorLabels, reading *sgp30.AirQualityReading, samples []measurement.Sample) {
+	sgp_readings_total.WithLabelValues(labels.values("air_quality")...).Inc()
+	sgp_reading_valid.WithLabelValues(labels.values()...).Set(boolToFloat(reading.IsValid))
+	sgp_acclimation_remaining_seconds.WithLabelValues(labels.values()...).Set(reading.DurationUntilValid.Seconds())
+
 	sgp_received_packets.Inc()
+	sgp_seconds_until_acclimated.Set(reading.DurationUntilValid.Seconds())
 
 	var label string
 	if reading.IsValid {
@@ -56,13 +124,53 @@ func setSGPAirQualityMetrics(reading *sgp30.AirQualityReading) {
 	} else {
 		label = "invalid"
 	}
-	sgp_eco2_ppm.WithLabelValues(label).Set(float64(reading.EquivalentCO2))
-	sgp_tvoc_ppb.WithLabelValues(label).Set(float64(reading.TotalVOC))
-	sgp_seconds_until_acclimated.Set(reading.DurationUntilValid.Seconds())
+	for _, sample := range samples {
+		switch sample.Measurement {
+		case "eco2":
+			sgp_eco2_parts_per_million.WithLabelValues(labels.values()...).Set(sample.Value)
+			sgp_eco2_ppm.WithLabelValues(label).Set(sample.Value)
+		case "tvoc":
+			sgp_tvoc_parts_per_billion.WithLabelValues(labels.values()...).Set(sample.Value)
+			sgp_tvoc_ppb.WithLabelValues(label).Set(sample.Value)
+		}
+	}
 }
 
-func setSGPRawMetrics(reading *sgp30.RawReading) {
+// setSGPRawMetrics counts a raw signal reading and sets the gauges of the samples that passed the filter
+func setSGPRawMetrics(labels sensorLabels, samples []measurement.Sample) {
+	sgp_readings_total.WithLabelValues(labels.values("raw")...).Inc()
 	sgp_received_packets.Inc()
-	sgp_h2_ppm.Set(float64(reading.H2))
-	sgp_ethanol_ppm.Set(float64(reading.Ethanol))
+
+	for _, sample := range samples {
+		switch sample.Measurement {
+		case "h2_raw":
+			sgp_h2_raw_signal.WithLabelValues(labels.values()...).Set(sample.Value)
+			sgp_h2_ppm.Set(sample.Value)
+		case "ethanol_raw":
+			sgp_ethanol_raw_signal.WithLabelValues(labels.values()...).Set(sample.Value)
+			sgp_ethanol_ppm.Set(sample.Value)
+		}
+	}
+}
+
+func incSGPBaselineRejections(labels sensorLabels) {
+	sgp_baseline_rejections_total.WithLabelValues(labels.values()...).Inc()
+}
+
+// deleteSGPMetrics removes the series of a sensor that is no longer connected, such as after the sensor has been swapped
+func deleteSGPMetrics(labels sensorLabels) {
+	for _, signal := range []string{"air_quality", "raw"} {
+		sgp_readings_total.DeleteLabelValues(labels.values(signal)...)
+	}
+	sgp_baseline_rejections_total.DeleteLabelValues(labels.values()...)
+	for _, gauge := range []*prometheus.GaugeVec{
+		sgp_h2_raw_signal,
+		sgp_ethanol_raw_signal,
+		sgp_acclimation_remaining_seconds,
+		sgp_reading_valid,
+		sgp_tvoc_parts_per_billion,
+		sgp_eco2_parts_per_million,
+	} {
+		gauge.DeleteLabelValues(labels.values()...)
+	}
 }
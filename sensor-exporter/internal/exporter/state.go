@@ -0,0 +1,112 @@
+package exporter
+
+import (
+	"sensor-exporter/clock"
+	"sensor-exporter/internal/models"
+	"sensor-exporter/internal/state"
+	"sensor-exporter/sgp30"
+	"time"
+
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+const (
+	// fanRunTimeSaveInterval is how often the fan run time of a particulate sensor is written to the state file
+	fanRunTimeSaveInterval = 10 * time.Minute
+	// maxFanRunTimeGap is the longest gap between readings that is still counted as the fan running
+	maxFanRunTimeGap = 10 * time.Second
+)
+
+// OpenState opens the state store, migrating the baseline files of the configured SGP30 sensors into it if the state
+// file does not exist yet
+func OpenState(settings *Settings, instances []SensorSettings) (*state.Store, error) {
+	legacyPaths := []string{}
+	for _, instance := range instances {
+		if instance.Model == models.SGP30 {
+			legacyPaths = append(legacyPaths, instance.BaselineFile)
+		}
+	}
+	return state.Open(settings.StateFile, legacyPaths...)
+}
+
+// BaselineKey returns the key of the state of the SGP30 sensor with the given serial
+func BaselineKey(serial []uint16) string {
+	return state.SerialKey(models.SGP30, sgp30.FormatSerial(serial))
+}
+
+// StoredBaseline returns a lookup of the baselines of SGP30 sensors held in the state store. Acclimation is only resumed
+// from a provisional baseline once the clock is synchronized, since it depends on how long the sensor was off.
+func StoredBaseline(store *state.Store, clockGuard *clock.Guard) sgp30.BaselineLookup {
+	return func(serial []uint16) *sgp30.BaselineReading {
+		sensor := store.Sensor(BaselineKey(serial))
+		if sensor == nil || sensor.Baseline == nil {
+			return nil
+		}
+		if sensor.Baseline.Provisional {
+			if !clockGuard.Synchronized() {
+				log.Warn("failed to resume acclimation since the clock is not synchronized; sensor will acclimate from scratch",
+					"baseline", sensor.Baseline)
+				return nil
+			}
+			return resumeAcclimation(sensor, time.Now())
+		}
+
+		log.Info("initializing sensor with stored baseline",
+			"path", store.Path(),
+			"initialBaseline", sensor.Baseline)
+		return sensor.Baseline
+	}
+}
+
+// fanRunTime accumulates the time for which the fan of a particulate sensor runs, judged by readings arriving
+type fanRunTime struct {
+	store       *state.Store
+	key         string
+	total       time.Duration
+	lastReading time.Time
+	lastSaved   time.Time
+}
+
+func newFanRunTime(store *state.Store, instance SensorSettings) *fanRunTime {
+	key := state.InstanceKey(models.PMS5003, instance.Name)
+	total := time.Duration(0)
+	if sensor := store.Sensor(key); sensor != nil {
+		total = sensor.FanRunTime
+	}
+	return &fanRunTime{
+		store:     store,
+		key:       key,
+		total:     total,
+		lastSaved: time.Now(),
+	}
+}
+
+// observe counts the time since the previous reading as run time and returns the added duration
+func (f *fanRunTime) observe(now time.Time) time.Duration {
+	added := time.Duration(0)
+	if !f.lastReading.IsZero() && now.Sub(f.lastReading) <= maxFanRunTimeGap {
+		added = now.Sub(f.lastReading)
+	}
+	f.lastReading = now
+	f.total += added
+
+	if now.Sub(f.lastSaved) >= fanRunTimeSaveInterval {
+		f.save(now)
+	}
+	return added
+}
+
+func (f *fanRunTime) save(now time.Time) {
+	f.lastSaved = now
+	err := f.store.Update(f.key, func(sensor *state.Sensor) error {
+		sensor.Model = "PMS5003"
+		sensor.FanRunTime = f.total
+		return nil
+	})
+	if err != nil {
+		log.Error("failed to store fan run time",
+			"err", err,
+			"key", f.key,
+			"path", f.store.Path())
+	}
+}
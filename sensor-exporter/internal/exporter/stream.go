@@ -0,0 +1,159 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sensor-exporter/internal/measurement"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+const (
+	// streamBuffer is the number of batches of samples a stream buffers before dropping samples for a slow client
+	streamBuffer = 64
+	// streamKeepAlive is how often a stream sends a keep-alive so that idle connections are not closed by proxies
+	streamKeepAlive = 15 * time.Second
+	// streamWriteTimeout is how long a websocket client may take to accept a message before the stream is closed
+	streamWriteTimeout = 10 * time.Second
+)
+
+var upgrader = websocket.Upgrader{}
+
+// serveStream streams samples as server-sent events, or as websocket messages if the client requests an upgrade. The
+// stream starts with the latest sample of each selected measurement and continues with samples as they are read.
+func (a *api) serveStream(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	_, ok := a.selectInstances(w, query["sensor"])
+	if !ok {
+		return
+	}
+	filter := sampleFilter{
+		Sensors:      query["sensor"],
+		Measurements: query["measurement"],
+	}
+
+	subscription := a.sensors.samples.subscribe("stream", filter, streamBuffer)
+	defer a.sensors.samples.unsubscribe(subscription)
+	latest := a.sensors.latestSamples(filter)
+
+	if websocket.IsWebSocketUpgrade(r) {
+		a.streamWebSocket(w, r, latest, subscription)
+		return
+	}
+	a.streamEvents(w, r, latest, subscription)
+}
+
+func (a *api) streamEvents(w http.ResponseWriter, r *http.Request, latest []measurement.Sample, subscription *subscription) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("failed to stream; response cannot be flushed"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	err := writeEvents(w, latest)
+	for err == nil {
+		flusher.Flush()
+		select {
+		case samples := <-subscription.samples:
+			err = writeEvents(w, samples)
+		case <-keepAlive.C:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-a.ctx.Done():
+			return
+		}
+	}
+	log.Debug("failed to write event stream",
+		"err", err,
+		"remoteAddr", r.RemoteAddr)
+}
+
+func writeEvents(w io.Writer, samples []measurement.Sample) error {
+	for _, sample := range samples {
+		data, err := json.Marshal(sample)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode sample")
+		}
+		_, err = fmt.Fprintf(w, "event: sample\ndata: %s\n\n", data)
+		if err != nil {
+			return errors.Wrap(err, "failed to write event")
+		}
+	}
+	return nil
+}
+
+func (a *api) streamWebSocket(w http.ResponseWriter, r *http.Request, latest []measurement.Sample, subscription *subscription) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Debug("failed to upgrade stream to websocket",
+			"err", err,
+			"remoteAddr", r.RemoteAddr)
+		return
+	}
+	defer conn.Close()
+
+	// Control messages such as a close from the client are only handled while reading
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_, _, err := conn.NextReader()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	err = writeMessages(conn, latest)
+	for err == nil {
+		select {
+		case samples := <-subscription.samples:
+			err = writeMessages(conn, samples)
+		case <-keepAlive.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout))
+		case <-closed:
+			return
+		case <-a.ctx.Done():
+			message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "exporter stopping")
+			_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(streamWriteTimeout))
+			return
+		}
+	}
+	log.Debug("failed to write websocket stream",
+		"err", err,
+		"remoteAddr", r.RemoteAddr)
+}
+
+func writeMessages(conn *websocket.Conn, samples []measurement.Sample) error {
+	for _, sample := range samples {
+		err := conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if err != nil {
+			return errors.Wrap(err, "failed to set write deadline")
+		}
+		err = conn.WriteJSON(sample)
+		if err != nil {
+			return errors.Wrap(err, "failed to write message")
+		}
+	}
+	return nil
+}
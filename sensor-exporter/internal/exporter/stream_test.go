@@ -0,0 +1,219 @@
+package exporter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sensor-exporter/internal/measurement"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// streamReader reads the samples of a stream one at a time
+type streamReader interface {
+	next() (measurement.Sample, error)
+}
+
+type eventReader struct {
+	scanner *bufio.Scanner
+}
+
+func (r *eventReader) next() (measurement.Sample, error) {
+	sample := measurement.Sample{}
+	event := ""
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && event == "sample":
+			return sample, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &sample)
+		}
+	}
+	if r.scanner.Err() != nil {
+		return sample, r.scanner.Err()
+	}
+	return sample, fmt.Errorf("event stream ended")
+}
+
+type messageReader struct {
+	conn *websocket.Conn
+}
+
+func (r *messageReader) next() (measurement.Sample, error) {
+	sample := measurement.Sample{}
+	err := r.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err != nil {
+		return sample, err
+	}
+	return sample, r.conn.ReadJSON(&sample)
+}
+
+// openStream connects to the stream of the server with the given query, as server-sent events or over a websocket
+func openStream(t *testing.T, server *httptest.Server, query string, useWebSocket bool) streamReader {
+	t.Helper()
+	if useWebSocket {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/stream" + query
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("failed to connect websocket: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return &messageReader{conn}
+	}
+
+	response, err := http.Get(server.URL + "/api/v1/stream" + query)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { response.Body.Close() })
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("got status %v, want %v", response.StatusCode, http.StatusOK)
+	}
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("got content type %v, want text/event-stream", contentType)
+	}
+	return &eventReader{bufio.NewScanner(response.Body)}
+}
+
+func TestStream(t *testing.T) {
+	sample := func(sensor, name string, value float64) measurement.Sample {
+		return measurement.Sample{
+			Source:      measurement.Source{Sensor: sensor, Model: strings.ToUpper(sensor)},
+			Measurement: name,
+			Value:       value,
+			Valid:       true,
+			Time:        time.Now(),
+		}
+	}
+	tests := []struct {
+		name  string
+		query string
+		// Samples published after connecting, one batch at a time
+		published [][]measurement.Sample
+		// Samples expected on the stream, starting with the latest ones, as sensor/measurement=value
+		want []string
+	}{
+		{
+			name:  "latest samples then new ones",
+			query: "",
+			published: [][]measurement.Sample{
+				{sample("aht20", "temperature", 22)},
+				{sample("sgp30", "eco2", 450), sample("sgp30", "tvoc", 12)},
+			},
+			want: []string{"aht20/temperature=21.5", "aht20/temperature=22", "sgp30/eco2=450", "sgp30/tvoc=12"},
+		},
+		{
+			name:  "filtered by sensor",
+			query: "?sensor=sgp30",
+			published: [][]measurement.Sample{
+				{sample("aht20", "temperature", 22)},
+				{sample("sgp30", "eco2", 450)},
+			},
+			want: []string{"sgp30/eco2=450"},
+		},
+		{
+			name:  "filtered by measurement",
+			query: "?measurement=tvoc&measurement=relative_humidity",
+			published: [][]measurement.Sample{
+				{sample("aht20", "temperature", 22), sample("aht20", "relative_humidity", 0.4)},
+				{sample("sgp30", "eco2", 450), sample("sgp30", "tvoc", 12)},
+			},
+			want: []string{"aht20/relative_humidity=0.4", "sgp30/tvoc=12"},
+		},
+	}
+
+	for _, useWebSocket := range []bool{false, true} {
+		for _, test := range tests {
+			name := test.name + " as server-sent events"
+			if useWebSocket {
+				name = test.name + " over a websocket"
+			}
+			t.Run(name, func(t *testing.T) {
+				ctx, cancel := context.WithCancel(context.Background())
+				api := newTestAPI(t, ctx, false)
+				server := httptest.NewServer(api)
+				// streams only end with the exporter, so it stops before the server waits for them
+				defer server.Close()
+				defer cancel()
+
+				stream := openStream(t, server, test.query, useWebSocket)
+				// the stream subscribes before the response starts, so samples published from here on are delivered
+				for _, batch := range test.published {
+					api.sensors.setSamples(batch[0].Sensor, batch)
+				}
+
+				got := []string{}
+				for range test.want {
+					sample, err := stream.next()
+					if err != nil {
+						t.Fatalf("failed to read sample after %v: %v", got, err)
+					}
+					got = append(got, fmt.Sprintf("%v/%v=%v", sample.Sensor, sample.Measurement, sample.Value))
+				}
+				if strings.Join(got, ",") != strings.Join(test.want, ",") {
+					t.Errorf("got samples %v, want %v", got, test.want)
+				}
+			})
+		}
+	}
+}
+
+func TestStreamUnknownSensor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api := newTestAPI(t, ctx, false)
+
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/stream?sensor=pms5003", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("got status %v, want %v", recorder.Code, http.StatusNotFound)
+	}
+	if len(api.sensors.samples.subscribers) != 0 {
+		t.Errorf("got %v subscribers, want none for a refused stream", len(api.sensors.samples.subscribers))
+	}
+}
+
+func TestStreamStopsWithExporter(t *testing.T) {
+	for _, useWebSocket := range []bool{false, true} {
+		t.Run(fmt.Sprintf("websocket %v", useWebSocket), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			api := newTestAPI(t, ctx, false)
+			server := httptest.NewServer(api)
+			defer server.Close()
+			defer cancel()
+
+			stream := openStream(t, server, "?sensor=sgp30", useWebSocket)
+			cancel()
+
+			_, err := stream.next()
+			if err == nil {
+				t.Fatalf("got a sample, want the stream to end")
+			}
+			if closeErr, ok := err.(*websocket.CloseError); useWebSocket && (!ok || closeErr.Code != websocket.CloseGoingAway) {
+				t.Errorf("got error %v, want a going away close", err)
+			}
+
+			// the stream unsubscribes once it ends
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				api.sensors.samples.mu.Lock()
+				subscribers := len(api.sensors.samples.subscribers)
+				api.sensors.samples.mu.Unlock()
+				if subscribers == 0 {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("got %v subscribers after the stream ended, want none", subscribers)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package exporter
+
+import (
+	"sensor-exporter/aht20"
+	"sensor-exporter/internal/filter"
+	"sensor-exporter/internal/measurement"
+	"sensor-exporter/internal/state"
+	"sensor-exporter/reconnect"
+	"sensor-exporter/sgp30"
+	"sensor-exporter/units"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// tracker holds the latest information received from each sensor so that it can be used across sensors and by the API
+type tracker struct {
+	mu      sync.Mutex
+	sensors map[string]*trackedSensor
+	// Fans the samples out as they are recorded
+	samples *hub
+	// Filters the samples before they are recorded
+	filter *filter.Filter
+}
+
+type trackedSensor struct {
+	gasSensor     *sgp30.Sensor
+	serial        []uint16
+	firmware      string
+	tempHumidity  *aht20.Reading
+	airQuality    *sgp30.AirQualityReading
+	samples       map[string]measurement.Sample
+	status        reconnect.Status
+	lastReadingAt time.Time
+}
+
+func newTracker(filter *filter.Filter) *tracker {
+	return &tracker{
+		sensors: map[string]*trackedSensor{},
+		samples: newHub(),
+		filter:  filter,
+	}
+}
+
+func (t *tracker) sensor(name string) *trackedSensor {
+	sensor, ok := t.sensors[name]
+	if !ok {
+		sensor = &trackedSensor{}
+		t.sensors[name] = sensor
+	}
+	return sensor
+}
+
+func (t *tracker) setGasSensor(name string, sensor *sgp30.Sensor) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sensor(name).gasSensor = sensor
+}
+
+// gasSensor returns the driver of a gas sensor and the serial it last reported, which is nil if it has not reported one
+func (t *tracker) gasSensor(name string) (*sgp30.Sensor, []uint16) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sensor := t.sensor(name)
+	return sensor.gasSensor, sensor.serial
+}
+
+// setSerial records the serial a sensor reported, dropping the samples of a sensor that was swapped
+func (t *tracker) setSerial(name string, serial []uint16) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sensor := t.sensor(name)
+	if !slices.Equal(sensor.serial, serial) {
+		sensor.samples = nil
+		sensor.airQuality = nil
+	}
+	sensor.serial = serial
+}
+
+func (t *tracker) setFirmware(name string, firmware string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sensor(name).firmware = firmware
+}
+
+func (t *tracker) setTempHumidity(name string, reading *aht20.Reading) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sensor(name).tempHumidity = reading
+}
+
+func (t *tracker) setAirQuality(name string, reading *sgp30.AirQualityReading) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sensor(name).airQuality = reading
+}
+
+// setSamples filters the samples of a sensor, records them as its latest samples, replacing earlier samples of the same
+// measurements, and publishes them to the subscribers of the tracker. It returns the samples that passed the filter.
+func (t *tracker) setSamples(name string, samples []measurement.Sample) []measurement.Sample {
+	samples = t.filter.Apply(samples)
+	if len(samples) == 0 {
+		return samples
+	}
+	t.recordSamples(name, samples)
+	t.samples.publish(samples)
+	return samples
+}
+
+func (t *tracker) recordSamples(name string, samples []measurement.Sample) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sensor := t.sensor(name)
+	if sensor.samples == nil {
+		sensor.samples = map[string]measurement.Sample{}
+	}
+	for _, sample := range samples {
+		sensor.samples[sample.Measurement] = sample
+		if sample.Time.After(sensor.lastReadingAt) {
+			sensor.lastReadingAt = sample.Time
+		}
+	}
+}
+
+// latestSamples returns the latest sample of each measurement that matches the filter, ordered by sensor and measurement
+func (t *tracker) latestSamples(filter sampleFilter) []measurement.Sample {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	samples := []measurement.Sample{}
+	for _, sensor := range t.sensors {
+		samples = append(samples, filter.apply(maps.Values(sensor.samples))...)
+	}
+	slices.SortFunc(samples, func(a, b measurement.Sample) bool {
+		if a.Sensor != b.Sensor {
+			return a.Sensor < b.Sensor
+		}
+		return a.Measurement < b.Measurement
+	})
+	return samples
+}
+
+// connected returns whether a sensor is connected
+func (t *tracker) connected(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sensor(name).status.Connected
+}
+
+// observeReconnects returns reconnect settings that report the connection status of a sensor to the tracker
+func (t *tracker) observeReconnects(name string, settings reconnect.Settings) reconnect.Settings {
+	settings.OnStatus = func(status reconnect.Status) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.sensor(name).status = status
+	}
+	return settings
+}
+
+// conditions describes the environment of a gas sensor from its latest readings and those of its humidity sensor
+func (t *tracker) conditions(instance SensorSettings) state.Conditions {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conditions := state.Conditions{}
+	if reading := t.sensor(instance.Name).airQuality; reading != nil && reading.IsValid {
+		conditions.EquivalentCO2PartsPerMillion = float64Ptr(float64(reading.EquivalentCO2))
+		conditions.TotalVOCPartsPerBillion = float64Ptr(float64(reading.TotalVOC))
+	}
+	if instance.HumiditySensor == "" {
+		return conditions
+	}
+	if reading := t.sensor(instance.HumiditySensor).tempHumidity; reading != nil {
+		conditions.TemperatureCelsius = float64Ptr(float64(reading.Temperature))
+		conditions.RelativeHumidityRatio = float64Ptr(float64(reading.Humidity))
+		conditions.AbsoluteHumidityGramsPerCubicMeter = float64Ptr(float64(units.AbsoluteHumidity(reading.Temperature, reading.Humidity)))
+	}
+	return conditions
+}
+
+func float64Ptr(value float64) *float64 {
+	return &value
+}
@@ -0,0 +1,114 @@
+// Package filter smooths the samples of a measurement and rejects glitches, such as a single implausible frame with a
+// valid checksum, before they reach the metrics and outputs
+package filter
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Reasons a sample is rejected, used as the reason label of the rejection counter
+const (
+	ReasonBelowRange = "below_range"
+	ReasonAboveRange = "above_range"
+	ReasonRate       = "rate"
+)
+
+// staleAfter is how long a chain may go without samples before its state is discarded, so that readings after a
+// reconnect are not compared with or smoothed into readings from before it
+const staleAfter = time.Minute
+
+// Settings configure the filter chain of a measurement. Each step is disabled by its zero value.
+type Settings struct {
+	// Lowest and highest physically possible value; samples outside the range are rejected
+	Min *float64 `mapstructure:"min"`
+	Max *float64 `mapstructure:"max"`
+	// Largest plausible change per second from the last accepted sample; faster changes are rejected. As the time
+	// since the last accepted sample grows, so does the change allowed, so a lasting step is accepted after a while.
+	MaxRate float64 `mapstructure:"max-rate"`
+	// Number of accepted samples whose median is taken
+	Median int `mapstructure:"median"`
+	// Smoothing factor of the exponential moving average of the medians, between 0 and 1 where 1 does not smooth
+	EMA float64 `mapstructure:"ema"`
+}
+
+// Validate returns an error if the settings cannot be applied
+func (s Settings) Validate() error {
+	if s.Min != nil && s.Max != nil && *s.Min > *s.Max {
+		return errors.Errorf("min %v is above max %v", *s.Min, *s.Max)
+	}
+	if s.MaxRate < 0 {
+		return errors.Errorf("max-rate %v is negative", s.MaxRate)
+	}
+	if s.Median < 0 {
+		return errors.Errorf("median %v is negative", s.Median)
+	}
+	if s.EMA < 0 || s.EMA > 1 {
+		return errors.Errorf("ema %v is not between 0 and 1", s.EMA)
+	}
+	return nil
+}
+
+// chain is the state of the filters of one measurement of a sensor
+type chain struct {
+	settings Settings
+
+	// Last accepted raw value and when it was acquired
+	last     float64
+	lastTime time.Time
+	// Accepted raw values whose median is taken, oldest first
+	window []float64
+	// Exponential moving average of the medians, valid once lastTime is set
+	average float64
+}
+
+// apply passes a raw value through the range check, rate limit, rolling median and moving average in that order,
+// returning the filtered value or the reason the value was rejected
+func (c *chain) apply(value float64, t time.Time) (float64, string) {
+	if c.settings.Min != nil && value < *c.settings.Min {
+		return 0, ReasonBelowRange
+	}
+	if c.settings.Max != nil && value > *c.settings.Max {
+		return 0, ReasonAboveRange
+	}
+
+	started := !c.lastTime.IsZero() && t.Sub(c.lastTime) <= staleAfter
+	if !started {
+		c.window = nil
+	}
+	if started && c.settings.MaxRate > 0 {
+		elapsed := math.Max(t.Sub(c.lastTime).Seconds(), 0)
+		if math.Abs(value-c.last) > c.settings.MaxRate*elapsed {
+			return 0, ReasonRate
+		}
+	}
+	c.last = value
+	c.lastTime = t
+
+	filtered := value
+	if c.settings.Median > 1 {
+		c.window = append(c.window, value)
+		if len(c.window) > c.settings.Median {
+			c.window = c.window[len(c.window)-c.settings.Median:]
+		}
+		filtered = median(c.window)
+	}
+	if c.settings.EMA > 0 && started {
+		filtered = c.average + c.settings.EMA*(filtered-c.average)
+	}
+	c.average = filtered
+	return filtered, ""
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[middle]
+	}
+	return (sorted[middle-1] + sorted[middle]) / 2
+}
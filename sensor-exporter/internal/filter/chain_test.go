@@ -0,0 +1,140 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChainApply(t *testing.T) {
+	low, high := 0.0, 1000.0
+	tests := []struct {
+		name     string
+		settings Settings
+		values   []float64
+		// Seconds between the samples; one second if nil
+		gaps []float64
+		// Filtered value or reason of the rejection of each sample
+		want []string
+	}{
+		{
+			name:   "no steps",
+			values: []float64{1, 100, -5},
+			want:   []string{"1", "100", "-5"},
+		},
+		{
+			name:     "range",
+			settings: Settings{Min: &low, Max: &high},
+			values:   []float64{0, -0.5, 1000, 1000.5},
+			want:     []string{"0", ReasonBelowRange, "1000", ReasonAboveRange},
+		},
+		{
+			name:     "rate",
+			settings: Settings{MaxRate: 10},
+			values:   []float64{20, 25, 100, 35, 20},
+			want:     []string{"20", "25", ReasonRate, "35", ReasonRate},
+			gaps:     []float64{0, 1, 1, 1, 1},
+		},
+		{
+			name:     "rate allows more change after a longer gap",
+			settings: Settings{MaxRate: 10},
+			values:   []float64{20, 100, 100},
+			gaps:     []float64{0, 1, 8},
+			want:     []string{"20", ReasonRate, "100"},
+		},
+		{
+			name:     "rate not applied after a stale gap",
+			settings: Settings{MaxRate: 1},
+			values:   []float64{20, 500},
+			gaps:     []float64{0, 61},
+			want:     []string{"20", "500"},
+		},
+		{
+			name:     "median",
+			settings: Settings{Median: 3},
+			values:   []float64{10, 1000, 12, 11, 13},
+			want:     []string{"10", "505", "12", "12", "12"},
+		},
+		{
+			name:     "median of an even window",
+			settings: Settings{Median: 4},
+			values:   []float64{1, 2, 3, 4, 5},
+			want:     []string{"1", "1.5", "2", "2.5", "3.5"},
+		},
+		{
+			name:     "median restarted after a stale gap",
+			settings: Settings{Median: 3},
+			values:   []float64{10, 10, 50},
+			gaps:     []float64{0, 1, 120},
+			want:     []string{"10", "10", "50"},
+		},
+		{
+			name:     "ema",
+			settings: Settings{EMA: 0.5},
+			values:   []float64{10, 20, 20, 0},
+			want:     []string{"10", "15", "17.5", "8.75"},
+		},
+		{
+			name:     "ema of 1 does not smooth",
+			settings: Settings{EMA: 1},
+			values:   []float64{10, 20},
+			want:     []string{"10", "20"},
+		},
+		{
+			name:     "rejected samples left out of the median and ema",
+			settings: Settings{Max: &high, Median: 3, EMA: 0.5},
+			values:   []float64{10, 5000, 20},
+			want:     []string{"10", ReasonAboveRange, "12.5"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &chain{settings: test.settings}
+			now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+			got := []string{}
+			for i, value := range test.values {
+				gap := 1.0
+				if test.gaps != nil {
+					gap = test.gaps[i]
+				}
+				now = now.Add(time.Duration(gap * float64(time.Second)))
+				filtered, reason := c.apply(value, now)
+				if reason != "" {
+					got = append(got, reason)
+				} else {
+					got = append(got, fmt.Sprint(filtered))
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	low, high := 10.0, 5.0
+	tests := []struct {
+		name     string
+		settings Settings
+		valid    bool
+	}{
+		{"no steps", Settings{}, true},
+		{"min above max", Settings{Min: &low, Max: &high}, false},
+		{"negative rate", Settings{MaxRate: -1}, false},
+		{"negative median", Settings{Median: -1}, false},
+		{"ema above 1", Settings{EMA: 1.5}, false},
+		{"every step", Settings{Min: &high, Max: &low, MaxRate: 1, Median: 5, EMA: 0.2}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.settings.Validate()
+			if (err == nil) != test.valid {
+				t.Errorf("got error %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
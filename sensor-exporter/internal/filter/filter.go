@@ -0,0 +1,70 @@
+package filter
+
+import (
+	"sensor-exporter/internal/measurement"
+	"sync"
+)
+
+// Filter applies the filter chain configured for each measurement of each sensor, keeping the state of every chain
+type Filter struct {
+	// Settings of the chains by sensor and measurement
+	rules map[string]map[string]Settings
+
+	mu     sync.Mutex
+	chains map[string]map[string]*chain
+}
+
+// NewFilter returns a filter applying the given settings by sensor name and measurement
+func NewFilter(rules map[string]map[string]Settings) *Filter {
+	return &Filter{
+		rules:  rules,
+		chains: map[string]map[string]*chain{},
+	}
+}
+
+// Apply filters the samples of a reading. Samples of measurements without a chain and invalid samples, such as those
+// of an acclimating SGP30, are passed through as they are. Filtered samples carry their raw value, and rejected samples
+// are left out and counted. The value of every sample passed through a chain is also exposed as it was before the chain,
+// so that rejected values stay visible.
+func (f *Filter) Apply(samples []measurement.Sample) []measurement.Sample {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	filtered := []measurement.Sample{}
+	for _, sample := range samples {
+		settings, ok := f.rules[sample.Sensor][sample.Measurement]
+		if !ok || !sample.Valid {
+			filtered = append(filtered, sample)
+			continue
+		}
+
+		rawValues.WithLabelValues(sample.Sensor, sample.Model, sample.Serial, sample.Measurement, sample.Unit).Set(sample.Value)
+		c := f.chain(sample.Sensor, sample.Measurement, settings)
+		value, reason := c.apply(sample.Value, sample.Time)
+		if reason != "" {
+			rejectedSamples.WithLabelValues(sample.Sensor, sample.Model, sample.Serial, sample.Measurement, reason).Inc()
+			continue
+		}
+
+		raw := sample.Value
+		sample.Raw = &raw
+		sample.Value = value
+		filteredValues.WithLabelValues(sample.Sensor, sample.Model, sample.Serial, sample.Measurement, sample.Unit).Set(value)
+		filtered = append(filtered, sample)
+	}
+	return filtered
+}
+
+func (f *Filter) chain(sensor string, name string, settings Settings) *chain {
+	chains, ok := f.chains[sensor]
+	if !ok {
+		chains = map[string]*chain{}
+		f.chains[sensor] = chains
+	}
+	c, ok := chains[name]
+	if !ok {
+		c = &chain{settings: settings}
+		chains[name] = c
+	}
+	return c
+}
@@ -0,0 +1,62 @@
+package filter
+
+import (
+	"sensor-exporter/internal/measurement"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestApply(t *testing.T) {
+	high := 1000.0
+	f := NewFilter(map[string]map[string]Settings{
+		"pms5003": {"pm2_5_environmental": {Max: &high}},
+	})
+	source := measurement.Source{Sensor: "pms5003", Model: "PMS5003"}
+	tests := []struct {
+		name        string
+		measurement string
+		value       float64
+		// Whether the sample passes the filter, and the gauges of the measurement afterwards
+		passed   bool
+		filtered float64
+		raw      float64
+	}{
+		{"accepted", "pm2_5_environmental", 12, true, 12, 12},
+		{"glitch rejected", "pm2_5_environmental", 1500, false, 12, 1500},
+		{"accepted after a glitch", "pm2_5_environmental", 14, true, 14, 14},
+		{"without a chain", "pm10_environmental", 20, true, 0, 0},
+	}
+
+	now := time.Now()
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sample := measurement.Sample{
+				Source:      source,
+				Measurement: test.measurement,
+				Value:       test.value,
+				Unit:        measurement.UnitMicrogramsPerCubicMeter,
+				Valid:       true,
+				Time:        now.Add(time.Duration(i) * time.Second),
+			}
+			samples := f.Apply([]measurement.Sample{sample})
+			if (len(samples) == 1) != test.passed {
+				t.Fatalf("got samples %+v, want passed %v", samples, test.passed)
+			}
+			if test.passed && samples[0].Value != test.value {
+				t.Errorf("got value %v, want %v", samples[0].Value, test.value)
+			}
+
+			labels := []string{source.Sensor, source.Model, source.Serial, test.measurement, sample.Unit}
+			filtered := testutil.ToFloat64(filteredValues.WithLabelValues(labels...))
+			if filtered != test.filtered {
+				t.Errorf("got filtered value %v, want %v", filtered, test.filtered)
+			}
+			raw := testutil.ToFloat64(rawValues.WithLabelValues(labels...))
+			if raw != test.raw {
+				t.Errorf("got raw value %v, want %v", raw, test.raw)
+			}
+		})
+	}
+}
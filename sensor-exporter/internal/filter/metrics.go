@@ -0,0 +1,36 @@
+package filter
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	rejectedSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sensor_filter_rejected_samples_total",
+			Help: "Number of samples rejected by the range check or rate limit of their measurement",
+		},
+		[]string{"sensor", "model", "serial", "measurement", "reason"},
+	)
+	filteredValues = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sensor_filtered_value",
+			Help: "Latest value of a measurement after its filter chain",
+		},
+		[]string{"sensor", "model", "serial", "measurement", "unit"},
+	)
+	rawValues = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sensor_raw_value",
+			Help: "Latest value of a filtered measurement before its filter chain, including values the chain rejected",
+		},
+		[]string{"sensor", "model", "serial", "measurement", "unit"},
+	)
+)
+
+// Collectors returns the metrics of the filters for registration
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		rejectedSamples,
+		filteredValues,
+		rawValues,
+	}
+}
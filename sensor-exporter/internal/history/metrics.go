@@ -0,0 +1,28 @@
+package history
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	writeErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_history_write_errors_total",
+			Help: "Number of failures to write to or clean up the history by tier",
+		},
+		[]string{"tier"},
+	)
+	storedBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sensor_exporter_history_stored_bytes",
+			Help: "Size of the history on disk by tier",
+		},
+		[]string{"tier"},
+	)
+)
+
+// Collectors returns the metrics of the history store for registration
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		writeErrors,
+		storedBytes,
+	}
+}
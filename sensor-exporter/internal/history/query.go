@@ -0,0 +1,190 @@
+package history
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Query selects the history of one measurement of a sensor. A step of zero returns the history at the resolution of
+// the tier that covers the start of the range, which for the raw tier means every sample.
+type Query struct {
+	Sensor      string
+	Measurement string
+	From        time.Time
+	To          time.Time
+	Step        time.Duration
+	// Number of raw samples beyond which a query without a step fails rather than holding them all in memory, or 0 for
+	// no limit
+	MaxSamples int
+}
+
+// TooManySamplesError is the failure of a query without a step over more raw samples than its limit
+type TooManySamplesError struct {
+	MaxSamples int
+}
+
+func (e TooManySamplesError) Error() string {
+	return fmt.Sprintf("failed to query history; more than %v raw samples between from and to, use a step", e.MaxSamples)
+}
+
+// Result is the history of a measurement, as raw samples or as aggregates per step
+type Result struct {
+	Unit string `json:"unit,omitempty"`
+	// Tier the result was read from: raw, 1m or 1h
+	Tier string `json:"tier"`
+	// Step of the aggregates, which is at least the resolution of the tier
+	Step    string   `json:"step,omitempty"`
+	Samples []Sample `json:"samples,omitempty"`
+	Points  []Point  `json:"points,omitempty"`
+}
+
+// Sample is a raw sample
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+	Valid bool      `json:"valid"`
+}
+
+// Point aggregates the valid samples of a step, starting at its time
+type Point struct {
+	Time  time.Time `json:"time"`
+	Count uint64    `json:"count"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Mean  float64   `json:"mean"`
+}
+
+// Query reads the history of a measurement from the coarsest tier that still covers the start of the range and whose
+// resolution is no coarser than the step
+func (s *Store) Query(q Query) (Result, error) {
+	now := time.Now()
+	t := s.selectTier(q, now)
+	step := q.Step
+	if step > 0 && step < t.resolution {
+		step = t.resolution
+	}
+	if step == 0 {
+		step = t.resolution
+	}
+	key := seriesKey{q.Sensor, q.Measurement}
+
+	// Write buffered records so that the segments are up to date, and take the aggregates of the current buckets,
+	// which are not written yet
+	s.mu.Lock()
+	s.handleError(t, t.writer.flush())
+	pending := []aggregate{}
+	unit := ""
+	if p, ok := t.pending[key]; ok {
+		pending = append(pending, p.aggregate)
+		unit = p.series.unit
+	}
+	s.mu.Unlock()
+
+	segments, err := listSegments(t.writer.dir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{Tier: t.name}
+	tooMany := false
+	buckets := map[int64]*aggregate{}
+	addAggregate := func(a aggregate) {
+		if a.start.Before(q.From) || !a.start.Before(q.To) {
+			return
+		}
+		bucket := a.start.Truncate(step)
+		existing, ok := buckets[bucket.UnixNano()]
+		if !ok {
+			existing = &aggregate{start: bucket}
+			buckets[bucket.UnixNano()] = existing
+		}
+		existing.merge(a)
+	}
+
+	for _, segment := range segments {
+		if !segment.start.Before(q.To) || !segment.start.Add(t.segment).After(q.From) {
+			continue
+		}
+		_, _, err := scanSegment(segment.path, segment.start, func(kind byte, r record) {
+			if r.series.key != key {
+				return
+			}
+			unit = r.series.unit
+			switch {
+			case kind == recordAggregate:
+				addAggregate(r.aggregate)
+			case step == 0:
+				if r.time.Before(q.From) || !r.time.Before(q.To) {
+					return
+				}
+				if q.MaxSamples > 0 && len(result.Samples) >= q.MaxSamples {
+					tooMany = true
+				} else {
+					result.Samples = append(result.Samples, Sample{r.time, r.value, r.valid})
+				}
+			case r.valid:
+				single := aggregate{start: r.time}
+				single.add(r.value)
+				addAggregate(single)
+			}
+		})
+		if err != nil {
+			return Result{}, err
+		}
+		if tooMany {
+			break
+		}
+	}
+	if tooMany {
+		return Result{}, TooManySamplesError{q.MaxSamples}
+	}
+	for _, a := range pending {
+		addAggregate(a)
+	}
+
+	result.Unit = unit
+	if step == 0 {
+		sort.SliceStable(result.Samples, func(i, j int) bool {
+			return result.Samples[i].Time.Before(result.Samples[j].Time)
+		})
+		return result, nil
+	}
+
+	result.Step = step.String()
+	result.Points = []Point{}
+	for _, a := range buckets {
+		result.Points = append(result.Points, Point{
+			Time:  a.start,
+			Count: a.count,
+			Min:   a.min,
+			Max:   a.max,
+			Mean:  a.sum / float64(a.count),
+		})
+	}
+	sort.Slice(result.Points, func(i, j int) bool {
+		return result.Points[i].Time.Before(result.Points[j].Time)
+	})
+	return result, nil
+}
+
+// selectTier returns the coarsest tier whose resolution is no coarser than the step and whose retention covers the
+// start of the range, as it holds the fewest records and aggregates every sample even where the raw tier keeps only
+// some, falling back to the finest tier that covers it regardless of the step, and to the coarsest tier for ranges
+// older than every retention
+func (s *Store) selectTier(q Query, now time.Time) *tier {
+	covers := func(t *tier) bool {
+		return t.retention == 0 || !q.From.Before(now.Add(-t.retention))
+	}
+	for i := len(s.tiers) - 1; i >= 0; i-- {
+		if t := s.tiers[i]; t.resolution <= q.Step && covers(t) {
+			return t
+		}
+	}
+	for _, t := range s.tiers {
+		if covers(t) {
+			return t
+		}
+	}
+	return s.tiers[len(s.tiers)-1]
+}
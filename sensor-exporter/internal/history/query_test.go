@@ -0,0 +1,85 @@
+package history
+
+import (
+	"fmt"
+	"sensor-exporter/internal/measurement"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	end := time.Now().Truncate(time.Hour)
+	tests := []struct {
+		name       string
+		step       time.Duration
+		maxSamples int
+		result     string
+		err        string
+	}{
+		{
+			name:   "every raw sample without a step",
+			result: "raw samples=120 points=0",
+		},
+		{
+			name:       "raw samples up to the limit",
+			maxSamples: 120,
+			result:     "raw samples=120 points=0",
+		},
+		{
+			name:       "raw samples beyond the limit",
+			maxSamples: 119,
+			err:        "failed to query history; more than 119 raw samples between from and to, use a step",
+		},
+		{
+			name:       "limit ignored with a step",
+			step:       time.Minute,
+			maxSamples: 10,
+			result:     "1m samples=0 points=2",
+		},
+		{
+			name:   "raw tier for a step finer than the aggregates",
+			step:   30 * time.Second,
+			result: "raw samples=0 points=4",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := Open(Settings{Dir: t.TempDir(), RawRetention: 24 * time.Hour}, func() bool { return true })
+			if err != nil {
+				t.Fatalf("failed to open history: %v", err)
+			}
+			for i := 0; i < 120; i++ {
+				store.record([]measurement.Sample{{
+					Source:      measurement.Source{Sensor: "room", Model: "AHT20"},
+					Measurement: "temperature",
+					Value:       float64(i),
+					Valid:       true,
+					Time:        end.Add(-2*time.Minute + time.Duration(i)*time.Second),
+				}})
+			}
+
+			result, err := store.Query(Query{
+				Sensor:      "room",
+				Measurement: "temperature",
+				From:        end.Add(-time.Hour),
+				To:          end,
+				Step:        test.step,
+				MaxSamples:  test.maxSamples,
+			})
+			if test.err != "" {
+				if _, ok := err.(TooManySamplesError); !ok || err.Error() != test.err {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to query history: %v", err)
+			}
+			got := fmt.Sprintf("%v samples=%v points=%v", result.Tier, len(result.Samples), len(result.Points))
+			if got != test.result {
+				t.Errorf("got %v, want %v", got, test.result)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package history
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Kinds of the records in a segment. A series record assigns an ID to a sensor and measurement the first time either
+// is written to the segment, so that the records of its readings only carry the ID.
+const (
+	recordSeries    byte = 1
+	recordSample    byte = 2
+	recordAggregate byte = 3
+)
+
+const segmentSuffix = ".seg"
+
+type seriesKey struct {
+	sensor      string
+	measurement string
+}
+
+type seriesDef struct {
+	key  seriesKey
+	unit string
+}
+
+// record is a raw sample or an aggregate read from a segment
+type record struct {
+	series seriesDef
+	time   time.Time
+	// Raw samples
+	value float64
+	valid bool
+	// Aggregates
+	aggregate aggregate
+}
+
+// aggregate summarizes the valid samples of a series over a bucket of time
+type aggregate struct {
+	start time.Time
+	count uint64
+	min   float64
+	max   float64
+	sum   float64
+}
+
+func (a *aggregate) add(value float64) {
+	if a.count == 0 || value < a.min {
+		a.min = value
+	}
+	if a.count == 0 || value > a.max {
+		a.max = value
+	}
+	a.count++
+	a.sum += value
+}
+
+func (a *aggregate) merge(other aggregate) {
+	if other.count == 0 {
+		return
+	}
+	if a.count == 0 || other.min < a.min {
+		a.min = other.min
+	}
+	if a.count == 0 || other.max > a.max {
+		a.max = other.max
+	}
+	a.count += other.count
+	a.sum += other.sum
+}
+
+// segmentWriter appends records to the segments of a tier, each covering a fixed span of time. Records go to the
+// segment their time falls into, which is usually the latest one.
+type segmentWriter struct {
+	dir      string
+	duration time.Duration
+
+	start  time.Time
+	file   *os.File
+	buffer *bufio.Writer
+	series map[seriesKey]uint64
+}
+
+func newSegmentWriter(dir string, duration time.Duration) (*segmentWriter, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create history directory %v", dir)
+	}
+	return &segmentWriter{
+		dir:      dir,
+		duration: duration,
+	}, nil
+}
+
+// appendSample appends a raw sample
+func (w *segmentWriter) appendSample(series seriesDef, t time.Time, value float64, valid bool) error {
+	fields := protowire.AppendFixed64(nil, math.Float64bits(value))
+	if valid {
+		fields = append(fields, 1)
+	} else {
+		fields = append(fields, 0)
+	}
+	return w.append(series, t, recordSample, fields)
+}
+
+// appendAggregate appends the aggregate of a bucket starting at its start time
+func (w *segmentWriter) appendAggregate(series seriesDef, a aggregate) error {
+	fields := protowire.AppendVarint(nil, a.count)
+	fields = protowire.AppendFixed64(fields, math.Float64bits(a.min))
+	fields = protowire.AppendFixed64(fields, math.Float64bits(a.max))
+	fields = protowire.AppendFixed64(fields, math.Float64bits(a.sum))
+	return w.append(series, a.start, recordAggregate, fields)
+}
+
+func (w *segmentWriter) append(series seriesDef, t time.Time, kind byte, fields []byte) error {
+	start := t.Truncate(w.duration)
+	if w.file == nil || !start.Equal(w.start) {
+		err := w.open(start)
+		if err != nil {
+			return err
+		}
+	}
+
+	encoded := []byte{}
+	id, ok := w.series[series.key]
+	if !ok {
+		id = uint64(len(w.series) + 1)
+		encoded = append(encoded, recordSeries)
+		encoded = protowire.AppendVarint(encoded, id)
+		encoded = protowire.AppendString(encoded, series.key.sensor)
+		encoded = protowire.AppendString(encoded, series.key.measurement)
+		encoded = protowire.AppendString(encoded, series.unit)
+		w.series[series.key] = id
+	}
+	encoded = append(encoded, kind)
+	encoded = protowire.AppendVarint(encoded, id)
+	encoded = protowire.AppendVarint(encoded, uint64(t.Sub(start)/time.Millisecond))
+	encoded = append(encoded, fields...)
+
+	_, err := w.buffer.Write(encoded)
+	if err != nil {
+		w.close()
+		return errors.Wrapf(err, "failed to write to history segment %v", w.path(start))
+	}
+	return nil
+}
+
+// open opens the segment starting at the given time for appending, reading the series it already defines and
+// truncating a record left incomplete by a crash
+func (w *segmentWriter) open(start time.Time) error {
+	w.close()
+
+	path := w.path(start)
+	definitions, length, err := scanSegment(path, start, nil)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open history segment %v", path)
+	}
+	err = file.Truncate(length)
+	if err == nil {
+		_, err = file.Seek(length, 0)
+	}
+	if err != nil {
+		file.Close()
+		return errors.Wrapf(err, "failed to truncate history segment %v", path)
+	}
+
+	w.series = map[seriesKey]uint64{}
+	for id, series := range definitions {
+		w.series[series.key] = id
+	}
+	w.start = start
+	w.file = file
+	w.buffer = bufio.NewWriter(file)
+	return nil
+}
+
+// flush writes the buffered records to the segment file
+func (w *segmentWriter) flush() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.buffer.Flush()
+	if err != nil {
+		path := w.file.Name()
+		w.close()
+		return errors.Wrapf(err, "failed to write to history segment %v", path)
+	}
+	return nil
+}
+
+func (w *segmentWriter) close() {
+	if w.file == nil {
+		return
+	}
+	w.buffer.Flush()
+	w.file.Close()
+	w.file = nil
+	w.buffer = nil
+	w.series = nil
+}
+
+func (w *segmentWriter) path(start time.Time) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%d%v", start.Unix(), segmentSuffix))
+}
+
+// segmentFile is a segment on disk
+type segmentFile struct {
+	path  string
+	start time.Time
+	size  int64
+}
+
+// listSegments returns the segments in a directory, oldest first
+func listSegments(dir string) ([]segmentFile, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read history directory %v", dir)
+	}
+
+	segments := []segmentFile{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), segmentSuffix) {
+			continue
+		}
+		seconds, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segmentFile{
+			path:  filepath.Join(dir, entry.Name()),
+			start: time.Unix(seconds, 0),
+			size:  entry.Size(),
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].start.Before(segments[j].start)
+	})
+	return segments, nil
+}
+
+// scanSegment reads the records of a segment, calling visit, if given, with each sample and aggregate. It returns the
+// series the segment defines and the length of its valid records; reading stops at the first incomplete or invalid
+// record, such as one cut short by a crash. A missing segment is empty.
+func scanSegment(path string, start time.Time, visit func(kind byte, r record)) (map[uint64]seriesDef, int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[uint64]seriesDef{}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to read history segment %v", path)
+	}
+
+	definitions := map[uint64]seriesDef{}
+	offset := 0
+	for offset < len(data) {
+		n := decodeRecord(data[offset:], start, definitions, visit)
+		if n <= 0 {
+			break
+		}
+		offset += n
+	}
+	return definitions, int64(offset), nil
+}
+
+// decodeRecord decodes the record at the start of data and returns its length, or 0 if it is incomplete or invalid
+func decodeRecord(data []byte, start time.Time, definitions map[uint64]seriesDef, visit func(kind byte, r record)) int {
+	kind := data[0]
+	rest := data[1:]
+	id, n := protowire.ConsumeVarint(rest)
+	if n < 0 {
+		return 0
+	}
+	rest = rest[n:]
+
+	if kind == recordSeries {
+		fields := [3]string{}
+		for i := range fields {
+			fields[i], n = protowire.ConsumeString(rest)
+			if n < 0 {
+				return 0
+			}
+			rest = rest[n:]
+		}
+		definitions[id] = seriesDef{seriesKey{fields[0], fields[1]}, fields[2]}
+		return len(data) - len(rest)
+	}
+
+	series, ok := definitions[id]
+	if !ok {
+		return 0
+	}
+	offset, n := protowire.ConsumeVarint(rest)
+	if n < 0 {
+		return 0
+	}
+	rest = rest[n:]
+	r := record{
+		series: series,
+		time:   start.Add(time.Duration(offset) * time.Millisecond),
+	}
+
+	switch kind {
+	case recordSample:
+		if len(rest) < 9 {
+			return 0
+		}
+		value, _ := protowire.ConsumeFixed64(rest)
+		r.value = math.Float64frombits(value)
+		r.valid = rest[8] == 1
+		rest = rest[9:]
+	case recordAggregate:
+		count, n := protowire.ConsumeVarint(rest)
+		if n < 0 || len(rest) < n+24 {
+			return 0
+		}
+		rest = rest[n:]
+		values := [3]float64{}
+		for i := range values {
+			value, _ := protowire.ConsumeFixed64(rest)
+			values[i] = math.Float64frombits(value)
+			rest = rest[8:]
+		}
+		r.aggregate = aggregate{r.time, count, values[0], values[1], values[2]}
+	default:
+		return 0
+	}
+
+	if visit != nil {
+		visit(kind, r)
+	}
+	return len(data) - len(rest)
+}
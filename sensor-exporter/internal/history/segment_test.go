@@ -0,0 +1,242 @@
+package history
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+var segmentStart = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+var (
+	temperature = seriesDef{seriesKey{"room", "temperature"}, "°C"}
+	humidity    = seriesDef{seriesKey{"room", "relative_humidity"}, "%"}
+	eco2        = seriesDef{seriesKey{"gas", "eco2"}, "ppm"}
+)
+
+// write is a record appended to a segment
+type write struct {
+	series    seriesDef
+	offset    time.Duration
+	value     float64
+	valid     bool
+	aggregate bool
+}
+
+func (w write) append(t *testing.T, writer *segmentWriter) {
+	t.Helper()
+	var err error
+	if w.aggregate {
+		a := aggregate{start: segmentStart.Add(w.offset)}
+		a.add(w.value)
+		a.add(w.value + 1)
+		err = writer.appendAggregate(w.series, a)
+	} else {
+		err = writer.appendSample(w.series, segmentStart.Add(w.offset), w.value, w.valid)
+	}
+	if err != nil {
+		t.Fatalf("failed to append record: %v", err)
+	}
+}
+
+// scan formats the records of the segment starting at segmentStart
+func scan(t *testing.T, dir string) []string {
+	t.Helper()
+	records := []string{}
+	_, _, err := scanSegment(newTestWriter(t, dir).path(segmentStart), segmentStart, func(kind byte, r record) {
+		prefix := fmt.Sprintf("%v/%v %v %v", r.series.key.sensor, r.series.key.measurement, r.series.unit, r.time.Sub(segmentStart))
+		if kind == recordAggregate {
+			a := r.aggregate
+			records = append(records, fmt.Sprintf("%v count=%v min=%v max=%v sum=%v", prefix, a.count, a.min, a.max, a.sum))
+		} else {
+			records = append(records, fmt.Sprintf("%v %v valid=%v", prefix, r.value, r.valid))
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to scan segment: %v", err)
+	}
+	return records
+}
+
+func newTestWriter(t *testing.T, dir string) *segmentWriter {
+	t.Helper()
+	writer, err := newSegmentWriter(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create segment writer: %v", err)
+	}
+	return writer
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSegmentRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		writes  []write
+		records []string
+	}{
+		{
+			name:    "sample",
+			writes:  []write{{series: temperature, offset: 1500 * time.Millisecond, value: 21.25, valid: true}},
+			records: []string{"room/temperature °C 1.5s 21.25 valid=true"},
+		},
+		{
+			name: "series defined once per segment",
+			writes: []write{
+				{series: temperature, value: -3.5, valid: true},
+				{series: humidity, offset: time.Second, value: 45, valid: false},
+				{series: temperature, offset: 2 * time.Second, value: -3.75, valid: true},
+			},
+			records: []string{
+				"room/temperature °C 0s -3.5 valid=true",
+				"room/relative_humidity % 1s 45 valid=false",
+				"room/temperature °C 2s -3.75 valid=true",
+			},
+		},
+		{
+			name: "offsets truncated to milliseconds",
+			writes: []write{
+				{series: eco2, offset: 59*time.Minute + 1234567*time.Microsecond, value: 400, valid: true},
+			},
+			records: []string{"gas/eco2 ppm 59m1.234s 400 valid=true"},
+		},
+		{
+			name: "aggregates",
+			writes: []write{
+				{series: eco2, offset: time.Minute, value: 410, aggregate: true},
+				{series: eco2, offset: 2 * time.Minute, value: 0.5, aggregate: true},
+			},
+			records: []string{
+				"gas/eco2 ppm 1m0s count=2 min=410 max=411 sum=821",
+				"gas/eco2 ppm 2m0s count=2 min=0.5 max=1.5 sum=2",
+			},
+		},
+		{
+			name: "records of later segments elsewhere",
+			writes: []write{
+				{series: temperature, offset: 30 * time.Minute, value: 20, valid: true},
+				{series: temperature, offset: 90 * time.Minute, value: 21, valid: true},
+			},
+			records: []string{"room/temperature °C 30m0s 20 valid=true"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writer := newTestWriter(t, dir)
+			for _, w := range test.writes {
+				w.append(t, writer)
+			}
+			writer.close()
+
+			records := scan(t, dir)
+			if !equal(records, test.records) {
+				t.Errorf("got records\n%v\nwant\n%v", records, test.records)
+			}
+		})
+	}
+}
+
+func TestSegmentCrashRecovery(t *testing.T) {
+	writes := []write{
+		{series: temperature, value: 20, valid: true},
+		{series: temperature, offset: time.Second, value: 21, valid: true},
+		{series: temperature, offset: 2 * time.Second, value: 22, valid: true},
+	}
+	tests := []struct {
+		name string
+		// Damages the segment the way a crash could
+		damage  func(data []byte) []byte
+		records []string
+	}{
+		{
+			name:   "intact",
+			damage: func(data []byte) []byte { return data },
+			records: []string{
+				"room/temperature °C 0s 20 valid=true",
+				"room/temperature °C 1s 21 valid=true",
+				"room/temperature °C 2s 22 valid=true",
+				"room/temperature °C 3s 23 valid=true",
+			},
+		},
+		{
+			name:   "last record cut short",
+			damage: func(data []byte) []byte { return data[:len(data)-4] },
+			records: []string{
+				"room/temperature °C 0s 20 valid=true",
+				"room/temperature °C 1s 21 valid=true",
+				"room/temperature °C 3s 23 valid=true",
+			},
+		},
+		{
+			name:   "only the kind of a record written",
+			damage: func(data []byte) []byte { return append(data, recordSample) },
+			records: []string{
+				"room/temperature °C 0s 20 valid=true",
+				"room/temperature °C 1s 21 valid=true",
+				"room/temperature °C 2s 22 valid=true",
+				"room/temperature °C 3s 23 valid=true",
+			},
+		},
+		{
+			name:   "unknown record kind",
+			damage: func(data []byte) []byte { return append(data, 9, 1, 0) },
+			records: []string{
+				"room/temperature °C 0s 20 valid=true",
+				"room/temperature °C 1s 21 valid=true",
+				"room/temperature °C 2s 22 valid=true",
+				"room/temperature °C 3s 23 valid=true",
+			},
+		},
+		{
+			name:   "series definition cut short",
+			damage: func(data []byte) []byte { return data[:5] },
+			records: []string{
+				"room/temperature °C 3s 23 valid=true",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writer := newTestWriter(t, dir)
+			for _, w := range writes {
+				w.append(t, writer)
+			}
+			writer.close()
+
+			path := writer.path(segmentStart)
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read segment: %v", err)
+			}
+			err = ioutil.WriteFile(path, test.damage(data), 0644)
+			if err != nil {
+				t.Fatalf("failed to damage segment: %v", err)
+			}
+
+			// reopening the segment after a restart discards the damaged record, so that new records can be read again
+			writer = newTestWriter(t, dir)
+			write{series: temperature, offset: 3 * time.Second, value: 23, valid: true}.append(t, writer)
+			writer.close()
+
+			records := scan(t, dir)
+			if !equal(records, test.records) {
+				t.Errorf("got records\n%v\nwant\n%v", records, test.records)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+// Package history keeps the readings of the sensors on disk in tiers of decreasing resolution and increasing
+// retention: raw samples, 1-minute aggregates and hourly aggregates
+package history
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"sensor-exporter/internal/measurement"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+// Settings configure where the history is stored and for how long each tier is kept
+type Settings struct {
+	Dir             string
+	RawRetention    time.Duration
+	MinuteRetention time.Duration
+	HourRetention   time.Duration
+	// Minimum interval between the raw signals of a gas sensor kept in the raw tier, as they are measured many times a
+	// second, or 0 to keep every one. The other tiers aggregate every signal.
+	RawSignalInterval time.Duration
+}
+
+// tier is a level of the history. Raw samples have no resolution; the other tiers aggregate samples into buckets of
+// their resolution.
+type tier struct {
+	name       string
+	resolution time.Duration
+	retention  time.Duration
+	// Span of time each segment file covers, so that retention is enforced by deleting whole segments
+	segment time.Duration
+	writer  *segmentWriter
+	// Aggregates of the current bucket of each series, written once a sample falls into a later bucket
+	pending map[seriesKey]*pendingAggregate
+}
+
+type pendingAggregate struct {
+	series    seriesDef
+	aggregate aggregate
+}
+
+// maintenanceInterval is how often buffered records are written and expired segments removed
+const maintenanceInterval = 10 * time.Second
+
+// Store records samples into the tiers and answers queries over them
+type Store struct {
+	settings Settings
+	// Whether the wall clock can be trusted to expire segments
+	clockSynced func() bool
+
+	mu    sync.Mutex
+	tiers []*tier
+	// Time of the latest raw signal of each series kept in the raw tier
+	lastRawSignal map[seriesKey]time.Time
+}
+
+// Open opens the history in the settings directory, creating a directory per tier
+func Open(settings Settings, clockSynced func() bool) (*Store, error) {
+	s := &Store{
+		settings:      settings,
+		clockSynced:   clockSynced,
+		lastRawSignal: map[seriesKey]time.Time{},
+		tiers: []*tier{
+			{name: "raw", retention: settings.RawRetention, segment: time.Hour},
+			{name: "1m", resolution: time.Minute, retention: settings.MinuteRetention, segment: 24 * time.Hour},
+			{name: "1h", resolution: time.Hour, retention: settings.HourRetention, segment: 30 * 24 * time.Hour},
+		},
+	}
+	for _, t := range s.tiers {
+		writer, err := newSegmentWriter(filepath.Join(settings.Dir, t.name), t.segment)
+		if err != nil {
+			return nil, err
+		}
+		t.writer = writer
+		t.pending = map[seriesKey]*pendingAggregate{}
+	}
+	s.maintain(time.Now())
+	return s, nil
+}
+
+// Start records the samples received until the context is done, at which point the aggregates of the current buckets
+// are written as they are
+func (s *Store) Start(ctx context.Context, samples <-chan []measurement.Sample) func() error {
+	return func() error {
+		log.Info("recording history",
+			"dir", s.settings.Dir)
+
+		ticker := time.NewTicker(maintenanceInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case batch, ok := <-samples:
+				if !ok {
+					s.close()
+					return nil
+				}
+				s.record(batch)
+			case now := <-ticker.C:
+				s.maintain(now)
+			case <-ctx.Done():
+				s.close()
+				return nil
+			}
+		}
+	}
+}
+
+// record writes raw samples and adds valid samples to the aggregates of their buckets
+func (s *Store) record(batch []measurement.Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sample := range batch {
+		series := seriesDef{seriesKey{sample.Sensor, sample.Measurement}, sample.Unit}
+		for _, t := range s.tiers {
+			if t.resolution == 0 {
+				if !s.keepRaw(series, sample) {
+					continue
+				}
+				s.handleError(t, t.writer.appendSample(series, sample.Time, sample.Value, sample.Valid))
+				continue
+			}
+			if !sample.Valid || math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				continue
+			}
+
+			bucket := sample.Time.Truncate(t.resolution)
+			pending, ok := t.pending[series.key]
+			if ok && !pending.aggregate.start.Equal(bucket) {
+				s.handleError(t, t.writer.appendAggregate(pending.series, pending.aggregate))
+				ok = false
+			}
+			if !ok {
+				pending = &pendingAggregate{series: series, aggregate: aggregate{start: bucket}}
+				t.pending[series.key] = pending
+			}
+			pending.aggregate.add(sample.Value)
+		}
+	}
+}
+
+// keepRaw returns whether a sample is written to the raw tier, which keeps raw signals at most once per interval
+func (s *Store) keepRaw(series seriesDef, sample measurement.Sample) bool {
+	if series.unit != measurement.UnitRawSignal || s.settings.RawSignalInterval <= 0 {
+		return true
+	}
+	last, ok := s.lastRawSignal[series.key]
+	if ok && sample.Time.Sub(last) < s.settings.RawSignalInterval && !sample.Time.Before(last) {
+		return false
+	}
+	s.lastRawSignal[series.key] = sample.Time
+	return true
+}
+
+// maintain writes the aggregates of buckets that have ended and the buffered records, and removes segments older than
+// the retention of their tier once the clock can be trusted
+func (s *Store) maintain(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tiers {
+		for key, pending := range t.pending {
+			if !pending.aggregate.start.Add(t.resolution).After(now) {
+				s.handleError(t, t.writer.appendAggregate(pending.series, pending.aggregate))
+				delete(t.pending, key)
+			}
+		}
+		s.handleError(t, t.writer.flush())
+
+		segments, err := listSegments(t.writer.dir)
+		if err != nil {
+			s.handleError(t, err)
+			continue
+		}
+		size := int64(0)
+		for _, segment := range segments {
+			if t.retention > 0 && s.clockSynced() && !segment.start.Add(t.segment).After(now.Add(-t.retention)) {
+				if segment.start.Equal(t.writer.start) {
+					t.writer.close()
+				}
+				err = os.Remove(segment.path)
+				if err != nil && !os.IsNotExist(err) {
+					s.handleError(t, errors.Wrapf(err, "failed to remove expired history segment %v", segment.path))
+					size += segment.size
+				}
+				continue
+			}
+			size += segment.size
+		}
+		storedBytes.WithLabelValues(t.name).Set(float64(size))
+	}
+}
+
+func (s *Store) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tiers {
+		for _, pending := range t.pending {
+			s.handleError(t, t.writer.appendAggregate(pending.series, pending.aggregate))
+		}
+		t.pending = map[seriesKey]*pendingAggregate{}
+		s.handleError(t, t.writer.flush())
+		t.writer.close()
+	}
+}
+
+func (s *Store) handleError(t *tier, err error) {
+	if err == nil {
+		return
+	}
+	writeErrors.WithLabelValues(t.name).Inc()
+	log.Error("failed to record history",
+		"err", err,
+		"tier", t.name)
+}
@@ -0,0 +1,66 @@
+package history
+
+import (
+	"sensor-exporter/internal/measurement"
+	"testing"
+	"time"
+)
+
+func TestRecordRawSignals(t *testing.T) {
+	end := time.Now().Truncate(time.Hour)
+	tests := []struct {
+		name        string
+		interval    time.Duration
+		measurement string
+		unit        string
+		// Number of raw samples kept of 80 recorded at 40 Hz
+		raw int
+	}{
+		{"raw signal downsampled", time.Second, "h2_raw", measurement.UnitRawSignal, 2},
+		{"raw signal kept without an interval", 0, "h2_raw", measurement.UnitRawSignal, 80},
+		{"other measurement kept", time.Second, "eco2", measurement.UnitPartsPerMillion, 80},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := Open(Settings{
+				Dir:               t.TempDir(),
+				RawRetention:      24 * time.Hour,
+				MinuteRetention:   24 * time.Hour,
+				RawSignalInterval: test.interval,
+			}, func() bool { return true })
+			if err != nil {
+				t.Fatalf("failed to open history: %v", err)
+			}
+			for i := 0; i < 80; i++ {
+				store.record([]measurement.Sample{{
+					Source:      measurement.Source{Sensor: "sgp30", Model: "SGP30"},
+					Measurement: test.measurement,
+					Value:       float64(i),
+					Unit:        test.unit,
+					Valid:       true,
+					Time:        end.Add(-time.Minute + time.Duration(i)*25*time.Millisecond),
+				}})
+			}
+
+			query := Query{Sensor: "sgp30", Measurement: test.measurement, From: end.Add(-time.Hour), To: end}
+			result, err := store.Query(query)
+			if err != nil {
+				t.Fatalf("failed to query history: %v", err)
+			}
+			if result.Tier != "raw" || len(result.Samples) != test.raw {
+				t.Errorf("got %v samples from the %v tier, want %v from the raw tier", len(result.Samples), result.Tier, test.raw)
+			}
+
+			// the aggregates count every sample
+			query.Step = time.Minute
+			result, err = store.Query(query)
+			if err != nil {
+				t.Fatalf("failed to query history: %v", err)
+			}
+			if len(result.Points) != 1 || result.Points[0].Count != 80 {
+				t.Errorf("got points %+v, want one of 80 samples", result.Points)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxDatagramSize keeps UDP datagrams below the usual MTU so that they are not fragmented
+const maxDatagramSize = 1400
+
+// client writes lines to InfluxDB
+type client interface {
+	write(ctx context.Context, lines []byte) error
+}
+
+// permanentError is a failure that retrying the same lines will not resolve, such as lines the server rejects
+type permanentError struct {
+	error
+}
+
+func newClient(settings Settings) (client, error) {
+	u, err := url.Parse(settings.URL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse InfluxDB URL %v", settings.URL)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		if settings.Bucket == "" {
+			return nil, errors.New("failed to configure InfluxDB output without a bucket")
+		}
+		// InfluxDB may be served below a base path, e.g. behind a reverse proxy
+		u.Path = path.Join("/", u.Path, "api/v2/write")
+		u.RawQuery = url.Values{
+			"org":       {settings.Org},
+			"bucket":    {settings.Bucket},
+			"precision": {"ns"},
+		}.Encode()
+		return &httpClient{
+			url:    u.String(),
+			token:  settings.Token,
+			client: &http.Client{Timeout: settings.Timeout},
+		}, nil
+	case "udp":
+		return &udpClient{
+			address: u.Host,
+			timeout: settings.Timeout,
+		}, nil
+	default:
+		return nil, errors.Errorf("failed to configure InfluxDB output with URL %v; expected an http, https or udp URL", settings.URL)
+	}
+}
+
+// httpClient writes through the InfluxDB v2 HTTP API
+type httpClient struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (c *httpClient) write(ctx context.Context, lines []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(lines))
+	if err != nil {
+		return errors.Wrap(err, "failed to create InfluxDB write request")
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if c.token != "" {
+		request.Header.Set("Authorization", "Token "+c.token)
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return errors.Wrap(err, "failed to write to InfluxDB")
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+
+	switch {
+	case response.StatusCode/100 == 2:
+		return nil
+	case response.StatusCode == http.StatusTooManyRequests || response.StatusCode/100 == 5:
+		return errors.Errorf("failed to write to InfluxDB; server responded %v: %s", response.Status, body)
+	default:
+		return permanentError{errors.Errorf("failed to write to InfluxDB; server rejected lines with %v: %s", response.Status, body)}
+	}
+}
+
+// udpClient writes to the UDP listener of InfluxDB, which cannot report whether the lines arrived
+type udpClient struct {
+	address string
+	timeout time.Duration
+}
+
+func (c *udpClient) write(ctx context.Context, lines []byte) error {
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, "udp", c.address)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to InfluxDB at %v", c.address)
+	}
+	defer conn.Close()
+
+	for len(lines) > 0 {
+		datagram := nextDatagram(lines)
+		err = conn.SetWriteDeadline(time.Now().Add(c.timeout))
+		if err == nil {
+			_, err = conn.Write(datagram)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to write to InfluxDB at %v", c.address)
+		}
+		lines = lines[len(datagram):]
+	}
+	return nil
+}
+
+// nextDatagram returns as many whole lines as fit in a datagram, or a single line if it is larger than that
+func nextDatagram(lines []byte) []byte {
+	end := 0
+	for end < len(lines) {
+		next := bytes.IndexByte(lines[end:], '\n')
+		if next < 0 {
+			next = len(lines) - end - 1
+		}
+		if end > 0 && end+next+1 > maxDatagramSize {
+			break
+		}
+		end += next + 1
+	}
+	return lines[:end]
+}
@@ -0,0 +1,93 @@
+package influx
+
+import (
+	"bytes"
+	"sensor-exporter/internal/aggregate"
+	"sensor-exporter/internal/measurement"
+	"strconv"
+	"strings"
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `, "\n", `\n`)
+	keyEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+)
+
+// appendLine appends a batch of samples of one reading as a line, with the lowercase model of the sensor as the
+// measurement, its name and serial as tags, each measurement as a float field and whether all of them are valid as
+// the valid field. The timestamp is the time the reading was acquired, in nanoseconds.
+func appendLine(buffer *bytes.Buffer, batch []measurement.Sample) {
+	if len(batch) == 0 {
+		return
+	}
+	first := batch[0]
+
+	buffer.WriteString(measurementEscaper.Replace(strings.ToLower(first.Model)))
+	buffer.WriteString(",sensor=")
+	buffer.WriteString(keyEscaper.Replace(first.Sensor))
+	if first.Serial != "" {
+		buffer.WriteString(",serial=")
+		buffer.WriteString(keyEscaper.Replace(first.Serial))
+	}
+
+	valid := true
+	for i, sample := range batch {
+		if i == 0 {
+			buffer.WriteByte(' ')
+		} else {
+			buffer.WriteByte(',')
+		}
+		buffer.WriteString(keyEscaper.Replace(sample.Measurement))
+		buffer.WriteByte('=')
+		buffer.WriteString(strconv.FormatFloat(sample.Value, 'f', -1, 64))
+		valid = valid && sample.Valid
+	}
+	buffer.WriteString(",valid=")
+	buffer.WriteString(strconv.FormatBool(valid))
+
+	buffer.WriteByte(' ')
+	buffer.WriteString(strconv.FormatInt(first.Time.UnixNano(), 10))
+	buffer.WriteByte('\n')
+}
+
+// appendSummaryLine appends the summary of a window as a line, with the lowercase model of the sensor suffixed by
+// _window as the measurement, its name, serial, the measurement and the window as tags and the statistics as fields.
+// The timestamp is the start of the window, in nanoseconds.
+func appendSummaryLine(buffer *bytes.Buffer, summary aggregate.Summary) {
+	buffer.WriteString(measurementEscaper.Replace(strings.ToLower(summary.Model) + "_window"))
+	buffer.WriteString(",sensor=")
+	buffer.WriteString(keyEscaper.Replace(summary.Sensor))
+	if summary.Serial != "" {
+		buffer.WriteString(",serial=")
+		buffer.WriteString(keyEscaper.Replace(summary.Serial))
+	}
+	buffer.WriteString(",measurement=")
+	buffer.WriteString(keyEscaper.Replace(summary.Measurement))
+	buffer.WriteString(",window=")
+	buffer.WriteString(keyEscaper.Replace(summary.Window))
+
+	buffer.WriteString(" count=")
+	buffer.WriteString(strconv.Itoa(summary.Count))
+	buffer.WriteByte('i')
+	fields := []struct {
+		name  string
+		value float64
+	}{
+		{"min", summary.Min},
+		{"max", summary.Max},
+		{"mean", summary.Mean},
+		{"stddev", summary.Stddev},
+		{"p50", summary.P50},
+		{"p95", summary.P95},
+	}
+	for _, field := range fields {
+		buffer.WriteByte(',')
+		buffer.WriteString(field.name)
+		buffer.WriteByte('=')
+		buffer.WriteString(strconv.FormatFloat(field.value, 'f', -1, 64))
+	}
+
+	buffer.WriteByte(' ')
+	buffer.WriteString(strconv.FormatInt(summary.Start.UnixNano(), 10))
+	buffer.WriteByte('\n')
+}
@@ -0,0 +1,160 @@
+package influx
+
+import (
+	"bytes"
+	"sensor-exporter/internal/aggregate"
+	"sensor-exporter/internal/measurement"
+	"strings"
+	"testing"
+	"time"
+)
+
+var acquired = time.Date(2026, 10, 1, 12, 0, 0, 500, time.UTC)
+
+func sample(source measurement.Source, name string, value float64, valid bool) measurement.Sample {
+	return measurement.Sample{
+		Source:      source,
+		Measurement: name,
+		Value:       value,
+		Valid:       valid,
+		Time:        acquired,
+	}
+}
+
+func TestAppendLine(t *testing.T) {
+	gas := measurement.Source{Sensor: "living-room-gas", Model: "SGP30", Serial: "0000-0123-4567"}
+	tests := []struct {
+		name  string
+		batch []measurement.Sample
+		line  string
+	}{
+		{
+			name:  "empty batch",
+			batch: []measurement.Sample{},
+			line:  "",
+		},
+		{
+			name:  "fields in order with serial",
+			batch: []measurement.Sample{sample(gas, "eco2", 412, true), sample(gas, "tvoc", 3.5, true)},
+			line:  "sgp30,sensor=living-room-gas,serial=0000-0123-4567 eco2=412,tvoc=3.5,valid=true 1790856000000000500\n",
+		},
+		{
+			name:  "invalid if any sample is invalid",
+			batch: []measurement.Sample{sample(gas, "eco2", 400, false), sample(gas, "tvoc", 0, true)},
+			line:  "sgp30,sensor=living-room-gas,serial=0000-0123-4567 eco2=400,tvoc=0,valid=false 1790856000000000500\n",
+		},
+		{
+			name: "without serial",
+			batch: []measurement.Sample{
+				sample(measurement.Source{Sensor: "room", Model: "AHT20"}, "temperature", -0.25, true),
+			},
+			line: "aht20,sensor=room temperature=-0.25,valid=true 1790856000000000500\n",
+		},
+		{
+			name: "escaped tags and fields",
+			batch: []measurement.Sample{
+				sample(measurement.Source{Sensor: "living room,east=1", Model: "PMS 5003"}, "pm2 5", 1e-7, true),
+			},
+			line: `pms\ 5003,sensor=living\ room\,east\=1 pm2\ 5=0.0000001,valid=true 1790856000000000500` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buffer := bytes.Buffer{}
+			appendLine(&buffer, test.batch)
+			if buffer.String() != test.line {
+				t.Errorf("got line\n%q\nwant\n%q", buffer.String(), test.line)
+			}
+		})
+	}
+}
+
+func TestAppendSummaryLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		summary aggregate.Summary
+		line    string
+	}{
+		{
+			name: "statistics as fields",
+			summary: aggregate.Summary{
+				Source:      measurement.Source{Sensor: "room", Model: "AHT20", Serial: "1"},
+				Measurement: "temperature",
+				Window:      "1m",
+				Start:       acquired,
+				Count:       60,
+				Min:         20,
+				Max:         22.5,
+				Mean:        21.25,
+				Stddev:      0.5,
+				P50:         21,
+				P95:         22,
+			},
+			line: "aht20_window,sensor=room,serial=1,measurement=temperature,window=1m " +
+				"count=60i,min=20,max=22.5,mean=21.25,stddev=0.5,p50=21,p95=22 1790856000000000500\n",
+		},
+		{
+			name: "escaped tags",
+			summary: aggregate.Summary{
+				Source:      measurement.Source{Sensor: "gas sensor", Model: "SGP30"},
+				Measurement: "h2_raw",
+				Window:      "1h30m",
+				Start:       acquired,
+				Count:       1,
+				Min:         13000,
+				Max:         13000,
+				Mean:        13000,
+				P50:         13000,
+				P95:         13000,
+			},
+			line: `sgp30_window,sensor=gas\ sensor,measurement=h2_raw,window=1h30m ` +
+				"count=1i,min=13000,max=13000,mean=13000,stddev=0,p50=13000,p95=13000 1790856000000000500\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buffer := bytes.Buffer{}
+			appendSummaryLine(&buffer, test.summary)
+			if buffer.String() != test.line {
+				t.Errorf("got line\n%q\nwant\n%q", buffer.String(), test.line)
+			}
+		})
+	}
+}
+
+func TestNextDatagram(t *testing.T) {
+	line := strings.Repeat("x", 699) + "\n"
+	long := strings.Repeat("y", 2000) + "\n"
+	tests := []struct {
+		name      string
+		lines     string
+		datagrams []int
+	}{
+		{"lines that fit", "a 1\nb 2\n", []int{8}},
+		{"split at a line", line + line + line, []int{1400, 700}},
+		{"long line alone", line + long + line, []int{700, 2001, 700}},
+		{"missing final newline", line + "z", []int{701}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines := []byte(test.lines)
+			datagrams := []int{}
+			for len(lines) > 0 {
+				datagram := nextDatagram(lines)
+				datagrams = append(datagrams, len(datagram))
+				lines = lines[len(datagram):]
+			}
+			if len(datagrams) != len(test.datagrams) {
+				t.Fatalf("got datagrams of %v bytes, want %v", datagrams, test.datagrams)
+			}
+			for i := range datagrams {
+				if datagrams[i] != test.datagrams[i] {
+					t.Fatalf("got datagrams of %v bytes, want %v", datagrams, test.datagrams)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package influx
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	writtenLines = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_influx_written_lines_total",
+			Help: "Number of lines written to InfluxDB, including lines replayed from the spool",
+		},
+	)
+	writeErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_influx_write_errors_total",
+			Help: "Number of failed writes to InfluxDB",
+		},
+	)
+	droppedLines = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_influx_dropped_lines_total",
+			Help: "Number of lines dropped because InfluxDB rejected them or the spool was full",
+		},
+	)
+	spooledBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "sensor_exporter_influx_spooled_bytes",
+			Help: "Size of the lines spooled to disk while InfluxDB is unreachable",
+		},
+	)
+)
+
+// Collectors returns the metrics of the InfluxDB writer for registration
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		writtenLines,
+		writeErrors,
+		droppedLines,
+		spooledBytes,
+	}
+}
@@ -0,0 +1,247 @@
+// Package influx writes sensor samples to InfluxDB in line protocol, spooling them to disk while InfluxDB is
+// unreachable
+package influx
+
+import (
+	"bytes"
+	"context"
+	"sensor-exporter/internal/aggregate"
+	"sensor-exporter/internal/measurement"
+	"sensor-exporter/internal/spool"
+	"time"
+
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+// Settings configure where and how lines are written
+type Settings struct {
+	// URL of InfluxDB, either http(s)://host:8086 for the v2 HTTP API or udp://host:8089 for a UDP listener
+	URL    string
+	Org    string
+	Bucket string
+	Token  string
+	// Number of lines after which a batch is written without waiting for the flush interval
+	BatchSize int
+	// Longest time lines wait before they are written
+	FlushInterval time.Duration
+	// Time limit of a single write
+	Timeout time.Duration
+	// Directory in which batches are spooled while InfluxDB is unreachable
+	SpoolDir string
+	// Size of the spool beyond which the oldest batches are dropped
+	SpoolMaxBytes int64
+}
+
+// Writer batches samples into line protocol and writes them to InfluxDB
+type Writer struct {
+	settings Settings
+	client   client
+	spool    *spool.Spool
+	batch    bytes.Buffer
+	lines    int
+}
+
+// lineBatch is a batch of lines handed from the loop that reads samples to the goroutine that writes them
+type lineBatch struct {
+	lines []byte
+	count int
+}
+
+// NewWriter validates the settings and opens the spool, which may hold batches from before a restart
+func NewWriter(settings Settings) (*Writer, error) {
+	client, err := newClient(settings)
+	if err != nil {
+		return nil, err
+	}
+	lineSpool, err := spool.Open(settings.SpoolDir, ".lp", settings.SpoolMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	spooledBytes.Set(float64(lineSpool.Size()))
+
+	return &Writer{
+		settings: settings,
+		client:   client,
+		spool:    lineSpool,
+	}, nil
+}
+
+// Start writes the samples and window summaries received until the context is done, at which point the batch in
+// progress is written or spooled for the next start. The summaries channel is nil if samples are not aggregated.
+// Writes happen in a goroutine of their own, so that a slow or unreachable InfluxDB does not hold up the samples.
+func (w *Writer) Start(ctx context.Context, samples <-chan []measurement.Sample, summaries <-chan []aggregate.Summary) func() error {
+	return func() error {
+		log.Info("writing to InfluxDB",
+			"url", w.settings.URL,
+			"bucket", w.settings.Bucket,
+			"spooledBatches", w.spool.Len())
+
+		batches := make(chan lineBatch)
+		stopped := make(chan struct{})
+		go func() {
+			w.writeLoop(ctx, batches)
+			close(stopped)
+		}()
+
+		w.readLoop(ctx, samples, summaries, batches)
+		close(batches)
+		<-stopped
+
+		// the context may be done, so the last batch gets a time limit of its own
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), w.settings.Timeout)
+		defer cancel()
+		w.write(shutdownCtx, lineBatch{w.batch.Bytes(), w.lines}, nil)
+		return nil
+	}
+}
+
+// readLoop appends the samples and summaries to the batch in progress, handing it to the writer once it is full or
+// the flush interval has passed, until the samples channel is closed or the context is done
+func (w *Writer) readLoop(ctx context.Context, samples <-chan []measurement.Sample, summaries <-chan []aggregate.Summary, batches chan<- lineBatch) {
+	ticker := time.NewTicker(w.settings.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case batch, ok := <-samples:
+			if !ok {
+				return
+			}
+			appendLine(&w.batch, batch)
+			w.lines++
+			if w.lines >= w.settings.BatchSize {
+				w.handOver(batches)
+			}
+		case batch := <-summaries:
+			for _, summary := range batch {
+				appendSummaryLine(&w.batch, summary)
+				w.lines++
+			}
+			if w.lines >= w.settings.BatchSize {
+				w.handOver(batches)
+			}
+		case <-ticker.C:
+			w.handOver(batches)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handOver passes the batch in progress to the writer if it is idle. Otherwise the batch keeps growing until the next
+// attempt, which is at most one write later since the writer spools batches it receives while replaying.
+func (w *Writer) handOver(batches chan<- lineBatch) {
+	if w.lines == 0 {
+		return
+	}
+	select {
+	case batches <- lineBatch{w.batch.Bytes(), w.lines}:
+		// the writer owns the handed over bytes, so the next batch starts in a buffer of its own
+		w.batch = bytes.Buffer{}
+		w.lines = 0
+	default:
+	}
+}
+
+// writeLoop writes the batches handed over until the channel is closed, replaying spooled batches every flush interval
+func (w *Writer) writeLoop(ctx context.Context, batches <-chan lineBatch) {
+	ticker := time.NewTicker(w.settings.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case batch, ok := <-batches:
+			if !ok {
+				return
+			}
+			w.write(ctx, batch, batches)
+		case <-ticker.C:
+			w.replay(ctx, batches)
+		}
+	}
+}
+
+// write writes a batch after any spooled batches, so that lines arrive in the order they were read. Batches that
+// cannot be written are spooled and replayed on a later flush.
+func (w *Writer) write(ctx context.Context, batch lineBatch, batches <-chan lineBatch) {
+	if batch.count > 0 {
+		if w.spool.Len() == 0 {
+			err := w.client.write(ctx, batch.lines)
+			if err == nil {
+				writtenLines.Add(float64(batch.count))
+				return
+			}
+			if w.handleError(err, batch.count) {
+				w.put(batch.lines, batch.count)
+			}
+			return
+		}
+		w.put(batch.lines, batch.count)
+	}
+
+	w.replay(ctx, batches)
+}
+
+// handleError records a failed write and returns whether the lines should be retried
+func (w *Writer) handleError(err error, lines int) bool {
+	writeErrors.Inc()
+	if _, permanent := err.(permanentError); permanent {
+		log.Error("dropping lines rejected by InfluxDB",
+			"err", err,
+			"lines", lines)
+		droppedLines.Add(float64(lines))
+		return false
+	}
+	log.Warn("failed to write to InfluxDB; lines are spooled for retry",
+		"err", err,
+		"lines", lines)
+	return true
+}
+
+func (w *Writer) put(lines []byte, count int) {
+	dropped, err := w.spool.Put(lines)
+	for _, batch := range dropped {
+		droppedLines.Add(float64(bytes.Count(batch, []byte("\n"))))
+	}
+	spooledBytes.Set(float64(w.spool.Size()))
+	if err != nil {
+		log.Error("failed to spool lines for InfluxDB",
+			"err", err,
+			"dir", w.spool.Dir())
+		droppedLines.Add(float64(count))
+	}
+}
+
+// replay writes spooled batches, oldest first, until one fails. Batches handed over meanwhile are spooled behind them.
+func (w *Writer) replay(ctx context.Context, batches <-chan lineBatch) {
+	for w.spool.Len() > 0 {
+		name, lines, err := w.spool.Peek()
+		if err != nil {
+			log.Error("dropping unreadable spooled lines",
+				"err", err)
+		} else {
+			count := bytes.Count(lines, []byte("\n"))
+			err = w.client.write(ctx, lines)
+			if err == nil {
+				writtenLines.Add(float64(count))
+			} else if w.handleError(err, count) {
+				return
+			}
+		}
+
+		err = w.spool.Remove(name)
+		spooledBytes.Set(float64(w.spool.Size()))
+		if err != nil {
+			log.Error("failed to remove replayed lines from spool",
+				"err", err)
+			return
+		}
+
+		select {
+		case batch, ok := <-batches:
+			if !ok {
+				return
+			}
+			w.put(batch.lines, batch.count)
+		default:
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package influx
+
+import (
+	"context"
+	"fmt"
+	"sensor-exporter/internal/measurement"
+	"sensor-exporter/internal/spool"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestNewClientURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"host only", "http://influx:8086", "http://influx:8086/api/v2/write?bucket=sensors&org=home&precision=ns"},
+		{"trailing slash", "http://influx:8086/", "http://influx:8086/api/v2/write?bucket=sensors&org=home&precision=ns"},
+		{"base path", "https://proxy/influxdb", "https://proxy/influxdb/api/v2/write?bucket=sensors&org=home&precision=ns"},
+		{"base path with query", "https://proxy/influxdb/?org=other", "https://proxy/influxdb/api/v2/write?bucket=sensors&org=home&precision=ns"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := newClient(Settings{URL: test.url, Org: "home", Bucket: "sensors"})
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+			if c.(*httpClient).url != test.want {
+				t.Errorf("got write URL %v, want %v", c.(*httpClient).url, test.want)
+			}
+		})
+	}
+}
+
+// fakeClient records the lines written to it, failing while it is down and blocking while it hangs
+type fakeClient struct {
+	mu      sync.Mutex
+	down    bool
+	hang    chan struct{}
+	written []string
+}
+
+func (c *fakeClient) write(ctx context.Context, lines []byte) error {
+	if c.hang != nil {
+		select {
+		case <-c.hang:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.down {
+		return errors.New("failed to write to InfluxDB; connection refused")
+	}
+	c.written = append(c.written, strings.Split(strings.TrimSuffix(string(lines), "\n"), "\n")...)
+	return nil
+}
+
+func (c *fakeClient) setDown(down bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.down = down
+}
+
+// wait returns the values of the written lines once count have been written, or those written by the timeout
+func (c *fakeClient) wait(count int, timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for {
+		c.mu.Lock()
+		values := []string{}
+		for _, line := range c.written {
+			fields := strings.Split(line, " ")
+			values = append(values, strings.TrimSuffix(fields[1], ",valid=true"))
+		}
+		c.mu.Unlock()
+		if len(values) >= count || time.Now().After(deadline) {
+			return values
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name string
+		// Whether writes hang until the first step closes the hang channel
+		hang bool
+		// Called after each reading is sent, with the number of readings sent so far
+		step    func(c *fakeClient, sent int)
+		written int
+	}{
+		{
+			name:    "written in batches",
+			step:    func(*fakeClient, int) {},
+			written: 10,
+		},
+		{
+			name: "spooled while down and replayed in order",
+			step: func(c *fakeClient, sent int) {
+				c.setDown(sent < 6)
+				// gives the flush interval the chance to retry while down
+				time.Sleep(5 * time.Millisecond)
+			},
+			written: 10,
+		},
+		{
+			name: "readings received while a write hangs",
+			hang: true,
+			step: func(c *fakeClient, sent int) {
+				if sent == 8 {
+					close(c.hang)
+				}
+			},
+			written: 10,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &fakeClient{}
+			if test.hang {
+				c.hang = make(chan struct{})
+			}
+			lineSpool, err := spool.Open(t.TempDir(), ".lp", 1<<20)
+			if err != nil {
+				t.Fatalf("failed to open spool: %v", err)
+			}
+			w := &Writer{
+				settings: Settings{BatchSize: 2, FlushInterval: time.Millisecond, Timeout: time.Second},
+				client:   c,
+				spool:    lineSpool,
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			samples := make(chan []measurement.Sample)
+			done := make(chan error)
+			go func() {
+				done <- w.Start(ctx, samples, nil)()
+			}()
+
+			source := measurement.Source{Sensor: "room", Model: "AHT20"}
+			for sent := 1; sent <= test.written; sent++ {
+				select {
+				case samples <- []measurement.Sample{sample(source, "temperature", float64(sent), true)}:
+				case <-time.After(time.Second):
+					t.Fatalf("writer stopped receiving readings after %v", sent-1)
+				}
+				test.step(c, sent)
+			}
+			close(samples)
+			<-done
+
+			want := []string{}
+			for value := 1; value <= test.written; value++ {
+				want = append(want, fmt.Sprintf("temperature=%v", value))
+			}
+			written := c.wait(test.written, time.Second)
+			if strings.Join(written, ",") != strings.Join(want, ",") {
+				t.Errorf("got lines %v, want %v", written, want)
+			}
+			if w.spool.Len() != 0 {
+				t.Errorf("%v batches left in the spool, want none", w.spool.Len())
+			}
+		})
+	}
+}
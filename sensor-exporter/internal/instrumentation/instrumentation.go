@@ -0,0 +1,113 @@
+// Package instrumentation holds the metrics the exporter reports about itself, such as the health of the buses its sensors are attached to
+package instrumentation
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	i2cTransactionDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sensor_exporter_i2c_transaction_duration_seconds",
+			Help:    "Duration of complete I2C command and response transactions, including the wait for the sensor to process the command",
+			Buckets: []float64{0.001, 0.0025, 0.005, 0.01, 0.015, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+		},
+		[]string{"driver", "device", "command"},
+	)
+	i2cBusWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sensor_exporter_i2c_bus_wait_seconds",
+			Help:    "Duration a transaction waited for exclusive access to an I2C bus",
+			Buckets: []float64{0.0001, 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25},
+		},
+		[]string{"bus"},
+	)
+	i2cBusBusy = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_i2c_bus_busy_seconds_total",
+			Help: "Total time an I2C bus was held by a transaction; its rate is the utilization of the bus",
+		},
+		[]string{"bus"},
+	)
+	crcFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_crc_failures_total",
+			Help: "Number of words read from a sensor that failed CRC validation",
+		},
+		[]string{"driver", "device"},
+	)
+	reconnects = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_reconnects_total",
+			Help: "Number of times the exporter reconnected to a sensor after a failure",
+		},
+		[]string{"driver", "device"},
+	)
+	circuitState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sensor_exporter_circuit_state",
+			Help: "State of the reconnect circuit breaker of a sensor: 0 closed, 1 half-open, 2 open",
+		},
+		[]string{"driver", "device"},
+	)
+	backoffSeconds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_backoff_seconds_total",
+			Help: "Total time spent waiting to reconnect to a sensor after a failure",
+		},
+		[]string{"driver", "device"},
+	)
+)
+
+// Collectors returns the collectors to register with the exporter's registry
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		i2cTransactionDuration,
+		i2cBusWait,
+		i2cBusBusy,
+		crcFailures,
+		reconnects,
+		circuitState,
+		backoffSeconds,
+	}
+}
+
+// ObserveI2CTransaction starts timing an I2C transaction; call the returned function once the transaction completes
+func ObserveI2CTransaction(driver, device, command string) func() {
+	timer := prometheus.NewTimer(i2cTransactionDuration.WithLabelValues(driver, device, command))
+	return func() {
+		timer.ObserveDuration()
+	}
+}
+
+// ObserveI2CBusWait records how long a transaction waited for exclusive access to a bus
+func ObserveI2CBusWait(bus string, duration time.Duration) {
+	i2cBusWait.WithLabelValues(bus).Observe(duration.Seconds())
+}
+
+// AddI2CBusBusy accumulates the time a bus was held by a transaction
+func AddI2CBusBusy(bus string, duration time.Duration) {
+	i2cBusBusy.WithLabelValues(bus).Add(duration.Seconds())
+}
+
+// IncCRCFailures counts a word that failed CRC validation
+func IncCRCFailures(driver, device string) {
+	crcFailures.WithLabelValues(driver, device).Inc()
+}
+
+// IncReconnects counts an attempt to reconnect to a sensor
+func IncReconnects(driver, device string) {
+	reconnects.WithLabelValues(driver, device).Inc()
+}
+
+// AddBackoff accumulates time spent waiting to reconnect to a sensor
+func AddBackoff(driver, device string, duration time.Duration) {
+	backoffSeconds.WithLabelValues(driver, device).Add(duration.Seconds())
+}
+
+// SetCircuitState reports the state of the reconnect circuit breaker of a sensor
+func SetCircuitState(driver, device string, state int) {
+	circuitState.WithLabelValues(driver, device).Set(float64(state))
+}
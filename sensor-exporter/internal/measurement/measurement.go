@@ -0,0 +1,143 @@
+// Package measurement flattens the typed readings of the sensor drivers into samples of individual measurements with units
+package measurement
+
+import (
+	"sensor-exporter/aht20"
+	"sensor-exporter/pms5003"
+	"sensor-exporter/sgp30"
+	"sensor-exporter/units"
+	"time"
+)
+
+// Units of the measurements
+const (
+	UnitCelsius                 = "°C"
+	UnitRatio                   = "ratio"
+	UnitGramsPerCubicMeter      = "g/m³"
+	UnitMicrogramsPerCubicMeter = "µg/m³"
+	UnitParticlesPerDeciliter   = "particles/0.1L"
+	UnitPartsPerMillion         = "ppm"
+	UnitPartsPerBillion         = "ppb"
+	UnitRawSignal               = "raw"
+)
+
+// Source identifies the sensor that produced a sample
+type Source struct {
+	// Name of the sensor as configured in the exporter
+	Sensor string `json:"sensor"`
+	// Model of the sensor, e.g. AHT20
+	Model string `json:"model"`
+	// Serial number of the sensor, if the sensor reports one
+	Serial string `json:"serial,omitempty"`
+}
+
+// Sample is a single measurement taken by a sensor
+type Sample struct {
+	Source
+	// Name of the measurement, e.g. temperature
+	Measurement string  `json:"measurement"`
+	Value       float64 `json:"value"`
+	Unit        string  `json:"unit"`
+	// Whether the value can be trusted, e.g. false while an SGP30 is acclimating
+	Valid bool `json:"valid"`
+	// Time at which the reading was acquired
+	Time time.Time `json:"time"`
+	// Value before filtering, present if the measurement is filtered
+	Raw *float64 `json:"raw,omitempty"`
+}
+
+// Measurements lists the measurements of each model in a fixed order, for outputs whose layout must not change
+// between readings
+var Measurements = map[string][]string{
+	"AHT20": {"temperature", "relative_humidity", "absolute_humidity"},
+	"PMS5003": {
+		"pm1_0_standard", "pm2_5_standard", "pm10_standard",
+		"pm1_0_environmental", "pm2_5_environmental", "pm10_environmental",
+		"particles_0_3um", "particles_0_5um", "particles_1_0um", "particles_2_5um", "particles_5_0um", "particles_10um",
+	},
+	"SGP30": {"eco2", "tvoc", "h2_raw", "ethanol_raw"},
+}
+
+func sample(source Source, measurement string, value float64, unit string, valid bool, t time.Time) Sample {
+	return Sample{Source: source, Measurement: measurement, Value: value, Unit: unit, Valid: valid, Time: t}
+}
+
+// FromAHT returns the samples of a temperature and humidity reading, including the derived absolute humidity
+func FromAHT(source Source, reading *aht20.Reading, t time.Time) []Sample {
+	humidity := units.AbsoluteHumidity(reading.Temperature, reading.Humidity)
+	return []Sample{
+		sample(source, "temperature", float64(reading.Temperature), UnitCelsius, true, t),
+		sample(source, "relative_humidity", float64(reading.Humidity), UnitRatio, true, t),
+		sample(source, "absolute_humidity", float64(humidity), UnitGramsPerCubicMeter, true, t),
+	}
+}
+
+// FromPMS returns the samples of a particulate reading
+func FromPMS(source Source, reading *pms5003.Reading, t time.Time) []Sample {
+	return []Sample{
+		sample(source, "pm1_0_standard", float64(reading.Pm10Std), UnitMicrogramsPerCubicMeter, true, t),
+		sample(source, "pm2_5_standard", float64(reading.Pm25Std), UnitMicrogramsPerCubicMeter, true, t),
+		sample(source, "pm10_standard", float64(reading.Pm100Std), UnitMicrogramsPerCubicMeter, true, t),
+		sample(source, "pm1_0_environmental", float64(reading.Pm10Env), UnitMicrogramsPerCubicMeter, true, t),
+		sample(source, "pm2_5_environmental", float64(reading.Pm25Env), UnitMicrogramsPerCubicMeter, true, t),
+		sample(source, "pm10_environmental", float64(reading.Pm100Env), UnitMicrogramsPerCubicMeter, true, t),
+		sample(source, "particles_0_3um", float64(reading.Particles3um), UnitParticlesPerDeciliter, true, t),
+		sample(source, "particles_0_5um", float64(reading.Particles5um), UnitParticlesPerDeciliter, true, t),
+		sample(source, "particles_1_0um", float64(reading.Particles10um), UnitParticlesPerDeciliter, true, t),
+		sample(source, "particles_2_5um", float64(reading.Particles25um), UnitParticlesPerDeciliter, true, t),
+		sample(source, "particles_5_0um", float64(reading.Particles50um), UnitParticlesPerDeciliter, true, t),
+		sample(source, "particles_10um", float64(reading.Particles100um), UnitParticlesPerDeciliter, true, t),
+	}
+}
+
+// FromSGPAirQuality returns the samples of an air quality reading, which are only valid once the sensor has acclimated
+func FromSGPAirQuality(source Source, reading *sgp30.AirQualityReading, t time.Time) []Sample {
+	return []Sample{
+		sample(source, "eco2", float64(reading.EquivalentCO2), UnitPartsPerMillion, reading.IsValid, t),
+		sample(source, "tvoc", float64(reading.TotalVOC), UnitPartsPerBillion, reading.IsValid, t),
+	}
+}
+
+// FromSGPRaw returns the samples of a raw signal reading
+func FromSGPRaw(source Source, reading *sgp30.RawReading, t time.Time) []Sample {
+	return []Sample{
+		sample(source, "h2_raw", float64(reading.H2), UnitRawSignal, true, t),
+		sample(source, "ethanol_raw", float64(reading.Ethanol), UnitRawSignal, true, t),
+	}
+}
+
+// Average combines repeated samples of the same measurements into their mean, valid only if every sample was valid
+func Average(samples []Sample) []Sample {
+	type sum struct {
+		sample Sample
+		total  float64
+		count  int
+	}
+
+	order := []string{}
+	sums := map[string]*sum{}
+	for _, s := range samples {
+		key := s.Sensor + "/" + s.Measurement
+		existing, ok := sums[key]
+		if !ok {
+			existing = &sum{sample: s}
+			sums[key] = existing
+			order = append(order, key)
+		}
+
+		existing.total += s.Value
+		existing.count++
+		existing.sample.Valid = existing.sample.Valid && s.Valid
+		if s.Time.After(existing.sample.Time) {
+			existing.sample.Time = s.Time
+		}
+	}
+
+	averaged := []Sample{}
+	for _, key := range order {
+		s := sums[key]
+		s.sample.Value = s.total / float64(s.count)
+		averaged = append(averaged, s.sample)
+	}
+	return averaged
+}
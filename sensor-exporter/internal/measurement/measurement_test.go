@@ -0,0 +1,74 @@
+package measurement
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAverage(t *testing.T) {
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	room := Source{Sensor: "room", Model: "AHT20"}
+	attic := Source{Sensor: "attic", Model: "AHT20"}
+	sample := func(source Source, measurement string, value float64, valid bool, seconds int) Sample {
+		return Sample{
+			Source:      source,
+			Measurement: measurement,
+			Value:       value,
+			Valid:       valid,
+			Time:        start.Add(time.Duration(seconds) * time.Second),
+		}
+	}
+	tests := []struct {
+		name     string
+		samples  []Sample
+		averaged []string
+	}{
+		{
+			name:     "no samples",
+			samples:  []Sample{},
+			averaged: []string{},
+		},
+		{
+			name: "mean of each measurement in the order first seen",
+			samples: []Sample{
+				sample(room, "temperature", 20, true, 0),
+				sample(room, "relative_humidity", 0.4, true, 0),
+				sample(room, "temperature", 21, true, 1),
+				sample(room, "relative_humidity", 0.5, true, 1),
+				sample(room, "temperature", 22.5, true, 2),
+			},
+			averaged: []string{"room/temperature 21.166666666666668 valid=true 2s", "room/relative_humidity 0.45 valid=true 1s"},
+		},
+		{
+			name: "invalid if any sample is invalid",
+			samples: []Sample{
+				sample(room, "temperature", 20, true, 0),
+				sample(room, "temperature", 30, false, 1),
+			},
+			averaged: []string{"room/temperature 25 valid=false 1s"},
+		},
+		{
+			name: "sensors kept apart",
+			samples: []Sample{
+				sample(room, "temperature", 20, true, 1),
+				sample(attic, "temperature", 10, true, 0),
+				sample(room, "temperature", 22, true, 0),
+			},
+			averaged: []string{"room/temperature 21 valid=true 1s", "attic/temperature 10 valid=true 0s"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			averaged := []string{}
+			for _, s := range Average(test.samples) {
+				averaged = append(averaged, fmt.Sprintf("%v/%v %v valid=%v %v", s.Sensor, s.Measurement, s.Value, s.Valid, s.Time.Sub(start)))
+			}
+			if strings.Join(averaged, ",") != strings.Join(test.averaged, ",") {
+				t.Errorf("got %v, want %v", averaged, test.averaged)
+			}
+		})
+	}
+}
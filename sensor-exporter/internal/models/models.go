@@ -0,0 +1,9 @@
+// Package models names the supported sensor models, as given in the model setting of a sensor
+package models
+
+// Supported sensor models
+const (
+	AHT20   string = "aht20"
+	SGP30   string = "sgp30"
+	PMS5003 string = "pms5003"
+)
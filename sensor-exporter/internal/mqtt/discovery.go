@@ -0,0 +1,112 @@
+package mqtt
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// entity describes how a measurement is presented as a Home Assistant sensor entity
+type entity struct {
+	measurement string
+	name        string
+	deviceClass string
+	unit        string
+	// Format of the expression converting the published value into the unit of the entity, if it needs converting
+	conversion string
+}
+
+// entities are the measurements announced to Home Assistant for each model
+var entities = map[string][]entity{
+	"AHT20": {
+		{measurement: "temperature", name: "Temperature", deviceClass: "temperature", unit: "°C"},
+		{measurement: "relative_humidity", name: "Humidity", deviceClass: "humidity", unit: "%", conversion: "(%v * 100) | round(1)"},
+	},
+	"PMS5003": {
+		{measurement: "pm1_0_environmental", name: "PM1", deviceClass: "pm1", unit: "µg/m³"},
+		{measurement: "pm2_5_environmental", name: "PM2.5", deviceClass: "pm25", unit: "µg/m³"},
+		{measurement: "pm10_environmental", name: "PM10", deviceClass: "pm10", unit: "µg/m³"},
+	},
+	"SGP30": {
+		{measurement: "eco2", name: "eCO2", deviceClass: "carbon_dioxide", unit: "ppm"},
+		{measurement: "tvoc", name: "TVOC", deviceClass: "volatile_organic_compounds_parts", unit: "ppb"},
+	},
+}
+
+var manufacturers = map[string]string{
+	"AHT20":   "Aosong",
+	"PMS5003": "Plantower",
+	"SGP30":   "Sensirion",
+}
+
+// discoveryConfig is the Home Assistant MQTT discovery payload of a sensor entity
+type discoveryConfig struct {
+	Name              string               `json:"name"`
+	UniqueID          string               `json:"unique_id"`
+	StateTopic        string               `json:"state_topic"`
+	ValueTemplate     string               `json:"value_template"`
+	UnitOfMeasurement string               `json:"unit_of_measurement"`
+	DeviceClass       string               `json:"device_class"`
+	StateClass        string               `json:"state_class"`
+	Availability      []availabilityConfig `json:"availability"`
+	AvailabilityMode  string               `json:"availability_mode"`
+	Device            deviceConfig         `json:"device"`
+}
+
+type availabilityConfig struct {
+	Topic string `json:"topic"`
+}
+
+type deviceConfig struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Model        string   `json:"model"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+}
+
+var invalidIDCharacters = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// objectID returns an identifier made of the characters Home Assistant allows in discovery topics
+func objectID(parts ...string) string {
+	return invalidIDCharacters.ReplaceAllString(strings.Join(parts, "_"), "_")
+}
+
+// discoveryTopic returns the topic on which the config of an entity is announced
+func (p *Publisher) discoveryTopic(device Device, e entity) string {
+	return fmt.Sprintf("%v/sensor/%v/%v/config", p.settings.DiscoveryPrefix, objectID(p.settings.Node), objectID(device.Name, e.measurement))
+}
+
+// discoveryConfig returns the config announcing a measurement of a device as a Home Assistant entity
+func (p *Publisher) discoveryConfig(device Device, e entity) discoveryConfig {
+	stateTopic := p.stateTopic(device.Name, e.measurement)
+	value := "value_json." + e.measurement
+	if p.settings.Format == FormatValue {
+		value = "value | float"
+	}
+	if e.conversion != "" {
+		value = fmt.Sprintf(e.conversion, value)
+	}
+	valueTemplate := fmt.Sprintf("{{ %v }}", value)
+
+	deviceID := objectID("sensor-exporter", p.settings.Node, device.Name)
+	return discoveryConfig{
+		Name:              e.name,
+		UniqueID:          objectID(deviceID, e.measurement),
+		StateTopic:        stateTopic,
+		ValueTemplate:     valueTemplate,
+		UnitOfMeasurement: e.unit,
+		DeviceClass:       e.deviceClass,
+		StateClass:        "measurement",
+		Availability: []availabilityConfig{
+			{Topic: p.statusTopic},
+			{Topic: p.availabilityTopic(device.Name)},
+		},
+		AvailabilityMode: "all",
+		Device: deviceConfig{
+			Identifiers:  []string{deviceID},
+			Name:         fmt.Sprintf("%v %v", p.settings.Node, device.Name),
+			Model:        device.Model,
+			Manufacturer: manufacturers[device.Model],
+		},
+	}
+}
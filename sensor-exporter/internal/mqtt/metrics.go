@@ -0,0 +1,40 @@
+package mqtt
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	connected = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "sensor_exporter_mqtt_connected",
+			Help: "Whether the exporter is connected to the MQTT broker (1) or not (0)",
+		},
+	)
+	publishedMessages = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_mqtt_published_messages_total",
+			Help: "Number of reading messages published to the MQTT broker",
+		},
+	)
+	bufferedMessages = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "sensor_exporter_mqtt_buffered_messages",
+			Help: "Number of reading messages waiting for the connection to the MQTT broker",
+		},
+	)
+	droppedMessages = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_mqtt_dropped_messages_total",
+			Help: "Number of reading messages dropped because the buffer was full while the MQTT broker was unreachable",
+		},
+	)
+)
+
+// Collectors returns the metrics of the MQTT publisher for registration
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		connected,
+		publishedMessages,
+		bufferedMessages,
+		droppedMessages,
+	}
+}
@@ -0,0 +1,400 @@
+// Package mqtt publishes sensor samples to an MQTT broker, announcing the sensors to Home Assistant through MQTT
+// discovery and reporting their availability
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sensor-exporter/internal/measurement"
+	"strconv"
+	"text/template"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+// Formats of the published readings
+const (
+	// FormatJSON publishes the latest value of every measurement of a sensor as one JSON object
+	FormatJSON = "json"
+	// FormatValue publishes each measurement as a plain value on its own topic below the topic of the sensor
+	FormatValue = "value"
+)
+
+const (
+	// availabilityInterval is how often the availability of the sensors is checked for changes
+	availabilityInterval = 5 * time.Second
+	// publishTimeout is how long a publish may wait for the broker before the message is kept for later
+	publishTimeout = 10 * time.Second
+)
+
+// Settings configure the connection to the broker and what is published
+type Settings struct {
+	// URL of the broker, e.g. tcp://localhost:1883
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// Name identifying the exporter in topics and in Home Assistant
+	Node string
+	// Template of the topic of a sensor with the fields Node, Sensor and Model
+	Topic string
+	// Template of the topic on which the exporter reports itself online, with the field Node
+	StatusTopic string
+	// Format of the published readings: json or value
+	Format string
+	QoS    byte
+	// Whether readings are retained by the broker
+	Retain bool
+	// Whether to announce the sensors through Home Assistant MQTT discovery
+	Discovery       bool
+	DiscoveryPrefix string
+	// Maximum number of reading messages kept while the broker is unreachable
+	BufferSize int
+	// Shortest duration between two publications of the readings of a sensor, so that fast sensors such as the SGP30 do
+	// not publish a retained message for every reading; 0 publishes every reading
+	Interval time.Duration
+}
+
+// Device is a configured sensor whose readings are published
+type Device struct {
+	// Name of the sensor as configured in the exporter
+	Name string
+	// Model of the sensor, e.g. SGP30
+	Model string
+}
+
+// Availability reports whether a sensor is online
+type Availability func(sensor string) bool
+
+type message struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+// Publisher publishes the samples of the sensors to an MQTT broker
+type Publisher struct {
+	settings     Settings
+	devices      []Device
+	available    Availability
+	sensorTopics map[string]string
+	statusTopic  string
+	client       paho.Client
+	connects     chan struct{}
+	pending      []message
+	// Latest sample of each measurement of a sensor, and the newest of them
+	latest map[string]map[string]measurement.Sample
+	newest map[string]measurement.Sample
+	// Measurements of a sensor with samples that are not published yet, and when the sensor was last published
+	changed   map[string]map[string]bool
+	published map[string]time.Time
+	online    map[string]bool
+}
+
+type topicFields struct {
+	Node   string
+	Sensor string
+	Model  string
+}
+
+// NewPublisher validates the settings and prepares the topics of the devices
+func NewPublisher(settings Settings, devices []Device, available Availability) (*Publisher, error) {
+	if settings.Format != FormatJSON && settings.Format != FormatValue {
+		return nil, errors.Errorf("failed to configure MQTT with unknown format %q; expected json or value", settings.Format)
+	}
+	if settings.QoS > 2 {
+		return nil, errors.Errorf("failed to configure MQTT with QoS %v; expected 0, 1 or 2", settings.QoS)
+	}
+	if settings.BufferSize <= 0 {
+		return nil, errors.Errorf("failed to configure MQTT with buffer size %v; expected at least 1", settings.BufferSize)
+	}
+	if settings.Interval < 0 {
+		return nil, errors.Errorf("failed to configure MQTT with interval %v; expected 0 or more", settings.Interval)
+	}
+
+	statusTopic, err := renderTopic(settings.StatusTopic, topicFields{Node: settings.Node})
+	if err != nil {
+		return nil, err
+	}
+
+	sensorTopics := map[string]string{}
+	for _, device := range devices {
+		topic, err := renderTopic(settings.Topic, topicFields{Node: settings.Node, Sensor: device.Name, Model: device.Model})
+		if err != nil {
+			return nil, err
+		}
+		sensorTopics[device.Name] = topic
+	}
+
+	return &Publisher{
+		settings:     settings,
+		devices:      devices,
+		available:    available,
+		sensorTopics: sensorTopics,
+		statusTopic:  statusTopic,
+		connects:     make(chan struct{}, 1),
+		latest:       map[string]map[string]measurement.Sample{},
+		newest:       map[string]measurement.Sample{},
+		changed:      map[string]map[string]bool{},
+		published:    map[string]time.Time{},
+		online:       map[string]bool{},
+	}, nil
+}
+
+func renderTopic(text string, fields topicFields) (string, error) {
+	t, err := template.New("topic").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse MQTT topic template")
+	}
+	buffer := &bytes.Buffer{}
+	err = t.Execute(buffer, fields)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to render MQTT topic")
+	}
+	return buffer.String(), nil
+}
+
+// stateTopic returns the topic on which a measurement of a sensor is published
+func (p *Publisher) stateTopic(sensor string, measurement string) string {
+	if p.settings.Format == FormatValue {
+		return p.sensorTopics[sensor] + "/" + measurement
+	}
+	return p.sensorTopics[sensor]
+}
+
+// availabilityTopic returns the topic on which a sensor is reported online or offline
+func (p *Publisher) availabilityTopic(sensor string) string {
+	return p.sensorTopics[sensor] + "/availability"
+}
+
+// Start connects to the broker and publishes the samples received until the context is done, at most once per interval
+// for each sensor. While the broker is unreachable, reading messages are buffered up to the buffer size, dropping the
+// oldest first.
+func (p *Publisher) Start(ctx context.Context, samples <-chan []measurement.Sample) func() error {
+	return func() error {
+		options := paho.NewClientOptions().
+			AddBroker(p.settings.Broker).
+			SetClientID(p.settings.ClientID).
+			SetUsername(p.settings.Username).
+			SetPassword(p.settings.Password).
+			SetWill(p.statusTopic, "offline", p.settings.QoS, true).
+			SetAutoReconnect(true).
+			SetConnectRetry(true).
+			SetConnectRetryInterval(10 * time.Second).
+			SetMaxReconnectInterval(2 * time.Minute).
+			SetOnConnectHandler(func(paho.Client) {
+				select {
+				case p.connects <- struct{}{}:
+				default:
+				}
+			}).
+			SetConnectionLostHandler(func(_ paho.Client, err error) {
+				connected.Set(0)
+				log.Warn("lost connection to MQTT broker",
+					"err", err,
+					"broker", p.settings.Broker)
+			})
+		p.client = paho.NewClient(options)
+
+		log.Info("connecting to MQTT broker",
+			"broker", p.settings.Broker,
+			"clientID", p.settings.ClientID)
+		p.client.Connect()
+
+		ticker := time.NewTicker(availabilityInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.connects:
+				connected.Set(1)
+				log.Info("connected to MQTT broker",
+					"broker", p.settings.Broker)
+				p.announce()
+				p.flush()
+			case batch, ok := <-samples:
+				if !ok {
+					p.disconnect()
+					return nil
+				}
+				p.handle(batch)
+				p.publishDue(time.Now())
+				p.flush()
+			case <-ticker.C:
+				p.updateAvailability(false)
+				p.publishDue(time.Now())
+				p.flush()
+			case <-ctx.Done():
+				p.disconnect()
+				return nil
+			}
+		}
+	}
+}
+
+// announce publishes the state that is retained by the broker, which is repeated on every connect since the broker
+// may have lost it
+func (p *Publisher) announce() {
+	p.publishNow(message{p.statusTopic, []byte("online"), true})
+	if p.settings.Discovery {
+		for _, device := range p.devices {
+			for _, e := range entities[device.Model] {
+				payload, err := json.Marshal(p.discoveryConfig(device, e))
+				if err != nil {
+					log.Error("failed to encode Home Assistant discovery config",
+						"err", err,
+						"sensor", device.Name)
+					continue
+				}
+				p.publishNow(message{p.discoveryTopic(device, e), payload, true})
+			}
+		}
+	}
+	p.updateAvailability(true)
+}
+
+// updateAvailability publishes the availability of the sensors that changed, or of every sensor if forced
+func (p *Publisher) updateAvailability(force bool) {
+	for _, device := range p.devices {
+		online := p.available(device.Name)
+		if !force && p.online[device.Name] == online {
+			continue
+		}
+
+		payload := "offline"
+		if online {
+			payload = "online"
+		}
+		if p.publishNow(message{p.availabilityTopic(device.Name), []byte(payload), true}) {
+			p.online[device.Name] = online
+		}
+	}
+}
+
+// handle records a batch of samples to be published. Readings of some sensors arrive in several batches, such as SGP30
+// air quality and raw signals, so the latest sample of each measurement is kept.
+func (p *Publisher) handle(batch []measurement.Sample) {
+	if len(batch) == 0 {
+		return
+	}
+	sensor := batch[0].Sensor
+	if _, ok := p.sensorTopics[sensor]; !ok {
+		return
+	}
+
+	latest, ok := p.latest[sensor]
+	if !ok {
+		latest = map[string]measurement.Sample{}
+		p.latest[sensor] = latest
+		p.changed[sensor] = map[string]bool{}
+	}
+	for _, sample := range batch {
+		latest[sample.Measurement] = sample
+		p.changed[sensor][sample.Measurement] = true
+	}
+	p.newest[sensor] = batch[0]
+}
+
+// publishDue queues the messages publishing the sensors with unpublished samples whose interval has passed
+func (p *Publisher) publishDue(now time.Time) {
+	for sensor, changed := range p.changed {
+		if len(changed) == 0 || now.Sub(p.published[sensor]) < p.settings.Interval {
+			continue
+		}
+		p.published[sensor] = now
+		p.changed[sensor] = map[string]bool{}
+
+		if p.settings.Format == FormatValue {
+			for name := range changed {
+				payload := []byte(strconv.FormatFloat(p.latest[sensor][name].Value, 'f', -1, 64))
+				p.enqueue(message{p.stateTopic(sensor, name), payload, p.settings.Retain})
+			}
+			continue
+		}
+
+		payload, err := json.Marshal(jsonState(p.newest[sensor], p.latest[sensor]))
+		if err != nil {
+			log.Error("failed to encode MQTT state",
+				"err", err,
+				"sensor", sensor)
+			continue
+		}
+		p.enqueue(message{p.stateTopic(sensor, ""), payload, p.settings.Retain})
+	}
+}
+
+// jsonState returns the JSON state of a sensor, with the latest value of each measurement as a field, whether all
+// of them are valid and the time of the latest reading
+func jsonState(latestSample measurement.Sample, latest map[string]measurement.Sample) map[string]interface{} {
+	state := map[string]interface{}{
+		"sensor": latestSample.Sensor,
+		"model":  latestSample.Model,
+		"time":   latestSample.Time,
+	}
+	if latestSample.Serial != "" {
+		state["serial"] = latestSample.Serial
+	}
+
+	valid := true
+	for name, sample := range latest {
+		state[name] = sample.Value
+		valid = valid && sample.Valid
+	}
+	state["valid"] = valid
+	return state
+}
+
+func (p *Publisher) enqueue(m message) {
+	if len(p.pending) >= p.settings.BufferSize {
+		p.pending = p.pending[1:]
+		droppedMessages.Inc()
+	}
+	p.pending = append(p.pending, m)
+	bufferedMessages.Set(float64(len(p.pending)))
+}
+
+// flush publishes the buffered messages in order while the connection is open
+func (p *Publisher) flush() {
+	for len(p.pending) > 0 {
+		if !p.publishNow(p.pending[0]) {
+			break
+		}
+		p.pending = p.pending[1:]
+		publishedMessages.Inc()
+	}
+	bufferedMessages.Set(float64(len(p.pending)))
+}
+
+// publishNow publishes a message if the connection is open and returns whether the broker accepted it
+func (p *Publisher) publishNow(m message) bool {
+	if !p.client.IsConnectionOpen() {
+		return false
+	}
+
+	token := p.client.Publish(m.topic, p.settings.QoS, m.retain, m.payload)
+	if !token.WaitTimeout(publishTimeout) {
+		log.Warn("failed to publish MQTT message in time",
+			"topic", m.topic)
+		return false
+	}
+	if token.Error() != nil {
+		log.Warn("failed to publish MQTT message",
+			"err", token.Error(),
+			"topic", m.topic)
+		return false
+	}
+	return true
+}
+
+// disconnect reports the exporter offline, since a clean disconnect does not send the will, and closes the connection
+func (p *Publisher) disconnect() {
+	p.publishNow(message{p.statusTopic, []byte("offline"), true})
+	p.client.Disconnect(250)
+	connected.Set(0)
+	log.Info("disconnected from MQTT broker",
+		"broker", p.settings.Broker,
+		"unpublished", len(p.pending))
+}
@@ -0,0 +1,242 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sensor-exporter/internal/measurement"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	broker "github.com/mochi-co/mqtt/server"
+	"github.com/mochi-co/mqtt/server/listeners"
+)
+
+// startBroker runs an embedded broker on a free local port and returns its URL
+func startBroker(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	server := broker.NewServer(nil)
+	err = server.AddListener(listeners.NewTCP("test", address), nil)
+	if err != nil {
+		t.Fatalf("failed to add broker listener: %v", err)
+	}
+	err = server.Serve()
+	if err != nil {
+		t.Fatalf("failed to start broker: %v", err)
+	}
+	t.Cleanup(func() {
+		server.Close()
+	})
+	return "tcp://" + address
+}
+
+// recorder collects the messages published below a topic
+type recorder struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func subscribe(t *testing.T, url string, topic string) *recorder {
+	t.Helper()
+	r := &recorder{}
+	client := paho.NewClient(paho.NewClientOptions().AddBroker(url).SetClientID("test-subscriber"))
+	token := client.Connect()
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("failed to connect subscriber: %v", token.Error())
+	}
+	token = client.Subscribe(topic, 1, func(_ paho.Client, m paho.Message) {
+		if strings.HasSuffix(m.Topic(), "/availability") {
+			return
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.messages = append(r.messages, fmt.Sprintf("%s %s", m.Topic(), m.Payload()))
+	})
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("failed to subscribe: %v", token.Error())
+	}
+	t.Cleanup(func() {
+		client.Disconnect(0)
+	})
+	return r
+}
+
+// wait returns the messages once count have arrived, or those that arrived by the timeout
+func (r *recorder) wait(count int, timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for {
+		r.mu.Lock()
+		messages := append([]string{}, r.messages...)
+		r.mu.Unlock()
+		if len(messages) >= count || time.Now().After(deadline) {
+			return messages
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func sample(name string, value float64) measurement.Sample {
+	return measurement.Sample{
+		Source:      measurement.Source{Sensor: "air", Model: "SGP30"},
+		Measurement: name,
+		Value:       value,
+		Valid:       true,
+		Time:        time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+// jsonValues formats the measurement values of a JSON state message, ignoring its other fields
+func jsonValues(t *testing.T, message string) string {
+	t.Helper()
+	topic, payload, _ := strings.Cut(message, " ")
+	state := map[string]interface{}{}
+	err := json.Unmarshal([]byte(payload), &state)
+	if err != nil {
+		t.Fatalf("failed to decode state %v: %v", payload, err)
+	}
+	values := []string{}
+	for name, value := range state {
+		if value, ok := value.(float64); ok {
+			values = append(values, fmt.Sprintf("%s=%v", name, value))
+		}
+	}
+	sort.Strings(values)
+	return topic + " " + strings.Join(values, ",")
+}
+
+func TestPublisher(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		interval time.Duration
+		batches  [][]measurement.Sample
+		messages []string
+	}{
+		{
+			name:   "every batch without an interval",
+			format: FormatJSON,
+			batches: [][]measurement.Sample{
+				{sample("eco2", 400), sample("tvoc", 0)},
+				{sample("h2_raw", 13000)},
+			},
+			messages: []string{
+				"test/air eco2=400,tvoc=0",
+				"test/air eco2=400,h2_raw=13000,tvoc=0",
+			},
+		},
+		{
+			name:     "first batch within the interval",
+			format:   FormatJSON,
+			interval: time.Hour,
+			batches: [][]measurement.Sample{
+				{sample("eco2", 400), sample("tvoc", 0)},
+				{sample("h2_raw", 13000)},
+				{sample("h2_raw", 13001)},
+			},
+			messages: []string{
+				"test/air eco2=400,tvoc=0",
+			},
+		},
+		{
+			name:   "one topic per measurement",
+			format: FormatValue,
+			batches: [][]measurement.Sample{
+				{sample("eco2", 400), sample("tvoc", 12.5)},
+			},
+			messages: []string{
+				"test/air/eco2 400",
+				"test/air/tvoc 12.5",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			url := startBroker(t)
+			received := subscribe(t, url, "test/#")
+
+			publisher, err := NewPublisher(Settings{
+				Broker:      url,
+				ClientID:    "test-publisher",
+				Node:        "node",
+				Topic:       "test/{{.Sensor}}",
+				StatusTopic: "status/{{.Node}}",
+				Format:      test.format,
+				QoS:         1,
+				BufferSize:  10,
+				Interval:    test.interval,
+			}, []Device{{Name: "air", Model: "SGP30"}}, func(string) bool { return true })
+			if err != nil {
+				t.Fatalf("failed to create publisher: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			samples := make(chan []measurement.Sample)
+			done := make(chan error)
+			go func() {
+				done <- publisher.Start(ctx, samples)()
+			}()
+			for _, batch := range test.batches {
+				samples <- batch
+			}
+
+			// waiting past the expected messages catches any that should not have been published
+			messages := received.wait(len(test.messages)+1, time.Second)
+			cancel()
+			<-done
+
+			if test.format == FormatJSON {
+				for i := range messages {
+					messages[i] = jsonValues(t, messages[i])
+				}
+			} else {
+				// the measurements of a sensor are published in no particular order
+				sort.Strings(messages)
+			}
+			if strings.Join(messages, "\n") != strings.Join(test.messages, "\n") {
+				t.Errorf("got messages\n%v\nwant\n%v", strings.Join(messages, "\n"), strings.Join(test.messages, "\n"))
+			}
+		})
+	}
+}
+
+func TestNewPublisherRejectsInvalidSettings(t *testing.T) {
+	valid := Settings{Format: FormatJSON, QoS: 1, BufferSize: 1}
+	tests := []struct {
+		name   string
+		modify func(settings *Settings)
+	}{
+		{"unknown format", func(settings *Settings) { settings.Format = "xml" }},
+		{"QoS above 2", func(settings *Settings) { settings.QoS = 3 }},
+		{"no buffer", func(settings *Settings) { settings.BufferSize = 0 }},
+		{"negative buffer", func(settings *Settings) { settings.BufferSize = -1 }},
+		{"negative interval", func(settings *Settings) { settings.Interval = -time.Second }},
+	}
+
+	_, err := NewPublisher(valid, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create publisher with valid settings: %v", err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := valid
+			test.modify(&settings)
+			_, err := NewPublisher(settings, nil, nil)
+			if err == nil {
+				t.Errorf("created publisher with invalid settings %+v", settings)
+			}
+		})
+	}
+}
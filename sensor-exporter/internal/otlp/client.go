@@ -0,0 +1,254 @@
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
+)
+
+// maxResponseSize limits how much of a response is read, which for a successful export is at most a partial success
+const maxResponseSize = 64 << 10
+
+// grpcExportPath is the method through which metrics are exported over gRPC
+const grpcExportPath = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+
+// gRPC status codes after which the OTLP specification allows retrying an export
+var retryableGRPCCodes = map[int]bool{
+	1:  true, // CANCELLED
+	4:  true, // DEADLINE_EXCEEDED
+	8:  true, // RESOURCE_EXHAUSTED
+	10: true, // ABORTED
+	11: true, // OUT_OF_RANGE
+	14: true, // UNAVAILABLE
+	15: true, // DATA_LOSS
+}
+
+// client sends an encoded ExportMetricsServiceRequest and returns the encoded response
+type client interface {
+	export(ctx context.Context, request []byte) ([]byte, error)
+}
+
+// permanentError is a failure that retrying the same request will not resolve, such as data the collector rejects
+type permanentError struct {
+	error
+}
+
+func newClient(settings Settings) (client, error) {
+	u, err := url.Parse(settings.Endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse OTLP endpoint %v", settings.Endpoint)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("failed to configure OTLP output with endpoint %v; expected an http or https URL", settings.Endpoint)
+	}
+	if settings.Compression != "gzip" && settings.Compression != "none" {
+		return nil, errors.Errorf("failed to configure OTLP output with compression %v; expected gzip or none", settings.Compression)
+	}
+
+	switch settings.Protocol {
+	case "http/protobuf":
+		if u.Path == "" || u.Path == "/" {
+			u.Path = "/v1/metrics"
+		}
+		return &httpClient{
+			url:      u.String(),
+			headers:  settings.Headers,
+			compress: settings.Compression == "gzip",
+			client:   &http.Client{Timeout: settings.Timeout},
+		}, nil
+	case "grpc":
+		transport := &http2.Transport{}
+		if u.Scheme == "http" {
+			// Plaintext gRPC speaks HTTP/2 without TLS
+			transport.AllowHTTP = true
+			transport.DialTLS = func(network, address string, _ *tls.Config) (net.Conn, error) {
+				return net.DialTimeout(network, address, settings.Timeout)
+			}
+		}
+		u.Path = grpcExportPath
+		return &grpcClient{
+			url:      u.String(),
+			headers:  settings.Headers,
+			compress: settings.Compression == "gzip",
+			client:   &http.Client{Transport: transport, Timeout: settings.Timeout},
+		}, nil
+	default:
+		return nil, errors.Errorf("failed to configure OTLP output with protocol %v; expected grpc or http/protobuf", settings.Protocol)
+	}
+}
+
+// httpClient exports over OTLP/HTTP with binary protobuf bodies
+type httpClient struct {
+	url      string
+	headers  map[string]string
+	compress bool
+	client   *http.Client
+}
+
+func (c *httpClient) export(ctx context.Context, request []byte) ([]byte, error) {
+	body := request
+	if c.compress {
+		var err error
+		body, err = gzipped(request)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create OTLP export request")
+	}
+	for name, value := range c.headers {
+		httpRequest.Header.Set(name, value)
+	}
+	httpRequest.Header.Set("Content-Type", "application/x-protobuf")
+	if c.compress {
+		httpRequest.Header.Set("Content-Encoding", "gzip")
+	}
+
+	response, err := c.client.Do(httpRequest)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to export to OTLP endpoint")
+	}
+	defer response.Body.Close()
+	responseBody, err := ioutil.ReadAll(io.LimitReader(response.Body, maxResponseSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read OTLP export response")
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return responseBody, nil
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return nil, errors.Errorf("failed to export to OTLP endpoint; endpoint responded %v", response.Status)
+	default:
+		return nil, permanentError{errors.Errorf("failed to export to OTLP endpoint; endpoint rejected metrics with %v", response.Status)}
+	}
+}
+
+// grpcClient exports over OTLP/gRPC, framing the unary call by hand on top of HTTP/2
+type grpcClient struct {
+	url      string
+	headers  map[string]string
+	compress bool
+	client   *http.Client
+}
+
+func (c *grpcClient) export(ctx context.Context, request []byte) ([]byte, error) {
+	message := request
+	compressed := byte(0)
+	if c.compress {
+		var err error
+		message, err = gzipped(request)
+		if err != nil {
+			return nil, err
+		}
+		compressed = 1
+	}
+	body := make([]byte, 5, 5+len(message))
+	body[0] = compressed
+	binary.BigEndian.PutUint32(body[1:], uint32(len(message)))
+	body = append(body, message...)
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create OTLP export request")
+	}
+	for name, value := range c.headers {
+		httpRequest.Header.Set(strings.ToLower(name), value)
+	}
+	httpRequest.Header.Set("Content-Type", "application/grpc")
+	httpRequest.Header.Set("TE", "trailers")
+	if c.compress {
+		httpRequest.Header.Set("grpc-encoding", "gzip")
+	}
+
+	response, err := c.client.Do(httpRequest)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to export to OTLP endpoint")
+	}
+	defer response.Body.Close()
+	responseBody, err := ioutil.ReadAll(io.LimitReader(response.Body, maxResponseSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read OTLP export response")
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to export to OTLP endpoint; endpoint responded %v", response.Status)
+	}
+
+	// A call that fails before responding has its status in the headers instead of the trailers
+	status := response.Trailer.Get("grpc-status")
+	statusMessage := response.Trailer.Get("grpc-message")
+	if status == "" {
+		status = response.Header.Get("grpc-status")
+		statusMessage = response.Header.Get("grpc-message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return nil, errors.Errorf("failed to export to OTLP endpoint; response has invalid gRPC status %q", status)
+	}
+	if code != 0 {
+		if unescaped, err := url.PathUnescape(statusMessage); err == nil {
+			statusMessage = unescaped
+		}
+		err = errors.Errorf("failed to export to OTLP endpoint; endpoint responded with gRPC status %v: %v", code, statusMessage)
+		if retryableGRPCCodes[code] {
+			return nil, err
+		}
+		return nil, permanentError{err}
+	}
+
+	return unframe(responseBody, response.Header.Get("grpc-encoding"))
+}
+
+// unframe returns the message of a gRPC response, which is empty if the collector accepted everything
+func unframe(body []byte, encoding string) ([]byte, error) {
+	if len(body) == 0 {
+		return nil, nil
+	}
+	if len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+		return nil, errors.New("failed to decode OTLP export response; invalid gRPC framing")
+	}
+	message := body[5:]
+	if body[0] == 0 {
+		return message, nil
+	}
+	if encoding != "gzip" {
+		return nil, errors.Errorf("failed to decode OTLP export response with unsupported encoding %q", encoding)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(message))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress OTLP export response")
+	}
+	decompressed, err := ioutil.ReadAll(io.LimitReader(reader, maxResponseSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress OTLP export response")
+	}
+	return decompressed, nil
+}
+
+func gzipped(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	_, err := writer.Write(data)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compress OTLP export request")
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,258 @@
+// Package otlp exports sensor readings as OpenTelemetry gauges to an OTLP collector over gRPC or HTTP
+package otlp
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sensor-exporter/internal/measurement"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+// Settings configure the collector and how readings are exported to it
+type Settings struct {
+	// URL of the collector, e.g. http://collector:4317 for gRPC or http://collector:4318 for HTTP
+	Endpoint string
+	// Either grpc or http/protobuf
+	Protocol string
+	// Headers sent with every export, e.g. for authentication
+	Headers map[string]string
+	// Either gzip or none
+	Compression string
+	// How often the readings received since the last export are exported
+	Interval time.Duration
+	// Time limit of a single export
+	Timeout time.Duration
+	// Number of data points kept while the collector is unreachable
+	BufferSize int
+	// Resource attributes added to or overriding the ones describing the device
+	ResourceAttributes map[string]string
+	// Version of the exporter, reported in the resource and instrumentation scope
+	Version string
+}
+
+// Exporter batches the readings of the sensors into gauges and exports them to the collector
+type Exporter struct {
+	settings Settings
+	client   client
+	resource []attribute
+	rooms    map[string]string
+	pending  []measurement.Sample
+}
+
+// UCUM units of the measurements, as OpenTelemetry expects them
+var ucumUnits = map[string]string{
+	measurement.UnitCelsius:                 "Cel",
+	measurement.UnitRatio:                   "1",
+	measurement.UnitGramsPerCubicMeter:      "g/m3",
+	measurement.UnitMicrogramsPerCubicMeter: "ug/m3",
+	measurement.UnitParticlesPerDeciliter:   "{particles}/dL",
+	measurement.UnitPartsPerMillion:         "[ppm]",
+	measurement.UnitPartsPerBillion:         "[ppb]",
+	measurement.UnitRawSignal:               "{signal}",
+}
+
+// NewExporter validates the settings. Rooms maps sensor names to the room each sensor is placed in, if configured.
+func NewExporter(settings Settings, rooms map[string]string) (*Exporter, error) {
+	client, err := newClient(settings)
+	if err != nil {
+		return nil, err
+	}
+	resource, err := resourceAttributes(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Exporter{
+		settings: settings,
+		client:   client,
+		resource: resource,
+		rooms:    rooms,
+	}, nil
+}
+
+// resourceAttributes identifies the device following the OpenTelemetry semantic conventions for services and hosts
+func resourceAttributes(settings Settings) ([]attribute, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to determine hostname for OTLP resource")
+	}
+
+	attributes := map[string]string{
+		"service.name":        "sensor-exporter",
+		"service.version":     settings.Version,
+		"service.instance.id": hostname,
+		"host.name":           hostname,
+		"host.arch":           hostArch(),
+		"os.type":             runtime.GOOS,
+	}
+	if machineID, err := ioutil.ReadFile("/etc/machine-id"); err == nil {
+		attributes["host.id"] = strings.TrimSpace(string(machineID))
+	}
+	for key, value := range settings.ResourceAttributes {
+		attributes[key] = value
+	}
+
+	resource := []attribute{}
+	for key, value := range attributes {
+		if value != "" {
+			resource = append(resource, attribute{key, value})
+		}
+	}
+	sort.Slice(resource, func(i, j int) bool {
+		return resource[i].key < resource[j].key
+	})
+	return resource, nil
+}
+
+// hostArch returns the architecture by the names the semantic conventions use
+func hostArch() string {
+	switch runtime.GOARCH {
+	case "arm":
+		return "arm32"
+	case "386":
+		return "x86"
+	case "ppc64le":
+		return "ppc64"
+	default:
+		return runtime.GOARCH
+	}
+}
+
+// Start exports the samples received every interval until the context is done, at which point the remaining samples
+// are exported once more
+func (e *Exporter) Start(ctx context.Context, samples <-chan []measurement.Sample) func() error {
+	return func() error {
+		log.Info("exporting to OTLP collector",
+			"endpoint", e.settings.Endpoint,
+			"protocol", e.settings.Protocol)
+
+		ticker := time.NewTicker(e.settings.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case batch, ok := <-samples:
+				if !ok {
+					e.flush(context.Background())
+					return nil
+				}
+				e.add(batch)
+			case <-ticker.C:
+				e.flush(ctx)
+			case <-ctx.Done():
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), e.settings.Timeout)
+				e.flush(shutdownCtx)
+				cancel()
+				return nil
+			}
+		}
+	}
+}
+
+// add buffers the valid samples of a reading, dropping the oldest samples beyond the buffer size. Samples that are
+// not valid yet, such as SGP30 readings while it acclimates, are not exported.
+func (e *Exporter) add(batch []measurement.Sample) {
+	for _, sample := range batch {
+		if sample.Valid {
+			e.pending = append(e.pending, sample)
+		}
+	}
+	if excess := len(e.pending) - e.settings.BufferSize; excess > 0 {
+		droppedPoints.Add(float64(excess))
+		e.pending = append([]measurement.Sample{}, e.pending[excess:]...)
+	}
+	bufferedPoints.Set(float64(len(e.pending)))
+}
+
+// flush exports the buffered samples, keeping them for the next interval if the export may succeed on retry
+func (e *Exporter) flush(ctx context.Context) {
+	if len(e.pending) == 0 {
+		return
+	}
+	count := len(e.pending)
+	request := encodeExportRequest(e.resource, scope{"sensor-exporter", e.settings.Version}, e.gauges())
+
+	response, err := e.client.export(ctx, request)
+	if err != nil {
+		exportErrors.Inc()
+		if _, permanent := err.(permanentError); !permanent {
+			log.Warn("failed to export to OTLP collector; data points are kept for retry",
+				"err", err,
+				"points", count)
+			return
+		}
+		log.Error("dropping data points rejected by OTLP collector",
+			"err", err,
+			"points", count)
+		droppedPoints.Add(float64(count))
+		e.reset()
+		return
+	}
+
+	rejected, message, err := decodePartialSuccess(response)
+	if err != nil {
+		log.Warn("failed to decode OTLP export response",
+			"err", err)
+	}
+	if rejected > 0 {
+		log.Warn("OTLP collector rejected some data points",
+			"rejected", rejected,
+			"message", message)
+		droppedPoints.Add(float64(rejected))
+	}
+	exportedPoints.Add(float64(int64(count) - rejected))
+	e.reset()
+}
+
+func (e *Exporter) reset() {
+	e.pending = nil
+	bufferedPoints.Set(0)
+}
+
+// gauges groups the buffered samples into one gauge per measurement, whose data points carry the attributes of the
+// sensor that took them
+func (e *Exporter) gauges() []gauge {
+	gauges := []gauge{}
+	indices := map[string]int{}
+	for _, sample := range e.pending {
+		index, ok := indices[sample.Measurement]
+		if !ok {
+			index = len(gauges)
+			indices[sample.Measurement] = index
+			gauges = append(gauges, gauge{
+				name: "sensor." + sample.Measurement,
+				unit: ucumUnit(sample.Unit),
+			})
+		}
+
+		attributes := []attribute{
+			{"hw.name", sample.Sensor},
+			{"hw.model", sample.Model},
+		}
+		if sample.Serial != "" {
+			attributes = append(attributes, attribute{"hw.serial_number", sample.Serial})
+		}
+		if room := e.rooms[sample.Sensor]; room != "" {
+			attributes = append(attributes, attribute{"room", room})
+		}
+		gauges[index].points = append(gauges[index].points, dataPoint{
+			attributes: attributes,
+			value:      sample.Value,
+			time:       sample.Time.UnixNano(),
+		})
+	}
+	return gauges
+}
+
+func ucumUnit(unit string) string {
+	if ucum, ok := ucumUnits[unit]; ok {
+		return ucum
+	}
+	return unit
+}
@@ -0,0 +1,40 @@
+package otlp
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	exportedPoints = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_otlp_exported_points_total",
+			Help: "Number of data points accepted by the OTLP collector",
+		},
+	)
+	exportErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_otlp_export_errors_total",
+			Help: "Number of failed exports to the OTLP collector",
+		},
+	)
+	droppedPoints = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_otlp_dropped_points_total",
+			Help: "Number of data points dropped because the OTLP collector rejected them or the buffer was full",
+		},
+	)
+	bufferedPoints = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "sensor_exporter_otlp_buffered_points",
+			Help: "Number of data points waiting to be exported to the OTLP collector",
+		},
+	)
+)
+
+// Collectors returns the metrics of the OTLP exporter for registration
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		exportedPoints,
+		exportErrors,
+		droppedPoints,
+		bufferedPoints,
+	}
+}
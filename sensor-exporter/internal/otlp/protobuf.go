@@ -0,0 +1,181 @@
+package otlp
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the OTLP metrics messages, see opentelemetry-proto
+const (
+	exportRequestResourceMetrics protowire.Number = 1
+	resourceMetricsResource      protowire.Number = 1
+	resourceMetricsScopeMetrics  protowire.Number = 2
+	resourceAttributesField      protowire.Number = 1
+	scopeMetricsScope            protowire.Number = 1
+	scopeMetricsMetrics          protowire.Number = 2
+	scopeName                    protowire.Number = 1
+	scopeVersion                 protowire.Number = 2
+	metricName                   protowire.Number = 1
+	metricUnit                   protowire.Number = 3
+	metricGauge                  protowire.Number = 5
+	gaugeDataPoints              protowire.Number = 1
+	dataPointTimeUnixNano        protowire.Number = 3
+	dataPointAsDouble            protowire.Number = 4
+	dataPointAttributes          protowire.Number = 7
+	keyValueKey                  protowire.Number = 1
+	keyValueValue                protowire.Number = 2
+	anyValueString               protowire.Number = 1
+	exportResponsePartialSuccess protowire.Number = 1
+	partialSuccessRejectedPoints protowire.Number = 1
+	partialSuccessErrorMessage   protowire.Number = 2
+)
+
+type attribute struct {
+	key   string
+	value string
+}
+
+type dataPoint struct {
+	attributes []attribute
+	value      float64
+	// Nanoseconds since the epoch
+	time int64
+}
+
+type gauge struct {
+	name   string
+	unit   string
+	points []dataPoint
+}
+
+// scope describes the exporter as the instrumentation scope of the metrics
+type scope struct {
+	name    string
+	version string
+}
+
+// encodeExportRequest encodes an ExportMetricsServiceRequest holding the gauges of one resource
+func encodeExportRequest(resource []attribute, instrumentation scope, gauges []gauge) []byte {
+	encodedResource := []byte{}
+	for _, a := range resource {
+		encodedResource = appendAttribute(encodedResource, resourceAttributesField, a)
+	}
+
+	encodedScope := protowire.AppendTag(nil, scopeName, protowire.BytesType)
+	encodedScope = protowire.AppendString(encodedScope, instrumentation.name)
+	encodedScope = protowire.AppendTag(encodedScope, scopeVersion, protowire.BytesType)
+	encodedScope = protowire.AppendString(encodedScope, instrumentation.version)
+
+	scopeMetrics := protowire.AppendTag(nil, scopeMetricsScope, protowire.BytesType)
+	scopeMetrics = protowire.AppendBytes(scopeMetrics, encodedScope)
+	for _, g := range gauges {
+		scopeMetrics = protowire.AppendTag(scopeMetrics, scopeMetricsMetrics, protowire.BytesType)
+		scopeMetrics = protowire.AppendBytes(scopeMetrics, encodeGauge(g))
+	}
+
+	resourceMetrics := protowire.AppendTag(nil, resourceMetricsResource, protowire.BytesType)
+	resourceMetrics = protowire.AppendBytes(resourceMetrics, encodedResource)
+	resourceMetrics = protowire.AppendTag(resourceMetrics, resourceMetricsScopeMetrics, protowire.BytesType)
+	resourceMetrics = protowire.AppendBytes(resourceMetrics, scopeMetrics)
+
+	request := protowire.AppendTag(nil, exportRequestResourceMetrics, protowire.BytesType)
+	return protowire.AppendBytes(request, resourceMetrics)
+}
+
+func encodeGauge(g gauge) []byte {
+	points := []byte{}
+	for _, p := range g.points {
+		point := []byte{}
+		for _, a := range p.attributes {
+			point = appendAttribute(point, dataPointAttributes, a)
+		}
+		point = protowire.AppendTag(point, dataPointTimeUnixNano, protowire.Fixed64Type)
+		point = protowire.AppendFixed64(point, uint64(p.time))
+		point = protowire.AppendTag(point, dataPointAsDouble, protowire.Fixed64Type)
+		point = protowire.AppendFixed64(point, math.Float64bits(p.value))
+
+		points = protowire.AppendTag(points, gaugeDataPoints, protowire.BytesType)
+		points = protowire.AppendBytes(points, point)
+	}
+
+	metric := protowire.AppendTag(nil, metricName, protowire.BytesType)
+	metric = protowire.AppendString(metric, g.name)
+	metric = protowire.AppendTag(metric, metricUnit, protowire.BytesType)
+	metric = protowire.AppendString(metric, g.unit)
+	metric = protowire.AppendTag(metric, metricGauge, protowire.BytesType)
+	return protowire.AppendBytes(metric, points)
+}
+
+// appendAttribute appends a KeyValue with a string value as the given field
+func appendAttribute(message []byte, number protowire.Number, a attribute) []byte {
+	value := protowire.AppendTag(nil, anyValueString, protowire.BytesType)
+	value = protowire.AppendString(value, a.value)
+
+	keyValue := protowire.AppendTag(nil, keyValueKey, protowire.BytesType)
+	keyValue = protowire.AppendString(keyValue, a.key)
+	keyValue = protowire.AppendTag(keyValue, keyValueValue, protowire.BytesType)
+	keyValue = protowire.AppendBytes(keyValue, value)
+
+	message = protowire.AppendTag(message, number, protowire.BytesType)
+	return protowire.AppendBytes(message, keyValue)
+}
+
+// decodePartialSuccess returns the number of data points and the message with which the collector rejected part of
+// an export, both zero if it accepted everything
+func decodePartialSuccess(response []byte) (int64, string, error) {
+	var rejected int64
+	var message string
+	for len(response) > 0 {
+		number, wireType, n := protowire.ConsumeTag(response)
+		if n < 0 {
+			return 0, "", errors.Wrap(protowire.ParseError(n), "failed to decode export response")
+		}
+		response = response[n:]
+		if number != exportResponsePartialSuccess || wireType != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(number, wireType, response)
+			if n < 0 {
+				return 0, "", errors.Wrap(protowire.ParseError(n), "failed to decode export response")
+			}
+			response = response[n:]
+			continue
+		}
+
+		partialSuccess, n := protowire.ConsumeBytes(response)
+		if n < 0 {
+			return 0, "", errors.Wrap(protowire.ParseError(n), "failed to decode export response")
+		}
+		response = response[n:]
+		for len(partialSuccess) > 0 {
+			number, wireType, n := protowire.ConsumeTag(partialSuccess)
+			if n < 0 {
+				return 0, "", errors.Wrap(protowire.ParseError(n), "failed to decode partial success")
+			}
+			partialSuccess = partialSuccess[n:]
+			switch {
+			case number == partialSuccessRejectedPoints && wireType == protowire.VarintType:
+				value, n := protowire.ConsumeVarint(partialSuccess)
+				if n < 0 {
+					return 0, "", errors.Wrap(protowire.ParseError(n), "failed to decode partial success")
+				}
+				rejected = int64(value)
+				partialSuccess = partialSuccess[n:]
+			case number == partialSuccessErrorMessage && wireType == protowire.BytesType:
+				value, n := protowire.ConsumeString(partialSuccess)
+				if n < 0 {
+					return 0, "", errors.Wrap(protowire.ParseError(n), "failed to decode partial success")
+				}
+				message = value
+				partialSuccess = partialSuccess[n:]
+			default:
+				n = protowire.ConsumeFieldValue(number, wireType, partialSuccess)
+				if n < 0 {
+					return 0, "", errors.Wrap(protowire.ParseError(n), "failed to decode partial success")
+				}
+				partialSuccess = partialSuccess[n:]
+			}
+		}
+	}
+	return rejected, message, nil
+}
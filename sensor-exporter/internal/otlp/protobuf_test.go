@@ -0,0 +1,251 @@
+package otlp
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// field is a decoded protobuf field; value holds the bytes of length delimited fields and fixed holds 64 bit fields
+type field struct {
+	number protowire.Number
+	value  []byte
+	fixed  uint64
+}
+
+func decodeFields(t *testing.T, message []byte) []field {
+	t.Helper()
+	fields := []field{}
+	for len(message) > 0 {
+		number, wireType, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			t.Fatalf("failed to decode field tag: %v", protowire.ParseError(n))
+		}
+		message = message[n:]
+		f := field{number: number}
+		switch wireType {
+		case protowire.BytesType:
+			f.value, n = protowire.ConsumeBytes(message)
+		case protowire.Fixed64Type:
+			f.fixed, n = protowire.ConsumeFixed64(message)
+		default:
+			t.Fatalf("unexpected wire type %v of field %v", wireType, number)
+		}
+		if n < 0 {
+			t.Fatalf("failed to decode field %v: %v", number, protowire.ParseError(n))
+		}
+		message = message[n:]
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// decodeAttribute formats a KeyValue with a string value as key=value
+func decodeAttribute(t *testing.T, keyValue []byte) string {
+	t.Helper()
+	var key, value string
+	for _, f := range decodeFields(t, keyValue) {
+		switch f.number {
+		case keyValueKey:
+			key = string(f.value)
+		case keyValueValue:
+			for _, v := range decodeFields(t, f.value) {
+				if v.number == anyValueString {
+					value = string(v.value)
+				}
+			}
+		}
+	}
+	return key + "=" + value
+}
+
+// decodeExportRequest formats an encoded ExportMetricsServiceRequest as one line per resource, scope, metric and
+// data point
+func decodeExportRequest(t *testing.T, request []byte) []string {
+	t.Helper()
+	lines := []string{}
+	for _, resourceMetrics := range decodeFields(t, request) {
+		if resourceMetrics.number != exportRequestResourceMetrics {
+			t.Fatalf("unexpected field %v in export request", resourceMetrics.number)
+		}
+		for _, f := range decodeFields(t, resourceMetrics.value) {
+			switch f.number {
+			case resourceMetricsResource:
+				attributes := []string{}
+				for _, a := range decodeFields(t, f.value) {
+					attributes = append(attributes, decodeAttribute(t, a.value))
+				}
+				lines = append(lines, "resource "+strings.Join(attributes, ","))
+			case resourceMetricsScopeMetrics:
+				lines = append(lines, decodeScopeMetrics(t, f.value)...)
+			}
+		}
+	}
+	return lines
+}
+
+func decodeScopeMetrics(t *testing.T, scopeMetrics []byte) []string {
+	t.Helper()
+	lines := []string{}
+	for _, f := range decodeFields(t, scopeMetrics) {
+		switch f.number {
+		case scopeMetricsScope:
+			var name, version string
+			for _, s := range decodeFields(t, f.value) {
+				switch s.number {
+				case scopeName:
+					name = string(s.value)
+				case scopeVersion:
+					version = string(s.value)
+				}
+			}
+			lines = append(lines, fmt.Sprintf("scope %v %v", name, version))
+		case scopeMetricsMetrics:
+			var name, unit string
+			points := []string{}
+			for _, m := range decodeFields(t, f.value) {
+				switch m.number {
+				case metricName:
+					name = string(m.value)
+				case metricUnit:
+					unit = string(m.value)
+				case metricGauge:
+					for _, p := range decodeFields(t, m.value) {
+						points = append(points, decodeDataPoint(t, p.value))
+					}
+				}
+			}
+			lines = append(lines, fmt.Sprintf("gauge %v %v", name, unit))
+			lines = append(lines, points...)
+		}
+	}
+	return lines
+}
+
+func decodeDataPoint(t *testing.T, point []byte) string {
+	t.Helper()
+	attributes := []string{}
+	var value float64
+	var time uint64
+	for _, f := range decodeFields(t, point) {
+		switch f.number {
+		case dataPointAttributes:
+			attributes = append(attributes, decodeAttribute(t, f.value))
+		case dataPointTimeUnixNano:
+			time = f.fixed
+		case dataPointAsDouble:
+			value = math.Float64frombits(f.fixed)
+		}
+	}
+	return fmt.Sprintf("  {%v} %v %v", strings.Join(attributes, ","), value, time)
+}
+
+func TestEncodeExportRequest(t *testing.T) {
+	room := dataPoint{
+		attributes: []attribute{{"hw.name", "room"}, {"hw.model", "AHT20"}},
+		value:      21.25,
+		time:       1790856000000000500,
+	}
+	tests := []struct {
+		name     string
+		resource []attribute
+		gauges   []gauge
+		lines    []string
+	}{
+		{
+			name:     "no gauges",
+			resource: []attribute{{"service.name", "sensor-exporter"}},
+			gauges:   []gauge{},
+			lines: []string{
+				"resource service.name=sensor-exporter",
+				"scope sensor-exporter 1.2.3",
+			},
+		},
+		{
+			name:     "gauge with data points",
+			resource: []attribute{{"service.name", "sensor-exporter"}, {"host.name", "pi"}},
+			gauges: []gauge{{
+				name: "sensor.temperature",
+				unit: "Cel",
+				points: []dataPoint{
+					room,
+					{attributes: []attribute{{"hw.name", "attic"}, {"room", "attic"}}, value: -3.5, time: 1790856001000000000},
+				},
+			}},
+			lines: []string{
+				"resource service.name=sensor-exporter,host.name=pi",
+				"scope sensor-exporter 1.2.3",
+				"gauge sensor.temperature Cel",
+				"  {hw.name=room,hw.model=AHT20} 21.25 1790856000000000500",
+				"  {hw.name=attic,room=attic} -3.5 1790856001000000000",
+			},
+		},
+		{
+			name:     "gauges in order",
+			resource: []attribute{},
+			gauges: []gauge{
+				{name: "sensor.eco2", unit: "ppm", points: []dataPoint{{value: 400}}},
+				{name: "sensor.temperature", unit: "Cel", points: []dataPoint{room}},
+			},
+			lines: []string{
+				"resource ",
+				"scope sensor-exporter 1.2.3",
+				"gauge sensor.eco2 ppm",
+				"  {} 400 0",
+				"gauge sensor.temperature Cel",
+				"  {hw.name=room,hw.model=AHT20} 21.25 1790856000000000500",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := encodeExportRequest(test.resource, scope{"sensor-exporter", "1.2.3"}, test.gauges)
+			lines := decodeExportRequest(t, request)
+			if strings.Join(lines, "\n") != strings.Join(test.lines, "\n") {
+				t.Errorf("got request\n%v\nwant\n%v", strings.Join(lines, "\n"), strings.Join(test.lines, "\n"))
+			}
+		})
+	}
+}
+
+func TestDecodePartialSuccess(t *testing.T) {
+	partialSuccess := func(rejected uint64, message string) []byte {
+		encoded := protowire.AppendTag(nil, partialSuccessRejectedPoints, protowire.VarintType)
+		encoded = protowire.AppendVarint(encoded, rejected)
+		encoded = protowire.AppendTag(encoded, partialSuccessErrorMessage, protowire.BytesType)
+		encoded = protowire.AppendString(encoded, message)
+		response := protowire.AppendTag(nil, exportResponsePartialSuccess, protowire.BytesType)
+		return protowire.AppendBytes(response, encoded)
+	}
+	unknown := protowire.AppendTag(nil, 9, protowire.VarintType)
+	unknown = protowire.AppendVarint(unknown, 1)
+
+	tests := []struct {
+		name     string
+		response []byte
+		rejected int64
+		message  string
+		valid    bool
+	}{
+		{"everything accepted", []byte{}, 0, "", true},
+		{"some rejected", partialSuccess(3, "unit mismatch"), 3, "unit mismatch", true},
+		{"unknown fields skipped", append(append([]byte{}, unknown...), partialSuccess(1, "stale")...), 1, "stale", true},
+		{"cut short", partialSuccess(3, "unit mismatch")[:5], 0, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rejected, message, err := decodePartialSuccess(test.response)
+			if (err == nil) != test.valid {
+				t.Fatalf("got error %v, want valid %v", err, test.valid)
+			}
+			if rejected != test.rejected || message != test.message {
+				t.Errorf("got %v rejected with %q, want %v with %q", rejected, message, test.rejected, test.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+// Package readout takes one-shot readings from the configured sensors for diagnostics and scripts
+package readout
+
+import (
+	"context"
+	"sensor-exporter/aht20"
+	"sensor-exporter/clock"
+	"sensor-exporter/i2cbus"
+	"sensor-exporter/internal/exporter"
+	"sensor-exporter/internal/measurement"
+	"sensor-exporter/internal/models"
+	"sensor-exporter/pms5003"
+	"sensor-exporter/reconnect"
+	"sensor-exporter/sgp30"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/go-kit/v2/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// Result is the outcome of reading one sensor
+type Result struct {
+	Sensor  string               `json:"sensor"`
+	Samples []measurement.Sample `json:"samples"`
+	Error   string               `json:"error,omitempty"`
+}
+
+// Read takes count valid readings from each of the named sensors, or every configured sensor if no names are given, and
+// averages them. Sensors that do not produce enough readings before the timeout report an error in their result.
+func Read(ctx context.Context, settings *exporter.Settings, names []string, count int, timeout time.Duration) ([]Result, error) {
+	instances, err := settings.SensorInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	selected, err := selectInstances(instances, names)
+	if err != nil {
+		return nil, err
+	}
+
+	clockGuard, err := settings.ClockGuard()
+	if err != nil {
+		return nil, err
+	}
+
+	reconnectSettings, err := settings.ReconnectSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	var lookupBaseline sgp30.BaselineLookup
+	calibrations := map[string]exporter.Calibration{}
+	store, err := exporter.OpenState(settings, instances)
+	if err != nil {
+		log.Warn("failed to open state; gas sensors will not be initialized with their stored baselines and readings are not calibrated",
+			"err", err)
+	} else {
+		lookupBaseline = exporter.StoredBaseline(store, clockGuard)
+		for _, instance := range selected {
+			calibrations[instance.Name] = exporter.StoredCalibration(store, instance)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	buses := i2cbus.NewManager()
+	results := make([]Result, len(selected))
+	var wg sync.WaitGroup
+	for i, instance := range selected {
+		wg.Add(1)
+		go func(i int, instance exporter.SensorSettings) {
+			defer wg.Done()
+
+			samples, err := readSensor(ctx, reconnectSettings, buses, instance, count, calibrations[instance.Name], lookupBaseline, clockGuard)
+			results[i] = Result{
+				Sensor:  instance.Name,
+				Samples: measurement.Average(samples),
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, instance)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+func selectInstances(instances []exporter.SensorSettings, names []string) ([]exporter.SensorSettings, error) {
+	if len(names) == 0 {
+		return instances, nil
+	}
+
+	byName := map[string]exporter.SensorSettings{}
+	for _, instance := range instances {
+		byName[instance.Name] = instance
+	}
+
+	selected := []exporter.SensorSettings{}
+	for _, name := range names {
+		instance, ok := byName[name]
+		if !ok {
+			return nil, errors.Errorf("failed to find sensor %v in the configuration", name)
+		}
+		selected = append(selected, instance)
+	}
+	return selected, nil
+}
+
+// readSensor starts the driver of a sensor and collects samples from count readings of each kind it produces
+func readSensor(ctx context.Context, settings reconnect.Settings, buses *i2cbus.Manager, instance exporter.SensorSettings, count int, calibration exporter.Calibration, lookupBaseline sgp30.BaselineLookup, clockGuard *clock.Guard) ([]measurement.Sample, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	source := measurement.Source{
+		Sensor: instance.Name,
+		Model:  strings.ToUpper(instance.Model),
+	}
+	samples := []measurement.Sample{}
+	group, ctx := errgroup.WithContext(ctx)
+	connection := &connection{}
+	reconnectSettings := connection.observe(settings)
+
+	switch instance.Model {
+	case models.AHT20:
+		sensor := aht20.NewSensor(buses, instance.I2CAddress(), reconnectSettings)
+		group.Go(sensor.Start(ctx))
+		for received := 0; received < count; {
+			select {
+			case <-ctx.Done():
+				return samples, failure(group, connection, received, count)
+			case <-sensor.Infos():
+			case reading, ok := <-sensor.Readings():
+				if !ok {
+					return samples, failure(group, connection, received, count)
+				}
+				samples = append(samples, calibration.Apply(measurement.FromAHT(source, reading, time.Now()))...)
+				received++
+			}
+		}
+	case models.PMS5003:
+		sensor := pms5003.NewSensor(instance.Port, reconnectSettings)
+		group.Go(sensor.Start(ctx))
+		for received := 0; received < count; {
+			select {
+			case <-ctx.Done():
+				return samples, failure(group, connection, received, count)
+			case <-sensor.Infos():
+			case reading, ok := <-sensor.Readings():
+				if !ok {
+					return samples, failure(group, connection, received, count)
+				}
+				samples = append(samples, calibration.Apply(measurement.FromPMS(source, reading, time.Now()))...)
+				received++
+			}
+		}
+	case models.SGP30:
+		sensor := sgp30.NewSensor(buses, instance.I2CAddress(), reconnectSettings, lookupBaseline, clockGuard)
+		group.Go(sensor.Start(ctx))
+		airQuality, raw := 0, 0
+		for airQuality < count || raw < count {
+			select {
+			case <-ctx.Done():
+				return samples, failure(group, connection, airQuality, count)
+			case info, ok := <-sensor.Infos():
+				if !ok {
+					return samples, failure(group, connection, airQuality, count)
+				}
+				source.Serial = sgp30.FormatSerial(info.Serial)
+			case reading, ok := <-sensor.AirQualityReadings():
+				if !ok {
+					return samples, failure(group, connection, airQuality, count)
+				}
+				// readings before initialization are fixed values rather than measurements
+				if !reading.IsInitialized || airQuality >= count {
+					continue
+				}
+				samples = append(samples, measurement.FromSGPAirQuality(source, reading, time.Now())...)
+				airQuality++
+			case reading, ok := <-sensor.RawReadings():
+				if !ok {
+					return samples, failure(group, connection, airQuality, count)
+				}
+				if raw >= count {
+					continue
+				}
+				samples = append(samples, measurement.FromSGPRaw(source, reading, time.Now())...)
+				raw++
+			case <-sensor.BaselineReadings():
+			}
+		}
+	}
+
+	cancel()
+	group.Wait()
+	return samples, nil
+}
+
+// connection remembers the last failure of a driver, which retries rather than returning it
+type connection struct {
+	mu      sync.Mutex
+	lastErr error
+}
+
+// observe returns reconnect settings that record the failures of the driver
+func (c *connection) observe(settings reconnect.Settings) reconnect.Settings {
+	settings.OnStatus = func(status reconnect.Status) {
+		if status.LastError == nil {
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.lastErr = status.LastError
+	}
+	return settings
+}
+
+func (c *connection) err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastErr
+}
+
+// failure explains why readings stopped arriving: either the driver failed or the timeout passed, in which case the last
+// failure to connect to the sensor, if any, is the likely cause
+func failure(group *errgroup.Group, connection *connection, received, count int) error {
+	err := group.Wait()
+	if err != nil {
+		return err
+	}
+	err = connection.err()
+	if err != nil {
+		return errors.Wrapf(err, "failed to receive %v readings before the timeout (received %v)", count, received)
+	}
+	return errors.Errorf("failed to receive %v readings before the timeout (received %v)", count, received)
+}
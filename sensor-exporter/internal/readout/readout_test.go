@@ -0,0 +1,98 @@
+package readout
+
+import (
+	"sensor-exporter/internal/exporter"
+	"sensor-exporter/reconnect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestSelectInstances(t *testing.T) {
+	instances := []exporter.SensorSettings{
+		{Name: "aht20", Model: "aht20"},
+		{Name: "sgp30", Model: "sgp30"},
+		{Name: "outside", Model: "pms5003"},
+	}
+	tests := []struct {
+		name     string
+		names    []string
+		selected []string
+		valid    bool
+	}{
+		{"every sensor without names", nil, []string{"aht20", "sgp30", "outside"}, true},
+		{"named sensors in the order given", []string{"outside", "aht20"}, []string{"outside", "aht20"}, true},
+		{"unknown sensor", []string{"aht20", "attic"}, nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selected, err := selectInstances(instances, test.names)
+			if (err == nil) != test.valid {
+				t.Fatalf("got error %v, want valid %v", err, test.valid)
+			}
+			names := []string{}
+			for _, instance := range selected {
+				names = append(names, instance.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(test.selected, ",") {
+				t.Errorf("selected %v, want %v", names, test.selected)
+			}
+		})
+	}
+}
+
+func TestFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		// Error returned by the driver, if it stopped
+		driverErr error
+		// Statuses reported by the reconnect policy of the driver
+		statuses []reconnect.Status
+		err      string
+	}{
+		{
+			name: "timeout without failures",
+			err:  "failed to receive 3 readings before the timeout (received 1)",
+		},
+		{
+			name:     "timeout after failing to connect",
+			statuses: []reconnect.Status{{LastError: errors.New("failed to open /dev/i2c-1: no such file or directory")}},
+			err:      "failed to receive 3 readings before the timeout (received 1): failed to open /dev/i2c-1: no such file or directory",
+		},
+		{
+			name: "last failure reported",
+			statuses: []reconnect.Status{
+				{LastError: errors.New("failed to open /dev/i2c-1: no such file or directory")},
+				{LastError: errors.New("failed to read status: remote I/O error")},
+				{Connected: true},
+			},
+			err: "failed to receive 3 readings before the timeout (received 1): failed to read status: remote I/O error",
+		},
+		{
+			name:      "driver stopped",
+			driverErr: errors.New("failed to open serial port /dev/ttyS0: permission denied"),
+			statuses:  []reconnect.Status{{LastError: errors.New("failed to read frame")}},
+			err:       "failed to open serial port /dev/ttyS0: permission denied",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			group := &errgroup.Group{}
+			group.Go(func() error { return test.driverErr })
+			connection := &connection{}
+			settings := connection.observe(reconnect.Settings{})
+			for _, status := range test.statuses {
+				settings.OnStatus(status)
+			}
+
+			err := failure(group, connection, 1, 3)
+			if err == nil || err.Error() != test.err {
+				t.Errorf("got error %v, want %v", err, test.err)
+			}
+		})
+	}
+}
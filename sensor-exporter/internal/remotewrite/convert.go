@@ -0,0 +1,84 @@
+package remotewrite
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gatherSeries gathers the metrics of the gatherer as series with one sample each, named and labelled like the
+// series a scrape of /metrics produces. Samples without their own timestamp are stamped with the given time.
+func gatherSeries(gatherer prometheus.Gatherer, externalLabels []label, timestamp int64) ([]timeSeries, error) {
+	families, err := gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return nil, errors.Wrap(err, "failed to gather metrics")
+	}
+
+	series := []timeSeries{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := append([]label{}, externalLabels...)
+			for _, pair := range metric.GetLabel() {
+				name := pair.GetName()
+				// Like a scrape, external labels take precedence over conflicting labels of the metric
+				for _, external := range externalLabels {
+					if external.name == name {
+						name = "exported_" + name
+					}
+				}
+				labels = append(labels, label{name, pair.GetValue()})
+			}
+			t := timestamp
+			if metric.TimestampMs != nil {
+				t = metric.GetTimestampMs()
+			}
+
+			add := func(name string, value float64, extra ...label) {
+				all := append(append([]label{{"__name__", name}}, labels...), extra...)
+				series = append(series, timeSeries{all, []sample{{value, t}}})
+			}
+
+			name := family.GetName()
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, metric.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, metric.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, q := range summary.GetQuantile() {
+					add(name, q.GetValue(), label{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add(name+"_sum", summary.GetSampleSum())
+				add(name+"_count", float64(summary.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				hasInf := false
+				for _, b := range histogram.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						hasInf = true
+					}
+					add(name+"_bucket", float64(b.GetCumulativeCount()), label{"le", formatFloat(b.GetUpperBound())})
+				}
+				if !hasInf {
+					add(name+"_bucket", float64(histogram.GetSampleCount()), label{"le", "+Inf"})
+				}
+				add(name+"_sum", histogram.GetSampleSum())
+				add(name+"_count", float64(histogram.GetSampleCount()))
+			}
+		}
+	}
+	return series, nil
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
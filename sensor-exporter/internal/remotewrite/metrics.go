@@ -0,0 +1,41 @@
+package remotewrite
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	sentSamples = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_remote_write_sent_samples_total",
+			Help: "Number of samples accepted by the remote write endpoint, including samples backfilled from the buffer",
+		},
+	)
+	failedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_remote_write_failed_requests_total",
+			Help: "Number of failed remote write requests by whether they are retried or were rejected",
+		},
+		[]string{"reason"},
+	)
+	droppedSamples = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sensor_exporter_remote_write_dropped_samples_total",
+			Help: "Number of samples dropped because the endpoint rejected them or the buffer was full",
+		},
+	)
+	pendingBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "sensor_exporter_remote_write_pending_bytes",
+			Help: "Size of the compressed requests waiting in the write-ahead buffer",
+		},
+	)
+)
+
+// Collectors returns the metrics of the remote write sender for registration
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		sentSamples,
+		failedRequests,
+		droppedSamples,
+		pendingBytes,
+	}
+}
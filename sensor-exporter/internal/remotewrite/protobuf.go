@@ -0,0 +1,119 @@
+package remotewrite
+
+import (
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the remote write protocol messages, see prometheus/prompb
+const (
+	writeRequestTimeseries protowire.Number = 1
+	timeSeriesLabels       protowire.Number = 1
+	timeSeriesSamples      protowire.Number = 2
+	labelName              protowire.Number = 1
+	labelValue             protowire.Number = 2
+	sampleValue            protowire.Number = 1
+	sampleTimestamp        protowire.Number = 2
+)
+
+type label struct {
+	name  string
+	value string
+}
+
+type sample struct {
+	value float64
+	// Milliseconds since the epoch
+	timestamp int64
+}
+
+type timeSeries struct {
+	labels  []label
+	samples []sample
+}
+
+// encodeWriteRequest encodes a prometheus.WriteRequest holding the given series, whose labels are sorted by name as
+// the protocol requires
+func encodeWriteRequest(series []timeSeries) []byte {
+	request := []byte{}
+	for _, s := range series {
+		sort.Slice(s.labels, func(i, j int) bool {
+			return s.labels[i].name < s.labels[j].name
+		})
+
+		encoded := []byte{}
+		for _, l := range s.labels {
+			message := protowire.AppendTag(nil, labelName, protowire.BytesType)
+			message = protowire.AppendString(message, l.name)
+			message = protowire.AppendTag(message, labelValue, protowire.BytesType)
+			message = protowire.AppendString(message, l.value)
+
+			encoded = protowire.AppendTag(encoded, timeSeriesLabels, protowire.BytesType)
+			encoded = protowire.AppendBytes(encoded, message)
+		}
+		for _, v := range s.samples {
+			message := protowire.AppendTag(nil, sampleValue, protowire.Fixed64Type)
+			message = protowire.AppendFixed64(message, math.Float64bits(v.value))
+			message = protowire.AppendTag(message, sampleTimestamp, protowire.VarintType)
+			message = protowire.AppendVarint(message, uint64(v.timestamp))
+
+			encoded = protowire.AppendTag(encoded, timeSeriesSamples, protowire.BytesType)
+			encoded = protowire.AppendBytes(encoded, message)
+		}
+
+		request = protowire.AppendTag(request, writeRequestTimeseries, protowire.BytesType)
+		request = protowire.AppendBytes(request, encoded)
+	}
+	return request
+}
+
+// countSamples returns the number of samples in an encoded write request
+func countSamples(request []byte) (int, error) {
+	count := 0
+	err := forEachField(request, func(number protowire.Number, value []byte) error {
+		if number != writeRequestTimeseries {
+			return nil
+		}
+		return forEachField(value, func(number protowire.Number, _ []byte) error {
+			if number == timeSeriesSamples {
+				count++
+			}
+			return nil
+		})
+	})
+	return count, err
+}
+
+// forEachField calls f with the number and contents of each length-delimited field of a message
+func forEachField(message []byte, f func(protowire.Number, []byte) error) error {
+	for len(message) > 0 {
+		number, wireType, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return errors.Wrap(protowire.ParseError(n), "failed to decode field tag")
+		}
+		message = message[n:]
+
+		if wireType != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(number, wireType, message)
+			if n < 0 {
+				return errors.Wrap(protowire.ParseError(n), "failed to decode field")
+			}
+			message = message[n:]
+			continue
+		}
+
+		value, n := protowire.ConsumeBytes(message)
+		if n < 0 {
+			return errors.Wrap(protowire.ParseError(n), "failed to decode field")
+		}
+		message = message[n:]
+		err := f(number, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
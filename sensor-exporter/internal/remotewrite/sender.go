@@ -0,0 +1,316 @@
+// Package remotewrite pushes the metrics of the exporter to a Prometheus remote write endpoint, keeping them in an
+// on-disk write-ahead buffer until the endpoint has accepted them
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sensor-exporter/internal/spool"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/syncromatics/go-kit/v2/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// Settings configure the remote write endpoint and how metrics are buffered for it
+type Settings struct {
+	// URL of the remote write endpoint, e.g. http://prometheus:9090/api/v1/write
+	URL         string
+	Username    string
+	Password    string
+	BearerToken string
+	// Labels added to every series, such as job and instance, which a scrape would otherwise add
+	ExternalLabels map[string]string
+	// How often the metrics are gathered
+	Interval time.Duration
+	// Time limit of a single request
+	Timeout time.Duration
+	// Delay before the first retry of a failed request, doubling up to the maximum
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Directory of the write-ahead buffer
+	WALDir string
+	// Size of the write-ahead buffer beyond which the oldest requests are dropped
+	WALMaxBytes int64
+	// Version of the exporter, reported in the user agent
+	Version string
+}
+
+// Sender gathers and sends metrics, buffering the requests that could not be sent and sending them in order
+type Sender struct {
+	settings       Settings
+	gatherer       prometheus.Gatherer
+	externalLabels []label
+	wal            *spool.Spool
+	client         *http.Client
+	wake           chan struct{}
+}
+
+// retryableError is a failure after which the same request may succeed, optionally after a delay the endpoint asked for
+type retryableError struct {
+	error
+	retryAfter time.Duration
+}
+
+// NewSender opens the write-ahead buffer, which may hold requests from before a restart
+func NewSender(settings Settings, gatherer prometheus.Gatherer) (*Sender, error) {
+	wal, err := spool.Open(settings.WALDir, ".pb.snappy", settings.WALMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	pendingBytes.Set(float64(wal.Size()))
+
+	externalLabels := []label{}
+	for name, value := range settings.ExternalLabels {
+		externalLabels = append(externalLabels, label{name, value})
+	}
+	sort.Slice(externalLabels, func(i, j int) bool {
+		return externalLabels[i].name < externalLabels[j].name
+	})
+
+	return &Sender{
+		settings:       settings,
+		gatherer:       gatherer,
+		externalLabels: externalLabels,
+		wal:            wal,
+		client:         &http.Client{Timeout: settings.Timeout},
+		wake:           make(chan struct{}, 1),
+	}, nil
+}
+
+// Start gathers and sends metrics until the context is done. Requests that have not been sent by then stay in the
+// write-ahead buffer and are sent after the next start, backfilling the gap with their original timestamps.
+func (s *Sender) Start(ctx context.Context) func() error {
+	return func() error {
+		log.Info("sending metrics to remote write endpoint",
+			"url", s.settings.URL,
+			"pendingRequests", s.wal.Len())
+
+		group, ctx := errgroup.WithContext(ctx)
+		group.Go(func() error {
+			s.gatherLoop(ctx)
+			return nil
+		})
+		group.Go(func() error {
+			s.sendLoop(ctx)
+			return nil
+		})
+		return group.Wait()
+	}
+}
+
+func (s *Sender) gatherLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.settings.Interval)
+	defer ticker.Stop()
+	for {
+		s.gather(ctx, time.Now())
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// gather sends a request holding the current value of every metric. The request is sent directly while the
+// write-ahead buffer is empty, and only buffered if sending it fails or earlier requests are still waiting, so that
+// they are sent first.
+func (s *Sender) gather(ctx context.Context, now time.Time) {
+	series, err := gatherSeries(s.gatherer, s.externalLabels, now.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		log.Error("failed to gather metrics for remote write",
+			"err", err)
+		return
+	}
+	request := snappy.Encode(nil, encodeWriteRequest(series))
+
+	// only this goroutine adds to the buffer, and the send loop removes a request after sending it, so an empty
+	// buffer means nothing else is being sent
+	if s.wal.Len() == 0 {
+		err = s.send(ctx, request)
+		if _, ok := err.(retryableError); !ok {
+			if err != nil {
+				failedRequests.WithLabelValues("rejected").Inc()
+				log.Error("dropping metrics the remote write endpoint rejected",
+					"err", err)
+				s.drop(request)
+			}
+			return
+		}
+		failedRequests.WithLabelValues("retryable").Inc()
+		log.Warn("failed to send metrics to remote write endpoint; buffering them to retry",
+			"err", err)
+	}
+
+	dropped, err := s.wal.Put(request)
+	for _, request := range dropped {
+		s.drop(request)
+	}
+	pendingBytes.Set(float64(s.wal.Size()))
+	if err != nil {
+		log.Error("failed to buffer metrics for remote write",
+			"err", err,
+			"dir", s.wal.Dir())
+		droppedSamples.Add(float64(len(series)))
+		return
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sendLoop sends the buffered requests oldest first, retrying a failed request with backoff so that the samples of
+// each series arrive in order
+func (s *Sender) sendLoop(ctx context.Context) {
+	backoff := s.settings.MinBackoff
+	for {
+		if s.wal.Len() == 0 {
+			select {
+			case <-s.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		name, request, err := s.wal.Peek()
+		if err != nil {
+			log.Error("dropping unreadable request from remote write buffer",
+				"err", err)
+			if !s.remove(name) {
+				return
+			}
+			continue
+		}
+
+		err = s.send(ctx, request)
+
+		if retryable, ok := err.(retryableError); ok {
+			failedRequests.WithLabelValues("retryable").Inc()
+			delay := backoff
+			if retryable.retryAfter > delay {
+				delay = retryable.retryAfter
+			}
+			backoff *= 2
+			if backoff > s.settings.MaxBackoff {
+				backoff = s.settings.MaxBackoff
+			}
+			log.Warn("failed to send metrics to remote write endpoint; retrying",
+				"err", err,
+				"retryIn", delay,
+				"pendingRequests", s.wal.Len())
+
+			select {
+			case <-time.After(delay):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		backoff = s.settings.MinBackoff
+		if err != nil {
+			failedRequests.WithLabelValues("rejected").Inc()
+			log.Error("dropping metrics the remote write endpoint rejected",
+				"err", err)
+			s.drop(request)
+		}
+
+		if !s.remove(name) {
+			return
+		}
+	}
+}
+
+// remove removes a request returned by Peek from the write-ahead buffer and returns whether it succeeded
+func (s *Sender) remove(name string) bool {
+	err := s.wal.Remove(name)
+	pendingBytes.Set(float64(s.wal.Size()))
+	if err != nil {
+		log.Error("failed to remove request from remote write buffer",
+			"err", err)
+		return false
+	}
+	return true
+}
+
+// send sends a snappy-compressed write request. Network errors, rate limiting and server errors are retryable as the
+// remote write specification requires; other responses mean the request will never be accepted.
+func (s *Sender) send(ctx context.Context, request []byte) error {
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, s.settings.URL, bytes.NewReader(request))
+	if err != nil {
+		return errors.Wrap(err, "failed to create remote write request")
+	}
+	httpRequest.Header.Set("Content-Encoding", "snappy")
+	httpRequest.Header.Set("Content-Type", "application/x-protobuf")
+	httpRequest.Header.Set("User-Agent", fmt.Sprintf("sensor-exporter/%v", s.settings.Version))
+	httpRequest.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	switch {
+	case s.settings.BearerToken != "":
+		httpRequest.Header.Set("Authorization", "Bearer "+s.settings.BearerToken)
+	case s.settings.Username != "":
+		httpRequest.SetBasicAuth(s.settings.Username, s.settings.Password)
+	}
+
+	response, err := s.client.Do(httpRequest)
+	if err != nil {
+		return retryableError{error: errors.Wrap(err, "failed to send remote write request")}
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+
+	switch {
+	case response.StatusCode/100 == 2:
+		samples, err := decodedSampleCount(request)
+		if err == nil {
+			sentSamples.Add(float64(samples))
+		}
+		return nil
+	case response.StatusCode == http.StatusTooManyRequests || response.StatusCode/100 == 5:
+		return retryableError{
+			error:      errors.Errorf("failed to send remote write request; endpoint responded %v: %s", response.Status, body),
+			retryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+		}
+	default:
+		return errors.Errorf("failed to send remote write request; endpoint rejected it with %v: %s", response.Status, body)
+	}
+}
+
+// drop accounts for the samples of a request that will not be sent
+func (s *Sender) drop(request []byte) {
+	samples, err := decodedSampleCount(request)
+	if err != nil {
+		log.Error("failed to count dropped remote write samples",
+			"err", err)
+		return
+	}
+	droppedSamples.Add(float64(samples))
+}
+
+func decodedSampleCount(request []byte) (int, error) {
+	decoded, err := snappy.Decode(nil, request)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to decompress remote write request")
+	}
+	return countSamples(decoded)
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds, returning zero if it is absent or a date
+func parseRetryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,318 @@
+package remotewrite
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest formats the series of an encoded write request like the text exposition format, followed by the
+// timestamp of each sample
+func decodeWriteRequest(t *testing.T, request []byte) []string {
+	t.Helper()
+	series := []string{}
+	err := forEachField(request, func(number protowire.Number, value []byte) error {
+		if number != writeRequestTimeseries {
+			return nil
+		}
+		labels := []string{}
+		samples := []string{}
+		err := forEachField(value, func(number protowire.Number, value []byte) error {
+			switch number {
+			case timeSeriesLabels:
+				pair := []string{}
+				err := forEachField(value, func(_ protowire.Number, value []byte) error {
+					pair = append(pair, string(value))
+					return nil
+				})
+				labels = append(labels, fmt.Sprintf("%v=%q", pair[0], pair[1]))
+				return err
+			case timeSeriesSamples:
+				samples = append(samples, decodeSample(t, value))
+			}
+			return nil
+		})
+		series = append(series, fmt.Sprintf("{%v} %v", strings.Join(labels, ","), strings.Join(samples, " ")))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to decode write request: %v", err)
+	}
+	return series
+}
+
+func decodeSample(t *testing.T, message []byte) string {
+	t.Helper()
+	var value float64
+	var timestamp int64
+	for len(message) > 0 {
+		number, wireType, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			t.Fatalf("failed to decode sample field tag: %v", protowire.ParseError(n))
+		}
+		message = message[n:]
+		switch {
+		case number == sampleValue && wireType == protowire.Fixed64Type:
+			var bits uint64
+			bits, n = protowire.ConsumeFixed64(message)
+			value = math.Float64frombits(bits)
+		case number == sampleTimestamp && wireType == protowire.VarintType:
+			var varint uint64
+			varint, n = protowire.ConsumeVarint(message)
+			timestamp = int64(varint)
+		default:
+			n = protowire.ConsumeFieldValue(number, wireType, message)
+		}
+		if n < 0 {
+			t.Fatalf("failed to decode sample field: %v", protowire.ParseError(n))
+		}
+		message = message[n:]
+	}
+	return fmt.Sprintf("%v@%v", value, timestamp)
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		series  []timeSeries
+		decoded []string
+		samples int
+	}{
+		{
+			name:    "no series",
+			series:  []timeSeries{},
+			decoded: []string{},
+			samples: 0,
+		},
+		{
+			name: "labels sorted by name",
+			series: []timeSeries{
+				{
+					labels:  []label{{"job", "sensors"}, {"__name__", "eco2"}, {"instance", "node"}},
+					samples: []sample{{400, 1790000000000}},
+				},
+			},
+			decoded: []string{`{__name__="eco2",instance="node",job="sensors"} 400@1790000000000`},
+			samples: 1,
+		},
+		{
+			name: "several series and samples",
+			series: []timeSeries{
+				{
+					labels:  []label{{"__name__", "pm2_5"}},
+					samples: []sample{{12.5, 1000}, {-0.25, 2000}},
+				},
+				{
+					labels:  []label{{"__name__", "up"}, {"le", "+Inf"}},
+					samples: []sample{{math.Inf(1), 0}},
+				},
+			},
+			decoded: []string{
+				`{__name__="pm2_5"} 12.5@1000 -0.25@2000`,
+				`{__name__="up",le="+Inf"} +Inf@0`,
+			},
+			samples: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := encodeWriteRequest(test.series)
+
+			decoded := decodeWriteRequest(t, request)
+			if strings.Join(decoded, "\n") != strings.Join(test.decoded, "\n") {
+				t.Errorf("got series\n%v\nwant\n%v", strings.Join(decoded, "\n"), strings.Join(test.decoded, "\n"))
+			}
+
+			samples, err := countSamples(request)
+			if err != nil {
+				t.Fatalf("failed to count samples: %v", err)
+			}
+			if samples != test.samples {
+				t.Errorf("counted %v samples, want %v", samples, test.samples)
+			}
+		})
+	}
+}
+
+// receiver is a remote write endpoint that responds to each request with the next of the given statuses, and with
+// 204 once they run out
+type receiver struct {
+	t        *testing.T
+	mu       sync.Mutex
+	statuses []int
+	requests [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	headers := map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"User-Agent":                        "sensor-exporter/test",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	}
+	for name, value := range headers {
+		if request.Header.Get(name) != value {
+			r.t.Errorf("got %v header %q, want %q", name, request.Header.Get(name), value)
+		}
+	}
+	username, password, _ := request.BasicAuth()
+	if username != "user" || password != "secret" {
+		r.t.Errorf("got credentials %v:%v, want user:secret", username, password)
+	}
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		r.t.Errorf("failed to read request: %v", err)
+	}
+	decoded, err := snappy.Decode(nil, body)
+	if err != nil {
+		r.t.Errorf("failed to decompress request: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, decoded)
+	status := http.StatusNoContent
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+// wait returns the decompressed requests once count have arrived, or those that arrived by the timeout
+func (r *receiver) wait(count int, timeout time.Duration) [][]byte {
+	deadline := time.Now().Add(timeout)
+	for {
+		r.mu.Lock()
+		requests := append([][]byte{}, r.requests...)
+		r.mu.Unlock()
+		if len(requests) >= count || time.Now().After(deadline) {
+			return requests
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSender(t *testing.T) {
+	const series = `{__name__="temperature",job="sensors",sensor="room"} 21.5@1790000000000`
+	const earlier = `{__name__="temperature",job="sensors",sensor="room"} 21.5@1789999985000`
+	tests := []struct {
+		name     string
+		statuses []int
+		// Whether an earlier request is waiting in the write-ahead buffer
+		backlog bool
+		// Number of requests in the write-ahead buffer after gathering
+		buffered int
+		requests []string
+	}{
+		{
+			name:     "accepted",
+			statuses: []int{http.StatusNoContent},
+			buffered: 0,
+			requests: []string{series},
+		},
+		{
+			name:     "retried after a server error",
+			statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			buffered: 1,
+			requests: []string{series, series, series},
+		},
+		{
+			name:     "dropped after a rejection",
+			statuses: []int{http.StatusBadRequest},
+			buffered: 0,
+			requests: []string{series},
+		},
+		{
+			name:     "buffered behind a backlog",
+			statuses: []int{http.StatusOK, http.StatusOK},
+			backlog:  true,
+			buffered: 2,
+			requests: []string{earlier, series},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &receiver{t: t, statuses: test.statuses}
+			server := httptest.NewServer(r)
+			defer server.Close()
+
+			registry := prometheus.NewRegistry()
+			gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+				Name:        "temperature",
+				ConstLabels: prometheus.Labels{"sensor": "room"},
+			})
+			gauge.Set(21.5)
+			registry.MustRegister(gauge)
+
+			sender, err := NewSender(Settings{
+				URL:            server.URL,
+				Username:       "user",
+				Password:       "secret",
+				ExternalLabels: map[string]string{"job": "sensors"},
+				Interval:       time.Hour,
+				Timeout:        time.Second,
+				MinBackoff:     time.Millisecond,
+				MaxBackoff:     10 * time.Millisecond,
+				WALDir:         t.TempDir(),
+				WALMaxBytes:    1 << 20,
+				Version:        "test",
+			}, registry)
+			if err != nil {
+				t.Fatalf("failed to create sender: %v", err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.backlog {
+				series, err := gatherSeries(registry, sender.externalLabels, 1789999985000)
+				if err != nil {
+					t.Fatalf("failed to gather metrics: %v", err)
+				}
+				_, err = sender.wal.Put(snappy.Encode(nil, encodeWriteRequest(series)))
+				if err != nil {
+					t.Fatalf("failed to buffer request: %v", err)
+				}
+			}
+			// the request is gathered at a known time rather than by the gather loop
+			sender.gather(ctx, time.UnixMilli(1790000000000))
+			if sender.wal.Len() != test.buffered {
+				t.Errorf("got %v requests in the write-ahead buffer after gathering, want %v", sender.wal.Len(), test.buffered)
+			}
+
+			done := make(chan struct{})
+			go func() {
+				sender.sendLoop(ctx)
+				close(done)
+			}()
+
+			// waiting past the expected requests catches any that should not have been sent
+			received := r.wait(len(test.requests)+1, 500*time.Millisecond)
+			cancel()
+			<-done
+
+			requests := []string{}
+			for _, request := range received {
+				requests = append(requests, strings.Join(decodeWriteRequest(t, request), "\n"))
+			}
+			if strings.Join(requests, "\n") != strings.Join(test.requests, "\n") {
+				t.Errorf("got requests\n%v\nwant\n%v", strings.Join(requests, "\n"), strings.Join(test.requests, "\n"))
+			}
+			if sender.wal.Len() != 0 {
+				t.Errorf("%v requests left in the write-ahead buffer, want none", sender.wal.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package scan
+
+import (
+	"fmt"
+	"io"
+	"sensor-exporter/internal/models"
+)
+
+// WriteConfig writes the sensors list of a configuration file for the exporter
+func WriteConfig(w io.Writer, found []Found) error {
+	lines := []string{
+		"# Generated by sensor-exporter scan",
+		"sensors:",
+	}
+	if len(found) == 0 {
+		lines = append(lines, "  # no sensors found", "  []")
+	}
+
+	for _, sensor := range found {
+		lines = append(lines,
+			fmt.Sprintf("  # %s", sensor.Description),
+			fmt.Sprintf("  - name: %s", sensor.Name),
+			fmt.Sprintf("    model: %s", sensor.Model),
+		)
+
+		if sensor.Model == models.PMS5003 {
+			lines = append(lines, fmt.Sprintf("    port: %s", sensor.Port))
+			continue
+		}
+
+		lines = append(lines,
+			fmt.Sprintf("    bus: %d", sensor.Address.Bus),
+			fmt.Sprintf("    address: 0x%02x", sensor.Address.Device),
+		)
+		if sensor.Address.Mux != nil {
+			lines = append(lines,
+				"    mux:",
+				fmt.Sprintf("      address: 0x%02x", sensor.Address.Mux.Address),
+				fmt.Sprintf("      channel: %d", sensor.Address.Mux.Channel),
+			)
+		}
+	}
+
+	for _, line := range lines {
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
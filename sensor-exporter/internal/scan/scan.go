@@ -0,0 +1,237 @@
+// Package scan discovers and identifies sensors attached to the I2C buses and serial ports of the host
+package scan
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sensor-exporter/aht20"
+	"sensor-exporter/i2cbus"
+	"sensor-exporter/internal/models"
+	"sensor-exporter/pms5003"
+	"sensor-exporter/sgp30"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+const driverName = "scan"
+
+// Options defines where to look for sensors
+type Options struct {
+	// Numbers of the I2C buses to scan; all /dev/i2c-N buses if empty
+	Buses []int
+	// Serial ports to listen on for PMS5003 frames
+	Ports []string
+	// Duration to listen on each serial port
+	SerialTimeout time.Duration
+	// Whether to look behind the channels of TCA9548A multiplexers
+	Muxes bool
+}
+
+var (
+	// DefaultPorts are the serial ports on which a PMS5003 is commonly attached to a Raspberry Pi
+	DefaultPorts = []string{"/dev/serial0", "/dev/ttyAMA0", "/dev/ttyS0", "/dev/ttyUSB0"}
+
+	ahtAddresses = []uint8{0x38, 0x39}
+	sgpAddresses = []uint8{0x58}
+	// Addresses a TCA9548A can be strapped to, which other devices such as the BME280 share; ProbeTCA9548A only switches
+	// channels after the control register reads back what was written to it
+	muxAddresses = []uint8{0x70, 0x71, 0x72, 0x73, 0x74, 0x75, 0x76, 0x77}
+)
+
+// Found is a sensor identified by a scan
+type Found struct {
+	// Name of the sensor in the generated configuration
+	Name string
+	// Model of the sensor, as given in the model setting of a sensor
+	Model string
+	// Serial port of a PMS5003
+	Port string
+	// Where an AHT20 or SGP30 is attached
+	Address i2cbus.Address
+	// Human readable description of what the sensor reported about itself
+	Description string
+}
+
+// Buses returns the numbers of the I2C buses of the host
+func Buses() ([]int, error) {
+	paths, err := filepath.Glob("/dev/i2c-*")
+	if err != nil {
+		return nil, err
+	}
+
+	buses := []int{}
+	for _, path := range paths {
+		number, err := strconv.Atoi(strings.TrimPrefix(path, "/dev/i2c-"))
+		if err != nil {
+			continue
+		}
+		buses = append(buses, number)
+	}
+	sort.Ints(buses)
+	return buses, nil
+}
+
+// Scan probes the I2C buses and serial ports for known sensors
+func Scan(ctx context.Context, options Options) ([]Found, error) {
+	buses := options.Buses
+	if len(buses) == 0 {
+		var err error
+		buses, err = Buses()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	found := []Found{}
+	manager := i2cbus.NewManager()
+	for _, bus := range buses {
+		found = append(found, scanBus(ctx, manager, bus, options.Muxes)...)
+	}
+
+	for _, port := range options.Ports {
+		log.Info("listening for PMS5003 frames",
+			"port", port,
+			"timeout", options.SerialTimeout)
+		reading, err := pms5003.Probe(ctx, port, options.SerialTimeout)
+		if err != nil {
+			log.Debug("no PMS5003 found",
+				"port", port,
+				"err", err)
+			continue
+		}
+
+		found = append(found, Found{
+			Model:       models.PMS5003,
+			Port:        port,
+			Description: fmt.Sprintf("version 0x%02x, error code 0x%02x", reading.Version(), reading.ErrorCode()),
+		})
+	}
+
+	nameSensors(found)
+	return found, nil
+}
+
+func scanBus(ctx context.Context, manager *i2cbus.Manager, bus int, muxes bool) []Found {
+	log.Info("scanning I2C bus",
+		"bus", i2cbus.Name(bus))
+
+	channels := []*i2cbus.MuxChannel{nil}
+	if muxes {
+		for _, addr := range muxAddresses {
+			isMux := probe(ctx, manager, i2cbus.Address{Bus: bus, Device: addr}, func(device *i2cbus.Device) (bool, error) {
+				return i2cbus.ProbeTCA9548A(ctx, device)
+			})
+			if !isMux {
+				continue
+			}
+
+			log.Info("found TCA9548A multiplexer",
+				"bus", i2cbus.Name(bus),
+				"address", fmt.Sprintf("0x%02x", addr))
+			for channel := 0; channel < 8; channel++ {
+				channels = append(channels, &i2cbus.MuxChannel{Address: addr, Channel: channel})
+			}
+		}
+	}
+
+	found := []Found{}
+	direct := map[uint8]bool{}
+	for _, channel := range channels {
+		for _, addr := range ahtAddresses {
+			if direct[addr] {
+				continue
+			}
+
+			address := i2cbus.Address{Bus: bus, Mux: channel, Device: addr}
+			var info *aht20.Info
+			ok := probe(ctx, manager, address, func(device *i2cbus.Device) (bool, error) {
+				var err error
+				info, err = aht20.Probe(ctx, device)
+				return err == nil, err
+			})
+			if !ok {
+				continue
+			}
+
+			if channel == nil {
+				direct[addr] = true
+			}
+			found = append(found, newFound(models.AHT20, address, fmt.Sprintf("variant %v, status 0x%02x", info.Variant, info.Status)))
+		}
+
+		for _, addr := range sgpAddresses {
+			if direct[addr] {
+				continue
+			}
+
+			address := i2cbus.Address{Bus: bus, Mux: channel, Device: addr}
+			var info *sgp30.Info
+			ok := probe(ctx, manager, address, func(device *i2cbus.Device) (bool, error) {
+				var err error
+				info, err = sgp30.Probe(ctx, device)
+				return err == nil, err
+			})
+			if !ok {
+				continue
+			}
+
+			if channel == nil {
+				direct[addr] = true
+			}
+			description := fmt.Sprintf("serial %v, feature set 0x%04x", sgp30.FormatSerial(info.Serial), info.FeatureSet)
+			if !sgp30.IsSupportedFeatureSet(info.FeatureSet) {
+				description += " (unsupported)"
+			}
+			found = append(found, newFound(models.SGP30, address, description))
+		}
+	}
+	return found
+}
+
+// probe opens the device at an address and reports whether fn identified it
+func probe(ctx context.Context, manager *i2cbus.Manager, address i2cbus.Address, fn func(device *i2cbus.Device) (bool, error)) bool {
+	device, err := manager.Open(driverName, address)
+	if err != nil {
+		log.Debug("failed to open device",
+			"address", address,
+			"err", err)
+		return false
+	}
+	defer device.Close()
+
+	ok, err := fn(device)
+	if err != nil {
+		log.Debug("no device identified",
+			"address", address,
+			"err", err)
+		return false
+	}
+	return ok
+}
+
+func newFound(model string, address i2cbus.Address, description string) Found {
+	return Found{
+		Model:       model,
+		Address:     address,
+		Description: description,
+	}
+}
+
+// nameSensors names the first sensor of each model after the model, as the exporter does by default, and numbers the rest
+func nameSensors(found []Found) {
+	counts := map[string]int{}
+	for i := range found {
+		model := found[i].Model
+		counts[model]++
+		if counts[model] == 1 {
+			found[i].Name = model
+		} else {
+			found[i].Name = fmt.Sprintf("%s-%d", model, counts[model])
+		}
+	}
+}
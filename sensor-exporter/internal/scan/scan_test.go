@@ -0,0 +1,218 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sensor-exporter/i2cbus"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/sigurn/crc8"
+)
+
+// ahtDevice answers every read with its status byte
+type ahtDevice struct {
+	status byte
+}
+
+func (d *ahtDevice) Read(buf []byte) (int, error) {
+	buf[0] = d.status
+	return len(buf), nil
+}
+
+func (d *ahtDevice) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+// sgpDevice answers the serial and feature set commands of an SGP30 with words followed by their CRC
+type sgpDevice struct {
+	serial     []uint16
+	featureSet uint16
+	command    []byte
+}
+
+var sensirionTable = crc8.MakeTable(crc8.Params{Poly: 0x31, Init: 0xFF, Name: "CRC-8/Sensiron"})
+
+func (d *sgpDevice) Read(buf []byte) (int, error) {
+	var words []uint16
+	switch {
+	case bytes.Equal(d.command, []byte{0x36, 0x82}):
+		words = d.serial
+	case bytes.Equal(d.command, []byte{0x20, 0x2F}):
+		words = []uint16{d.featureSet}
+	default:
+		return 0, errors.Errorf("unexpected command %x", d.command)
+	}
+	response := []byte{}
+	for _, word := range words {
+		data := []byte{byte(word >> 8), byte(word)}
+		response = append(response, data[0], data[1], crc8.Checksum(data, sensirionTable))
+	}
+	return copy(buf, response), nil
+}
+
+func (d *sgpDevice) Write(buf []byte) (int, error) {
+	d.command = append([]byte{}, buf...)
+	return len(buf), nil
+}
+
+// registerDevice reads back the last byte written to it, like the control register of a TCA9548A, or a fixed value
+type registerDevice struct {
+	fixed    *byte
+	register byte
+}
+
+func (d *registerDevice) Read(buf []byte) (int, error) {
+	buf[0] = d.register
+	if d.fixed != nil {
+		buf[0] = *d.fixed
+	}
+	return len(buf), nil
+}
+
+func (d *registerDevice) Write(buf []byte) (int, error) {
+	d.register = buf[0]
+	return len(buf), nil
+}
+
+// behindMux is a device attached to a channel of a multiplexer, which only answers while that channel is selected
+type behindMux struct {
+	mux     *registerDevice
+	channel int
+	device  io.ReadWriter
+}
+
+func (d *behindMux) selected() error {
+	if d.mux.register&(1<<d.channel) == 0 {
+		return errors.New("no acknowledgement")
+	}
+	return nil
+}
+
+func (d *behindMux) Read(buf []byte) (int, error) {
+	err := d.selected()
+	if err != nil {
+		return 0, err
+	}
+	return d.device.Read(buf)
+}
+
+func (d *behindMux) Write(buf []byte) (int, error) {
+	err := d.selected()
+	if err != nil {
+		return 0, err
+	}
+	return d.device.Write(buf)
+}
+
+func TestScanBus(t *testing.T) {
+	chipID := byte(0x60)
+	mux := &registerDevice{}
+	tests := []struct {
+		name    string
+		devices map[uint8]io.ReadWriter
+		muxes   bool
+		found   []string
+	}{
+		{
+			name:  "no devices",
+			found: []string{},
+		},
+		{
+			name: "AHT20 and SGP30",
+			devices: map[uint8]io.ReadWriter{
+				0x38: &ahtDevice{0x18},
+				0x58: &sgpDevice{serial: []uint16{0x0000, 0x0123, 0x4567}, featureSet: 0x0022},
+			},
+			found: []string{
+				"aht20 i2c-1/0x38 variant AHT2x, status 0x18",
+				"sgp30 i2c-1/0x58 serial 000001234567, feature set 0x0022",
+			},
+		},
+		{
+			name:    "AHT10 at the alternative address",
+			devices: map[uint8]io.ReadWriter{0x39: &ahtDevice{0x08}},
+			found:   []string{"aht20 i2c-1/0x39 variant AHT1x, status 0x08"},
+		},
+		{
+			name:    "device with reserved status bits set",
+			devices: map[uint8]io.ReadWriter{0x38: &ahtDevice{0xff}},
+			found:   []string{},
+		},
+		{
+			name:    "SGP30 with an unsupported feature set",
+			devices: map[uint8]io.ReadWriter{0x58: &sgpDevice{serial: []uint16{1, 2, 3}, featureSet: 0x0010}},
+			found:   []string{"sgp30 i2c-1/0x58 serial 000100020003, feature set 0x0010 (unsupported)"},
+		},
+		{
+			name:    "multiplexers not scanned unless asked",
+			devices: map[uint8]io.ReadWriter{0x70: &registerDevice{}, 0x38: &ahtDevice{0x18}},
+			found:   []string{"aht20 i2c-1/0x38 variant AHT2x, status 0x18"},
+		},
+		{
+			name:    "device on the bus not found again behind a multiplexer",
+			devices: map[uint8]io.ReadWriter{0x70: &registerDevice{}, 0x38: &ahtDevice{0x18}},
+			muxes:   true,
+			found:   []string{"aht20 i2c-1/0x38 variant AHT2x, status 0x18"},
+		},
+		{
+			name: "device behind a multiplexer",
+			devices: map[uint8]io.ReadWriter{
+				0x71: mux,
+				0x58: &behindMux{mux, 2, &sgpDevice{serial: []uint16{1, 2, 3}, featureSet: 0x0020}},
+			},
+			muxes: true,
+			found: []string{"sgp30 i2c-1/0x71:2/0x58 serial 000100020003, feature set 0x0020"},
+		},
+		{
+			name:    "other device at a multiplexer address",
+			devices: map[uint8]io.ReadWriter{0x76: &registerDevice{fixed: &chipID}, 0x38: &ahtDevice{0x18}},
+			muxes:   true,
+			found:   []string{"aht20 i2c-1/0x38 variant AHT2x, status 0x18"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager := i2cbus.NewFakeManager(test.devices)
+			found := []string{}
+			for _, sensor := range scanBus(context.Background(), manager, 1, test.muxes) {
+				found = append(found, fmt.Sprintf("%v %v %v", sensor.Model, sensor.Address, sensor.Description))
+			}
+			if strings.Join(found, "\n") != strings.Join(test.found, "\n") {
+				t.Errorf("found\n%v\nwant\n%v", strings.Join(found, "\n"), strings.Join(test.found, "\n"))
+			}
+		})
+	}
+}
+
+func TestNameSensors(t *testing.T) {
+	tests := []struct {
+		name   string
+		models []string
+		names  []string
+	}{
+		{"one of each model", []string{"aht20", "sgp30", "pms5003"}, []string{"aht20", "sgp30", "pms5003"}},
+		{"numbered after the first", []string{"aht20", "sgp30", "aht20", "aht20"}, []string{"aht20", "sgp30", "aht20-2", "aht20-3"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found := []Found{}
+			for _, model := range test.models {
+				found = append(found, Found{Model: model})
+			}
+			nameSensors(found)
+			names := []string{}
+			for _, sensor := range found {
+				names = append(names, sensor.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(test.names, ",") {
+				t.Errorf("got names %v, want %v", names, test.names)
+			}
+		})
+	}
+}
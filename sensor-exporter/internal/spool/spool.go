@@ -0,0 +1,191 @@
+// Package spool is a bounded on-disk queue of batches, which outputs use to keep data while their destination is
+// unreachable and across restarts
+package spool
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sensor-exporter/internal/state"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Spool holds batches in files named by a sequence number, so that they sort in the order they were put regardless of
+// steps of the wall clock. It is safe for concurrent use by one producer and one consumer.
+type Spool struct {
+	dir      string
+	suffix   string
+	maxBytes int64
+
+	mu    sync.Mutex
+	files []file
+	size  int64
+	// Sequence number of the latest batch, which continues from the highest found when the spool was opened
+	sequence uint64
+	// Name of the batch the consumer peeked at and has not removed yet, which is not dropped to make room
+	inFlight string
+}
+
+type file struct {
+	name string
+	size int64
+}
+
+// Open opens the spool in the given directory, picking up batches put before a restart. Files with other suffixes,
+// such as partially written batches, are ignored.
+func Open(dir string, suffix string, maxBytes int64) (*Spool, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create spool directory %v", dir)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read spool directory %v", dir)
+	}
+
+	s := &Spool{
+		dir:      dir,
+		suffix:   suffix,
+		maxBytes: maxBytes,
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), suffix) {
+			continue
+		}
+		s.files = append(s.files, file{entry.Name(), entry.Size()})
+		s.size += entry.Size()
+		if sequence := parseSequence(entry.Name(), suffix); sequence > s.sequence {
+			s.sequence = sequence
+		}
+	}
+	sort.Slice(s.files, func(i, j int) bool {
+		return s.files[i].name < s.files[j].name
+	})
+	return s, nil
+}
+
+// parseSequence returns the sequence number a batch is named by, or 0 if its name does not start with one. Batches
+// spooled by earlier versions are named by the time they were put followed by a counter, so batches put after them
+// continue from that time and still sort after them.
+func parseSequence(name, suffix string) uint64 {
+	name = strings.TrimSuffix(name, suffix)
+	if idx := strings.Index(name, "-"); idx >= 0 {
+		name = name[:idx]
+	}
+	sequence, err := strconv.ParseUint(name, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return sequence
+}
+
+// Dir returns the directory of the spool
+func (s *Spool) Dir() string {
+	return s.dir
+}
+
+// Len returns the number of batches in the spool
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.files)
+}
+
+// Size returns the total size of the batches in the spool
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Put appends a batch, removing the oldest batches if the spool grows beyond its size limit, and returns the removed
+// batches so that the caller can account for them
+func (s *Spool) Put(data []byte) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sequence++
+	name := fmt.Sprintf("%020d%v", s.sequence, s.suffix)
+	err := state.WriteAtomically(filepath.Join(s.dir, name), data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to spool batch")
+	}
+	s.files = append(s.files, file{name, int64(len(data))})
+	s.size += int64(len(data))
+
+	// the newest batch is always kept, as is the batch in flight
+	dropped := [][]byte{}
+	for s.size > s.maxBytes {
+		oldest := 0
+		if s.files[0].name == s.inFlight {
+			oldest = 1
+		}
+		if oldest >= len(s.files)-1 {
+			break
+		}
+		data, err := s.read(oldest)
+		if err == nil {
+			dropped = append(dropped, data)
+		}
+		err = s.remove(oldest)
+		if err != nil {
+			return dropped, err
+		}
+	}
+	return dropped, nil
+}
+
+// Peek reads the oldest batch and returns its name, which is passed to Remove once the batch has been consumed. The
+// batch is not dropped to make room for newer batches until then.
+func (s *Spool) Peek() (string, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.files) == 0 {
+		return "", nil, errors.New("failed to read from empty spool")
+	}
+	s.inFlight = s.files[0].name
+	data, err := s.read(0)
+	return s.inFlight, data, err
+}
+
+func (s *Spool) read(index int) ([]byte, error) {
+	path := filepath.Join(s.dir, s.files[index].name)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read spooled batch %v", path)
+	}
+	return data, nil
+}
+
+// Remove removes a batch returned by Peek
+func (s *Spool) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name == s.inFlight {
+		s.inFlight = ""
+	}
+	for index := range s.files {
+		if s.files[index].name == name {
+			return s.remove(index)
+		}
+	}
+	return nil
+}
+
+func (s *Spool) remove(index int) error {
+	path := filepath.Join(s.dir, s.files[index].name)
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove spooled batch %v", path)
+	}
+	s.size -= s.files[index].size
+	s.files = append(s.files[:index], s.files[index+1:]...)
+	return nil
+}
@@ -0,0 +1,200 @@
+package spool
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpoolEvictsOldestFirst(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxBytes int64
+		// Batches put in order, where a step of "peek" instead peeks at the oldest batch, keeping it in flight, and "remove"
+		// removes the batch peeked at last
+		steps   []string
+		dropped []string
+		kept    []string
+	}{
+		{
+			name:     "below the limit",
+			maxBytes: 10,
+			steps:    []string{"aa", "bb", "cc"},
+			dropped:  []string{},
+			kept:     []string{"aa", "bb", "cc"},
+		},
+		{
+			name:     "oldest dropped first",
+			maxBytes: 4,
+			steps:    []string{"aa", "bb", "cc", "dd"},
+			dropped:  []string{"aa", "bb"},
+			kept:     []string{"cc", "dd"},
+		},
+		{
+			name:     "newest kept beyond the limit",
+			maxBytes: 4,
+			steps:    []string{"aa", "bbbbbb"},
+			dropped:  []string{"aa"},
+			kept:     []string{"bbbbbb"},
+		},
+		{
+			name:     "batch in flight kept",
+			maxBytes: 4,
+			steps:    []string{"aa", "peek", "bb", "cc", "dd"},
+			dropped:  []string{"bb", "cc"},
+			kept:     []string{"aa", "dd"},
+		},
+		{
+			name:     "batch in flight removed",
+			maxBytes: 4,
+			steps:    []string{"aa", "peek", "bb", "remove", "cc", "dd"},
+			dropped:  []string{"bb"},
+			kept:     []string{"cc", "dd"},
+		},
+		{
+			name:     "in flight and newest kept",
+			maxBytes: 2,
+			steps:    []string{"aa", "peek", "bb", "cc"},
+			dropped:  []string{"bb"},
+			kept:     []string{"aa", "cc"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := Open(dir, ".batch", test.maxBytes)
+			if err != nil {
+				t.Fatalf("failed to open spool: %v", err)
+			}
+
+			dropped := []string{}
+			peeked := ""
+			for _, step := range test.steps {
+				switch step {
+				case "peek":
+					peeked, _, err = s.Peek()
+				case "remove":
+					err = s.Remove(peeked)
+				default:
+					var batches [][]byte
+					batches, err = s.Put([]byte(step))
+					for _, batch := range batches {
+						dropped = append(dropped, string(batch))
+					}
+				}
+				if err != nil {
+					t.Fatalf("failed to %v: %v", step, err)
+				}
+			}
+			if strings.Join(dropped, ",") != strings.Join(test.dropped, ",") {
+				t.Errorf("dropped %v, want %v", dropped, test.dropped)
+			}
+
+			// the batches are read back from a spool opened again, as after a restart, in the order they were put
+			s, err = Open(dir, ".batch", test.maxBytes)
+			if err != nil {
+				t.Fatalf("failed to reopen spool: %v", err)
+			}
+			kept := []string{}
+			for s.Len() > 0 {
+				name, batch, err := s.Peek()
+				if err != nil {
+					t.Fatalf("failed to peek: %v", err)
+				}
+				kept = append(kept, string(batch))
+				err = s.Remove(name)
+				if err != nil {
+					t.Fatalf("failed to remove: %v", err)
+				}
+			}
+			if strings.Join(kept, ",") != strings.Join(test.kept, ",") {
+				t.Errorf("kept %v, want %v", kept, test.kept)
+			}
+			if s.Size() != 0 {
+				t.Errorf("got size %v after removing every batch, want 0", s.Size())
+			}
+		})
+	}
+}
+
+func TestSpoolOrderAcrossRestarts(t *testing.T) {
+	tests := []struct {
+		name string
+		// Files in the spool directory before it is opened, by name
+		existing map[string]string
+		// Batches put, with "reopen" opening the spool again as after a restart
+		steps []string
+		// Batches read back in order
+		kept []string
+	}{
+		{
+			name:  "sequence continued after a restart",
+			steps: []string{"aa", "bb", "reopen", "cc", "reopen", "dd"},
+			kept:  []string{"aa", "bb", "cc", "dd"},
+		},
+		{
+			name: "batches of an earlier version named by time",
+			existing: map[string]string{
+				"01700000000000000000-000002.batch": "old2",
+				"01700000000000000000-000001.batch": "old1",
+				"01600000000000000000-000003.batch": "old0",
+			},
+			steps: []string{"aa", "reopen", "bb"},
+			kept:  []string{"old0", "old1", "old2", "aa", "bb"},
+		},
+		{
+			name: "partially written batches ignored",
+			existing: map[string]string{
+				"00000000000000000001.batch":              "aa",
+				"00000000000000000009.batch.tmp-12345678": "partial",
+			},
+			steps: []string{"bb"},
+			kept:  []string{"aa", "bb"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, contents := range test.existing {
+				err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s, err := Open(dir, ".batch", 1<<20)
+			if err != nil {
+				t.Fatalf("failed to open spool: %v", err)
+			}
+			for _, step := range test.steps {
+				if step == "reopen" {
+					s, err = Open(dir, ".batch", 1<<20)
+				} else {
+					_, err = s.Put([]byte(step))
+				}
+				if err != nil {
+					t.Fatalf("failed to %v: %v", step, err)
+				}
+			}
+
+			kept := []string{}
+			for s.Len() > 0 {
+				name, batch, err := s.Peek()
+				if err != nil {
+					t.Fatalf("failed to peek: %v", err)
+				}
+				kept = append(kept, string(batch))
+				err = s.Remove(name)
+				if err != nil {
+					t.Fatalf("failed to remove: %v", err)
+				}
+			}
+			if strings.Join(kept, ",") != strings.Join(test.kept, ",") {
+				t.Errorf("kept %v, want %v", kept, test.kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// WriteAtomically replaces the file at path with data such that a crash or power loss leaves either the old or the
+// new contents in place, never a truncated file
+func WriteAtomically(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	temp, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return errors.Wrapf(err, "failed to create temporary file for %v", path)
+	}
+	tempPath := temp.Name()
+	defer os.Remove(tempPath)
+
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Chmod(0644)
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	closeErr := temp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to write temporary file %v", tempPath)
+	}
+
+	err = os.Rename(tempPath, path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to replace %v", path)
+	}
+
+	return syncDir(dir)
+}
+
+// syncDir flushes a directory so that a rename within it survives power loss
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open directory %v", dir)
+	}
+	defer d.Close()
+
+	err = d.Sync()
+	if err != nil {
+		return errors.Wrapf(err, "failed to sync directory %v", dir)
+	}
+	return nil
+}
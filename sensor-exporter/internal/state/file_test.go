@@ -0,0 +1,99 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteAtomically(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing *string
+		data     string
+	}{
+		{"new file", nil, `{"version":2}`},
+		{"replaces existing file", stringPtr(`{"version":2,"sensors":{"old":{}}}`), `{"version":2}`},
+		{"replaces with empty contents", stringPtr(`{"version":2}`), ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "state.json")
+			if test.existing != nil {
+				err := ioutil.WriteFile(path, []byte(*test.existing), 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := WriteAtomically(path, []byte(test.data))
+			if err != nil {
+				t.Fatalf("failed to write: %v", err)
+			}
+
+			bytes, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(bytes) != test.data {
+				t.Errorf("got contents %q, want %q", bytes, test.data)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0644 {
+				t.Errorf("got mode %v, want %v", info.Mode().Perm(), os.FileMode(0644))
+			}
+			assertFiles(t, dir, "state.json")
+		})
+	}
+}
+
+func TestWriteAtomicallyFailure(t *testing.T) {
+	dir := t.TempDir()
+	// a directory cannot be replaced by a file, so the rename fails after the temporary file was written
+	path := filepath.Join(dir, "state.json")
+	err := os.Mkdir(path, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(path, "keep"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = WriteAtomically(path, []byte(`{"version":2}`))
+	if err == nil {
+		t.Fatalf("got no error replacing a directory")
+	}
+	assertFiles(t, dir, "state.json")
+}
+
+// assertFiles fails the test unless dir holds exactly the named entries, such as no temporary files left behind
+func assertFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := []string{}
+	for _, entry := range entries {
+		found = append(found, entry.Name())
+	}
+	if len(found) != len(names) {
+		t.Fatalf("got files %v, want %v", found, names)
+	}
+	for i := range names {
+		if found[i] != names[i] {
+			t.Fatalf("got files %v, want %v", found, names)
+		}
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
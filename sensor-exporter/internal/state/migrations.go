@@ -0,0 +1,76 @@
+package state
+
+import (
+	"encoding/json"
+	"sensor-exporter/sgp30"
+
+	"github.com/pkg/errors"
+)
+
+// currentVersion is the schema version written by this version of the exporter
+const currentVersion = 2
+
+var errUnsupportedVersion = errors.New("unsupported state version")
+
+// migrations upgrade a document from the schema version at their index plus one to the next version
+var migrations = []func(bytes []byte) ([]byte, error){
+	migrateBaselineFile,
+}
+
+// decode reads a document of any known schema version, migrating it to the current version
+func decode(bytes []byte) (*document, error) {
+	var header struct {
+		Version *int `json:"version"`
+	}
+	err := json.Unmarshal(bytes, &header)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal state version")
+	}
+
+	// baseline files written before the state store have no version and are treated as version 1
+	version := 1
+	if header.Version != nil {
+		version = *header.Version
+	}
+	if version < 1 || version > currentVersion {
+		return nil, errors.Wrapf(errUnsupportedVersion, "failed to migrate state from version %v to %v", version, currentVersion)
+	}
+
+	for ; version < currentVersion; version++ {
+		bytes, err = migrations[version-1](bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to migrate state from version %v", version)
+		}
+	}
+
+	doc := &document{}
+	err = json.Unmarshal(bytes, doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal state")
+	}
+	if doc.Sensors == nil {
+		doc.Sensors = map[string]*Sensor{}
+	}
+	return doc, nil
+}
+
+// migrateBaselineFile converts the single SGP30 baseline of a version 1 baseline file into a version 2 document keyed by
+// the serial of the sensor
+func migrateBaselineFile(bytes []byte) ([]byte, error) {
+	var baseline *sgp30.BaselineReading
+	err := json.Unmarshal(bytes, &baseline)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal baseline")
+	}
+
+	doc := newDocument()
+	doc.Version = 2
+	if baseline != nil && len(baseline.Serial) > 0 {
+		doc.Sensors[SerialKey("sgp30", sgp30.FormatSerial(baseline.Serial))] = &Sensor{
+			Model:     "SGP30",
+			Baseline:  baseline,
+			UpdatedAt: baseline.StoredAt(),
+		}
+	}
+	return json.Marshal(doc)
+}
@@ -0,0 +1,304 @@
+// Package state persists long-lived sensor state, such as SGP30 baselines and fan run time, across restarts
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sensor-exporter/sgp30"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+// Sensor is the persisted state of one sensor
+type Sensor struct {
+	// Model of the sensor
+	Model string `json:"model,omitempty"`
+	// Baseline of an SGP30 sensor to restore when it starts
+	Baseline *sgp30.BaselineReading `json:"baseline,omitempty"`
+	// Baselines read from an SGP30 sensor, oldest first, including those rejected by the baseline policy
+	BaselineHistory []BaselineRecord `json:"baselineHistory,omitempty"`
+	// Progress of an SGP30 sensor establishing its baseline, kept until it has acclimated
+	Acclimation *Acclimation `json:"acclimation,omitempty"`
+	// Total duration for which the fan of a PMS5003 sensor has run
+	FanRunTime time.Duration `json:"fanRunTime,omitempty"`
+	// Offsets added to the readings of the sensor by measurement, in the unit of the measurement
+	Calibration map[string]float64 `json:"calibration,omitempty"`
+	// Time at which the state was last updated
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// BaselineRecord is a baseline read from an SGP30 sensor and the conditions it was read under
+type BaselineRecord struct {
+	// Baseline read from the sensor
+	Baseline *sgp30.BaselineReading `json:"baseline"`
+	// Time at which the baseline was read
+	RecordedAt time.Time `json:"recordedAt"`
+	// Conditions around the sensor when the baseline was read
+	Conditions Conditions `json:"conditions"`
+	// Reason the baseline policy refused to store the baseline for restoring, if it did
+	Rejected string `json:"rejected,omitempty"`
+	// Whether the wall clock was not synchronized when the baseline was read, so that RecordedAt and the times in the
+	// baseline may be wrong
+	UntrustedTime bool `json:"untrustedTime,omitempty"`
+}
+
+// Acclimation is the progress of an SGP30 sensor establishing its baseline
+type Acclimation struct {
+	// Time at which the sensor started acclimating
+	StartedAt time.Time `json:"startedAt"`
+	// Duration for which the sensor has run while acclimating, which excludes time it was powered off
+	Elapsed time.Duration `json:"elapsed"`
+	// Time at which the sensor was last seen acclimating
+	LastSeen time.Time `json:"lastSeen"`
+	// Whether the wall clock was not synchronized when the progress was last saved, so that StartedAt and LastSeen may
+	// be wrong; Elapsed is measured on the monotonic clock and can be trusted
+	UntrustedTime bool `json:"untrustedTime,omitempty"`
+}
+
+// Conditions describes the environment of a sensor at a point in time. Fields are nil when unknown.
+type Conditions struct {
+	TemperatureCelsius                 *float64 `json:"temperatureCelsius,omitempty"`
+	RelativeHumidityRatio              *float64 `json:"relativeHumidityRatio,omitempty"`
+	AbsoluteHumidityGramsPerCubicMeter *float64 `json:"absoluteHumidityGramsPerCubicMeter,omitempty"`
+	EquivalentCO2PartsPerMillion       *float64 `json:"eco2PartsPerMillion,omitempty"`
+	TotalVOCPartsPerBillion            *float64 `json:"tvocPartsPerBillion,omitempty"`
+}
+
+type document struct {
+	Version int                `json:"version"`
+	Sensors map[string]*Sensor `json:"sensors"`
+}
+
+// Store holds the state of every sensor in a single file which is replaced atomically on each update
+type Store struct {
+	path string
+	mu   sync.Mutex
+	doc  *document
+}
+
+// SerialKey returns the key of a sensor that reports a serial number
+func SerialKey(model, serial string) string {
+	return strings.ToLower(model) + "/serial/" + serial
+}
+
+// InstanceKey returns the key of a sensor that cannot report a serial number, identified by its configured name instead
+func InstanceKey(model, name string) string {
+	return strings.ToLower(model) + "/instance/" + name
+}
+
+// Open reads the state file at path, creating its directory if needed. If the state file does not exist yet, the
+// legacy files, such as baseline files written by earlier versions, are migrated into it.
+func Open(path string, legacyPaths ...string) (*Store, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create state directory %v", filepath.Dir(path))
+	}
+
+	store := &Store{
+		path: path,
+		doc:  newDocument(),
+	}
+
+	bytes, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		for _, legacyPath := range legacyPaths {
+			store.mergeLegacy(legacyPath)
+		}
+		return store, nil
+	case err != nil:
+		return nil, errors.Wrapf(err, "failed to read state file %v", path)
+	}
+
+	doc, err := decode(bytes)
+	if errors.Is(err, errUnsupportedVersion) {
+		// refuse to start rather than overwrite state written by a newer version
+		return nil, errors.Wrapf(err, "failed to read state file %v", path)
+	}
+	if err != nil {
+		corruptPath := path + ".corrupt-" + time.Now().UTC().Format("20060102T150405Z")
+		log.Error("failed to decode state file; moving it aside and starting with empty state",
+			"err", err,
+			"path", path,
+			"corruptPath", corruptPath)
+		err = os.Rename(path, corruptPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to move corrupt state file %v aside", path)
+		}
+		return store, nil
+	}
+
+	store.doc = doc
+	return store, nil
+}
+
+// mergeLegacy migrates a legacy file into the store, logging rather than failing if it cannot be read
+func (s *Store) mergeLegacy(path string) {
+	if path == "" {
+		return
+	}
+
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Warn("failed to read legacy state file",
+			"err", err,
+			"path", path)
+		return
+	}
+
+	doc, err := decode(bytes)
+	if err != nil {
+		log.Warn("failed to migrate legacy state file",
+			"err", err,
+			"path", path)
+		return
+	}
+
+	for key, sensor := range doc.Sensors {
+		existing, ok := s.doc.Sensors[key]
+		if ok && existing.UpdatedAt.After(sensor.UpdatedAt) {
+			continue
+		}
+		s.doc.Sensors[key] = sensor
+	}
+	log.Info("migrated legacy state file",
+		"path", path,
+		"statePath", s.path)
+}
+
+// Path returns the path of the state file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Sensor returns a copy of the state of the sensor with the given key, or nil if none is stored
+func (s *Store) Sensor(key string) *Sensor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sensor, ok := s.doc.Sensors[key]
+	if !ok {
+		return nil
+	}
+	return sensor.clone()
+}
+
+// Sensors returns a copy of the state of every sensor by key
+func (s *Store) Sensors() map[string]*Sensor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sensors := map[string]*Sensor{}
+	for key, sensor := range s.doc.Sensors {
+		sensors[key] = sensor.clone()
+	}
+	return sensors
+}
+
+// Update changes the state of the sensor with the given key and writes the state file. The state in memory is only
+// changed if the update succeeds and the file was written.
+func (s *Store) Update(key string, update func(sensor *Sensor) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sensor := &Sensor{}
+	if existing, ok := s.doc.Sensors[key]; ok {
+		sensor = existing.clone()
+	}
+	err := update(sensor)
+	if err != nil {
+		return err
+	}
+	sensor.UpdatedAt = time.Now()
+
+	doc := &document{
+		Version: currentVersion,
+		Sensors: map[string]*Sensor{},
+	}
+	for k, v := range s.doc.Sensors {
+		doc.Sensors[k] = v
+	}
+	doc.Sensors[key] = sensor
+
+	err = s.write(doc)
+	if err != nil {
+		return err
+	}
+	s.doc = doc
+	return nil
+}
+
+// Delete removes the state of the sensor with the given key and writes the state file
+func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.doc.Sensors[key]; !ok {
+		return nil
+	}
+
+	doc := &document{
+		Version: currentVersion,
+		Sensors: map[string]*Sensor{},
+	}
+	for k, v := range s.doc.Sensors {
+		if k != key {
+			doc.Sensors[k] = v
+		}
+	}
+
+	err := s.write(doc)
+	if err != nil {
+		return err
+	}
+	s.doc = doc
+	return nil
+}
+
+func (s *Store) write(doc *document) error {
+	bytes, err := json.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal state")
+	}
+
+	return WriteAtomically(s.path, bytes)
+}
+
+func newDocument() *document {
+	return &document{
+		Version: currentVersion,
+		Sensors: map[string]*Sensor{},
+	}
+}
+
+func (s *Sensor) clone() *Sensor {
+	clone := *s
+	if s.Baseline != nil {
+		baseline := *s.Baseline
+		baseline.Serial = append([]uint16(nil), s.Baseline.Serial...)
+		clone.Baseline = &baseline
+	}
+	if s.Acclimation != nil {
+		acclimation := *s.Acclimation
+		clone.Acclimation = &acclimation
+	}
+	if s.BaselineHistory != nil {
+		clone.BaselineHistory = append([]BaselineRecord(nil), s.BaselineHistory...)
+	}
+	if s.Calibration != nil {
+		clone.Calibration = map[string]float64{}
+		for name, value := range s.Calibration {
+			clone.Calibration[name] = value
+		}
+	}
+	return &clone
+}
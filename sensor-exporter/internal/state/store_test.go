@@ -0,0 +1,251 @@
+package state
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sensor-exporter/sgp30"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const legacyBaseline = `{
+	"Serial": [0, 291, 17767],
+	"SensorReadingsNotValidBefore": "2024-01-01T12:00:00Z",
+	"BaselineInvalidAfter": "2024-01-08T00:00:00Z",
+	"TotalVOC": 120,
+	"EquivalentCO2": 410
+}`
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name string
+		// Contents of the state file, which does not exist if nil
+		state *string
+		// Contents of the legacy baseline file, which does not exist if nil
+		legacy *string
+		// Keys of the sensors in the opened store
+		keys []string
+		// Files in the state directory after opening, with the timestamp of moved aside files removed
+		files []string
+		// Whether opening is expected to fail with errUnsupportedVersion
+		unsupported bool
+	}{
+		{
+			name:  "no state",
+			keys:  []string{},
+			files: []string{},
+		},
+		{
+			name:  "current version",
+			state: stringPtr(`{"version": 2, "sensors": {"aht20/instance/aht20": {"model": "AHT20", "calibration": {"temperature": -1.5}}}}`),
+			keys:  []string{"aht20/instance/aht20"},
+			files: []string{"state.json"},
+		},
+		{
+			name:   "legacy baseline file migrated",
+			legacy: stringPtr(legacyBaseline),
+			keys:   []string{"sgp30/serial/" + sgp30.FormatSerial([]uint16{0, 291, 17767})},
+			files:  []string{"baseline.json"},
+		},
+		{
+			name:   "legacy baseline file ignored once the state file exists",
+			state:  stringPtr(`{"version": 2, "sensors": {}}`),
+			legacy: stringPtr(legacyBaseline),
+			keys:   []string{},
+			files:  []string{"baseline.json", "state.json"},
+		},
+		{
+			name:   "empty legacy baseline file",
+			legacy: stringPtr(`null`),
+			keys:   []string{},
+			files:  []string{"baseline.json"},
+		},
+		{
+			name:   "corrupt legacy baseline file skipped",
+			legacy: stringPtr(`{"Serial": [0, 291`),
+			keys:   []string{},
+			files:  []string{"baseline.json"},
+		},
+		{
+			name:  "state file without version read as a baseline file",
+			state: stringPtr(legacyBaseline),
+			keys:  []string{"sgp30/serial/" + sgp30.FormatSerial([]uint16{0, 291, 17767})},
+			files: []string{"state.json"},
+		},
+		{
+			name:  "corrupt state file moved aside",
+			state: stringPtr(`{"version": 2, "sensors": {`),
+			keys:  []string{},
+			files: []string{"state.json.corrupt-"},
+		},
+		{
+			name:  "state file of the wrong shape moved aside",
+			state: stringPtr(`{"version": 2, "sensors": []}`),
+			keys:  []string{},
+			files: []string{"state.json.corrupt-"},
+		},
+		{
+			name:        "future version refused",
+			state:       stringPtr(`{"version": 3, "sensors": {}}`),
+			files:       []string{"state.json"},
+			unsupported: true,
+		},
+		{
+			name:        "version 0 refused",
+			state:       stringPtr(`{"version": 0, "sensors": {}}`),
+			files:       []string{"state.json"},
+			unsupported: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "state.json")
+			legacyPath := filepath.Join(dir, "baseline.json")
+			if test.state != nil {
+				writeFile(t, path, *test.state)
+			}
+			if test.legacy != nil {
+				writeFile(t, legacyPath, *test.legacy)
+			}
+
+			store, err := Open(path, legacyPath)
+			if test.unsupported {
+				if !errors.Is(err, errUnsupportedVersion) {
+					t.Fatalf("got error %v, want %v", err, errUnsupportedVersion)
+				}
+				bytes, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(bytes) != *test.state {
+					t.Errorf("got state file %q, want it left unchanged", bytes)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("failed to open: %v", err)
+				}
+				keys := []string{}
+				for key := range store.Sensors() {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				if strings.Join(keys, ",") != strings.Join(test.keys, ",") {
+					t.Errorf("got sensors %v, want %v", keys, test.keys)
+				}
+			}
+
+			entries, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := []string{}
+			for _, entry := range entries {
+				name := entry.Name()
+				if i := strings.Index(name, ".corrupt-"); i >= 0 {
+					if _, err := time.Parse("20060102T150405Z", name[i+len(".corrupt-"):]); err != nil {
+						t.Errorf("got moved aside file %v, want it suffixed with a timestamp", name)
+					}
+					name = name[:i+len(".corrupt-")]
+				}
+				files = append(files, name)
+			}
+			if strings.Join(files, ",") != strings.Join(test.files, ",") {
+				t.Errorf("got files %v, want %v", files, test.files)
+			}
+		})
+	}
+}
+
+func TestMigrateBaselineFile(t *testing.T) {
+	doc, err := decode([]byte(legacyBaseline))
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if doc.Version != currentVersion {
+		t.Errorf("got version %v, want %v", doc.Version, currentVersion)
+	}
+
+	serial := []uint16{0, 291, 17767}
+	sensor, ok := doc.Sensors[SerialKey("SGP30", sgp30.FormatSerial(serial))]
+	if !ok {
+		t.Fatalf("got sensors %v, want the baseline keyed by serial", doc.Sensors)
+	}
+	if sensor.Model != "SGP30" {
+		t.Errorf("got model %v, want SGP30", sensor.Model)
+	}
+	if sensor.Baseline == nil || sensor.Baseline.TotalVOC != 120 || sensor.Baseline.EquivalentCO2 != 410 {
+		t.Errorf("got baseline %+v, want TVOC 120 and eCO2 410", sensor.Baseline)
+	}
+	storedAt := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC).Add(-sgp30.BaselineValidity)
+	if !sensor.UpdatedAt.Equal(storedAt) {
+		t.Errorf("got updated at %v, want the time the baseline was stored %v", sensor.UpdatedAt, storedAt)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := InstanceKey("AHT20", "aht20")
+	err = store.Update(key, func(sensor *Sensor) error {
+		sensor.Model = "AHT20"
+		sensor.Calibration = map[string]float64{"temperature": -1.5}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+
+	// copies returned by the store do not share the calibration with it
+	store.Sensor(key).Calibration["temperature"] = 10
+
+	err = store.Update(key, func(sensor *Sensor) error {
+		sensor.Calibration["relative_humidity"] = 0.02
+		return errors.New("failed to update")
+	})
+	if err == nil {
+		t.Fatalf("got no error from a failed update")
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*Store{store, reopened} {
+		calibration := s.Sensor(key).Calibration
+		if len(calibration) != 1 || calibration["temperature"] != -1.5 {
+			t.Errorf("got calibration %v, want temperature -1.5", calibration)
+		}
+	}
+
+	err = store.Delete(key)
+	if err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	reopened, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Sensor(key) != nil {
+		t.Errorf("got deleted sensor %+v, want nil", reopened.Sensor(key))
+	}
+	assertFiles(t, dir, "state.json")
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	err := ioutil.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,44 @@
+// Package version describes the build of the exporter
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version and Revision may be set at build time, e.g. -ldflags "-X sensor-exporter/internal/version.Version=v1.2.3"
+var (
+	Version  = "dev"
+	Revision = ""
+)
+
+// Info describes the build of the running exporter
+type Info struct {
+	Version   string
+	Revision  string
+	GoVersion string
+}
+
+// Get returns the build of the running exporter, falling back to the VCS revision stamped by the Go toolchain
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Revision:  Revision,
+		GoVersion: runtime.Version(),
+	}
+
+	if info.Revision == "" {
+		if build, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range build.Settings {
+				if setting.Key == "vcs.revision" {
+					info.Revision = setting.Value
+				}
+			}
+		}
+	}
+	if info.Revision == "" {
+		info.Revision = "unknown"
+	}
+
+	return info
+}
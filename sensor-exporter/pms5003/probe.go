@@ -0,0 +1,40 @@
+package pms5003
+
+import (
+	"bufio"
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tarm/serial"
+)
+
+// Probe listens on a serial port for up to the given duration and returns the first valid frame sent by a PMS5003
+func Probe(ctx context.Context, portName string, timeout time.Duration) (*Reading, error) {
+	config := newPortConfig(portName)
+	config.ReadTimeout = timeout
+	port, err := serial.OpenPort(config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open port %v", portName)
+	}
+	defer port.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	reader := bufio.NewReader(port)
+	for {
+		reading, err := readFrame(ctx, reader)
+		if err == errChecksum {
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil, errors.Errorf("failed to receive a frame on %v within %v", portName, timeout)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return reading, nil
+	}
+}
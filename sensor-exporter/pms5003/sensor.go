@@ -6,7 +6,7 @@ import (
 	"context"
 	"encoding/binary"
 	"io"
-	"time"
+	"sensor-exporter/reconnect"
 
 	"github.com/pkg/errors"
 	"github.com/syncromatics/go-kit/v2/log"
@@ -15,6 +15,8 @@ import (
 )
 
 const (
+	driverName = "pms5003"
+
 	startCharacter1 byte = 0x42
 	startCharacter2 byte = 0x4d
 )
@@ -51,43 +53,62 @@ type Reading struct {
 	Particles50um CountPerDeciliter
 	// Number of particles with diameter beyond 10.0 um in 0.1L of air.
 	Particles100um CountPerDeciliter
-	// Reserved
+	// Reserved; the high byte carries the firmware version and the low byte an error code
 	Unused uint16
 	// Check code
 	Checksum uint16
 }
 
+// Version returns the firmware version reported in the reserved data word
+func (r *Reading) Version() byte {
+	return byte(r.Unused >> 8)
+}
+
+// ErrorCode returns the error code reported in the reserved data word
+func (r *Reading) ErrorCode() byte {
+	return byte(r.Unused)
+}
+
+// Info describes the identity of the connected PMS5003 sensor
+type Info struct {
+	// Firmware version reported by the sensor
+	Version byte
+}
+
 type Sensor struct {
-	portName         string
-	readings         chan *Reading
-	reconnectTimeout time.Duration
+	portName          string
+	infos             chan *Info
+	readings          chan *Reading
+	reconnectSettings reconnect.Settings
 }
 
-func NewSensor(portName string, reconnectTimeout time.Duration) *Sensor {
+func NewSensor(portName string, reconnectSettings reconnect.Settings) *Sensor {
+	infos := make(chan *Info)
 	readings := make(chan *Reading)
 	return &Sensor{
 		portName,
+		infos,
 		readings,
-		reconnectTimeout,
+		reconnectSettings,
 	}
 }
 
+func (s *Sensor) Infos() <-chan *Info {
+	return s.infos
+}
+
 func (s *Sensor) Readings() <-chan *Reading {
 	return s.readings
 }
 
 func (s *Sensor) Start(ctx context.Context) func() error {
 	return func() error {
+		defer close(s.infos)
 		defer close(s.readings)
 
+		policy := reconnect.NewPolicy(s.reconnectSettings, driverName, s.portName)
 		for {
-			config := &serial.Config{
-				Name:     s.portName,
-				Baud:     9600,
-				Size:     8,
-				Parity:   serial.ParityNone,
-				StopBits: serial.Stop1,
-			}
+			config := newPortConfig(s.portName)
 			port, err := serial.OpenPort(config)
 			if err != nil {
 				return errors.Wrapf(err, "failed to open port %v", config.Name)
@@ -96,40 +117,28 @@ func (s *Sensor) Start(ctx context.Context) func() error {
 			reader := bufio.NewReader(port)
 			group, innerCtx := errgroup.WithContext(ctx)
 			group.Go(func() error {
+				var info *Info
 				for {
-					err = seekToRecordStart(innerCtx, reader)
-					if err != nil {
-						return errors.Wrap(err, "failed to seek to start of record")
+					reading, err := readFrame(innerCtx, reader)
+					if err == errChecksum {
+						continue
 					}
-
-					buf := make([]byte, 30)
-					_, err := io.ReadFull(reader, buf)
 					if err != nil {
-						return errors.Wrap(err, "failed to read record")
+						return err
 					}
 
-					rdr := bytes.NewReader(buf)
-					reading := &Reading{}
-					err = binary.Read(rdr, binary.BigEndian, reading)
-					if err != nil {
-						return errors.Wrapf(err, "failed to read %v into struct", buf)
-					}
-
-					var expectedChecksum uint16 = uint16(startCharacter1) + uint16(startCharacter2)
-					for i := 0; i < 28; i++ {
-						expectedChecksum += uint16(buf[i])
-					}
-
-					if reading.Checksum != expectedChecksum {
-						log.Debug("failed to validate checksum",
-							"buf", buf,
-							"reading", reading,
-							"expectedChecksum", expectedChecksum)
-						continue
+					if info == nil || info.Version != reading.Version() {
+						info = &Info{reading.Version()}
+						select {
+						case s.infos <- info:
+						case <-innerCtx.Done():
+							return nil
+						}
 					}
 
 					select {
 					case s.readings <- reading:
+						policy.Healthy()
 					case <-innerCtx.Done():
 						return nil
 					}
@@ -142,22 +151,68 @@ func (s *Sensor) Start(ctx context.Context) func() error {
 			})
 
 			err = group.Wait()
-			log.Info("disconnected from sensor; waiting to reconnect",
-				"err", err,
-				"reconnectTimeout", s.reconnectTimeout)
-
-			select {
-			case <-ctx.Done():
+			if !policy.Wait(ctx, err) {
 				return nil
-			case <-time.After(s.reconnectTimeout):
-				log.Info("reconnecting")
 			}
 		}
 	}
 }
 
+var errChecksum = errors.New("failed to validate checksum")
+
+// newPortConfig returns the serial configuration of the sensor's UART
+func newPortConfig(portName string) *serial.Config {
+	return &serial.Config{
+		Name:     portName,
+		Baud:     9600,
+		Size:     8,
+		Parity:   serial.ParityNone,
+		StopBits: serial.Stop1,
+	}
+}
+
+// readFrame reads the next frame from the sensor, returning errChecksum if the frame is corrupt
+func readFrame(ctx context.Context, reader *bufio.Reader) (*Reading, error) {
+	err := seekToRecordStart(ctx, reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to seek to start of record")
+	}
+
+	buf := make([]byte, 30)
+	_, err = io.ReadFull(reader, buf)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read record")
+	}
+
+	rdr := bytes.NewReader(buf)
+	reading := &Reading{}
+	err = binary.Read(rdr, binary.BigEndian, reading)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v into struct", buf)
+	}
+
+	var expectedChecksum uint16 = uint16(startCharacter1) + uint16(startCharacter2)
+	for i := 0; i < 28; i++ {
+		expectedChecksum += uint16(buf[i])
+	}
+
+	if reading.Checksum != expectedChecksum {
+		log.Debug("failed to validate checksum",
+			"buf", buf,
+			"reading", reading,
+			"expectedChecksum", expectedChecksum)
+		return nil, errChecksum
+	}
+
+	return reading, nil
+}
+
 func seekToRecordStart(ctx context.Context, reader *bufio.Reader) error {
 	for {
+		if ctx.Err() != nil {
+			return io.EOF
+		}
+
 		b, err := reader.ReadByte()
 		if err != nil {
 			return err
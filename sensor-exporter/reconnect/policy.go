@@ -0,0 +1,243 @@
+// Package reconnect decides how long a driver waits before reconnecting to a sensor after a failure
+package reconnect
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sensor-exporter/internal/instrumentation"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/go-kit/v2/log"
+)
+
+// Settings configures the backoff and circuit breaker of a reconnect policy
+type Settings struct {
+	// Delay before the first reconnect attempt
+	InitialDelay time.Duration
+	// Upper bound of the delay between reconnect attempts
+	MaxDelay time.Duration
+	// Factor by which the delay grows after each consecutive failure
+	Multiplier float64
+	// Fraction of the delay by which it is randomly varied, between 0 and 1
+	Jitter float64
+	// Duration of healthy readings after which the backoff resets
+	ResetAfter time.Duration
+	// Number of consecutive failures after which the circuit opens
+	FailureThreshold int
+	// Duration to wait while the circuit is open before probing the sensor again
+	OpenDuration time.Duration
+	// Called with the status of the connection whenever it changes; may be nil
+	OnStatus func(Status)
+}
+
+// Validate returns an error if the settings cannot be applied
+func (s Settings) Validate() error {
+	if s.Jitter < 0 || s.Jitter > 1 {
+		return errors.Errorf("jitter %v is not between 0 and 1", s.Jitter)
+	}
+	return nil
+}
+
+// Status describes the connection to a sensor as seen by its reconnect policy
+type Status struct {
+	// Whether the sensor has produced a healthy reading since it last failed
+	Connected bool
+	// State of the circuit breaker
+	Circuit State
+	// Number of consecutive failures
+	Failures int
+	// Most recent failure, if any
+	LastError error
+	// Time of the most recent failure
+	LastErrorAt time.Time
+}
+
+// State of the circuit breaker
+type State int
+
+const (
+	// Closed means reconnect attempts back off exponentially
+	Closed State = iota
+	// HalfOpen means the circuit was open and a single probe is underway
+	HalfOpen
+	// Open means the sensor failed repeatedly and reconnects wait for the open duration
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// Policy tracks the failures of one sensor and paces its reconnect attempts
+type Policy struct {
+	settings Settings
+	driver   string
+	device   string
+
+	mu           sync.Mutex
+	random       *rand.Rand
+	failures     int
+	state        State
+	healthySince time.Time
+	connected    bool
+	lastError    error
+	lastErrorAt  time.Time
+}
+
+func NewPolicy(settings Settings, driver, device string) *Policy {
+	p := &Policy{
+		settings: settings,
+		driver:   driver,
+		device:   device,
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	instrumentation.SetCircuitState(driver, device, int(Closed))
+	return p
+}
+
+// State returns the current state of the circuit breaker
+func (p *Policy) State() State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+// Healthy records a healthy reading from the sensor. A successful probe closes an open circuit, and once readings have been healthy for the reset period the backoff resets.
+func (p *Policy) Healthy() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.healthySince.IsZero() {
+		p.healthySince = now
+	}
+	if !p.connected {
+		p.connected = true
+		p.notify()
+	}
+
+	if p.state == HalfOpen {
+		log.Info("sensor recovered; closing circuit",
+			"driver", p.driver,
+			"device", p.device)
+		// the failures before the circuit opened are not held against the recovered sensor
+		p.failures = 0
+		p.setState(Closed)
+	}
+
+	if p.failures > 0 && now.Sub(p.healthySince) >= p.settings.ResetAfter {
+		p.failures = 0
+	}
+}
+
+// Wait records a failure and blocks until the sensor should be reconnected to. It returns false if the context is done first.
+func (p *Policy) Wait(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	delay := p.fail(err)
+
+	waitStart := time.Now()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(delay):
+	}
+	instrumentation.AddBackoff(p.driver, p.device, time.Since(waitStart))
+	instrumentation.IncReconnects(p.driver, p.device)
+
+	p.mu.Lock()
+	if p.state == Open {
+		p.setState(HalfOpen)
+	}
+	p.mu.Unlock()
+
+	log.Debug("reconnecting",
+		"driver", p.driver,
+		"device", p.device)
+	return true
+}
+
+func (p *Policy) fail(err error) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failures++
+	p.healthySince = time.Time{}
+	p.connected = false
+	p.lastError = err
+	p.lastErrorAt = time.Now()
+	defer p.notify()
+
+	if p.state == HalfOpen || (p.state == Closed && p.settings.FailureThreshold > 0 && p.failures >= p.settings.FailureThreshold) {
+		log.Warn("sensor keeps failing; opening circuit",
+			"driver", p.driver,
+			"device", p.device,
+			"err", err,
+			"failures", p.failures,
+			"openDuration", p.settings.OpenDuration)
+		p.setState(Open)
+		return p.settings.OpenDuration
+	}
+
+	delay := p.delay()
+	if p.failures == 1 {
+		log.Info("disconnected from sensor; waiting to reconnect",
+			"driver", p.driver,
+			"device", p.device,
+			"err", err,
+			"delay", delay)
+	} else {
+		log.Debug("disconnected from sensor; waiting to reconnect",
+			"driver", p.driver,
+			"device", p.device,
+			"err", err,
+			"failures", p.failures,
+			"delay", delay)
+	}
+	return delay
+}
+
+func (p *Policy) delay() time.Duration {
+	delay := float64(p.settings.InitialDelay) * math.Pow(math.Max(p.settings.Multiplier, 1), float64(p.failures-1))
+	if p.settings.MaxDelay > 0 {
+		delay = math.Min(delay, float64(p.settings.MaxDelay))
+	}
+	if p.settings.Jitter > 0 {
+		delay += delay * p.settings.Jitter * (2*p.random.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+func (p *Policy) setState(state State) {
+	p.state = state
+	instrumentation.SetCircuitState(p.driver, p.device, int(state))
+	p.notify()
+}
+
+// notify reports the status of the connection to the observer, if any; the caller holds the lock
+func (p *Policy) notify() {
+	if p.settings.OnStatus == nil {
+		return
+	}
+	p.settings.OnStatus(Status{
+		Connected:   p.connected,
+		Circuit:     p.state,
+		Failures:    p.failures,
+		LastError:   p.lastError,
+		LastErrorAt: p.lastErrorAt,
+	})
+}
@@ -0,0 +1,179 @@
+package reconnect
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var errDisconnected = errors.New("failed to read from sensor; no such device")
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings Settings
+		delays   []time.Duration
+	}{
+		{
+			name:     "grows by the multiplier",
+			settings: Settings{InitialDelay: 100 * time.Millisecond, Multiplier: 2},
+			delays:   []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond},
+		},
+		{
+			name:     "capped at the maximum",
+			settings: Settings{InitialDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond, Multiplier: 2},
+			delays:   []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond},
+		},
+		{
+			name:     "multiplier below 1 does not shrink",
+			settings: Settings{InitialDelay: 100 * time.Millisecond, Multiplier: 0.5},
+			delays:   []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := NewPolicy(test.settings, "test", test.name)
+			for i, want := range test.delays {
+				delay := p.fail(errDisconnected)
+				if delay != want {
+					t.Errorf("got delay %v after %v failures, want %v", delay, i+1, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDelayJitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		low      time.Duration
+		high     time.Duration
+	}{
+		{"first failure", 1, 80 * time.Millisecond, 120 * time.Millisecond},
+		{"grown", 3, 320 * time.Millisecond, 480 * time.Millisecond},
+		{"capped", 10, 800 * time.Millisecond, 1200 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second, Multiplier: 2, Jitter: 0.2}
+			p := NewPolicy(settings, "test", test.name)
+			p.failures = test.failures
+			shortest, longest := test.high, test.low
+			for i := 0; i < 1000; i++ {
+				delay := p.delay()
+				if delay < test.low || delay > test.high {
+					t.Fatalf("got delay %v, want between %v and %v", delay, test.low, test.high)
+				}
+				if delay < shortest {
+					shortest = delay
+				}
+				if delay > longest {
+					longest = delay
+				}
+			}
+			if longest-shortest < (test.high-test.low)/2 {
+				t.Errorf("got delays between %v and %v, want them spread between %v and %v", shortest, longest, test.low, test.high)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings Settings
+		valid    bool
+	}{
+		{"no jitter", Settings{}, true},
+		{"jitter", Settings{Jitter: 0.2}, true},
+		{"full jitter", Settings{Jitter: 1}, true},
+		{"negative jitter", Settings{Jitter: -0.1}, false},
+		{"jitter above 1", Settings{Jitter: 1.5}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.settings.Validate()
+			if (err == nil) != test.valid {
+				t.Errorf("got error %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestCircuit(t *testing.T) {
+	tests := []struct {
+		name string
+		// Events in order: fail waits for the reconnect after a failure, healthy records a healthy reading
+		events []string
+		// State of the circuit after each event
+		states []State
+	}{
+		{
+			name:   "closed below the threshold",
+			events: []string{"fail", "fail"},
+			states: []State{Closed, Closed},
+		},
+		{
+			name:   "failures kept until healthy for the reset period",
+			events: []string{"fail", "fail", "healthy", "fail"},
+			states: []State{Closed, Closed, Closed, HalfOpen},
+		},
+		{
+			name:   "half-open after the open duration",
+			events: []string{"fail", "fail", "fail"},
+			states: []State{Closed, Closed, HalfOpen},
+		},
+		{
+			name:   "reopened when the probe fails",
+			events: []string{"fail", "fail", "fail", "fail"},
+			states: []State{Closed, Closed, HalfOpen, HalfOpen},
+		},
+		{
+			name:   "closed when the probe succeeds",
+			events: []string{"fail", "fail", "fail", "healthy"},
+			states: []State{Closed, Closed, HalfOpen, Closed},
+		},
+		{
+			name:   "failures reset after recovery",
+			events: []string{"fail", "fail", "fail", "healthy", "fail", "fail"},
+			states: []State{Closed, Closed, HalfOpen, Closed, Closed, Closed},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{
+				InitialDelay:     time.Millisecond,
+				Multiplier:       2,
+				ResetAfter:       time.Hour,
+				FailureThreshold: 3,
+				OpenDuration:     time.Millisecond,
+			}
+			p := NewPolicy(settings, "test", test.name)
+			states := []string{}
+			want := []string{}
+			for i, event := range test.events {
+				switch event {
+				case "fail":
+					if !p.Wait(context.Background(), errDisconnected) {
+						t.Fatalf("reconnect wait stopped early")
+					}
+				case "healthy":
+					p.Healthy()
+				}
+				states = append(states, p.State().String())
+				want = append(want, test.states[i].String())
+			}
+			if strings.Join(states, ",") != strings.Join(want, ",") {
+				t.Errorf("got states %v, want %v", states, want)
+			}
+		})
+	}
+}
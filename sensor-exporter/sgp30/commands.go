@@ -2,28 +2,51 @@ package sgp30
 
 import (
 	"context"
-	"io"
+	"sensor-exporter/i2cbus"
+	"sensor-exporter/internal/instrumentation"
 	"sensor-exporter/units"
 	"time"
 
-	"github.com/d2r2/go-i2c"
 	"github.com/pkg/errors"
 	"github.com/sigurn/crc8"
 )
 
-func getSerialID(ctx context.Context, i2c *i2c.I2C) ([]uint16, error) {
-	_, err := i2c.WriteBytes([]byte{0x36, 0x82})
-	if err != nil {
-		return nil, err
-	}
+const driverName = "sgp30"
 
-	select {
-	case <-ctx.Done():
-		return nil, io.EOF
-	case <-time.After(10 * time.Millisecond):
-	}
+// readCommand writes a command to the sensor, waits for it to be processed and reads the given number of words in response
+func readCommand(ctx context.Context, device *i2cbus.Device, name string, command []byte, delay time.Duration, words int) ([]uint16, error) {
+	var data []uint16
+	err := device.Transaction(ctx, name, func(tx *i2cbus.Tx) error {
+		err := tx.Write(command)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Sleep(delay)
+		if err != nil {
+			return err
+		}
 
-	serial, err := readWords(i2c, 3)
+		data, err = readWords(tx, words)
+		return err
+	})
+	return data, err
+}
+
+// writeCommand writes a command to the sensor and waits for it to be processed
+func writeCommand(ctx context.Context, device *i2cbus.Device, name string, command []byte, delay time.Duration) error {
+	return device.Transaction(ctx, name, func(tx *i2cbus.Tx) error {
+		err := tx.Write(command)
+		if err != nil {
+			return err
+		}
+
+		return tx.Sleep(delay)
+	})
+}
+
+func getSerialID(ctx context.Context, device *i2cbus.Device) ([]uint16, error) {
+	serial, err := readCommand(ctx, device, "get_serial_id", []byte{0x36, 0x82}, 10*time.Millisecond, 3)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read serial")
 	}
@@ -31,13 +54,8 @@ func getSerialID(ctx context.Context, i2c *i2c.I2C) ([]uint16, error) {
 	return serial, nil
 }
 
-func getFeatureSetVersion(i2c *i2c.I2C) (uint16, error) {
-	_, err := i2c.WriteBytes([]byte{0x20, 0x2F})
-	if err != nil {
-		return 0, err
-	}
-
-	data, err := readWords(i2c, 1)
+func getFeatureSetVersion(ctx context.Context, device *i2cbus.Device) (uint16, error) {
+	data, err := readCommand(ctx, device, "get_feature_set_version", []byte{0x20, 0x2F}, 10*time.Millisecond, 1)
 	if err != nil {
 		return 0, errors.Wrap(err, "failed to read feature set version")
 	}
@@ -52,8 +70,8 @@ var (
 	}
 )
 
-func isSupportedFeatureSetVersion(i2c *i2c.I2C) (bool, uint16, error) {
-	featureSet, err := getFeatureSetVersion(i2c)
+func isSupportedFeatureSetVersion(ctx context.Context, device *i2cbus.Device) (bool, uint16, error) {
+	featureSet, err := getFeatureSetVersion(ctx, device)
 	if err != nil {
 		return false, 0, err
 	}
@@ -62,32 +80,12 @@ func isSupportedFeatureSetVersion(i2c *i2c.I2C) (bool, uint16, error) {
 	return exists, featureSet, nil
 }
 
-func initAirQuality(ctx context.Context, i2c *i2c.I2C) error {
-	_, err := i2c.WriteBytes([]byte{0x20, 0x03})
-	if err != nil {
-		return err
-	}
-
-	select {
-	case <-ctx.Done():
-	case <-time.After(10 * time.Millisecond):
-	}
-
-	return nil
+func initAirQuality(ctx context.Context, device *i2cbus.Device) error {
+	return writeCommand(ctx, device, "init_air_quality", []byte{0x20, 0x03}, 10*time.Millisecond)
 }
-func measureAirQuality(ctx context.Context, i2c *i2c.I2C) ([]uint16, error) {
-	_, err := i2c.WriteBytes([]byte{0x20, 0x08})
-	if err != nil {
-		return nil, err
-	}
 
-	select {
-	case <-ctx.Done():
-		return nil, io.EOF
-	case <-time.After(12 * time.Millisecond):
-	}
-
-	data, err := readWords(i2c, 2)
+func measureAirQuality(ctx context.Context, device *i2cbus.Device) ([]uint16, error) {
+	data, err := readCommand(ctx, device, "measure_air_quality", []byte{0x20, 0x08}, 12*time.Millisecond, 2)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read air quality")
 	}
@@ -95,19 +93,8 @@ func measureAirQuality(ctx context.Context, i2c *i2c.I2C) ([]uint16, error) {
 	return data, nil
 }
 
-func measureRawSignals(ctx context.Context, i2c *i2c.I2C) ([]uint16, error) {
-	_, err := i2c.WriteBytes([]byte{0x20, 0x50})
-	if err != nil {
-		return nil, err
-	}
-
-	select {
-	case <-ctx.Done():
-		return nil, io.EOF
-	case <-time.After(25 * time.Millisecond):
-	}
-
-	data, err := readWords(i2c, 2)
+func measureRawSignals(ctx context.Context, device *i2cbus.Device) ([]uint16, error) {
+	data, err := readCommand(ctx, device, "measure_raw_signals", []byte{0x20, 0x50}, 25*time.Millisecond, 2)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read raw signals")
 	}
@@ -115,63 +102,26 @@ func measureRawSignals(ctx context.Context, i2c *i2c.I2C) ([]uint16, error) {
 	return data, nil
 }
 
-func getBaseline(ctx context.Context, i2c *i2c.I2C) ([]uint16, error) {
-	_, err := i2c.WriteBytes([]byte{0x20, 0x15})
+func getBaseline(ctx context.Context, device *i2cbus.Device) ([]uint16, error) {
+	data, err := readCommand(ctx, device, "get_baseline", []byte{0x20, 0x15}, 10*time.Millisecond, 2)
 	if err != nil {
-		return nil, err
-	}
-
-	select {
-	case <-ctx.Done():
-		return nil, io.EOF
-	case <-time.After(10 * time.Millisecond):
-	}
-
-	data, err := readWords(i2c, 2)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to read raw signals")
+		return nil, errors.Wrap(err, "failed to read baseline")
 	}
 
 	return data, nil
 }
 
-func setBaseline(ctx context.Context, i2c *i2c.I2C, eCO2, tVOC uint16) error {
-	eCO2data := []byte{byte(eCO2 >> 8), byte(eCO2)}
-	eCO2crc := crc8.Checksum(eCO2data, checksumTable)
-	tVOCdata := []byte{byte(tVOC >> 8), byte(tVOC)}
-	tVOCcrc := crc8.Checksum(tVOCdata, checksumTable)
-
+func setBaseline(ctx context.Context, device *i2cbus.Device, eCO2, tVOC uint16) error {
 	command := []byte{0x20, 0x1E}
-	_, err := i2c.WriteBytes(append(command, eCO2data[0], eCO2data[1], eCO2crc, tVOCdata[0], tVOCdata[1], tVOCcrc))
-	if err != nil {
-		return err
-	}
-
-	select {
-	case <-ctx.Done():
-		return io.EOF
-	case <-time.After(10 * time.Millisecond):
-	}
-	return nil
+	command = appendWord(command, eCO2)
+	command = appendWord(command, tVOC)
+	return writeCommand(ctx, device, "set_baseline", command, 10*time.Millisecond)
 }
 
-func setHumidity(ctx context.Context, i2c *i2c.I2C, humidity units.GramsPerCubicMeter) error {
+func setHumidity(ctx context.Context, device *i2cbus.Device, humidity units.GramsPerCubicMeter) error {
 	fixedPointValue := uint16(humidity * 256)
-	humidityData := []byte{byte(fixedPointValue >> 8), byte(fixedPointValue)}
-	humidityCRC := crc8.Checksum(humidityData, checksumTable)
-
-	command := []byte{0x20, 0x61}
-	_, err := i2c.WriteBytes(append(command, humidityData[0], humidityData[1], humidityCRC))
-	if err != nil {
-		return err
-	}
-
-	select {
-	case <-ctx.Done():
-		return io.EOF
-	case <-time.After(10 * time.Millisecond):
-	}
-	return nil
+	command := appendWord([]byte{0x20, 0x61}, fixedPointValue)
+	return writeCommand(ctx, device, "set_humidity", command, 10*time.Millisecond)
 }
 
 var (
@@ -186,14 +136,21 @@ var (
 	})
 )
 
-func readWords(i2c *i2c.I2C, words int) ([]uint16, error) {
+// appendWord appends a big-endian word followed by its CRC
+func appendWord(buf []byte, word uint16) []byte {
+	data := []byte{byte(word >> 8), byte(word)}
+	crc := crc8.Checksum(data, checksumTable)
+	return append(buf, data[0], data[1], crc)
+}
+
+func readWords(tx *i2cbus.Tx, words int) ([]uint16, error) {
 	const (
 		wordLength = 2
 		crcLength  = 1
 	)
 
 	buf := make([]byte, words*(wordLength+crcLength))
-	_, err := i2c.ReadBytes(buf)
+	err := tx.Read(buf)
 	if err != nil {
 		return nil, err
 	}
@@ -204,6 +161,7 @@ func readWords(i2c *i2c.I2C, words int) ([]uint16, error) {
 		expectedCrc := buf[idx+2]
 		actualCrc := crc8.Checksum(wordBytes, checksumTable)
 		if actualCrc != expectedCrc {
+			instrumentation.IncCRCFailures(driverName, tx.Device().String())
 			return nil, errors.Errorf("failed to validate crc for %v (expected %v but got %v)", wordBytes, expectedCrc, actualCrc)
 		}
 
@@ -0,0 +1,26 @@
+package sgp30
+
+import (
+	"context"
+	"sensor-exporter/i2cbus"
+)
+
+// Probe reads the serial and feature set of an SGP30 sensor without initializing its air quality measurements
+func Probe(ctx context.Context, device *i2cbus.Device) (*Info, error) {
+	serial, err := getSerialID(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+
+	featureSet, err := getFeatureSetVersion(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Info{serial, featureSet}, nil
+}
+
+// IsSupportedFeatureSet returns whether the driver supports a feature set reported by a sensor
+func IsSupportedFeatureSet(featureSet uint16) bool {
+	return supportedFeatureSets[featureSet]
+}
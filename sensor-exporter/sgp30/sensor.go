@@ -2,11 +2,14 @@ package sgp30
 
 import (
 	"context"
+	"fmt"
+	"sensor-exporter/i2cbus"
+	"sensor-exporter/reconnect"
 	"sensor-exporter/units"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/d2r2/go-i2c"
-	"github.com/d2r2/go-logger"
 	"github.com/pkg/errors"
 	"github.com/syncromatics/go-kit/v2/log"
 	"golang.org/x/exp/slices"
@@ -16,20 +19,48 @@ import (
 type PartsPerBillion uint16
 type PartsPerMillion uint16
 
+// Clock defers decisions based on wall time, such as whether a stored baseline has expired, until the wall clock can
+// be trusted
+type Clock interface {
+	// WaitForSync waits for the wall clock to be synchronized, returning false if it gave up waiting
+	WaitForSync(ctx context.Context) bool
+}
+
+// BaselineLookup returns the stored baseline of the sensor with the given serial, or nil if there is none
+type BaselineLookup func(serial []uint16) *BaselineReading
+
 type requestAirQualityReading struct{}
 
 // AirQualityReading represents the transformed air quality signal from the SGP30 sensor
 type AirQualityReading struct {
 	// Indicates whether the reading can be considered valid depending on the initialization of the sensor and its running time
 	IsValid bool
+	// Indicates whether the sensor has finished initializing after power-up, before which it reports fixed eCO2 and tVOC values
+	IsInitialized bool
 	// Remaining duration until the air quality readings can be considered valid
 	DurationUntilValid time.Duration
+	// Indicates whether the driver is still waiting for the clock to be trusted before restoring the stored baseline, in
+	// which case DurationUntilValid assumes that the sensor acclimates from scratch
+	BaselinePending bool
 	// Total volatile organic compound (VOC) concentration in parts per billion
 	TotalVOC PartsPerBillion
 	// Equivalent carbon dioxide (CO2) concentration in parts per million
 	EquivalentCO2 PartsPerMillion
 }
 
+const (
+	// BaselineValidity is the duration for which a stored baseline may be restored to the sensor
+	BaselineValidity = 7 * 24 * time.Hour
+	// AcclimationDuration is the duration the sensor needs to establish a baseline without a valid stored one
+	AcclimationDuration = 12 * time.Hour
+
+	// baselineInterval is how often the baseline is read once the sensor has acclimated
+	baselineInterval = 1 * time.Hour
+	// provisionalBaselineInterval is how often the baseline is read while the sensor acclimates, so that little progress
+	// is lost if the exporter restarts
+	provisionalBaselineInterval = 10 * time.Minute
+)
+
 type requestBaselineReading struct {
 	serial                       []uint16
 	sensorReadingsNotValidBefore time.Time
@@ -46,6 +77,18 @@ type BaselineReading struct {
 	TotalVOC PartsPerBillion
 	// Equivalent carbon dioxide (CO2) concentration in parts per million
 	EquivalentCO2 PartsPerMillion
+	// Indicates whether the baseline was read before the sensor acclimated, so that it only preserves acclimation progress
+	Provisional bool `json:",omitempty"`
+}
+
+// StoredAt returns the time at which the baseline was read from the sensor
+func (b *BaselineReading) StoredAt() time.Time {
+	return b.BaselineInvalidAfter.Add(-BaselineValidity)
+}
+
+// IsExpired returns whether the baseline is too old to be restored to the sensor
+func (b *BaselineReading) IsExpired(now time.Time) bool {
+	return !now.Before(b.BaselineInvalidAfter)
 }
 
 type requestRawReading struct{}
@@ -58,49 +101,79 @@ type RawReading struct {
 	Ethanol PartsPerMillion
 }
 
+// Info describes the identity of the connected SGP30 sensor
+type Info struct {
+	// Serial number of the sensor
+	Serial []uint16
+	// Version of the feature set supported by the sensor
+	FeatureSet uint16
+}
+
 type becomeInitialized struct{}
 
+type restoreBaseline struct {
+	baseline *BaselineReading
+}
+
+// applyInitialBaseline sets the baseline chosen once the clock can be trusted, if any, and the time from which readings
+// are valid
+type applyInitialBaseline struct {
+	baseline                     *BaselineReading
+	sensorReadingsNotValidBefore time.Time
+}
+
 type updateHumidity struct {
 	humidity units.GramsPerCubicMeter
 }
 
 type Sensor struct {
-	i2cAddr            uint8
-	i2cBus             int
+	buses              *i2cbus.Manager
+	address            i2cbus.Address
+	infos              chan *Info
 	airQualityReadings chan *AirQualityReading
 	rawReadings        chan *RawReading
 	baselineReadings   chan *BaselineReading
-	reconnectTimeout   time.Duration
+	reconnectSettings  reconnect.Settings
 	commands           chan interface{}
-	initialBaseline    *BaselineReading
-}
-
-func init() {
-	logger.ChangePackageLogLevel("i2c", logger.InfoLevel)
+	done               chan struct{}
+	lookupBaseline     BaselineLookup
+	clock              Clock
+	lastBaseline       *BaselineReading
 }
 
 func NewSensor(
-	i2cAddr uint8,
-	i2cBus int,
-	reconnectTimeout time.Duration,
-	initialBaseline *BaselineReading,
+	buses *i2cbus.Manager,
+	address i2cbus.Address,
+	reconnectSettings reconnect.Settings,
+	lookupBaseline BaselineLookup,
+	clock Clock,
 ) *Sensor {
+	infos := make(chan *Info)
 	airQualityReadings := make(chan *AirQualityReading)
 	rawReadings := make(chan *RawReading)
 	baselineReadings := make(chan *BaselineReading)
 	commands := make(chan interface{})
+	done := make(chan struct{})
 	return &Sensor{
-		i2cAddr,
-		i2cBus,
+		buses,
+		address,
+		infos,
 		airQualityReadings,
 		rawReadings,
 		baselineReadings,
-		reconnectTimeout,
+		reconnectSettings,
 		commands,
-		initialBaseline,
+		done,
+		lookupBaseline,
+		clock,
+		nil,
 	}
 }
 
+func (s *Sensor) Infos() <-chan *Info {
+	return s.infos
+}
+
 func (s *Sensor) AirQualityReadings() <-chan *AirQualityReading {
 	return s.airQualityReadings
 }
@@ -118,6 +191,19 @@ func (s *Sensor) SetHumidity(ctx context.Context, humidity units.GramsPerCubicMe
 	go func() {
 		select {
 		case <-ctx.Done():
+		case <-s.done:
+		case s.commands <- command:
+		}
+	}()
+}
+
+// RestoreBaseline sets the baseline of the connected sensor, which must be the sensor the baseline was read from
+func (s *Sensor) RestoreBaseline(ctx context.Context, baseline *BaselineReading) {
+	command := &restoreBaseline{baseline}
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.done:
 		case s.commands <- command:
 		}
 	}()
@@ -125,24 +211,28 @@ func (s *Sensor) SetHumidity(ctx context.Context, humidity units.GramsPerCubicMe
 
 func (s *Sensor) Start(ctx context.Context) func() error {
 	return func() error {
+		defer close(s.infos)
 		defer close(s.airQualityReadings)
 		defer close(s.rawReadings)
 		defer close(s.baselineReadings)
-		defer close(s.commands)
+		// commands is left open since SetHumidity and RestoreBaseline may still send on it; closing done drops those
+		// commands instead
+		defer close(s.done)
+		policy := reconnect.NewPolicy(s.reconnectSettings, driverName, s.address.String())
 		for {
-			i2c, err := i2c.NewI2C(s.i2cAddr, s.i2cBus)
+			device, err := s.buses.Open(driverName, s.address)
 			if err != nil {
-				return errors.Wrapf(err, "failed to open I2C address %v on bus %v", s.i2cAddr, s.i2cBus)
+				return errors.Wrapf(err, "failed to open I2C device %v", s.address)
 			}
 
 			group, innerCtx := errgroup.WithContext(ctx)
 			group.Go(func() error {
-				serial, err := getSerialID(innerCtx, i2c)
+				serial, err := getSerialID(innerCtx, device)
 				if err != nil {
 					return errors.Wrap(err, "failed to read serial")
 				}
 
-				isSupported, featureSet, err := isSupportedFeatureSetVersion(i2c)
+				isSupported, featureSet, err := isSupportedFeatureSetVersion(innerCtx, device)
 				if err != nil {
 					return errors.Wrap(err, "failed to read feature set")
 				}
@@ -154,62 +244,114 @@ func (s *Sensor) Start(ctx context.Context) func() error {
 					"serial", serial,
 					"featureSet", featureSet)
 
-				err = initAirQuality(innerCtx, i2c)
-				if err != nil {
-					return errors.Wrap(err, "failed to initialize air quality")
+				select {
+				case <-innerCtx.Done():
+					return nil
+				case s.infos <- &Info{serial, featureSet}:
 				}
 
-				now := time.Now()
-				var sensorReadingsNotValidBefore time.Time
-				if s.initialBaseline != nil &&
-					slices.Equal(s.initialBaseline.Serial, serial) &&
-					now.Before(s.initialBaseline.BaselineInvalidAfter) {
-					sensorReadingsNotValidBefore = s.initialBaseline.SensorReadingsNotValidBefore
-
-					err = setBaseline(innerCtx, i2c, uint16(s.initialBaseline.EquivalentCO2), uint16(s.initialBaseline.TotalVOC))
-					if err != nil {
-						return errors.Wrap(err, "failed to set baseline")
-					}
-				} else {
-					log.Warn("failed to set baseline; sensor will require acclimation",
-						"serial", serial,
-						"now", now,
-						"initialBaseline", s.initialBaseline)
-					sensorReadingsNotValidBefore = now.Add(12 * time.Hour)
+				err = initAirQuality(innerCtx, device)
+				if err != nil {
+					return errors.Wrap(err, "failed to initialize air quality")
 				}
 
-				group.Go(s.handleCommands(innerCtx, i2c, sensorReadingsNotValidBefore))
+				// measurement starts right away, assuming the sensor acclimates from scratch until the stored baseline
+				// is restored once the clock can be trusted. Countdowns are kept relative to the monotonic clock from
+				// here on so that later clock steps do not shorten or lengthen them.
+				started := time.Now()
+				group.Go(s.handleCommands(innerCtx, device, policy, started.Add(AcclimationDuration)))
 				group.Go(s.scheduleRepeatedly(innerCtx, &requestAirQualityReading{}, 1*time.Second))
 				group.Go(s.scheduleRepeatedly(innerCtx, &requestRawReading{}, 25*time.Millisecond))
-				group.Go(s.scheduleRepeatedly(innerCtx, &requestBaselineReading{
-					serial,
-					sensorReadingsNotValidBefore,
-				}, 1*time.Hour))
 				group.Go(s.scheduleOnce(innerCtx, &becomeInitialized{}, 15*time.Second))
+				group.Go(s.restoreInitialBaseline(innerCtx, serial, started))
 
 				return nil
 			})
-			group.Go(func() error {
-				<-innerCtx.Done()
-				i2c.Close()
-				return nil
-			})
 
 			err = group.Wait()
-			log.Info("disconnected from sensor; waiting to reconnect",
-				"err", err,
-				"reconnectTimeout", s.reconnectTimeout)
-
-			select {
-			case <-ctx.Done():
+			device.Close()
+			if !policy.Wait(ctx, err) {
 				return nil
-			case <-time.After(s.reconnectTimeout):
-				log.Info("reconnecting")
 			}
 		}
 	}
 }
 
+// restoreInitialBaseline waits for the clock to be trusted before deciding whether the stored baseline has expired,
+// then restores it and starts reading baselines, which are held back until then so that one learned while waiting
+// does not replace the stored baseline
+func (s *Sensor) restoreInitialBaseline(ctx context.Context, serial []uint16, started time.Time) func() error {
+	return func() error {
+		synchronized := true
+		if s.clock != nil {
+			synchronized = s.clock.WaitForSync(ctx)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		now := time.Now()
+		command := &applyInitialBaseline{
+			sensorReadingsNotValidBefore: started.Add(AcclimationDuration),
+		}
+		initialBaseline := s.initialBaseline(serial)
+		switch {
+		case initialBaseline == nil || !slices.Equal(initialBaseline.Serial, serial):
+			log.Warn("failed to find a baseline for the sensor; sensor will require acclimation",
+				"serial", serial,
+				"initialBaseline", initialBaseline)
+		case synchronized && initialBaseline.IsExpired(now):
+			log.Warn("failed to set expired baseline; sensor will require acclimation",
+				"serial", serial,
+				"now", now,
+				"initialBaseline", initialBaseline)
+		default:
+			remaining := initialBaseline.SensorReadingsNotValidBefore.Sub(now)
+			if !synchronized {
+				// the age of the baseline cannot be judged, and discarding a good baseline costs a full acclimation, so
+				// it is restored; only a provisional one needs to acclimate further
+				log.Warn("restoring baseline without checking its age since the clock is not synchronized",
+					"serial", serial,
+					"initialBaseline", initialBaseline)
+				remaining = 0
+				if initialBaseline.Provisional {
+					remaining = AcclimationDuration
+				}
+			}
+			if remaining < 0 {
+				remaining = 0
+			}
+			command.baseline = initialBaseline
+			command.sensorReadingsNotValidBefore = now.Add(remaining)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case s.commands <- command:
+		}
+
+		return s.scheduleBaselineReadings(ctx, &requestBaselineReading{
+			serial,
+			command.sensorReadingsNotValidBefore,
+		})()
+	}
+}
+
+// initialBaseline returns the baseline to restore to the sensor with the given serial, preferring the stored one,
+// which may have been vetted or rolled back, over the one last read from the sensor by this driver
+func (s *Sensor) initialBaseline(serial []uint16) *BaselineReading {
+	if s.lookupBaseline != nil {
+		if baseline := s.lookupBaseline(serial); baseline != nil {
+			return baseline
+		}
+	}
+	if s.lastBaseline != nil && slices.Equal(s.lastBaseline.Serial, serial) {
+		return s.lastBaseline
+	}
+	return nil
+}
+
 func (s *Sensor) scheduleOnce(ctx context.Context, command interface{}, duration time.Duration) func() error {
 	return func() error {
 		select {
@@ -241,9 +383,33 @@ func (s *Sensor) scheduleRepeatedly(ctx context.Context, command interface{}, in
 	}
 }
 
-func (s *Sensor) handleCommands(innerCtx context.Context, i2c *i2c.I2C, sensorReadingsNotValidBefore time.Time) func() error {
+// scheduleBaselineReadings requests baseline readings frequently while the sensor acclimates and hourly afterwards
+func (s *Sensor) scheduleBaselineReadings(ctx context.Context, command *requestBaselineReading) func() error {
+	return func() error {
+		for {
+			interval := baselineInterval
+			if time.Now().Before(command.sensorReadingsNotValidBefore) {
+				interval = provisionalBaselineInterval
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(interval):
+				select {
+				case <-ctx.Done():
+					return nil
+				case s.commands <- command:
+				}
+			}
+		}
+	}
+}
+
+func (s *Sensor) handleCommands(innerCtx context.Context, device *i2cbus.Device, policy *reconnect.Policy, sensorReadingsNotValidBefore time.Time) func() error {
 	return func() error {
 		isInitialized := false
+		isBaselinePending := true
 		for {
 			select {
 			case <-innerCtx.Done():
@@ -253,7 +419,7 @@ func (s *Sensor) handleCommands(innerCtx context.Context, i2c *i2c.I2C, sensorRe
 				case *becomeInitialized:
 					isInitialized = true
 				case *requestAirQualityReading:
-					airQualityReadings, err := measureAirQuality(innerCtx, i2c)
+					airQualityReadings, err := measureAirQuality(innerCtx, device)
 					if err != nil {
 						return errors.Wrap(err, "failed to read air quality")
 					}
@@ -270,7 +436,9 @@ func (s *Sensor) handleCommands(innerCtx context.Context, i2c *i2c.I2C, sensorRe
 
 					airQualityReading := &AirQualityReading{
 						IsValid:            isValid,
+						IsInitialized:      isInitialized,
 						DurationUntilValid: durationUntilValid,
+						BaselinePending:    isBaselinePending,
 						EquivalentCO2:      PartsPerMillion(airQualityReadings[0]),
 						TotalVOC:           PartsPerBillion(airQualityReadings[1]),
 					}
@@ -278,9 +446,10 @@ func (s *Sensor) handleCommands(innerCtx context.Context, i2c *i2c.I2C, sensorRe
 					case <-innerCtx.Done():
 						return nil
 					case s.airQualityReadings <- airQualityReading:
+						policy.Healthy()
 					}
 				case *requestRawReading:
-					rawReadings, err := measureRawSignals(innerCtx, i2c)
+					rawReadings, err := measureRawSignals(innerCtx, device)
 					if err != nil {
 						return errors.Wrap(err, "failed to read raw signals")
 					}
@@ -294,25 +463,46 @@ func (s *Sensor) handleCommands(innerCtx context.Context, i2c *i2c.I2C, sensorRe
 					case s.rawReadings <- rawReading:
 					}
 				case *requestBaselineReading:
-					baseline, err := getBaseline(innerCtx, i2c)
+					baseline, err := getBaseline(innerCtx, device)
 					if err != nil {
 						return errors.Wrap(err, "failed to read baseline")
 					}
+					// the countdown is re-anchored to the current wall clock in case it was stepped since the countdown started
+					now := time.Now()
+					sensorReadingsNotValidBefore := now.Add(command.sensorReadingsNotValidBefore.Sub(now))
 					baselineReading := &BaselineReading{
 						Serial:                       command.serial,
-						SensorReadingsNotValidBefore: command.sensorReadingsNotValidBefore,
-						BaselineInvalidAfter:         time.Now().Add(7 * 24 * time.Hour),
+						SensorReadingsNotValidBefore: sensorReadingsNotValidBefore,
+						BaselineInvalidAfter:         now.Add(BaselineValidity),
 						EquivalentCO2:                PartsPerMillion(baseline[0]),
 						TotalVOC:                     PartsPerBillion(baseline[1]),
+						Provisional:                  now.Before(sensorReadingsNotValidBefore),
 					}
-					s.initialBaseline = baselineReading
+					s.lastBaseline = baselineReading
 					select {
 					case <-innerCtx.Done():
 						return nil
 					case s.baselineReadings <- baselineReading:
 					}
+				case *applyInitialBaseline:
+					if command.baseline != nil {
+						err := setBaseline(innerCtx, device, uint16(command.baseline.EquivalentCO2), uint16(command.baseline.TotalVOC))
+						if err != nil {
+							return errors.Wrap(err, "failed to set baseline")
+						}
+					}
+					isBaselinePending = false
+					sensorReadingsNotValidBefore = command.sensorReadingsNotValidBefore
+				case *restoreBaseline:
+					err := setBaseline(innerCtx, device, uint16(command.baseline.EquivalentCO2), uint16(command.baseline.TotalVOC))
+					if err != nil {
+						return errors.Wrap(err, "failed to restore baseline")
+					}
+					s.lastBaseline = command.baseline
+					log.Info("restored baseline",
+						"baseline", command.baseline)
 				case *updateHumidity:
-					err := setHumidity(innerCtx, i2c, command.humidity)
+					err := setHumidity(innerCtx, device, command.humidity)
 					if err != nil {
 						return errors.Wrap(err, "failed to set humidity")
 					}
@@ -324,3 +514,29 @@ func (s *Sensor) handleCommands(innerCtx context.Context, i2c *i2c.I2C, sensorRe
 		}
 	}
 }
+
+// FormatSerial formats the words of a serial number as a hexadecimal string
+func FormatSerial(serial []uint16) string {
+	var b strings.Builder
+	for _, word := range serial {
+		fmt.Fprintf(&b, "%04X", word)
+	}
+	return b.String()
+}
+
+// ParseSerial parses a serial number formatted by FormatSerial
+func ParseSerial(serial string) ([]uint16, error) {
+	if len(serial) == 0 || len(serial)%4 != 0 {
+		return nil, errors.Errorf("failed to parse serial %q; expected groups of four hexadecimal digits", serial)
+	}
+
+	words := []uint16{}
+	for idx := 0; idx < len(serial); idx += 4 {
+		word, err := strconv.ParseUint(serial[idx:idx+4], 16, 16)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse serial %q", serial)
+		}
+		words = append(words, uint16(word))
+	}
+	return words, nil
+}
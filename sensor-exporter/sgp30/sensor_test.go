@@ -0,0 +1,31 @@
+package sgp30
+
+import (
+	"context"
+	"io"
+	"sensor-exporter/i2cbus"
+	"sensor-exporter/reconnect"
+	"testing"
+	"time"
+)
+
+func TestCommandsAfterStop(t *testing.T) {
+	sensor := NewSensor(
+		i2cbus.NewFakeManager(map[uint8]io.ReadWriter{}),
+		i2cbus.Address{Bus: 1, Device: 0x58},
+		reconnect.Settings{},
+		nil,
+		nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := sensor.Start(ctx)()
+	if err != nil {
+		t.Fatalf("failed to stop: %v", err)
+	}
+
+	// commands sent after the sensor stopped, such as a baseline rolled back over the API during shutdown, are dropped
+	// rather than sent on a closed channel
+	sensor.RestoreBaseline(context.Background(), &BaselineReading{Serial: []uint16{1, 2, 3}})
+	sensor.SetHumidity(context.Background(), 8)
+	time.Sleep(10 * time.Millisecond)
+}